@@ -0,0 +1,78 @@
+package app
+
+import (
+	"net/http"
+
+	"kubecloud/internal/constants"
+	"kubecloud/kubedeployer"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xmonader/ewf"
+)
+
+// AddonsInput holds the add-ons a deployment should have
+type AddonsInput struct {
+	Addons []kubedeployer.Addon `json:"addons"`
+}
+
+// @Summary Set deployment add-ons
+// @Description Installs or updates the listed add-ons on a deployment and uninstalls the ones no longer listed.
+// @Description Supported add-ons are tfgw-crd, cert-manager, metrics-server, prometheus, flux and argocd.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param addons body AddonsInput true "Desired add-ons"
+// @Success 202 {object} Response "Add-ons workflow started successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/addons [put]
+func (h *Handler) HandleSetAddons(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var input AddonsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+		return
+	}
+
+	if err := kubedeployer.ValidateAddons(input.Addons); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	_, cl, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	removed := kubedeployer.RemovedAddons(cl.Addons, input.Addons)
+	cl.Addons = input.Addons
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowUpdateAddons)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":         config,
+		"cluster":        cl,
+		"removed_addons": removed,
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Add-ons workflow started successfully",
+	})
+}
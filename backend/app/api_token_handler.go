@@ -0,0 +1,185 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kubecloud/internal"
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// APITokenInput holds the data needed to create an API token
+type APITokenInput struct {
+	Name string `json:"name" binding:"required,max=64"`
+	// Deployment is the name of the deployment the token is bound to
+	Deployment string `json:"deployment" binding:"required"`
+	// Scope is k8s for the Kubernetes API proxy of the deployment
+	Scope string `json:"scope" binding:"required,oneof=k8s"`
+	// ExpiresInDays is the validity of the token, it never expires when empty
+	ExpiresInDays int `json:"expires_in_days,omitempty" binding:"omitempty,min=1,max=3650"`
+}
+
+// APITokenResponse holds a created API token, the token itself is only returned once
+type APITokenResponse struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+// createAPIToken generates an API token of a user bound to one of their deployments and stores its hash
+func (h *Handler) createAPIToken(userID int, name, scope, projectName string, expiresAt *time.Time) (models.APIToken, string, error) {
+	token, tokenHash, err := internal.GenerateAPIToken()
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+
+	apiToken := models.APIToken{
+		UserID:      userID,
+		Name:        name,
+		TokenHash:   tokenHash,
+		Prefix:      token[:len(internal.APITokenPrefix)+6],
+		Scope:       scope,
+		ProjectName: projectName,
+		ExpiresAt:   expiresAt,
+	}
+	if err := h.db.CreateAPIToken(&apiToken); err != nil {
+		return models.APIToken{}, "", err
+	}
+
+	return apiToken, token, nil
+}
+
+// @Summary List API tokens
+// @Description Lists the API tokens of the authenticated user
+// @Tags users
+// @ID list-api-tokens
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.APIToken
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 500 {object} APIResponse
+// @Router /user/api-tokens [get]
+// ListAPITokensHandler lists the API tokens of the authenticated user
+func (h *Handler) ListAPITokensHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		Error(c, http.StatusUnauthorized, "Unauthorized", "user not authenticated")
+		return
+	}
+
+	tokens, err := h.db.ListUserAPITokens(userID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Msg("failed to list API tokens")
+		InternalServerError(c)
+		return
+	}
+
+	Success(c, http.StatusOK, "API tokens retrieved successfully", tokens)
+}
+
+// @Summary Create API token
+// @Description Creates a long lived API token for one deployment of the authenticated user, it is only returned in this response.
+// @Description A k8s token is accepted by the Kubernetes API proxy of the deployment. API tokens are rejected by all other endpoints.
+// @Tags users
+// @ID create-api-token
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body APITokenInput true "API Token Input"
+// @Success 201 {object} APITokenResponse
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse
+// @Router /user/api-tokens [post]
+// CreateAPITokenHandler creates an API token for the authenticated user
+func (h *Handler) CreateAPITokenHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		Error(c, http.StatusUnauthorized, "Unauthorized", "user not authenticated")
+		return
+	}
+
+	var request APITokenInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		logger.GetLogger().Error().Err(err).Send()
+		Error(c, http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+
+	projectName := kubedeployer.GetProjectName(userID, request.Deployment)
+	if _, err := h.db.GetClusterByName(userID, projectName); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			Error(c, http.StatusNotFound, "Not Found", "deployment not found")
+			return
+		}
+		logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("failed to get deployment of API token")
+		InternalServerError(c)
+		return
+	}
+
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		expiry := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		expiresAt = &expiry
+	}
+
+	apiToken, token, err := h.createAPIToken(userID, request.Name, request.Scope, projectName, expiresAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			Error(c, http.StatusBadRequest, "Duplicate API token", "API token name already exists for this user.")
+			return
+		}
+		logger.GetLogger().Error().Err(err).Msg("failed to create API token")
+		InternalServerError(c)
+		return
+	}
+
+	Success(c, http.StatusCreated, "API token created successfully", APITokenResponse{APIToken: apiToken, Token: token})
+}
+
+// @Summary Delete API token
+// @Description Deletes an API token of the authenticated user, it is rejected right away
+// @Tags users
+// @ID delete-api-token
+// @Produce json
+// @Security BearerAuth
+// @Param api_token_id path int true "API Token ID"
+// @Success 200 {object} APIResponse
+// @Failure 400 {object} APIResponse "Invalid API token ID"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "API token not found"
+// @Failure 500 {object} APIResponse
+// @Router /user/api-tokens/{api_token_id} [delete]
+// DeleteAPITokenHandler deletes an API token of the authenticated user
+func (h *Handler) DeleteAPITokenHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		Error(c, http.StatusUnauthorized, "Unauthorized", "user not authenticated")
+		return
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("api_token_id"))
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Invalid request", "invalid API token ID format")
+		return
+	}
+
+	if err := h.db.DeleteAPIToken(tokenID, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			Error(c, http.StatusNotFound, "Not Found", "API token not found")
+			return
+		}
+		logger.GetLogger().Error().Err(err).Int("api_token_id", tokenID).Msg("failed to delete API token")
+		InternalServerError(c)
+		return
+	}
+
+	Success(c, http.StatusOK, "API token deleted successfully", nil)
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"kubecloud/internal"
 	"kubecloud/internal/activities"
+	"kubecloud/internal/backup"
 	"kubecloud/internal/metrics"
 	"kubecloud/internal/notification"
+	"kubecloud/kubedeployer"
 	"kubecloud/middlewares"
 	"kubecloud/models"
 	"net"
@@ -173,6 +175,13 @@ func NewApp(ctx context.Context, config internal.Configuration) (*App, error) {
 	}
 	sshPublicKey := strings.TrimSpace(string(sshPublicKeyBytes))
 
+	kubedeployer.SetDefaultFlist(config.K3sFlist)
+
+	snapshotStore, err := backup.NewStore(config.Backup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot store: %w", err)
+	}
+
 	appCtx, appCancel := context.WithCancel(ctx)
 
 	// Derive sponsor (system) account SS58 address once
@@ -213,8 +222,15 @@ func NewApp(ctx context.Context, config internal.Configuration) (*App, error) {
 
 	handler := NewHandler(tokenHandler, db, config, mailService, gridProxy,
 		substrateClient, graphqlClient, firesquidClient, redisClient,
-		sseManager, ewfEngine, config.SystemAccount.Network, sshPublicKey,
-		systemIdentity, kycClient, sponsorKeyPair, sponsorAddress, metrics, notificationService, gridClient)
+		sseManager, ewfEngine, ewfStore, config.SystemAccount.Network, sshPublicKey,
+		systemIdentity, kycClient, sponsorKeyPair, sponsorAddress, metrics, notificationService, gridClient,
+		snapshotStore)
+
+	// every SSH connection to cluster nodes checks their host key against the one trusted on first use
+	internal.SetHostKeyVerifier(internal.NewHostKeyVerifier(db, handler.alertHostKeyChange))
+
+	// clients subscribing to the events of a cluster over SSE get them from its watcher
+	sseManager.SetClusterEventSource(newClusterEventSource(handler))
 
 	app := &App{
 		router:              router,
@@ -242,6 +258,7 @@ func NewApp(ctx context.Context, config internal.Configuration) (*App, error) {
 		app.metrics,
 		app.notificationService,
 		gridProxy,
+		snapshotStore,
 	)
 
 	app.registerHandlers()
@@ -262,7 +279,6 @@ func (app *App) registerHandlers() {
 	v1 := app.router.Group("/api/v1")
 	{
 		v1.GET("/health", app.handlers.HealthHandler)
-		v1.GET("/workflow/:workflow_id", app.handlers.GetWorkflowStatus)
 		v1.GET("/twins/:twin_id/account", app.handlers.GetAccountIDHandler)
 		v1.GET("/system/maintenance/status", app.handlers.GetMaintenanceModeHandler)
 		v1.GET("/stats", app.handlers.GetStatsHandler)
@@ -283,6 +299,19 @@ func (app *App) registerHandlers() {
 			adminGroup.GET("/invoices", app.handlers.ListAllInvoicesHandler)
 			adminGroup.GET("/pending-records", app.handlers.ListPendingRecordsHandler)
 
+			driftsGroup := adminGroup.Group("/drifts")
+			{
+				driftsGroup.GET("", app.handlers.ListContractDriftsHandler)
+				driftsGroup.POST("/:drift_id/repair", app.handlers.RepairContractDriftHandler)
+				driftsGroup.POST("/:drift_id/clean", app.handlers.CleanContractDriftHandler)
+			}
+
+			hostKeysGroup := adminGroup.Group("/host-keys")
+			{
+				hostKeysGroup.GET("", app.handlers.ListNodeHostKeysHandler)
+				hostKeysGroup.POST("/:node_name/trust", app.handlers.RetrustNodeHostKeyHandler)
+			}
+
 			vouchersGroup := adminGroup.Group("/vouchers")
 			{
 				vouchersGroup.POST("/generate", app.handlers.GenerateVouchersHandler)
@@ -301,19 +330,21 @@ func (app *App) registerHandlers() {
 		{
 			userGroup.POST("/register", app.handlers.RegisterHandler)
 			userGroup.POST("/register/verify", app.handlers.VerifyRegisterCode)
+			userGroup.GET("/register/workflow/:workflow_id", app.handlers.GetRegistrationStatusHandler)
 			userGroup.POST("/login", app.handlers.LoginUserHandler)
 			userGroup.POST("/refresh", app.handlers.RefreshTokenHandler)
 			userGroup.POST("/forgot_password", app.handlers.ForgotPasswordHandler)
 			userGroup.POST("/forgot_password/verify", app.handlers.VerifyForgetPasswordCodeHandler)
 
 			authGroup := userGroup.Group("")
-			authGroup.Use(middlewares.UserMiddleware(app.handlers.tokenManager))
+			authGroup.Use(middlewares.UserMiddleware(app.handlers.tokenManager, app.handlers.db))
 			{
 				authGroup.GET("/", app.handlers.GetUserHandler)
 				authGroup.PUT("/change_password", app.handlers.ChangePasswordHandler)
 				authGroup.GET("/nodes", app.handlers.ListNodesHandler)
 				authGroup.GET("/nodes/rentable", app.handlers.ListRentableNodesHandler)
 				authGroup.GET("/nodes/rented", app.handlers.ListRentedNodesHandler)
+				authGroup.GET("/nodes/rented/gpus", app.handlers.ListRentedNodeGPUsHandler)
 				authGroup.POST("/nodes/:node_id", app.handlers.ReserveNodeHandler)
 				authGroup.DELETE("/nodes/unreserve/:contract_id", app.handlers.UnreserveNodeHandler)
 				authGroup.POST("/balance/charge", app.handlers.ChargeBalance)
@@ -326,24 +357,59 @@ func (app *App) registerHandlers() {
 				authGroup.GET("/ssh-keys", app.handlers.ListSSHKeysHandler)
 				authGroup.POST("/ssh-keys", app.handlers.AddSSHKeyHandler)
 				authGroup.DELETE("/ssh-keys/:ssh_key_id", app.handlers.DeleteSSHKeyHandler)
+				// API token management
+				authGroup.GET("/api-tokens", app.handlers.ListAPITokensHandler)
+				authGroup.POST("/api-tokens", app.handlers.CreateAPITokenHandler)
+				authGroup.DELETE("/api-tokens/:api_token_id", app.handlers.DeleteAPITokenHandler)
 			}
 		}
 
 		deployerGroup := v1.Group("")
-		deployerGroup.Use(middlewares.UserMiddleware(app.handlers.tokenManager))
+		deployerGroup.Use(middlewares.UserMiddleware(app.handlers.tokenManager, app.handlers.db))
 		{
 			deployerGroup.GET("/events", app.sseManager.HandleSSE)
+			deployerGroup.GET("/workflow/:workflow_id", app.handlers.GetWorkflowStatus)
+			deployerGroup.POST("/workflow/:workflow_id/cancel", app.handlers.HandleCancelWorkflow)
+			deployerGroup.POST("/workflow/:workflow_id/retry", app.handlers.HandleRetryWorkflow)
+			deployerGroup.GET("/workflows", app.handlers.HandleListWorkflows)
+			deployerGroup.GET("/workflows/:workflow_id", app.handlers.HandleGetWorkflow)
 
 			deploymentGroup := deployerGroup.Group("/deployments")
 			{
 				deploymentGroup.POST("", app.handlers.HandleDeployCluster)
+				deploymentGroup.POST("/estimate", app.handlers.HandleEstimateCluster)
+				deploymentGroup.POST("/import", app.handlers.HandleImportDeployment)
 				deploymentGroup.GET("", app.handlers.HandleListDeployments)
 				deploymentGroup.DELETE("", app.handlers.HandleDeleteAllDeployments)
+				deploymentGroup.POST("/cancel-deletion", app.handlers.HandleCancelAllDeletions)
 				deploymentGroup.GET("/:name", app.handlers.HandleGetDeployment)
 				deploymentGroup.GET("/:name/kubeconfig", app.handlers.HandleGetKubeconfig)
+				deploymentGroup.POST("/:name/kubeconfig/rotate", app.handlers.HandleRotateKubeconfig)
+				deploymentGroup.POST("/:name/kubeconfig/rotate-admin", app.handlers.HandleRotateAdminCredential)
+				deploymentGroup.GET("/:name/credentials", app.handlers.HandleListKubeconfigCredentials)
+				deploymentGroup.POST("/:name/credentials", app.handlers.HandleCreateKubeconfigCredential)
+				deploymentGroup.DELETE("/:name/credentials/:credential_id", app.handlers.HandleRevokeKubeconfigCredential)
+				deploymentGroup.GET("/:name/export", app.handlers.HandleExportDeployment)
+				deploymentGroup.GET("/:name/overview", app.handlers.HandleGetDeploymentOverview)
+				deploymentGroup.Any("/:name/k8s/*path", app.handlers.HandleK8sProxy)
 				deploymentGroup.DELETE("/:name", app.handlers.HandleDeleteCluster)
+				deploymentGroup.POST("/:name/cancel-deletion", app.handlers.HandleCancelDeletion)
+				deploymentGroup.PUT("/:name/deletion-protection", app.handlers.HandleSetDeletionProtection)
+				deploymentGroup.PATCH("/:name", app.handlers.HandleUpdateCluster)
+				deploymentGroup.PUT("/:name/metadata", app.handlers.HandleSetDeploymentMetadata)
 				deploymentGroup.POST("/:name/nodes", app.handlers.HandleAddNode)
 				deploymentGroup.DELETE("/:name/nodes/:node_name", app.handlers.HandleRemoveNode)
+				deploymentGroup.POST("/:name/failover", app.handlers.HandlePromoteLeader)
+				deploymentGroup.POST("/:name/upgrade", app.handlers.HandleUpgradeCluster)
+				deploymentGroup.PUT("/:name/addons", app.handlers.HandleSetAddons)
+				deploymentGroup.PUT("/:name/pools/:pool", app.handlers.HandlePutNodePool)
+				deploymentGroup.GET("/:name/snapshots", app.handlers.HandleListSnapshots)
+				deploymentGroup.POST("/:name/snapshots", app.handlers.HandleCreateSnapshot)
+				deploymentGroup.GET("/:name/snapshots/schedule", app.handlers.HandleGetSnapshotSchedule)
+				deploymentGroup.PUT("/:name/snapshots/schedule", app.handlers.HandleSetSnapshotSchedule)
+				deploymentGroup.GET("/:name/snapshots/:snapshot_id/download", app.handlers.HandleDownloadSnapshot)
+				deploymentGroup.POST("/:name/snapshots/:snapshot_id/restore", app.handlers.HandleRestoreSnapshot)
+				deploymentGroup.DELETE("/:name/snapshots/:snapshot_id", app.handlers.HandleDeleteSnapshot)
 			}
 
 			notificationGroup := deployerGroup.Group("/notifications")
@@ -366,7 +432,12 @@ func (app *App) StartBackgroundWorkers() {
 	go app.handlers.TrackUserDebt(app.gridClient)
 	go app.handlers.MonitorSystemBalanceAndHandleSettlement()
 	go app.handlers.TrackClusterHealth()
+	go app.handlers.TrackClusterOverviews()
 	go app.handlers.TrackReservedNodeHealth(app.notificationService, app.handlers.proxyClient)
+	go app.handlers.TrackSnapshotSchedules()
+	go app.handlers.TrackScheduledDeletions()
+	go app.handlers.TrackPendingRollbacks()
+	go app.handlers.TrackContractDrift()
 }
 
 // Run starts the server
@@ -376,7 +447,7 @@ func (app *App) Run() error {
 	// Start command socket
 	go app.startCommandSocket()
 
-	app.handlers.ewfEngine.ResumeRunningWorkflows()
+	go app.handlers.ResumeRunningWorkflows()
 	app.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%s", app.config.Server.Port),
 		Handler: app.router,
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"kubecloud/internal"
+	"kubecloud/internal/kubestats"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/notification"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"gorm.io/gorm"
+)
+
+const clusterWatchRetryDelay = 30 * time.Second
+
+// notification statuses of the important cluster events, by reason
+var clusterEventStatuses = map[string]string{
+	kubestats.ReasonNodeNotReady: "node_not_ready",
+	kubestats.ReasonOOMKilled:    "oom_killed",
+	"OOMKilling":                 "oom_killed",
+	"Evicted":                    "evicted",
+	"TaintManagerEviction":       "evicted",
+}
+
+// clusterEventSource watches the Kubernetes events of the deployments users subscribe to over SSE
+type clusterEventSource struct {
+	h *Handler
+}
+
+func newClusterEventSource(h *Handler) *clusterEventSource {
+	return &clusterEventSource{h: h}
+}
+
+// HasCluster reports whether the user has a deployment with this name
+func (s *clusterEventSource) HasCluster(userID int, cluster string) (bool, error) {
+	_, err := s.h.db.GetClusterByName(userID, kubedeployer.GetProjectName(userID, cluster))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// WatchCluster sends the events of a deployment until ctx is done, watching it again when the cluster can't be reached.
+// Important events are also persisted as cluster notifications.
+func (s *clusterEventSource) WatchCluster(ctx context.Context, userID int, cluster string, send func(internal.SSEMessage)) {
+	for {
+		err := s.watch(ctx, userID, cluster, send)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.GetLogger().Warn().Err(err).Int("user_id", userID).Str("cluster", cluster).Msg("Failed to watch cluster events")
+			send(internal.SSEMessage{
+				Type:      "cluster_watch_error",
+				Severity:  string(models.NotificationSeverityWarning),
+				Data:      map[string]string{"cluster": cluster, "error": err.Error()},
+				Timestamp: time.Now(),
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(clusterWatchRetryDelay):
+		}
+	}
+}
+
+func (s *clusterEventSource) watch(ctx context.Context, userID int, cluster string, send func(internal.SSEMessage)) error {
+	deployment, err := s.h.db.GetClusterByName(userID, kubedeployer.GetProjectName(userID, cluster))
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %w", err)
+	}
+
+	clusterResult, err := deployment.GetClusterResult()
+	if err != nil {
+		return fmt.Errorf("failed to deserialize cluster result: %w", err)
+	}
+
+	adminKubeconfig, err := s.h.getAdminKubeconfig(&deployment, clusterResult)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve kubeconfig: %w", err)
+	}
+
+	return kubestats.Watch(ctx, leaderKubeconfig(adminKubeconfig, clusterResult), func(event kubestats.ClusterEvent) {
+		severity := models.NotificationSeverityInfo
+		if event.Type == "Warning" {
+			severity = models.NotificationSeverityWarning
+		}
+
+		send(internal.SSEMessage{
+			Type:     "cluster_event",
+			Severity: string(severity),
+			Data: map[string]string{
+				"cluster":   cluster,
+				"kind":      event.Kind,
+				"type":      event.Type,
+				"reason":    event.Reason,
+				"namespace": event.Namespace,
+				"object":    event.Object,
+				"message":   event.Message,
+			},
+			Timestamp: event.Time,
+		})
+
+		if event.Important {
+			s.notify(userID, cluster, event)
+		}
+	})
+}
+
+// notify persists an important cluster event as a notification of the user
+func (s *clusterEventSource) notify(userID int, cluster string, event kubestats.ClusterEvent) {
+	status, ok := clusterEventStatuses[event.Reason]
+	if !ok {
+		status = strings.ToLower(event.Reason)
+	}
+
+	payload := notification.MergePayload(notification.CommonPayload{
+		Subject: fmt.Sprintf("%s in cluster %s", event.Reason, cluster),
+		Status:  status,
+		Message: event.Message,
+	}, map[string]string{
+		"cluster":   cluster,
+		"reason":    event.Reason,
+		"namespace": event.Namespace,
+		"object":    event.Object,
+		"timestamp": event.Time.UTC().Format("2006-01-02 15:04:05 UTC"),
+	})
+
+	n := models.NewNotification(userID, models.NotificationTypeCluster, payload)
+	if err := s.h.notificationService.Send(context.Background(), n); err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Str("cluster", cluster).Msg("Failed to send cluster event notification")
+	}
+}
@@ -0,0 +1,433 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kubecloud/internal"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/kubeaccess"
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xmonader/ewf"
+	"gorm.io/gorm"
+)
+
+const (
+	ownerCredentialTTL     = 365 * 24 * time.Hour
+	maxCredentialTTL       = 365 * 24 * time.Hour
+	defaultCredentialTTL   = 30 * 24 * time.Hour
+	credentialIssueTimeout = 2 * time.Minute
+)
+
+// KubeconfigCredentialInput describes a kubeconfig to issue for a deployment
+type KubeconfigCredentialInput struct {
+	Name string `json:"name" binding:"required,max=64"`
+	Role string `json:"role" binding:"required" enums:"admin,edit,view"`
+	Kind string `json:"kind" enums:"token,certificate" default:"token"`
+	// Namespace restricts the role to a namespace, the role applies to the whole cluster when empty
+	Namespace string `json:"namespace,omitempty" binding:"omitempty,max=63"`
+	// TTLHours is the validity of the credential, 30 days when empty
+	TTLHours int `json:"ttl_hours,omitempty" binding:"omitempty,min=1"`
+}
+
+// KubeconfigCredentialResponse holds an issued credential along with its kubeconfig, which is only returned once
+type KubeconfigCredentialResponse struct {
+	Credential models.KubeconfigCredential `json:"credential"`
+	Kubeconfig string                      `json:"kubeconfig"`
+}
+
+// KubeconfigCredentialView is a credential of a deployment as listed to its owner
+type KubeconfigCredentialView struct {
+	models.KubeconfigCredential
+	Active bool `json:"active"`
+}
+
+// leaderKubeconfig points a kubeconfig at the API server of the leader over its Mycelium IP
+func leaderKubeconfig(kubeconfig string, cluster kubedeployer.Cluster) string {
+	if leader, err := cluster.GetLeaderNode(); err == nil && leader.MyceliumIP != "" {
+		return internal.RewriteKubeconfigServer(kubeconfig, leader.MyceliumIP)
+	}
+	return kubeconfig
+}
+
+// newCredentialIssuer creates an issuer talking to the API server of the leader
+func newCredentialIssuer(adminKubeconfig string, cluster kubedeployer.Cluster) (*kubeaccess.Issuer, error) {
+	return kubeaccess.NewIssuer(leaderKubeconfig(adminKubeconfig, cluster))
+}
+
+// getOwnerCredential returns the active owner credential of a deployment, issuing a new one when it expired or was never issued
+func (h *Handler) getOwnerCredential(ctx context.Context, userID int, projectName, adminKubeconfig string, cluster kubedeployer.Cluster) (models.KubeconfigCredential, error) {
+	credential, err := h.db.GetOwnerKubeconfigCredential(userID, projectName)
+	if err == nil && credential.IsActive() && credential.Kubeconfig != "" {
+		return credential, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.KubeconfigCredential{}, fmt.Errorf("failed to get owner credential: %w", err)
+	}
+
+	issuer, err := newCredentialIssuer(adminKubeconfig, cluster)
+	if err != nil {
+		return models.KubeconfigCredential{}, err
+	}
+
+	// an expired owner credential is revoked so only one of them is ever bound
+	if credential.ID != 0 {
+		if err := h.revokeCredential(ctx, issuer, credential); err != nil {
+			return models.KubeconfigCredential{}, err
+		}
+	}
+
+	return h.issueCredential(ctx, issuer, userID, projectName, "owner", true, kubeaccess.Spec{
+		Role: kubeaccess.RoleClusterAdmin,
+		Kind: kubeaccess.KindToken,
+		TTL:  ownerCredentialTTL,
+	})
+}
+
+// issueCredential issues a credential in the cluster and records it
+func (h *Handler) issueCredential(ctx context.Context, issuer *kubeaccess.Issuer, userID int, projectName, name string, owner bool, spec kubeaccess.Spec) (models.KubeconfigCredential, error) {
+	ctx, cancel := context.WithTimeout(ctx, credentialIssueTimeout)
+	defer cancel()
+
+	issued, err := issuer.Issue(ctx, spec)
+	if err != nil {
+		return models.KubeconfigCredential{}, err
+	}
+
+	credential := models.KubeconfigCredential{
+		UserID:      userID,
+		ProjectName: projectName,
+		Name:        name,
+		Role:        spec.Role,
+		Kind:        spec.Kind,
+		Namespace:   spec.Namespace,
+		Subject:     issued.Subject,
+		Owner:       owner,
+		ExpiresAt:   issued.ExpiresAt,
+	}
+	if owner {
+		credential.Kubeconfig = issued.Kubeconfig
+	}
+
+	if err := h.db.CreateKubeconfigCredential(&credential); err != nil {
+		// an unrecorded credential could never be revoked
+		if revokeErr := issuer.Revoke(context.Background(), issued.Subject, spec.Kind, spec.Namespace); revokeErr != nil {
+			logger.GetLogger().Error().Err(revokeErr).Str("subject", issued.Subject).Msg("Failed to revoke unrecorded credential")
+		}
+		return models.KubeconfigCredential{}, fmt.Errorf("failed to save credential: %w", err)
+	}
+
+	credential.Kubeconfig = issued.Kubeconfig
+	return credential, nil
+}
+
+// revokeCredential removes the credential from the cluster and marks it as revoked
+func (h *Handler) revokeCredential(ctx context.Context, issuer *kubeaccess.Issuer, credential models.KubeconfigCredential) error {
+	if err := issuer.Revoke(ctx, credential.Subject, credential.Kind, credential.Namespace); err != nil {
+		return err
+	}
+	if err := h.db.RevokeKubeconfigCredential(credential.ID); err != nil {
+		return fmt.Errorf("failed to mark credential %d as revoked: %w", credential.ID, err)
+	}
+	return nil
+}
+
+// @Summary Rotate owner kubeconfig
+// @Description Revokes the owner kubeconfig of a deployment and issues a new one. The previous token stops working immediately.
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 200 {object} KubeconfigResponse "Kubeconfig rotated successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/kubeconfig/rotate [post]
+func (h *Handler) HandleRotateKubeconfig(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, clusterResult, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	adminKubeconfig, err := h.getAdminKubeconfig(&cluster, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to retrieve admin kubeconfig")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
+		return
+	}
+
+	issuer, err := newCredentialIssuer(adminKubeconfig, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to create credential issuer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reach the cluster"})
+		return
+	}
+
+	current, err := h.db.GetOwnerKubeconfigCredential(config.UserID, cluster.ProjectName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to get owner credential")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup kubeconfig"})
+		return
+	}
+	if err == nil {
+		if err := h.revokeCredential(c.Request.Context(), issuer, current); err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to revoke owner credential")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke kubeconfig: " + err.Error()})
+			return
+		}
+	}
+
+	credential, err := h.issueCredential(c.Request.Context(), issuer, config.UserID, cluster.ProjectName, "owner", true, kubeaccess.Spec{
+		Role: kubeaccess.RoleClusterAdmin,
+		Kind: kubeaccess.KindToken,
+		TTL:  ownerCredentialTTL,
+	})
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to issue owner credential")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue kubeconfig: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, KubeconfigResponse{Kubeconfig: credential.Kubeconfig})
+}
+
+// @Summary Rotate cluster admin credential
+// @Description Replaces the client CA of a deployment, which revokes the k3s admin kubeconfig and every certificate credential.
+// @Description Every node is restarted to load the new CA. Token credentials, including the owner kubeconfig, keep working.
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 202 {object} Response "Admin credential rotation started successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/kubeconfig/rotate-admin [post]
+func (h *Handler) HandleRotateAdminCredential(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, cl, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowRotateAdminCredential)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":  config,
+		"cluster": cl,
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Admin credential rotation started successfully",
+	})
+}
+
+// @Summary List deployment credentials
+// @Description Lists the kubeconfig credentials issued for a deployment, including revoked and expired ones
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 200 {array} KubeconfigCredentialView "Credentials retrieved successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/credentials [get]
+func (h *Handler) HandleListKubeconfigCredentials(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	credentials, err := h.db.ListKubeconfigCredentials(config.UserID, cluster.ProjectName)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to list kubeconfig credentials")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve credentials"})
+		return
+	}
+
+	views := make([]KubeconfigCredentialView, 0, len(credentials))
+	for _, credential := range credentials {
+		views = append(views, KubeconfigCredentialView{KubeconfigCredential: credential, Active: credential.IsActive()})
+	}
+
+	c.JSON(http.StatusOK, views)
+}
+
+// @Summary Create deployment credential
+// @Description Issues a kubeconfig bound to the admin, edit or view role, for the whole cluster or a single namespace.
+// @Description A token credential is a service account token, a certificate credential is a client certificate signed by the cluster.
+// @Description The kubeconfig is only returned in this response.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param credential body KubeconfigCredentialInput true "Credential to issue"
+// @Success 201 {object} KubeconfigCredentialResponse "Credential issued successfully"
+// @Failure 400 {object} APIResponse "Invalid request"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/credentials [post]
+func (h *Handler) HandleCreateKubeconfigCredential(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var input KubeconfigCredentialInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+		return
+	}
+
+	spec := kubeaccess.Spec{
+		Role:      input.Role,
+		Kind:      input.Kind,
+		Namespace: input.Namespace,
+		TTL:       time.Duration(input.TTLHours) * time.Hour,
+	}
+	if spec.Kind == "" {
+		spec.Kind = kubeaccess.KindToken
+	}
+	if spec.TTL == 0 {
+		spec.TTL = defaultCredentialTTL
+	}
+	if err := kubeaccess.ValidateSpec(spec, maxCredentialTTL); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	cluster, clusterResult, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	adminKubeconfig, err := h.getAdminKubeconfig(&cluster, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to retrieve admin kubeconfig")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
+		return
+	}
+
+	issuer, err := newCredentialIssuer(adminKubeconfig, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to create credential issuer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reach the cluster"})
+		return
+	}
+
+	credential, err := h.issueCredential(c.Request.Context(), issuer, config.UserID, cluster.ProjectName, input.Name, false, spec)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to issue kubeconfig credential")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue credential: " + err.Error()})
+		return
+	}
+
+	kubeconfig := credential.Kubeconfig
+	credential.Kubeconfig = ""
+	c.JSON(http.StatusCreated, KubeconfigCredentialResponse{Credential: credential, Kubeconfig: kubeconfig})
+}
+
+// @Summary Revoke deployment credential
+// @Description Revokes a kubeconfig credential of a deployment by removing its role binding, and its service account for tokens
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param credential_id path int true "Credential ID"
+// @Success 200 {object} APIResponse "Credential revoked successfully"
+// @Failure 400 {object} APIResponse "Invalid request"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment or credential not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/credentials/{credential_id} [delete]
+func (h *Handler) HandleRevokeKubeconfigCredential(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	credentialID, err := strconv.Atoi(c.Param("credential_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid credential ID"})
+		return
+	}
+
+	cluster, clusterResult, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	credential, err := h.db.GetKubeconfigCredential(config.UserID, cluster.ProjectName, credentialID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "credential not found"})
+		return
+	} else if err != nil {
+		logger.GetLogger().Error().Err(err).Int("credential_id", credentialID).Msg("Failed to get kubeconfig credential")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup credential"})
+		return
+	}
+	if credential.RevokedAt != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "credential is already revoked"})
+		return
+	}
+
+	adminKubeconfig, err := h.getAdminKubeconfig(&cluster, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to retrieve admin kubeconfig")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
+		return
+	}
+
+	issuer, err := newCredentialIssuer(adminKubeconfig, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to create credential issuer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reach the cluster"})
+		return
+	}
+
+	if err := h.revokeCredential(c.Request.Context(), issuer, credential); err != nil {
+		logger.GetLogger().Error().Err(err).Int("credential_id", credentialID).Msg("Failed to revoke kubeconfig credential")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke credential: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "credential revoked successfully"})
+}
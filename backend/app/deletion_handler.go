@@ -0,0 +1,268 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/notification"
+	"kubecloud/internal/statemanager"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xmonader/ewf"
+)
+
+const (
+	maxDeletionGracePeriod          = 30 * 24 * time.Hour
+	scheduledDeletionsCheckInterval = time.Minute
+)
+
+// DeletionProtectionInput turns the deletion protection of a deployment on or off
+type DeletionProtectionInput struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// parseGracePeriod reads the optional grace_period query of the delete requests, e.g. 24h
+func parseGracePeriod(c *gin.Context) (time.Duration, error) {
+	value := c.Query("grace_period")
+	if value == "" {
+		return 0, nil
+	}
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil || gracePeriod < 0 || gracePeriod > maxDeletionGracePeriod {
+		return 0, fmt.Errorf("grace_period must be a duration between 0 and %s, e.g. 24h", maxDeletionGracePeriod)
+	}
+	return gracePeriod, nil
+}
+
+// clusterDisplayName returns the name the user gave to a cluster
+func clusterDisplayName(cluster *models.Cluster) string {
+	if cl, err := cluster.GetClusterResult(); err == nil && cl.Name != "" {
+		return cl.Name
+	}
+	return cluster.ProjectName
+}
+
+// rejectDeletingCluster responds with 409 when the cluster is being deleted or pending deletion,
+// its nodes must not change anymore
+func rejectDeletingCluster(c *gin.Context, cluster *models.Cluster) bool {
+	if cluster.Status != models.ClusterStatusDeleting && !cluster.PendingDeletion() {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "deployment is being deleted or pending deletion, cancel its deletion first"})
+	return true
+}
+
+// notifyDeletion tells the user the deletion of some of their deployments was scheduled or cancelled
+func (h *Handler) notifyDeletion(userID int, status string, names []string, message string) {
+	payload := notification.MergePayload(notification.CommonPayload{
+		Subject: fmt.Sprintf("Deletion of %s %s", strings.Join(names, ", "), strings.ReplaceAll(strings.TrimPrefix(status, "deletion_"), "_", " ")),
+		Status:  status,
+		Message: message,
+	}, map[string]string{
+		"workflow_name": "Deleting Cluster",
+		"cluster_name":  strings.Join(names, ", "),
+		"timestamp":     time.Now().Local().Format("2006-01-02 15:04:05"),
+	})
+
+	n := models.NewNotification(userID, models.NotificationTypeDeployment, payload)
+	if err := h.notificationService.Send(context.Background(), n); err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Str("status", status).Msg("Failed to send deletion notification")
+	}
+}
+
+// scheduleDeletion marks clusters as pending deletion for gracePeriod and notifies the user, it returns the time they will be deleted at
+func (h *Handler) scheduleDeletion(userID int, clusters []models.Cluster, gracePeriod time.Duration) (time.Time, error) {
+	at := time.Now().Add(gracePeriod)
+	names := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		scheduled, err := h.db.ScheduleClusterDeletion(cluster.ID, at)
+		if err != nil {
+			return at, fmt.Errorf("failed to schedule deletion of %s: %w", cluster.ProjectName, err)
+		}
+		if scheduled {
+			names = append(names, clusterDisplayName(&cluster))
+		}
+	}
+
+	if len(names) > 0 {
+		h.notifyDeletion(userID, "deletion_scheduled", names, fmt.Sprintf(
+			"Deployments %s will be deleted at %s. The deletion can be cancelled until then.",
+			strings.Join(names, ", "), at.UTC().Format(time.RFC3339),
+		))
+	}
+	return at, nil
+}
+
+// @Summary Set deployment deletion protection
+// @Description Turns the deletion protection of a deployment on or off. Requests to delete a protected deployment,
+// @Description or all deployments while one is protected, fail with 409. Turning it on also cancels a scheduled deletion.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param protection body DeletionProtectionInput true "Deletion protection"
+// @Success 200 {object} APIResponse "Deletion protection updated successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/deletion-protection [put]
+func (h *Handler) HandleSetDeletionProtection(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var input DeletionProtectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, statemanager.ClientConfig{UserID: userID})
+	if !ok {
+		return
+	}
+
+	if err := h.db.SetClusterDeletionProtection(cluster.ID, *input.Enabled); err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to update deletion protection")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update deletion protection"})
+		return
+	}
+
+	if *input.Enabled && cluster.PendingDeletion() {
+		h.notifyDeletion(userID, "deletion_cancelled", []string{clusterDisplayName(&cluster)}, "The scheduled deletion was cancelled by turning on deletion protection.")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Deletion protection updated successfully",
+		"deletion_protection": *input.Enabled,
+	})
+}
+
+// @Summary Cancel deployment deletion
+// @Description Cancels the scheduled deletion of a deployment deleted with a grace period, as long as its contracts aren't being cancelled yet
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 200 {object} APIResponse "Deletion cancelled successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 409 {object} APIResponse "Deployment is not pending deletion"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/cancel-deletion [post]
+func (h *Handler) HandleCancelDeletion(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	cluster, _, ok := h.getUserDeployment(c, statemanager.ClientConfig{UserID: userID})
+	if !ok {
+		return
+	}
+
+	cancelled, err := h.db.CancelClusterDeletion(cluster.ID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to cancel deletion")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel deletion"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "deployment is not pending deletion"})
+		return
+	}
+
+	h.notifyDeletion(userID, "deletion_cancelled", []string{clusterDisplayName(&cluster)}, "The scheduled deletion was cancelled.")
+	c.JSON(http.StatusOK, gin.H{"message": "Deletion cancelled successfully"})
+}
+
+// @Summary Cancel all deployment deletions
+// @Description Cancels the scheduled deletion of every deployment of the user pending deletion
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} APIResponse "Deletions cancelled successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/cancel-deletion [post]
+func (h *Handler) HandleCancelAllDeletions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	clusters, err := h.db.ListUserClusters(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deployments"})
+		return
+	}
+
+	var names []string
+	for _, cluster := range clusters {
+		if !cluster.PendingDeletion() {
+			continue
+		}
+		cancelled, err := h.db.CancelClusterDeletion(cluster.ID)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to cancel deletion")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel deletion"})
+			return
+		}
+		if cancelled {
+			names = append(names, clusterDisplayName(&cluster))
+		}
+	}
+
+	if len(names) > 0 {
+		h.notifyDeletion(userID, "deletion_cancelled", names, "The scheduled deletion was cancelled.")
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Deletions cancelled successfully",
+		"deployments": names,
+	})
+}
+
+// TrackScheduledDeletions starts the deletion workflow of the clusters whose deletion grace period is over
+func (h *Handler) TrackScheduledDeletions() {
+	ticker := time.NewTicker(scheduledDeletionsCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		clusters, err := h.db.ListDueClusterDeletions(now)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Msg("Failed to list scheduled cluster deletions")
+			continue
+		}
+
+		for _, cluster := range clusters {
+			config, err := h.clientConfigForUser(cluster.UserID)
+			if err != nil {
+				logger.GetLogger().Error().Err(err).Int("user_id", cluster.UserID).Msg("Failed to get client config for scheduled deletion")
+				continue
+			}
+
+			wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowDeleteCluster)
+			if err != nil {
+				logger.GetLogger().Error().Err(err).Msg("Failed to create delete workflow")
+				continue
+			}
+			wf.State = ewf.State{
+				"config":       config,
+				"project_name": cluster.ProjectName,
+			}
+
+			// claiming the deletion makes it final, a concurrent cancellation wins before that
+			claimed, err := h.db.ClaimClusterDeletion(cluster.ID, now)
+			if err != nil {
+				logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to claim scheduled cluster deletion")
+				continue
+			}
+			if !claimed {
+				continue
+			}
+
+			h.ewfEngine.RunAsync(context.Background(), wf)
+		}
+	}
+}
@@ -8,8 +8,11 @@ import (
 	"kubecloud/internal/constants"
 	"kubecloud/internal/statemanager"
 	"kubecloud/kubedeployer"
+	"kubecloud/models"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"kubecloud/internal/logger"
 
@@ -25,19 +28,31 @@ type Response struct {
 	Message    string `json:"message"`
 }
 
+// DeployClusterResponse is the deployment workflow response along with the nodes placed automatically
+type DeployClusterResponse struct {
+	Response
+	Placement []kubedeployer.Placement `json:"placement,omitempty"`
+}
+
 // DeploymentResponse represents the response for deployment operations
 type DeploymentResponse struct {
-	ID          int         `json:"id"`
-	ProjectName string      `json:"project_name"`
-	Cluster     interface{} `json:"cluster"`
-	CreatedAt   string      `json:"created_at"`
-	UpdatedAt   string      `json:"updated_at"`
+	ID                  int               `json:"id"`
+	ProjectName         string            `json:"project_name"`
+	Cluster             interface{}       `json:"cluster"`
+	Status              string            `json:"status"`
+	Description         string            `json:"description"`
+	Labels              map[string]string `json:"labels"`
+	CreatedAt           string            `json:"created_at"`
+	UpdatedAt           string            `json:"updated_at"`
+	DeletionProtection  bool              `json:"deletion_protection"`
+	DeletionScheduledAt *string           `json:"deletion_scheduled_at,omitempty"` // set while the deployment is pending deletion
 }
 
 // DeploymentListResponse represents the response for listing deployments
 type DeploymentListResponse struct {
 	Deployments []DeploymentResponse `json:"deployments"`
 	Count       int                  `json:"count"`
+	NextCursor  string               `json:"next_cursor,omitempty"` // set when more deployments match the query
 }
 
 // KubeconfigResponse represents the response for kubeconfig requests
@@ -50,13 +65,21 @@ type ClusterInput struct {
 	Name  string      `json:"name" binding:"required"`
 	Token string      `json:"token"`
 	Nodes []NodeInput `json:"nodes" binding:"required"`
+	// CIDR is the private /16 network of the cluster, a range not used by the other clusters is picked when empty.
+	// 10.42.0.0/16 and 10.43.0.0/16 are the pod and service ranges of k3s and can't be used.
+	CIDR string `json:"cidr" example:"10.20.0.0/16"`
+	// IPv6CIDR is an optional unique local /48 to /55 prefix holding the IPv6 pod and service ranges of the cluster.
+	// Nodes don't get a private IPv6 address from it, they use their mycelium address for IPv6.
+	IPv6CIDR string `json:"ipv6_cidr" example:"fd12:3456:789a::/48"`
+	// Addons are installed once the cluster is ready
+	Addons []kubedeployer.Addon `json:"addons"`
 }
 
 // NodeInput represents the input structure for node configuration
 type NodeInput struct {
 	Name       string            `json:"name" binding:"required"`
 	Type       string            `json:"type" binding:"required" enums:"worker,master,leader"`
-	NodeID     uint32            `json:"node_id" binding:"required"`
+	NodeID     uint32            `json:"node_id"` // Optional, placed automatically when empty
 	CPU        uint8             `json:"cpu" binding:"required"`
 	Memory     uint64            `json:"memory" binding:"required"`    // Memory in MB
 	RootSize   uint64            `json:"root_size" binding:"required"` // Storage in MB
@@ -65,14 +88,27 @@ type NodeInput struct {
 	GPUIDs     []string          `json:"gpu_ids,omitempty"`            // List of GPU IDs
 	Flist      string            `json:"flist,omitempty"`
 	Entrypoint string            `json:"entrypoint,omitempty"`
+	PublicIPv4 bool              `json:"public_ipv4,omitempty"` // Reserve a public IPv4 of the farm
+	PublicIPv6 bool              `json:"public_ipv6,omitempty"` // Reserve a public IPv6 of the farm
 }
 
 // @Summary List deployments
-// @Description Retrieves a list of all deployments (clusters) for the authenticated user
+// @Description Retrieves a page of the deployments (clusters) of the authenticated user matching the filters.
+// @Description Pass the returned next_cursor as cursor, with the same filters and order, to get the next page.
 // @Tags deployments
 // @Security BearerAuth
 // @Produce json
+// @Param label_selector query string false "Kubernetes label selector, e.g. env=prod,team in (a,b),!deprecated"
+// @Param name_prefix query string false "Prefix of the deployment names"
+// @Param status query string false "Comma separated statuses, e.g. active,degraded"
+// @Param created_after query string false "RFC 3339 time the deployments were created at or after"
+// @Param created_before query string false "RFC 3339 time the deployments were created before"
+// @Param sort query string false "Sort field: created_at (default), updated_at or name"
+// @Param order query string false "Sort order: asc (default) or desc"
+// @Param limit query int false "Page size, 50 by default and at most 200"
+// @Param cursor query string false "next_cursor of the previous page"
 // @Success 200 {object} DeploymentListResponse "Deployments retrieved successfully"
+// @Failure 400 {object} APIResponse "Invalid query"
 // @Failure 401 {object} APIResponse "Unauthorized"
 // @Failure 500 {object} APIResponse "Internal server error"
 // @Router /deployments [get]
@@ -83,7 +119,13 @@ func (h *Handler) HandleListDeployments(c *gin.Context) {
 		return
 	}
 
-	clusters, err := h.db.ListUserClusters(userID)
+	query, err := parseDeploymentListQuery(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clusters, nextCursor, err := h.listDeploymentsPage(userID, query)
 	if err != nil {
 		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("Failed to list user clusters")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deployments"})
@@ -99,18 +141,27 @@ func (h *Handler) HandleListDeployments(c *gin.Context) {
 		}
 
 		deployments = append(deployments, gin.H{
-			"id":           cluster.ID,
-			"project_name": cluster.ProjectName,
-			"cluster":      clusterResult,
-			"created_at":   cluster.CreatedAt,
-			"updated_at":   cluster.UpdatedAt,
+			"id":                    cluster.ID,
+			"project_name":          cluster.ProjectName,
+			"cluster":               clusterResult,
+			"status":                cluster.Status,
+			"description":           cluster.Description,
+			"labels":                cluster.Labels,
+			"created_at":            cluster.CreatedAt,
+			"updated_at":            cluster.UpdatedAt,
+			"deletion_protection":   cluster.DeletionProtection,
+			"deletion_scheduled_at": cluster.DeletionScheduledAt,
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"deployments": deployments,
 		"count":       len(deployments),
-	})
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // @Summary Get deployment
@@ -159,22 +210,32 @@ func (h *Handler) HandleGetDeployment(c *gin.Context) {
 	}
 
 	response := gin.H{
-		"id":           cluster.ID,
-		"project_name": cluster.ProjectName,
-		"cluster":      clusterResult,
-		"created_at":   cluster.CreatedAt,
-		"updated_at":   cluster.UpdatedAt,
+		"id":                    cluster.ID,
+		"project_name":          cluster.ProjectName,
+		"cluster":               clusterResult,
+		"status":                cluster.Status,
+		"description":           cluster.Description,
+		"labels":                cluster.Labels,
+		"created_at":            cluster.CreatedAt,
+		"updated_at":            cluster.UpdatedAt,
+		"deletion_protection":   cluster.DeletionProtection,
+		"deletion_scheduled_at": cluster.DeletionScheduledAt,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
 // @Summary Get kubeconfig
-// @Description Retrieves the kubeconfig file for a specific deployment
+// @Description Retrieves the owner kubeconfig of a specific deployment. It holds a cluster-admin service account token,
+// @Description issued on first use and rotated with POST /deployments/{name}/kubeconfig/rotate.
+// @Description With endpoint=public the API server address is the public IP of a control plane node instead of its Mycelium IP.
+// @Description With endpoint=proxy the kubeconfig points at the Kubernetes API proxy of the backend with a k8s API token
+// @Description of the deployment valid for 30 days. Generating a proxy kubeconfig revokes the token of the previous one.
 // @Tags deployments
 // @Security BearerAuth
 // @Produce json
 // @Param name path string true "Deployment name"
+// @Param endpoint query string false "API server address to use, mycelium, public or proxy" default(mycelium)
 // @Success 200 {object} KubeconfigResponse "Kubeconfig retrieved successfully"
 // @Failure 400 {object} APIResponse "Invalid request"
 // @Failure 401 {object} APIResponse "Unauthorized"
@@ -194,6 +255,13 @@ func (h *Handler) HandleGetKubeconfig(c *gin.Context) {
 		return
 	}
 
+	endpoint := c.DefaultQuery("endpoint", "mycelium")
+	if endpoint != "mycelium" && endpoint != "public" && endpoint != "proxy" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endpoint must be mycelium, public or proxy"})
+		return
+	}
+
+	deploymentName := projectName
 	projectName = kubedeployer.GetProjectName(userID, projectName)
 	cluster, err := h.db.GetClusterByName(userID, projectName)
 	if err != nil {
@@ -207,11 +275,6 @@ func (h *Handler) HandleGetKubeconfig(c *gin.Context) {
 		return
 	}
 
-	if cluster.Kubeconfig != "" {
-		c.JSON(http.StatusOK, gin.H{"kubeconfig": cluster.Kubeconfig})
-		return
-	}
-
 	clusterResult, err := cluster.GetClusterResult()
 	if err != nil {
 		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to deserialize cluster result")
@@ -219,48 +282,70 @@ func (h *Handler) HandleGetKubeconfig(c *gin.Context) {
 		return
 	}
 
-	var targetNode *kubedeployer.Node
-	for _, node := range clusterResult.Nodes {
-		if node.Type == kubedeployer.NodeTypeLeader {
-			targetNode = &node
-			break
+	if endpoint == "proxy" {
+		kubeconfig, err := h.issueProxyKubeconfig(c, userID, deploymentName)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to issue proxy kubeconfig")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue kubeconfig"})
+			return
 		}
+		c.JSON(http.StatusOK, gin.H{"kubeconfig": kubeconfig})
+		return
 	}
 
-	if targetNode == nil {
-		for _, node := range clusterResult.Nodes {
-			if node.Type == kubedeployer.NodeTypeMaster {
-				targetNode = &node
-				break
-			}
+	var publicAddress string
+	if endpoint == "public" {
+		publicAddress = clusterResult.PublicAPIAddress()
+		if publicAddress == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no control plane node of the deployment has a public IP"})
+			return
 		}
 	}
 
-	if targetNode == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No leader or master node found in deployment"})
+	adminKubeconfig, err := h.getAdminKubeconfig(&cluster, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to retrieve admin kubeconfig")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
 		return
 	}
 
-	privateKeyBytes, err := os.ReadFile(h.config.SSH.PrivateKeyPath)
+	credential, err := h.getOwnerCredential(c.Request.Context(), userID, projectName, adminKubeconfig, clusterResult)
 	if err != nil {
-		logger.GetLogger().Error().Err(err).Str("key_path", h.config.SSH.PrivateKeyPath).Msg("Failed to read SSH private key")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read SSH configuration"})
+		logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to issue owner kubeconfig")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue kubeconfig: " + err.Error()})
 		return
 	}
 
-	kubeconfig, err := internal.GetKubeconfigViaSSH(string(privateKeyBytes), targetNode)
+	c.JSON(http.StatusOK, gin.H{"kubeconfig": internal.RewriteKubeconfigServer(credential.Kubeconfig, publicAddress)})
+}
+
+// getAdminKubeconfig returns the k3s admin kubeconfig of a cluster, fetching it from the control plane when it is not stored yet.
+// It is only used by the backend itself, users get credentials issued with it.
+func (h *Handler) getAdminKubeconfig(cluster *models.Cluster, clusterResult kubedeployer.Cluster) (string, error) {
+	if cluster.Kubeconfig != "" {
+		return cluster.Kubeconfig, nil
+	}
+
+	if len(clusterResult.GetControlPlaneNodes()) == 0 {
+		return "", fmt.Errorf("no leader or master node found in deployment")
+	}
+
+	privateKeyBytes, err := os.ReadFile(h.config.SSH.PrivateKeyPath)
 	if err != nil {
-		logger.GetLogger().Error().Err(err).Str("node_name", targetNode.Name).Msg("Failed to retrieve kubeconfig via SSH")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
-		return
+		return "", fmt.Errorf("failed to read SSH private key: %w", err)
+	}
+
+	kubeconfig, _, err := internal.GetKubeconfigFromControlPlane(string(privateKeyBytes), clusterResult)
+	if err != nil {
+		return "", err
 	}
 
 	cluster.Kubeconfig = kubeconfig
-	if err := h.db.UpdateCluster(&cluster); err != nil {
+	if err := h.db.UpdateCluster(cluster); err != nil {
 		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to save kubeconfig to database")
 	}
 
-	c.JSON(http.StatusOK, gin.H{"kubeconfig": kubeconfig})
+	return kubeconfig, nil
 }
 
 func (h *Handler) getClientConfig(c *gin.Context) (statemanager.ClientConfig, error) {
@@ -269,6 +354,11 @@ func (h *Handler) getClientConfig(c *gin.Context) (statemanager.ClientConfig, er
 		return statemanager.ClientConfig{}, fmt.Errorf("user_id not found in context")
 	}
 
+	return h.clientConfigForUser(userID)
+}
+
+// clientConfigForUser returns the deployer configuration acting on behalf of a user
+func (h *Handler) clientConfigForUser(userID int) (statemanager.ClientConfig, error) {
 	user, err := h.db.GetUserByID(userID)
 	if err != nil {
 		return statemanager.ClientConfig{}, fmt.Errorf("failed to get user: %v", err)
@@ -283,16 +373,59 @@ func (h *Handler) getClientConfig(c *gin.Context) (statemanager.ClientConfig, er
 	}, nil
 }
 
+var errClusterCIDROverlap = errors.New("cluster network range overlaps another cluster")
+
+// assignClusterCIDR picks a private range for a cluster that has none, or checks that the requested one
+// does not overlap the other clusters of the user so that their networks can be peered
+func (h *Handler) assignClusterCIDR(userID int, cluster *kubedeployer.Cluster) error {
+	clusters, err := h.db.ListUserClusters(userID)
+	if err != nil {
+		return fmt.Errorf("failed to list user clusters: %w", err)
+	}
+
+	used := make([]string, 0, len(clusters))
+	for _, existing := range clusters {
+		result, err := existing.GetClusterResult()
+		if err != nil {
+			return fmt.Errorf("failed to get cluster result of %s: %w", existing.ProjectName, err)
+		}
+		cidr := result.CIDR
+		if cidr == "" {
+			cidr = result.Network.IPRange.String()
+		}
+		used = append(used, cidr)
+
+		if cluster.IPv6CIDR != "" && result.IPv6CIDR != "" && kubedeployer.CIDRsOverlap(cluster.IPv6CIDR, result.IPv6CIDR) {
+			return fmt.Errorf("%w: ipv6_cidr %s is used by cluster %s", errClusterCIDROverlap, result.IPv6CIDR, result.Name)
+		}
+	}
+
+	if cluster.CIDR == "" {
+		cluster.CIDR, err = kubedeployer.NextFreeClusterCIDR(used)
+		return err
+	}
+
+	for i, cidr := range used {
+		if kubedeployer.CIDRsOverlap(cluster.CIDR, cidr) {
+			return fmt.Errorf("%w: cidr %s is used by cluster %s", errClusterCIDROverlap, cidr, clusters[i].ProjectName)
+		}
+	}
+	return nil
+}
+
 // @Summary Deploy cluster
-// @Description Creates and deploys a new Kubernetes cluster
+// @Description Creates and deploys a new Kubernetes cluster. Nodes without a node_id are placed on the user's rented nodes, masters on different nodes.
 // @Tags deployments
 // @Security BearerAuth
 // @Accept json
 // @Produce json
+// @Param fallback_rentable query bool false "Reserve rentable nodes when the rented nodes don't have enough capacity"
+// @Param retry_window query string false "How long the contracts of a failed deployment are kept for it to be retried, e.g. 2h. Without it a failed deployment is rolled back right away and can't be retried"
 // @Param cluster body ClusterInput true "Cluster configuration"
-// @Success 202 {object} Response "Deployment workflow started successfully"
+// @Success 202 {object} DeployClusterResponse "Deployment workflow started successfully"
 // @Failure 400 {object} APIResponse "Invalid request format"
 // @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 409 {object} APIResponse "Deployment already exists or a failed deployment with this name is kept for retry"
 // @Failure 500 {object} APIResponse "Internal server error"
 // @Router /deployments [post]
 func (h *Handler) HandleDeployCluster(c *gin.Context) {
@@ -324,8 +457,77 @@ func (h *Handler) HandleDeployCluster(c *gin.Context) {
 		return
 	}
 
+	// the contracts of a failed deployment kept for retry use the name until its rollback is claimed
+	keptWorkflowID, err := h.deploymentKeptForRetry(c.Request.Context(), config.UserID, cluster.Name)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Str("project_name", projectName).Msg("Failed to check failed deployments kept for retry")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check existing deployments"})
+		return
+	}
+	if keptWorkflowID != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "a failed deployment with this name is kept for retry, retry or cancel it first", "workflow_id": keptWorkflowID})
+		return
+	}
+
+	if err := h.assignClusterCIDR(config.UserID, &cluster); errors.Is(err, errClusterCIDROverlap) {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	} else if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Msg("Failed to assign cluster network range")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to assign cluster network range"})
+		return
+	}
+
+	fallbackRentable, err := strconv.ParseBool(c.DefaultQuery("fallback_rentable", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fallback_rentable must be a boolean"})
+		return
+	}
+
+	retryWindow, err := parseRetryWindow(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	placements, rentable, err := h.placeClusterNodes(c.Request.Context(), config.UserID, &cluster, fallbackRentable)
+	var unschedulable *kubedeployer.UnschedulableError
+	if errors.As(err, &unschedulable) {
+		Error(c, http.StatusBadRequest, "Failed to place cluster nodes", err.Error())
+		return
+	} else if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Msg("Failed to place cluster nodes")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to place cluster nodes"})
+		return
+	}
+
+	// placement filled the node ids, check them like the ones given by the user
+	if err := cluster.Validate(); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	var reserveNodes []uint32
+	if len(rentable) > 0 {
+		user, err := h.db.GetUserByID(config.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+			return
+		}
+
+		reserveNodes, err = h.nodesToReserve(user, placements, rentable)
+		if errors.Is(err, errInsufficientRentBalance) {
+			Error(c, http.StatusBadRequest, "Failed to reserve nodes", err.Error())
+			return
+		} else if err != nil {
+			logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Msg("Failed to check the rent of placed nodes")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reserve nodes for the cluster"})
+			return
+		}
+	}
+
 	wfName := fmt.Sprintf("deploy-%d-nodes", len(cluster.Nodes))
-	activities.NewDynamicDeployWorkflowTemplate(h.ewfEngine, h.metrics, h.notificationService, wfName, len(cluster.Nodes))
+	activities.NewDynamicDeployWorkflowTemplate(h.ewfEngine, h.metrics, h.db, h.notificationService, wfName, len(cluster.Nodes))
 
 	// Get the workflow
 	wf, err := h.ewfEngine.NewWorkflow(wfName)
@@ -338,26 +540,39 @@ func (h *Handler) HandleDeployCluster(c *gin.Context) {
 		"config":  config,
 		"cluster": cluster,
 	}
+	if retryWindow > 0 {
+		wf.State["retry_window"] = retryWindow
+	}
+	// the nodes are rented by the workflow, its rollback unreserves them when the deployment fails
+	if len(reserveNodes) > 0 {
+		wf.State["reserve_nodes"] = reserveNodes
+	}
 
-	h.ewfEngine.RunAsync(c, wf)
+	h.startCancellableWorkflow(wf)
 
-	c.JSON(http.StatusAccepted, Response{
-		WorkflowID: wf.UUID,
-		Status:     string(wf.Status),
-		Message:    "Deployment workflow started successfully",
+	c.JSON(http.StatusAccepted, DeployClusterResponse{
+		Response: Response{
+			WorkflowID: wf.UUID,
+			Status:     string(wf.Status),
+			Message:    "Deployment workflow started successfully",
+		},
+		Placement: placements,
 	})
 }
 
 // @Summary Delete deployment
-// @Description Deletes a specific deployment and all its resources
+// @Description Deletes a specific deployment and all its resources. With a grace_period the deployment is only marked
+// @Description as pending deletion, its contracts are cancelled once the grace period is over unless the deletion is cancelled.
 // @Tags deployments
 // @Security BearerAuth
 // @Produce json
 // @Param name path string true "Deployment name"
-// @Success 202 {object} Response "Deployment deletion workflow started successfully"
+// @Param grace_period query string false "Delay before the contracts are cancelled, e.g. 24h, at most 720h"
+// @Success 202 {object} Response "Deployment deletion workflow started or scheduled successfully"
 // @Failure 400 {object} APIResponse "Invalid request"
 // @Failure 401 {object} APIResponse "Unauthorized"
 // @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 409 {object} APIResponse "Deployment is protected from deletion or already being deleted"
 // @Failure 500 {object} APIResponse "Internal server error"
 // @Router /deployments/{name} [delete]
 func (h *Handler) HandleDeleteCluster(c *gin.Context) {
@@ -372,8 +587,14 @@ func (h *Handler) HandleDeleteCluster(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "deployment name is required"})
 		return
 	}
+	gracePeriod, err := parseGracePeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	projectName := kubedeployer.GetProjectName(config.UserID, deploymentName)
-	_, err = h.db.GetClusterByName(config.UserID, projectName)
+	cluster, err := h.db.GetClusterByName(config.UserID, projectName)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
@@ -384,6 +605,25 @@ func (h *Handler) HandleDeleteCluster(c *gin.Context) {
 		return
 	}
 
+	if cluster.DeletionProtection {
+		c.JSON(http.StatusConflict, gin.H{"error": "deployment is protected from deletion, turn off its deletion protection first"})
+		return
+	}
+
+	if gracePeriod > 0 {
+		at, err := h.scheduleDeletion(config.UserID, []models.Cluster{cluster}, gracePeriod)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to schedule deployment deletion")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to schedule deployment deletion"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":               "Deployment deletion scheduled successfully",
+			"deletion_scheduled_at": at,
+		})
+		return
+	}
+
 	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowDeleteCluster)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
@@ -395,6 +635,18 @@ func (h *Handler) HandleDeleteCluster(c *gin.Context) {
 		"project_name": projectName,
 	}
 
+	// deleting right away supersedes a scheduled deletion, the deployment stays deleting until the workflow ends
+	marked, err := h.db.MarkClusterDeleting(cluster.ID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to mark deployment as deleting")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		return
+	}
+	if !marked {
+		c.JSON(http.StatusConflict, gin.H{"error": "deployment is protected from deletion or already being deleted"})
+		return
+	}
+
 	h.ewfEngine.RunAsync(c, wf)
 
 	c.JSON(http.StatusAccepted, Response{
@@ -405,12 +657,16 @@ func (h *Handler) HandleDeleteCluster(c *gin.Context) {
 }
 
 // @Summary Delete all deployments
-// @Description Deletes all deployments and their resources for the authenticated user
+// @Description Deletes all deployments and their resources for the authenticated user. Nothing is deleted while one of them
+// @Description is protected from deletion. With a grace_period the deployments are only marked as pending deletion.
 // @Tags deployments
 // @Security BearerAuth
 // @Produce json
-// @Success 202 {object} Response "Delete all deployments workflow started successfully"
+// @Param grace_period query string false "Delay before the contracts are cancelled, e.g. 24h, at most 720h"
+// @Success 202 {object} Response "Delete all deployments workflow started or scheduled successfully"
+// @Failure 400 {object} APIResponse "Invalid request"
 // @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 409 {object} APIResponse "Some deployments are protected from deletion"
 // @Failure 500 {object} APIResponse "Internal server error"
 // @Router /deployments [delete]
 func (h *Handler) HandleDeleteAllDeployments(c *gin.Context) {
@@ -431,6 +687,40 @@ func (h *Handler) HandleDeleteAllDeployments(c *gin.Context) {
 		return
 	}
 
+	gracePeriod, err := parseGracePeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var protected []string
+	for _, cluster := range clusters {
+		if cluster.DeletionProtection {
+			protected = append(protected, clusterDisplayName(&cluster))
+		}
+	}
+	if len(protected) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":                 "some deployments are protected from deletion, turn off their deletion protection first",
+			"protected_deployments": protected,
+		})
+		return
+	}
+
+	if gracePeriod > 0 {
+		at, err := h.scheduleDeletion(config.UserID, clusters, gracePeriod)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Msg("Failed to schedule deployments deletion")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to schedule deployments deletion"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":               "Deletion of all deployments scheduled successfully",
+			"deletion_scheduled_at": at,
+		})
+		return
+	}
+
 	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowDeleteAllClusters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
@@ -450,12 +740,40 @@ func (h *Handler) HandleDeleteAllDeployments(c *gin.Context) {
 	})
 }
 
+// parseControlPlaneSize reads the optional control_plane_size query of the add node request, masters are added one
+// at a time so the control plane goes through an even size on its way to it. It defaults to the smallest size
+// keeping etcd quorum that fits the masters of nodes.
+func parseControlPlaneSize(c *gin.Context, nodes []kubedeployer.Node) (int, error) {
+	if value := c.Query("control_plane_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("control_plane_size must be 1, 3 or 5")
+		}
+		return size, nil
+	}
+
+	controlPlane := 0
+	for _, node := range nodes {
+		if node.Type == kubedeployer.NodeTypeLeader || node.Type == kubedeployer.NodeTypeMaster {
+			controlPlane++
+		}
+	}
+	for _, size := range []int{1, 3, 5} {
+		if controlPlane <= size {
+			return size, nil
+		}
+	}
+	return controlPlane, nil
+}
+
 // @Summary Add node to deployment
 // @Description Adds a new node to an existing deployment
 // @Tags deployments
 // @Security BearerAuth
 // @Accept json
 // @Produce json
+// @Param retry_window query string false "How long the contract of a node that failed to be added is kept for it to be retried, e.g. 2h. Without it a failed node is rolled back right away and can't be retried"
+// @Param control_plane_size query int false "Number of masters the control plane is growing to when adding a master (1, 3 or 5), defaults to the next quorum size"
 // @Param cluster body ClusterInput true "Cluster configuration with new node"
 // @Success 202 {object} Response "Node addition workflow started successfully"
 // @Failure 400 {object} APIResponse "Invalid request format"
@@ -476,6 +794,12 @@ func (h *Handler) HandleAddNode(c *gin.Context) {
 		return
 	}
 
+	retryWindow, err := parseRetryWindow(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	projectName := kubedeployer.GetProjectName(config.UserID, cluster.Name)
 	existingCluster, err := h.db.GetClusterByName(config.UserID, projectName)
 	if err != nil {
@@ -495,6 +819,11 @@ func (h *Handler) HandleAddNode(c *gin.Context) {
 		return
 	}
 
+	if len(cluster.Nodes) == 0 || cluster.Nodes[0].NodeID == 0 {
+		Error(c, http.StatusBadRequest, "Validation failed", "node_id is required to add a node")
+		return
+	}
+
 	// TODO: find a better place for this
 	cluster.Nodes[0].OriginalName = cluster.Nodes[0].Name
 
@@ -510,6 +839,18 @@ func (h *Handler) HandleAddNode(c *gin.Context) {
 		}
 	}
 
+	// copy the nodes, appending may write into the backing array of the stored cluster
+	nodes := append(append(make([]kubedeployer.Node, 0, len(cl.Nodes)+1), cl.Nodes...), cluster.Nodes[0])
+	controlPlaneSize, err := parseControlPlaneSize(c, nodes)
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Invalid query parameter", err.Error())
+		return
+	}
+	if err := kubedeployer.ValidateControlPlaneScaling(nodes, controlPlaneSize); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
 	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowAddNode)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
@@ -521,8 +862,11 @@ func (h *Handler) HandleAddNode(c *gin.Context) {
 		"cluster": cl,
 		"node":    cluster.Nodes[0],
 	}
+	if retryWindow > 0 {
+		wf.State["retry_window"] = retryWindow
+	}
 
-	h.ewfEngine.RunAsync(c, wf)
+	h.startCancellableWorkflow(wf)
 
 	c.JSON(http.StatusAccepted, Response{
 		WorkflowID: wf.UUID,
@@ -532,12 +876,16 @@ func (h *Handler) HandleAddNode(c *gin.Context) {
 }
 
 // @Summary Remove node from deployment
-// @Description Removes a specific node from an existing deployment
+// @Description Removes a specific node from an existing deployment. The node is cordoned and its pods evicted,
+// @Description honoring PodDisruptionBudgets, before its contract is cancelled and its Node object deleted.
+// @Description When the drain times out the node is uncordoned and kept, unless force is set.
 // @Tags deployments
 // @Security BearerAuth
 // @Produce json
 // @Param name path string true "Deployment name"
 // @Param node_name path string true "Node name to remove"
+// @Param drain_timeout query string false "How long to wait for the pods of the node to be evicted, e.g. 10m" default(5m)
+// @Param force query bool false "Remove the node even if it can't be cordoned or drained in time"
 // @Success 202 {object} Response "Node removal workflow started successfully"
 // @Failure 400 {object} APIResponse "Invalid request"
 // @Failure 401 {object} APIResponse "Unauthorized"
@@ -564,6 +912,12 @@ func (h *Handler) HandleRemoveNode(c *gin.Context) {
 		return
 	}
 
+	drainPolicy, err := parseDrainPolicy(c)
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
 	projectName := kubedeployer.GetProjectName(config.UserID, deploymentName)
 	cluster, err := h.db.GetClusterByName(config.UserID, projectName)
 	if err != nil {
@@ -603,9 +957,10 @@ func (h *Handler) HandleRemoveNode(c *gin.Context) {
 	}
 
 	wf.State = ewf.State{
-		"config":    config,
-		"cluster":   cl,
-		"node_name": nodeName,
+		"config":       config,
+		"cluster":      cl,
+		"node_name":    nodeName,
+		"drain_policy": drainPolicy,
 	}
 
 	h.ewfEngine.RunAsync(c, wf)
@@ -616,3 +971,395 @@ func (h *Handler) HandleRemoveNode(c *gin.Context) {
 		Message:    "Node removal workflow started successfully",
 	})
 }
+
+// parseDrainPolicy reads the optional drain_timeout and force queries of the requests removing nodes
+func parseDrainPolicy(c *gin.Context) (activities.DrainPolicy, error) {
+	var policy activities.DrainPolicy
+	if value := c.Query("drain_timeout"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 || timeout > activities.MaxDrainTimeout {
+			return policy, fmt.Errorf("drain_timeout must be a duration between 0 and %s, e.g. 10m", activities.MaxDrainTimeout)
+		}
+		policy.Timeout = timeout
+	}
+	if value := c.Query("force"); value != "" {
+		force, err := strconv.ParseBool(value)
+		if err != nil {
+			return policy, fmt.Errorf("force must be true or false")
+		}
+		policy.Force = force
+	}
+	return policy, nil
+}
+
+// ClusterUpdateResponse represents the response for declarative cluster updates
+type ClusterUpdateResponse struct {
+	WorkflowID string                  `json:"task_id,omitempty"`
+	Status     string                  `json:"status,omitempty"`
+	Message    string                  `json:"message"`
+	DryRun     bool                    `json:"dry_run"`
+	Plan       kubedeployer.UpdatePlan `json:"plan"`
+}
+
+// @Summary Update deployment
+// @Description Applies the desired cluster spec to an existing deployment by adding, replacing and removing nodes. With dry_run=true only the planned operations are returned.
+// @Description Removed and replaced nodes are cordoned and drained first.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param dry_run query bool false "Only return the update plan without applying it"
+// @Param drain_timeout query string false "How long to wait for the pods of a removed node to be evicted, e.g. 10m" default(5m)
+// @Param force query bool false "Remove nodes even if they can't be cordoned or drained in time"
+// @Param cluster body kubedeployer.Cluster true "Desired cluster configuration"
+// @Success 200 {object} ClusterUpdateResponse "Update plan (dry run or nothing to change)"
+// @Success 202 {object} ClusterUpdateResponse "Update workflow started successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 409 {object} APIResponse "Deployment is being deleted or another workflow changes its nodes"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name} [patch]
+func (h *Handler) HandleUpdateCluster(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	deploymentName := c.Param("name")
+	if deploymentName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deployment name is required"})
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be a boolean"})
+		return
+	}
+
+	drainPolicy, err := parseDrainPolicy(c)
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	var desired kubedeployer.Cluster
+	if err := c.ShouldBindJSON(&desired); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+		return
+	}
+
+	if desired.Name != deploymentName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cluster name in body does not match the deployment name"})
+		return
+	}
+
+	projectName := kubedeployer.GetProjectName(config.UserID, deploymentName)
+	existingCluster, err := h.db.GetClusterByName(config.UserID, projectName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		} else {
+			logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Str("project_name", projectName).Msg("Database error when looking up deployment for update")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		}
+		return
+	}
+
+	if rejectDeletingCluster(c, &existingCluster) {
+		return
+	}
+
+	cl, err := existingCluster.GetClusterResult()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", existingCluster.ID).Msg("Failed to deserialize cluster result")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deployment details"})
+		return
+	}
+
+	plan, err := kubedeployer.PlanClusterUpdate(cl, desired)
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, ClusterUpdateResponse{
+			Message: fmt.Sprintf("%d operations planned", len(plan.Operations)),
+			DryRun:  true,
+			Plan:    plan,
+		})
+		return
+	}
+
+	if plan.IsEmpty() {
+		c.JSON(http.StatusOK, ClusterUpdateResponse{
+			Message: "Deployment already matches the desired spec",
+			Plan:    plan,
+		})
+		return
+	}
+
+	// the update replaces the stored cluster, no other workflow may change its nodes meanwhile
+	staleClaim, ok := h.stalePoolsClaim(c, &existingCluster)
+	if !ok {
+		return
+	}
+
+	wfName := activities.GetUpdateWorkflowName(len(plan.Operations))
+	activities.NewDynamicUpdateWorkflowTemplate(h.ewfEngine, h.metrics, h.db, h.notificationService, h.config.SSH.PrivateKeyPath, wfName, len(plan.Operations))
+
+	wf, err := h.ewfEngine.NewWorkflow(wfName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":       config,
+		"cluster":      cl,
+		"plan":         plan,
+		"drain_policy": drainPolicy,
+	}
+	if !h.claimClusterPools(c, &existingCluster, wf, staleClaim) {
+		return
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, ClusterUpdateResponse{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Cluster update workflow started successfully",
+		Plan:       plan,
+	})
+}
+
+// @Summary Promote a new cluster leader
+// @Description Promotes the first healthy master of an HA deployment to leader and rewrites the stored kubeconfig to point at it.
+// @Description It is rejected while the deployment is being deleted or another workflow changes its nodes.
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 202 {object} Response "Leader promotion workflow started successfully"
+// @Failure 400 {object} APIResponse "Deployment has no other master to promote"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 409 {object} APIResponse "A leader promotion or another workflow changing the nodes of the deployment is already running, or the deployment is being deleted"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/failover [post]
+func (h *Handler) HandlePromoteLeader(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	deploymentName := c.Param("name")
+	if deploymentName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deployment name is required"})
+		return
+	}
+
+	projectName := kubedeployer.GetProjectName(config.UserID, deploymentName)
+	cluster, err := h.db.GetClusterByName(config.UserID, projectName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		} else {
+			logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Str("project_name", projectName).Msg("Database error when looking up deployment for leader promotion")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		}
+		return
+	}
+
+	cl, err := cluster.GetClusterResult()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to deserialize cluster result")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deployment details"})
+		return
+	}
+
+	if len(cl.GetControlPlaneNodes()) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deployment has no other master node to promote"})
+		return
+	}
+
+	if rejectDeletingCluster(c, &cluster) {
+		return
+	}
+
+	staleClaim, ok := h.stalePoolsClaim(c, &cluster)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	claimed, err := h.db.ClaimClusterFailover(cluster.ID, now, now.Add(-activities.FailoverClaimTimeout))
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to claim leader promotion")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start leader promotion"})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "a leader promotion of this deployment is already running"})
+		return
+	}
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowPromoteLeader)
+	if err != nil {
+		if err := h.db.ReleaseClusterFailover(cluster.ID); err != nil {
+			logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to release leader promotion")
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":  config,
+		"cluster": cl,
+	}
+
+	// the promotion stores the whole cluster, no other workflow may change its nodes meanwhile
+	if !h.claimClusterPools(c, &cluster, wf, staleClaim) {
+		if err := h.db.ReleaseClusterFailover(cluster.ID); err != nil {
+			logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to release leader promotion")
+		}
+		return
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Leader promotion workflow started successfully",
+	})
+}
+
+// UpgradeClusterInput holds the target image of a cluster upgrade
+type UpgradeClusterInput struct {
+	// Flist defaults to the configured k3s flist
+	Flist string `json:"flist"`
+}
+
+// @Summary Upgrade deployment
+// @Description Moves every node of a deployment to a new k3s flist one at a time, masters first. Each node is drained, redeployed keeping its data disk and uncordoned once Ready. Nodes already running the flist are left alone. Upgraded nodes are rolled back if a node fails.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param drain_timeout query string false "How long to wait for the pods of each node to be evicted, e.g. 10m" default(5m)
+// @Param force query bool false "Upgrade nodes even if they can't be drained in time"
+// @Param upgrade body UpgradeClusterInput false "Target flist"
+// @Success 202 {object} Response "Upgrade workflow started successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 409 {object} APIResponse "Deployment is being deleted or another workflow changes its nodes"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/upgrade [post]
+func (h *Handler) HandleUpgradeCluster(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	deploymentName := c.Param("name")
+	if deploymentName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deployment name is required"})
+		return
+	}
+
+	drainPolicy, err := parseDrainPolicy(c)
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	var input UpgradeClusterInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+			return
+		}
+	}
+	if input.Flist == "" {
+		input.Flist = kubedeployer.DefaultFlist()
+	}
+
+	projectName := kubedeployer.GetProjectName(config.UserID, deploymentName)
+	cluster, err := h.db.GetClusterByName(config.UserID, projectName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		} else {
+			logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Str("project_name", projectName).Msg("Database error when looking up deployment for upgrade")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		}
+		return
+	}
+
+	if rejectDeletingCluster(c, &cluster) {
+		return
+	}
+
+	cl, err := cluster.GetClusterResult()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to deserialize cluster result")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deployment details"})
+		return
+	}
+
+	order := activities.UpgradeOrder(cl, input.Flist)
+	if len(order) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "all nodes already run the requested flist"})
+		return
+	}
+
+	previousFlists := make(map[string]string, len(cl.Nodes))
+	for _, node := range cl.Nodes {
+		previousFlists[node.Name] = node.Flist
+	}
+
+	// nodes are redeployed one by one, no other workflow may change them meanwhile
+	staleClaim, ok := h.stalePoolsClaim(c, &cluster)
+	if !ok {
+		return
+	}
+
+	wfName := activities.GetUpgradeWorkflowName(len(order))
+	activities.NewDynamicUpgradeWorkflowTemplate(h.ewfEngine, h.db, h.notificationService, h.config.SSH.PrivateKeyPath, wfName, len(order))
+
+	wf, err := h.ewfEngine.NewWorkflow(wfName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":          config,
+		"cluster":         cl,
+		"flist":           input.Flist,
+		"upgrade_order":   order,
+		"previous_flists": previousFlists,
+		"drain_policy":    drainPolicy,
+	}
+	if !h.claimClusterPools(c, &cluster, wf, staleClaim) {
+		return
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Upgrade workflow started successfully",
+	})
+}
@@ -0,0 +1,366 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/xmonader/ewf"
+	"gorm.io/gorm"
+)
+
+// @Summary List contract drifts
+// @Description Returns the contracts whose state on chain does not match the database, as found by the last drift check
+// @Tags admin
+// @ID list-contract-drifts
+// @Produce json
+// @Success 200 {array} models.ContractDrift
+// @Failure 500 {object} APIResponse
+// @Security AdminMiddleware
+// @Router /drifts [get]
+// ListContractDriftsHandler returns all recorded contract drifts
+func (h *Handler) ListContractDriftsHandler(c *gin.Context) {
+	drifts, err := h.db.ListContractDrifts()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Msg("failed to list contract drifts")
+		InternalServerError(c)
+		return
+	}
+
+	Success(c, http.StatusOK, "Contract drifts are retrieved successfully", map[string]any{
+		"drifts": drifts,
+	})
+}
+
+// @Summary Repair a contract drift
+// @Description Brings the database in line with the chain while keeping what is still deployed.
+// @Description Missing contracts are dropped from their cluster or reserved node record, orphaned rent contracts are recorded as reserved nodes
+// @Description and orphaned deployments are imported as a cluster of their owner. Contracts in grace period cannot be repaired.
+// @Tags admin
+// @ID repair-contract-drift
+// @Produce json
+// @Param drift_id path int true "Drift ID"
+// @Success 200 {object} APIResponse "Drift repaired"
+// @Success 202 {object} APIResponse "Import workflow started"
+// @Failure 400 {object} APIResponse "Drift cannot be repaired"
+// @Failure 404 {object} APIResponse "Drift not found"
+// @Failure 409 {object} APIResponse "Orphaned deployment belongs to an existing cluster"
+// @Failure 500 {object} APIResponse
+// @Security AdminMiddleware
+// @Router /drifts/{drift_id}/repair [post]
+// RepairContractDriftHandler repairs the database record of a contract drift
+func (h *Handler) RepairContractDriftHandler(c *gin.Context) {
+	drift, ok := h.getContractDrift(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case drift.Kind == models.DriftGracePeriod:
+		Error(c, http.StatusBadRequest, "Contract is in grace period", "it recovers once its owner funds their account, clean it to delete it instead")
+
+	case drift.Kind == models.DriftMissingContract && drift.Resource == models.DriftResourceCluster:
+		h.forgetClusterContract(c, drift)
+
+	case drift.Kind == models.DriftMissingContract && drift.Resource == models.DriftResourceReservedNode:
+		if err := h.db.DeleteUserNode(drift.ContractID); err != nil {
+			logger.GetLogger().Error().Err(err).Uint64("contract_id", drift.ContractID).Msg("failed to delete reserved node of missing contract")
+			InternalServerError(c)
+			return
+		}
+		h.resolveContractDrift(c, drift, "Reserved node of missing contract is removed")
+
+	case drift.Kind == models.DriftOrphanedContract && drift.ContractType == "rent":
+		userNode := models.UserNodes{
+			UserID:     drift.UserID,
+			ContractID: drift.ContractID,
+			NodeID:     drift.NodeID,
+		}
+		if err := h.db.CreateUserNode(&userNode); err != nil {
+			logger.GetLogger().Error().Err(err).Uint64("contract_id", drift.ContractID).Msg("failed to record orphaned rent contract")
+			InternalServerError(c)
+			return
+		}
+		h.resolveContractDrift(c, drift, "Orphaned rent contract is recorded as a reserved node")
+
+	case drift.Kind == models.DriftOrphanedContract && drift.ContractType == "node":
+		h.importOrphanedDeployment(c, drift)
+
+	default:
+		Error(c, http.StatusBadRequest, "Drift cannot be repaired", fmt.Sprintf("no repair for %s of %s", drift.Kind, drift.ContractType))
+	}
+}
+
+// @Summary Clean a contract drift
+// @Description Removes what drifted: clusters referencing missing contracts are deleted with their remaining contracts,
+// @Description stale reserved nodes are removed and orphaned contracts are canceled on chain
+// @Tags admin
+// @ID clean-contract-drift
+// @Produce json
+// @Param drift_id path int true "Drift ID"
+// @Success 200 {object} APIResponse "Drift cleaned"
+// @Success 202 {object} APIResponse "Deletion workflow started"
+// @Failure 404 {object} APIResponse "Drift not found"
+// @Failure 500 {object} APIResponse
+// @Security AdminMiddleware
+// @Router /drifts/{drift_id}/clean [post]
+// CleanContractDriftHandler removes the resource of a contract drift
+func (h *Handler) CleanContractDriftHandler(c *gin.Context) {
+	drift, ok := h.getContractDrift(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case drift.Resource == models.DriftResourceCluster:
+		h.deleteDriftedCluster(c, drift)
+
+	case drift.Resource == models.DriftResourceReservedNode:
+		if drift.Kind == models.DriftGracePeriod {
+			if err := h.cancelUserContract(drift.UserID, drift.ContractID); err != nil {
+				logger.GetLogger().Error().Err(err).Uint64("contract_id", drift.ContractID).Msg("failed to cancel rent contract")
+				Error(c, http.StatusInternalServerError, "Failed to cancel contract", err.Error())
+				return
+			}
+		}
+		if err := h.db.DeleteUserNode(drift.ContractID); err != nil {
+			logger.GetLogger().Error().Err(err).Uint64("contract_id", drift.ContractID).Msg("failed to delete drifted reserved node")
+			InternalServerError(c)
+			return
+		}
+		h.resolveContractDrift(c, drift, "Reserved node is removed")
+
+	default:
+		if err := h.cancelUserContract(drift.UserID, drift.ContractID); err != nil {
+			logger.GetLogger().Error().Err(err).Uint64("contract_id", drift.ContractID).Msg("failed to cancel orphaned contract")
+			Error(c, http.StatusInternalServerError, "Failed to cancel contract", err.Error())
+			return
+		}
+		h.resolveContractDrift(c, drift, "Orphaned contract is canceled")
+	}
+}
+
+func (h *Handler) getContractDrift(c *gin.Context) (models.ContractDrift, bool) {
+	id, err := strconv.Atoi(c.Param("drift_id"))
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Invalid drift ID", err.Error())
+		return models.ContractDrift{}, false
+	}
+
+	drift, err := h.db.GetContractDrift(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		Error(c, http.StatusNotFound, "Drift not found", "")
+		return models.ContractDrift{}, false
+	}
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("drift_id", id).Msg("failed to get contract drift")
+		InternalServerError(c)
+		return models.ContractDrift{}, false
+	}
+
+	return drift, true
+}
+
+// forgetClusterContract drops the node or network deployment of a missing contract from its cluster
+func (h *Handler) forgetClusterContract(c *gin.Context, drift models.ContractDrift) {
+	cluster, err := h.db.GetClusterByName(drift.UserID, drift.ProjectName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		h.resolveContractDrift(c, drift, "Cluster no longer exists")
+		return
+	}
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", drift.ProjectName).Msg("failed to get cluster of contract drift")
+		InternalServerError(c)
+		return
+	}
+
+	cl, err := cluster.GetClusterResult()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("failed to get cluster result")
+		InternalServerError(c)
+		return
+	}
+
+	if !cl.ForgetContract(drift.ContractID) {
+		h.resolveContractDrift(c, drift, "Contract is no longer part of the cluster")
+		return
+	}
+	if len(cl.GetControlPlaneNodes()) == 0 {
+		Error(c, http.StatusBadRequest, "Cluster has no control plane left", "clean the drift to delete the cluster instead")
+		return
+	}
+
+	if err := cluster.SetClusterResult(cl); err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("failed to set cluster result")
+		InternalServerError(c)
+		return
+	}
+	if err := h.db.UpdateCluster(&cluster); err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("failed to update cluster")
+		InternalServerError(c)
+		return
+	}
+
+	h.resolveContractDrift(c, drift, "Missing contract is removed from the cluster")
+	h.refreshClusterStatus(cluster)
+}
+
+// importOrphanedDeployment adopts the deployments of an orphaned contract's project as a cluster of its owner
+func (h *Handler) importOrphanedDeployment(c *gin.Context, drift models.ContractDrift) {
+	prefix := kubedeployer.GetProjectName(drift.UserID, "")
+	clusterName := strings.TrimPrefix(drift.ProjectName, prefix)
+	if drift.ProjectName == "" || clusterName == drift.ProjectName || !clusterNameRegex.MatchString(clusterName) {
+		Error(c, http.StatusBadRequest, "Orphaned contract is not part of a cluster", "clean the drift to cancel it instead")
+		return
+	}
+
+	_, err := h.db.GetClusterByName(drift.UserID, drift.ProjectName)
+	if err == nil {
+		Error(c, http.StatusConflict, "Cluster already exists", "the contract is not referenced by it, clean the drift to cancel it")
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.GetLogger().Error().Err(err).Str("project_name", drift.ProjectName).Msg("failed to get cluster of orphaned contract")
+		InternalServerError(c)
+		return
+	}
+
+	config, err := h.clientConfigForUser(drift.UserID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", drift.UserID).Msg("failed to get client config")
+		InternalServerError(c)
+		return
+	}
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowImportCluster)
+	if err != nil {
+		InternalServerError(c)
+		return
+	}
+	wf.State = ewf.State{
+		"config":  config,
+		"cluster": kubedeployer.Cluster{Name: clusterName},
+	}
+	h.ewfEngine.RunAsync(c, wf)
+
+	Success(c, http.StatusAccepted, "Import workflow started successfully", map[string]any{
+		"task_id": wf.UUID,
+	})
+}
+
+// deleteDriftedCluster deletes a cluster referencing a missing contract along with its remaining contracts
+func (h *Handler) deleteDriftedCluster(c *gin.Context, drift models.ContractDrift) {
+	cluster, err := h.db.GetClusterByName(drift.UserID, drift.ProjectName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		h.resolveContractDrift(c, drift, "Cluster no longer exists")
+		return
+	}
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", drift.ProjectName).Msg("failed to get cluster of contract drift")
+		InternalServerError(c)
+		return
+	}
+	if cluster.DeletionProtection {
+		Error(c, http.StatusConflict, "Cluster is protected from deletion", "turn off its deletion protection first")
+		return
+	}
+
+	config, err := h.clientConfigForUser(drift.UserID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", drift.UserID).Msg("failed to get client config")
+		InternalServerError(c)
+		return
+	}
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowDeleteCluster)
+	if err != nil {
+		InternalServerError(c)
+		return
+	}
+	wf.State = ewf.State{
+		"config":       config,
+		"project_name": drift.ProjectName,
+	}
+
+	// the cluster stays marked as deleting until the workflow ends, a protection turned on meanwhile wins
+	marked, err := h.db.MarkClusterDeleting(cluster.ID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", drift.ProjectName).Msg("failed to mark cluster as deleting")
+		InternalServerError(c)
+		return
+	}
+	if !marked {
+		Error(c, http.StatusConflict, "Cluster can't be deleted", "it is protected from deletion or already being deleted")
+		return
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	if err := h.db.DeleteContractDrift(drift.ID); err != nil {
+		logger.GetLogger().Error().Err(err).Int("drift_id", drift.ID).Msg("failed to delete contract drift")
+	}
+
+	Success(c, http.StatusAccepted, "Deployment deletion workflow started successfully", map[string]any{
+		"task_id": wf.UUID,
+	})
+}
+
+// cancelUserContract cancels a contract on chain with the identity of the user owning it
+func (h *Handler) cancelUserContract(userID int, contractID uint64) error {
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase(user.Mnemonic)
+	if err != nil {
+		return err
+	}
+
+	err = h.substrateClient.CancelContract(identity, contractID)
+	if errors.Is(err, substrate.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (h *Handler) resolveContractDrift(c *gin.Context, drift models.ContractDrift, message string) {
+	if err := h.db.DeleteContractDrift(drift.ID); err != nil {
+		logger.GetLogger().Error().Err(err).Int("drift_id", drift.ID).Msg("failed to delete contract drift")
+		InternalServerError(c)
+		return
+	}
+
+	Success(c, http.StatusOK, message, nil)
+}
+
+// refreshClusterStatus marks a degraded cluster active again once none of its contracts drift anymore
+func (h *Handler) refreshClusterStatus(cluster models.Cluster) {
+	if cluster.Status != models.ClusterStatusDegraded {
+		return
+	}
+
+	drifts, err := h.db.ListContractDrifts()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Msg("failed to list contract drifts")
+		return
+	}
+	for _, drift := range drifts {
+		if drift.Resource == models.DriftResourceCluster && drift.UserID == cluster.UserID && drift.ProjectName == cluster.ProjectName {
+			return
+		}
+	}
+
+	if err := h.db.UpdateClusterStatus(cluster.ID, models.ClusterStatusActive); err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("failed to update cluster status")
+	}
+}
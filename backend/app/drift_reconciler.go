@@ -0,0 +1,260 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"kubecloud/internal/logger"
+	"kubecloud/models"
+
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+const (
+	defaultContractDriftCheckInterval = time.Hour
+	// contracts younger than this may belong to a deployment that is not stored yet
+	orphanedContractMinAge = time.Hour
+	proxyContractsPageSize = 100
+)
+
+// TrackContractDrift periodically compares the contracts known to the database with their state on chain
+func (h *Handler) TrackContractDrift() {
+	interval := time.Duration(h.config.ContractDriftCheckIntervalInMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultContractDriftCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		logger.GetLogger().Info().Msg("Contract drift check started")
+		if err := h.reconcileContractDrift(context.Background()); err != nil {
+			logger.GetLogger().Error().Err(err).Msg("Contract drift check failed")
+			continue
+		}
+		logger.GetLogger().Info().Msg("Contract drift check finished")
+	}
+}
+
+// reconcileContractDrift records the contracts whose state on chain does not match the database,
+// marks the clusters referencing missing contracts as degraded and drops the drifts that were resolved
+func (h *Handler) reconcileContractDrift(ctx context.Context) error {
+	startedAt := time.Now()
+	known := map[uint64]struct{}{}
+	complete := true
+
+	clusters, err := h.db.ListAllClusters()
+	if err != nil {
+		return fmt.Errorf("failed to list clusters: %w", err)
+	}
+
+	for _, cluster := range clusters {
+		cl, err := cluster.GetClusterResult()
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to get cluster result for drift check")
+			complete = false
+			continue
+		}
+
+		contracts := map[uint64]uint32{}
+		for _, node := range cl.Nodes {
+			if node.ContractID != 0 {
+				contracts[node.ContractID] = node.NodeID
+			}
+		}
+		for nodeID, contractID := range cl.Network.NodeDeploymentID {
+			if contractID != 0 {
+				contracts[contractID] = nodeID
+			}
+		}
+
+		degraded := false
+		for contractID, nodeID := range contracts {
+			known[contractID] = struct{}{}
+
+			kind, err := h.contractDriftKind(contractID)
+			if err != nil {
+				logger.GetLogger().Warn().Err(err).Uint64("contract_id", contractID).Msg("Failed to check contract state")
+				complete = false
+				continue
+			}
+			if kind == "" {
+				continue
+			}
+
+			degraded = true
+			h.saveContractDrift(&models.ContractDrift{
+				UserID:      cluster.UserID,
+				ContractID:  contractID,
+				Kind:        kind,
+				Resource:    models.DriftResourceCluster,
+				ProjectName: cluster.ProjectName,
+				NodeID:      nodeID,
+			})
+		}
+
+		// a cluster being deleted keeps its status until its deletion workflow ends
+		if cluster.Status == models.ClusterStatusDeleting {
+			continue
+		}
+
+		status := models.ClusterStatusActive
+		if degraded {
+			status = models.ClusterStatusDegraded
+		}
+		if cluster.Status != status {
+			if err := h.db.UpdateClusterStatus(cluster.ID, status); err != nil {
+				logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Str("status", status).Msg("Failed to update cluster status")
+			}
+		}
+	}
+
+	reservedNodes, err := h.db.ListAllReservedNodes()
+	if err != nil {
+		return fmt.Errorf("failed to list reserved nodes: %w", err)
+	}
+
+	for _, userNode := range reservedNodes {
+		known[userNode.ContractID] = struct{}{}
+
+		kind, err := h.contractDriftKind(userNode.ContractID)
+		if err != nil {
+			logger.GetLogger().Warn().Err(err).Uint64("contract_id", userNode.ContractID).Msg("Failed to check rent contract state")
+			complete = false
+			continue
+		}
+		if kind == "" {
+			continue
+		}
+
+		h.saveContractDrift(&models.ContractDrift{
+			UserID:     userNode.UserID,
+			ContractID: userNode.ContractID,
+			Kind:       kind,
+			Resource:   models.DriftResourceReservedNode,
+			NodeID:     userNode.NodeID,
+		})
+	}
+
+	if err := h.reportOrphanedContracts(ctx, known); err != nil {
+		logger.GetLogger().Error().Err(err).Msg("Failed to look up orphaned contracts")
+		complete = false
+	}
+
+	// a drift that was not seen again is resolved, unless it could not be checked this time
+	if !complete {
+		logger.GetLogger().Warn().Msg("Contract drift check was incomplete, keeping previously recorded drifts")
+		return nil
+	}
+	return h.db.DeleteContractDriftsSeenBefore(startedAt)
+}
+
+// contractDriftKind returns the drift of a contract referenced by the database, empty when it is active
+func (h *Handler) contractDriftKind(contractID uint64) (models.DriftKind, error) {
+	contract, err := h.substrateClient.GetContract(contractID)
+	if errors.Is(err, substrate.ErrNotFound) {
+		return models.DriftMissingContract, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case contract.State.IsDeleted:
+		return models.DriftMissingContract, nil
+	case contract.State.IsGracePeriod:
+		return models.DriftGracePeriod, nil
+	default:
+		return "", nil
+	}
+}
+
+// reportOrphanedContracts records the node and rent contracts of user twins that are not referenced by the database
+func (h *Handler) reportOrphanedContracts(ctx context.Context, known map[uint64]struct{}) error {
+	users, err := h.db.ListAllUsers()
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	for _, user := range users {
+		if user.Mnemonic == "" {
+			continue
+		}
+
+		twinID, err := h.getTwinIDFromUserID(user.ID)
+		if err != nil {
+			logger.GetLogger().Debug().Err(err).Int("user_id", user.ID).Msg("Skipping user without twin in drift check")
+			continue
+		}
+
+		contracts, err := h.listTwinContracts(ctx, twinID)
+		if err != nil {
+			return fmt.Errorf("failed to list contracts of twin %d: %w", twinID, err)
+		}
+
+		for _, contract := range contracts {
+			if _, ok := known[uint64(contract.ContractID)]; ok {
+				continue
+			}
+			if time.Since(time.Unix(int64(contract.CreatedAt), 0)) < orphanedContractMinAge {
+				continue
+			}
+
+			drift := models.ContractDrift{
+				UserID:       user.ID,
+				ContractID:   uint64(contract.ContractID),
+				Kind:         models.DriftOrphanedContract,
+				ContractType: contract.Type,
+			}
+			switch details := contract.Details.(type) {
+			case proxyTypes.NodeContractDetails:
+				drift.NodeID = uint32(details.NodeID)
+				if data, err := workloads.ParseDeploymentData(details.DeploymentData); err == nil {
+					drift.ProjectName = data.ProjectName
+					drift.DeploymentName = data.Name
+				}
+			case proxyTypes.RentContractDetails:
+				drift.NodeID = uint32(details.NodeID)
+			default:
+				continue
+			}
+
+			h.saveContractDrift(&drift)
+		}
+	}
+
+	return nil
+}
+
+// listTwinContracts returns the active and grace period contracts of a twin
+func (h *Handler) listTwinContracts(ctx context.Context, twinID uint64) ([]proxyTypes.Contract, error) {
+	filter := proxyTypes.ContractFilter{
+		TwinID: &twinID,
+		State:  []string{"Created", "GracePeriod"},
+	}
+
+	var contracts []proxyTypes.Contract
+	for page := uint64(1); ; page++ {
+		res, _, err := h.proxyClient.Contracts(ctx, filter, proxyTypes.Limit{Size: proxyContractsPageSize, Page: page})
+		if err != nil {
+			return nil, err
+		}
+		contracts = append(contracts, res...)
+		if len(res) < proxyContractsPageSize {
+			return contracts, nil
+		}
+	}
+}
+
+func (h *Handler) saveContractDrift(drift *models.ContractDrift) {
+	if err := h.db.SaveContractDrift(drift); err != nil {
+		logger.GetLogger().Error().Err(err).Uint64("contract_id", drift.ContractID).Str("kind", string(drift.Kind)).Msg("Failed to save contract drift")
+		return
+	}
+	logger.GetLogger().Warn().Uint64("contract_id", drift.ContractID).Int("user_id", drift.UserID).Str("kind", string(drift.Kind)).Msg("Contract drift detected")
+}
@@ -0,0 +1,283 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"kubecloud/internal"
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+
+	"github.com/gin-gonic/gin"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/calculator"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+const (
+	hoursPerMonth = 24 * 30
+
+	// BillingRented means the node is already rented by the user, its rent is billed separately
+	BillingRented = "rented"
+	// BillingRentRequired means the node has to be reserved before deploying on it
+	BillingRentRequired = "rent_required"
+	// BillingShared means the node capacity is billed per used resource units
+	BillingShared = "shared"
+)
+
+// Cost holds a price in USD and TFT
+type Cost struct {
+	USD float64 `json:"usd"`
+	TFT float64 `json:"tft"`
+}
+
+// CostEstimate holds hourly and monthly costs
+type CostEstimate struct {
+	Hourly  Cost `json:"hourly"`
+	Monthly Cost `json:"monthly"`
+}
+
+// NodeCostEstimate holds the estimated cost of a single cluster node
+type NodeCostEstimate struct {
+	Name    string       `json:"name"`
+	NodeID  uint32       `json:"node_id"`
+	Billing string       `json:"billing"`
+	Cost    CostEstimate `json:"cost"`
+}
+
+// ClusterEstimateResponse holds the estimated cost of deploying a cluster. Network contracts reserve no capacity
+// and public traffic is billed per use, so they aren't included.
+type ClusterEstimateResponse struct {
+	Nodes     []NodeCostEstimate       `json:"nodes"`
+	Total     CostEstimate             `json:"total"`
+	Placement []kubedeployer.Placement `json:"placement,omitempty"`
+	Warnings  []string                 `json:"warnings,omitempty"`
+}
+
+// @Summary Estimate cluster cost
+// @Description Estimates the hourly and monthly cost of deploying a cluster in USD and TFT without deploying it
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param fallback_rentable query bool false "Place nodes on rentable nodes when the rented nodes don't have enough capacity"
+// @Param cluster body kubedeployer.Cluster true "Cluster configuration"
+// @Success 200 {object} APIResponse{data=ClusterEstimateResponse} "Cost estimate calculated successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/estimate [post]
+func (h *Handler) HandleEstimateCluster(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var cluster kubedeployer.Cluster
+	if err := c.ShouldBindJSON(&cluster); err != nil {
+		Error(c, http.StatusBadRequest, "Invalid request json format", err.Error())
+		return
+	}
+
+	if err := cluster.Validate(); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("failed to get user")
+		InternalServerError(c)
+		return
+	}
+
+	twinID, err := h.getTwinIDFromUserID(userID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("failed to get user twin")
+		InternalServerError(c)
+		return
+	}
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase(user.Mnemonic)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("failed to create user identity")
+		InternalServerError(c)
+		return
+	}
+
+	fallbackRentable, err := strconv.ParseBool(c.DefaultQuery("fallback_rentable", "false"))
+	if err != nil {
+		Error(c, http.StatusBadRequest, "Invalid query parameter", "fallback_rentable must be a boolean")
+		return
+	}
+
+	placement, _, err := h.placeClusterNodes(c.Request.Context(), userID, &cluster, fallbackRentable)
+	var unschedulable *kubedeployer.UnschedulableError
+	if errors.As(err, &unschedulable) {
+		Error(c, http.StatusBadRequest, "Failed to place cluster nodes", err.Error())
+		return
+	} else if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("failed to place cluster nodes")
+		InternalServerError(c)
+		return
+	}
+
+	// placement filled the node ids, check them like the ones given by the user
+	if err := cluster.Validate(); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	gridNodes := make(map[uint32]proxyTypes.Node)
+	for _, node := range cluster.Nodes {
+		if _, found := gridNodes[node.NodeID]; found {
+			continue
+		}
+
+		gridNode, err := h.getGridNode(c, node.NodeID)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Uint32("node_id", node.NodeID).Msg("failed to get node from grid proxy")
+			InternalServerError(c)
+			return
+		}
+		if gridNode.NodeID == 0 {
+			Error(c, http.StatusBadRequest, "Validation failed", fmt.Sprintf("node %d of %s is not found", node.NodeID, node.Name))
+			return
+		}
+		if gridNode.Rented && uint64(gridNode.RentedByTwinID) != twinID {
+			Error(c, http.StatusBadRequest, "Validation failed", fmt.Sprintf("node %d of %s is rented by another user", node.NodeID, node.Name))
+			return
+		}
+		gridNodes[node.NodeID] = gridNode
+	}
+
+	calc := calculator.NewCalculator(h.gridClient.SubstrateConn, identity)
+	response, err := estimateClusterCost(&calc, cluster, gridNodes)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("failed to estimate cluster cost")
+		InternalServerError(c)
+		return
+	}
+	response.Placement = placement
+
+	usdMillicentBalance, err := internal.GetUserBalanceUSDMillicent(h.substrateClient, user.Mnemonic)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("failed to get user balance")
+		InternalServerError(c)
+		return
+	}
+
+	// the first billing period is one hour, same as the node reservation check
+	available := int64(usdMillicentBalance) - int64(user.Debt)
+	if available < int64(internal.FromUSDToUSDMillicent(response.Total.Hourly.USD)) {
+		response.Warnings = append(response.Warnings, "Your balance does not cover the first billing period of this cluster")
+	}
+
+	Success(c, http.StatusOK, "Cost estimate calculated successfully", response)
+}
+
+// contractPricer prices the contracts of a cluster, it is implemented by the grid calculator
+type contractPricer interface {
+	CalculateCost(cru, mru, hru, sru uint64, publicIP, certified bool) (float64, error)
+	CalculatePricesAfterDiscount(cost float64) (dedicatedPrice, sharedPrice float64, err error)
+	USDtoTFT(usd float64) (float64, error)
+}
+
+// estimateClusterCost prices the nodes of a cluster placed on gridNodes. The rent of a grid node that isn't rented yet
+// is counted once no matter how many cluster nodes are placed on it.
+func estimateClusterCost(pricer contractPricer, cluster kubedeployer.Cluster, gridNodes map[uint32]proxyTypes.Node) (ClusterEstimateResponse, error) {
+	var response ClusterEstimateResponse
+	var totalMonthlyUSD float64
+	rentCounted := make(map[uint32]bool)
+
+	for _, node := range cluster.Nodes {
+		gridNode := gridNodes[node.NodeID]
+		certified := gridNode.CertificationType == "Certified"
+		estimate := NodeCostEstimate{Name: node.Name, NodeID: node.NodeID}
+
+		var monthlyUSD float64
+		switch {
+		case gridNode.Rented:
+			estimate.Billing = BillingRented
+		case gridNode.Dedicated || gridNode.Rentable:
+			estimate.Billing = BillingRentRequired
+			if !rentCounted[node.NodeID] {
+				rentCounted[node.NodeID] = true
+				monthlyUSD = nodeRentMonthlyUSD(gridNode)
+				response.Warnings = append(response.Warnings, fmt.Sprintf("node %d is not rented yet, it must be reserved before deploying", node.NodeID))
+			}
+		default:
+			estimate.Billing = BillingShared
+		}
+
+		// the capacity of rented nodes is covered by their rent, their public IPs are still billed per contract
+		var cru, mru, sru uint64
+		if estimate.Billing == BillingShared {
+			cru = uint64(node.CPU)
+			mru = mbToGB(node.Memory)
+			sru = mbToGB(node.RootSize + node.DiskSize)
+		}
+		cost, err := pricer.CalculateCost(cru, mru, 0, sru, node.PublicIPv4, certified)
+		if err != nil {
+			return ClusterEstimateResponse{}, fmt.Errorf("failed to calculate cost of node %s: %w", node.Name, err)
+		}
+		if cost > 0 {
+			_, contractUSD, err := pricer.CalculatePricesAfterDiscount(cost)
+			if err != nil {
+				return ClusterEstimateResponse{}, fmt.Errorf("failed to apply discount to cost of node %s: %w", node.Name, err)
+			}
+			monthlyUSD += contractUSD
+		}
+
+		estimate.Cost, err = newCostEstimate(pricer, monthlyUSD)
+		if err != nil {
+			return ClusterEstimateResponse{}, fmt.Errorf("failed to convert cost of node %s to TFT: %w", node.Name, err)
+		}
+		response.Nodes = append(response.Nodes, estimate)
+		totalMonthlyUSD += monthlyUSD
+	}
+
+	total, err := newCostEstimate(pricer, totalMonthlyUSD)
+	if err != nil {
+		return ClusterEstimateResponse{}, fmt.Errorf("failed to convert total cost to TFT: %w", err)
+	}
+	response.Total = total
+	return response, nil
+}
+
+// mbToGB converts the MB of a cluster node to the GB it is billed for, partial gigabytes are billed
+func mbToGB(mb uint64) uint64 {
+	return (mb + 1023) / 1024
+}
+
+// getGridNode returns the grid proxy node with the given ID, or an empty node if it does not exist
+func (h *Handler) getGridNode(c *gin.Context, nodeID uint32) (proxyTypes.Node, error) {
+	nodeID64 := uint64(nodeID)
+	filter := proxyTypes.NodeFilter{
+		NodeID:   &nodeID64,
+		Features: zos3NodeFeatures,
+	}
+
+	nodes, _, err := h.proxyClient.Nodes(c.Request.Context(), filter, proxyTypes.Limit{})
+	if err != nil {
+		return proxyTypes.Node{}, err
+	}
+	if len(nodes) == 0 {
+		return proxyTypes.Node{}, nil
+	}
+
+	return nodes[0], nil
+}
+
+func newCostEstimate(pricer contractPricer, monthlyUSD float64) (CostEstimate, error) {
+	hourlyUSD := monthlyUSD / hoursPerMonth
+
+	monthlyTFT, err := pricer.USDtoTFT(monthlyUSD)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+
+	return CostEstimate{
+		Hourly:  Cost{USD: hourlyUSD, TFT: monthlyTFT / hoursPerMonth},
+		Monthly: Cost{USD: monthlyUSD, TFT: monthlyTFT},
+	}, nil
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"testing"
+
+	"kubecloud/kubedeployer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func TestMBToGB(t *testing.T) {
+	assert.Equal(t, uint64(0), mbToGB(0))
+	assert.Equal(t, uint64(4), mbToGB(4096))
+	// partial gigabytes are billed
+	assert.Equal(t, uint64(3), mbToGB(2560))
+	assert.Equal(t, uint64(16), mbToGB(5120+10752))
+}
+
+// fakePricer prices contracts with fixed unit prices instead of the chain pricing policy
+type fakePricer struct{}
+
+func (fakePricer) CalculateCost(cru, mru, hru, sru uint64, publicIP, certified bool) (float64, error) {
+	cost := float64(cru)*2 + float64(mru) + float64(sru)*0.1
+	if publicIP {
+		cost += 4
+	}
+	if certified {
+		cost *= 1.25
+	}
+	return cost, nil
+}
+
+func (fakePricer) CalculatePricesAfterDiscount(cost float64) (float64, float64, error) {
+	return cost * 0.25, cost * 0.5, nil
+}
+
+func (fakePricer) USDtoTFT(usd float64) (float64, error) {
+	return usd * 10, nil
+}
+
+func TestEstimateClusterCost(t *testing.T) {
+	gridNodes := map[uint32]proxyTypes.Node{
+		1: {
+			NodeID:         1,
+			FarmID:         1,
+			Rented:         true,
+			RentedByTwinID: 7,
+			TotalResources: proxyTypes.Capacity{CRU: 2, MRU: 4 << 30, SRU: 40 << 30},
+		},
+		2: {
+			NodeID:            2,
+			FarmID:            2,
+			FarmFreeIps:       1,
+			Rentable:          true,
+			PriceUsd:          100,
+			CertificationType: "Certified",
+			TotalResources:    proxyTypes.Capacity{CRU: 16, MRU: 64 << 30, SRU: 1024 << 30},
+			UsedResources:     proxyTypes.Capacity{CRU: 2, MRU: 2 << 30, SRU: 100 << 30},
+		},
+		3: {NodeID: 3, FarmID: 1},
+	}
+
+	cluster := kubedeployer.Cluster{
+		Name: "estimate",
+		Nodes: []kubedeployer.Node{
+			{Name: "worker", Type: kubedeployer.NodeTypeWorker, CPU: 1, Memory: 2048, RootSize: 5120, DiskSize: 10240},
+			{Name: "leader", Type: kubedeployer.NodeTypeLeader, CPU: 2, Memory: 4096, RootSize: 10240, DiskSize: 20480, PublicIPv4: true},
+			{Name: "shared", Type: kubedeployer.NodeTypeWorker, NodeID: 3, CPU: 1, Memory: 1000, RootSize: 5120},
+		},
+	}
+
+	// the rented node is too small for the leader, which is placed on the rentable node with a free public IP
+	candidates := []kubedeployer.NodeCapacity{nodeCapacity(gridNodes[1], true), nodeCapacity(gridNodes[2], false)}
+	placements, err := kubedeployer.ScheduleNodes(cluster.Nodes, candidates)
+	require.NoError(t, err)
+	require.Equal(t, []kubedeployer.Placement{
+		{NodeName: "worker", NodeID: 1},
+		{NodeName: "leader", NodeID: 2, RequiresRent: true},
+		{NodeName: "shared", NodeID: 3},
+	}, placements)
+
+	estimate, err := estimateClusterCost(fakePricer{}, cluster, gridNodes)
+	require.NoError(t, err)
+	require.Len(t, estimate.Nodes, 3)
+
+	// the capacity of a rented node is covered by its rent
+	assert.Equal(t, BillingRented, estimate.Nodes[0].Billing)
+	assert.Zero(t, estimate.Nodes[0].Cost.Monthly.USD)
+
+	// half of the node price is the rent, the certified public IPv4 is billed on top of it
+	assert.Equal(t, BillingRentRequired, estimate.Nodes[1].Billing)
+	assert.InDelta(t, 50+4*1.25*0.5, estimate.Nodes[1].Cost.Monthly.USD, 1e-9)
+
+	// 1 vCPU, 1 GB of memory and 5 GB of disk at the shared price
+	assert.Equal(t, BillingShared, estimate.Nodes[2].Billing)
+	assert.InDelta(t, (2+1+0.5)*0.5, estimate.Nodes[2].Cost.Monthly.USD, 1e-9)
+
+	assert.InDelta(t, 52.5+1.75, estimate.Total.Monthly.USD, 1e-9)
+	assert.InDelta(t, (52.5+1.75)/hoursPerMonth, estimate.Total.Hourly.USD, 1e-9)
+	assert.InDelta(t, (52.5+1.75)*10, estimate.Total.Monthly.TFT, 1e-9)
+	assert.Equal(t, []string{"node 2 is not rented yet, it must be reserved before deploying"}, estimate.Warnings)
+}
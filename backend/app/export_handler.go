@@ -0,0 +1,218 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xmonader/ewf"
+	"gorm.io/gorm"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	clusterDocumentAPIVersion = "kubecloud/v1"
+	clusterDocumentKind       = "Cluster"
+	maxClusterDocumentSize    = 10 << 20
+)
+
+var clusterNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]{3,20}$`)
+
+// ClusterDocument is the portable representation of a cluster used to export and import it
+type ClusterDocument struct {
+	APIVersion  string               `json:"api_version"`
+	Kind        string               `json:"kind"`
+	ExportedAt  time.Time            `json:"exported_at"`
+	ProjectName string               `json:"project_name"`
+	Cluster     kubedeployer.Cluster `json:"cluster"`
+	Kubeconfig  string               `json:"kubeconfig,omitempty"`
+}
+
+// @Summary Export deployment
+// @Description Exports the spec and computed state of a deployment (nodes, contracts, network) as a YAML or JSON document that can be imported again
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Produce application/yaml
+// @Param name path string true "Deployment name"
+// @Param format query string false "Document format, yaml or json" default(yaml)
+// @Param include_kubeconfig query bool false "Include the owner kubeconfig in the document"
+// @Success 200 {object} ClusterDocument "Exported deployment"
+// @Failure 400 {object} APIResponse "Invalid request"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/export [get]
+func (h *Handler) HandleExportDeployment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "yaml")
+	if format != "yaml" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be yaml or json"})
+		return
+	}
+
+	name := c.Param("name")
+	projectName := kubedeployer.GetProjectName(userID, name)
+	cluster, err := h.db.GetClusterByName(userID, projectName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		} else {
+			logger.GetLogger().Error().Err(err).Int("user_id", userID).Str("project_name", projectName).Msg("Database error when looking up deployment for export")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		}
+		return
+	}
+
+	clusterResult, err := cluster.GetClusterResult()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to deserialize cluster result")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deployment details"})
+		return
+	}
+
+	doc := ClusterDocument{
+		APIVersion:  clusterDocumentAPIVersion,
+		Kind:        clusterDocumentKind,
+		ExportedAt:  time.Now().UTC(),
+		ProjectName: cluster.ProjectName,
+		Cluster:     clusterResult,
+	}
+	// the owner credential is exported, never the k3s admin kubeconfig which can't be revoked
+	if c.Query("include_kubeconfig") == "true" {
+		adminKubeconfig, err := h.getAdminKubeconfig(&cluster, clusterResult)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to retrieve admin kubeconfig")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
+			return
+		}
+
+		credential, err := h.getOwnerCredential(c.Request.Context(), userID, projectName, adminKubeconfig, clusterResult)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to issue owner kubeconfig")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue kubeconfig: " + err.Error()})
+			return
+		}
+		doc.Kubeconfig = credential.Kubeconfig
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to marshal cluster document")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export deployment"})
+		return
+	}
+
+	contentType := "application/json"
+	if format == "yaml" {
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to convert cluster document to YAML")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export deployment"})
+			return
+		}
+		contentType = "application/yaml"
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// @Summary Import deployment
+// @Description Adopts the deployments found on chain under the project of a cluster into a new deployment record.
+// @Description The body is an exported YAML or JSON document. Every contract it lists must still be active and owned by the twin of the current user.
+// @Description Deployments exported under another user ID are moved to the project of the current user.
+// @Description A document holding only the cluster name recovers a deployment whose record was never stored.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Accept application/yaml
+// @Produce json
+// @Param document body ClusterDocument true "Exported cluster document"
+// @Success 202 {object} Response "Import workflow started successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 409 {object} APIResponse "Deployment already exists"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/import [post]
+func (h *Handler) HandleImportDeployment(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxClusterDocumentSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+		return
+	}
+
+	// JSON is valid YAML, so both formats are parsed the same way
+	var doc ClusterDocument
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster document: " + err.Error()})
+		return
+	}
+
+	if doc.Kind != "" && doc.Kind != clusterDocumentKind {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported document kind %q", doc.Kind)})
+		return
+	}
+	if !clusterNameRegex.MatchString(doc.Cluster.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cluster name must be 3 to 20 alphanumeric characters"})
+		return
+	}
+
+	// the document may come from another backend where the user had another ID, its contracts are only
+	// listed for the user's twin and are moved to the user's project once adopted
+	projectName := kubedeployer.GetProjectName(config.UserID, doc.Cluster.Name)
+	if doc.ProjectName != "" && !kubedeployer.IsProjectOfCluster(doc.ProjectName, doc.Cluster.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("project %s is not a project of cluster %s", doc.ProjectName, doc.Cluster.Name)})
+		return
+	}
+
+	_, err = h.db.GetClusterByName(config.UserID, projectName)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "deployment already exists"})
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Str("project_name", projectName).Msg("Database error when looking up deployment for import")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		return
+	}
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowImportCluster)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":         config,
+		"cluster":        doc.Cluster,
+		"source_project": doc.ProjectName,
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Import workflow started successfully",
+	})
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"kubecloud/internal"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/notification"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// alertHostKeyChange notifies the admins that a node presented another SSH host key than the trusted one
+func (h *Handler) alertHostKeyChange(changeErr *internal.HostKeyChangedError) {
+	admins, err := h.db.ListAdmins()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Msg("failed to list admins to alert about host key change")
+		return
+	}
+
+	payload := notification.CommonPayload{
+		Status:  "host_key_changed",
+		Subject: fmt.Sprintf("SSH host key of node %s changed", changeErr.NodeName),
+		Message: changeErr.Error(),
+	}
+	extras := map[string]string{
+		"node_name":             changeErr.NodeName,
+		"address":               changeErr.Address,
+		"trusted_fingerprint":   changeErr.TrustedFingerprint,
+		"presented_fingerprint": changeErr.PresentedFingerprint,
+	}
+
+	for _, admin := range admins {
+		n := models.NewNotification(admin.ID, models.NotificationTypeNode, notification.MergePayload(payload, extras), models.WithSeverity(models.NotificationSeverityError))
+		if err := h.notificationService.Send(context.Background(), n); err != nil {
+			logger.GetLogger().Error().Err(err).Int("admin_id", admin.ID).Msg("failed to send host key change alert")
+		}
+	}
+}
+
+// @Summary List node host keys
+// @Description Returns the SSH host keys trusted for cluster nodes. With changed=true only the nodes that presented another key are returned,
+// @Description SSH connections to them are refused until they are re-trusted.
+// @Tags admin
+// @ID list-node-host-keys
+// @Produce json
+// @Param changed query bool false "Only return nodes whose host key changed"
+// @Success 200 {array} models.NodeHostKey
+// @Failure 500 {object} APIResponse
+// @Security AdminMiddleware
+// @Router /host-keys [get]
+// ListNodeHostKeysHandler returns the trusted host keys of cluster nodes
+func (h *Handler) ListNodeHostKeysHandler(c *gin.Context) {
+	hostKeys, err := h.db.ListNodeHostKeys(c.Query("changed") == "true")
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Msg("failed to list node host keys")
+		InternalServerError(c)
+		return
+	}
+
+	Success(c, http.StatusOK, "Node host keys are retrieved successfully", map[string]any{
+		"host_keys": hostKeys,
+	})
+}
+
+// @Summary Re-trust a node host key
+// @Description Trusts the SSH host key a node presented after a legitimate rebuild. When the node did not present another key,
+// @Description its recorded key is forgotten and the key it presents on the next connection is trusted.
+// @Tags admin
+// @ID retrust-node-host-key
+// @Produce json
+// @Param node_name path string true "Full node name"
+// @Success 200 {object} APIResponse "Host key re-trusted"
+// @Failure 404 {object} APIResponse "Node host key not found"
+// @Failure 500 {object} APIResponse
+// @Security AdminMiddleware
+// @Router /host-keys/{node_name}/trust [post]
+// RetrustNodeHostKeyHandler trusts the host key a node presents after a rebuild
+func (h *Handler) RetrustNodeHostKeyHandler(c *gin.Context) {
+	nodeName := c.Param("node_name")
+
+	hostKey, err := h.db.GetNodeHostKey(nodeName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			Error(c, http.StatusNotFound, "Not Found", "no host key is recorded for this node")
+			return
+		}
+		logger.GetLogger().Error().Err(err).Str("node", nodeName).Msg("failed to get node host key")
+		InternalServerError(c)
+		return
+	}
+
+	if hostKey.PresentedKey == "" {
+		if err := h.db.DeleteNodeHostKey(nodeName); err != nil {
+			logger.GetLogger().Error().Err(err).Str("node", nodeName).Msg("failed to delete node host key")
+			InternalServerError(c)
+			return
+		}
+		Success(c, http.StatusOK, "Node host key is forgotten, the next key it presents is trusted", nil)
+		return
+	}
+
+	hostKey.HostKey = hostKey.PresentedKey
+	hostKey.Fingerprint = hostKey.PresentedFingerprint
+	if err := h.db.TrustNodeHostKey(&hostKey); err != nil {
+		logger.GetLogger().Error().Err(err).Str("node", nodeName).Msg("failed to trust node host key")
+		InternalServerError(c)
+		return
+	}
+
+	logger.GetLogger().Info().Str("node", nodeName).Str("fingerprint", hostKey.Fingerprint).Msg("Node host key re-trusted by admin")
+	Success(c, http.StatusOK, "Node host key is re-trusted", hostKey)
+}
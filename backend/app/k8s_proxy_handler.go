@@ -0,0 +1,218 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// proxyKubeconfigTTL is the lifetime of the API token of a proxy kubeconfig
+const proxyKubeconfigTTL = 30 * 24 * time.Hour
+
+// k8sProxyCache keeps a reverse proxy per cluster so that connections to its API server are reused across requests
+type k8sProxyCache struct {
+	mu      sync.Mutex
+	proxies map[string]k8sProxy
+}
+
+type k8sProxy struct {
+	kubeconfig string
+	proxy      *httputil.ReverseProxy
+	transport  *http.Transport
+}
+
+func newK8sProxyCache() *k8sProxyCache {
+	return &k8sProxyCache{proxies: make(map[string]k8sProxy)}
+}
+
+// get returns the proxy of a cluster, creating it again when its kubeconfig changed
+func (pc *k8sProxyCache) get(projectName, kubeconfig string) (*httputil.ReverseProxy, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if cached, ok := pc.proxies[projectName]; ok {
+		if cached.kubeconfig == kubeconfig {
+			return cached.proxy, nil
+		}
+		cached.transport.CloseIdleConnections()
+	}
+
+	proxy, transport, err := newK8sReverseProxy(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.proxies[projectName] = k8sProxy{kubeconfig: kubeconfig, proxy: proxy, transport: transport}
+	return proxy, nil
+}
+
+// newK8sReverseProxy creates a proxy forwarding requests to the API server of a kubeconfig with its credentials.
+// Responses are flushed right away for watches, and upgraded connections used by exec, attach and port-forward are
+// spliced as they are. HTTP/1.1 is forced towards the API server since upgrades are not possible over HTTP/2.
+func newK8sReverseProxy(kubeconfig string) (*httputil.ReverseProxy, *http.Transport, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	target, err := url.Parse(restConfig.Host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid API server address %q: %w", restConfig.Host, err)
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build TLS config: %w", err)
+	}
+	if tlsConfig != nil {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 10,
+	}
+
+	bearerToken := restConfig.BearerToken
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+
+			// the backend credentials of the caller must not reach the cluster
+			r.Out.Header.Del("Authorization")
+			query := r.Out.URL.Query()
+			if query.Has("token") {
+				query.Del("token")
+				r.Out.URL.RawQuery = query.Encode()
+			}
+			if bearerToken != "" {
+				r.Out.Header.Set("Authorization", "Bearer "+bearerToken)
+			}
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.GetLogger().Error().Err(err).Str("host", target.Host).Str("path", r.URL.Path).Msg("Kubernetes API proxy request failed")
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return proxy, transport, nil
+}
+
+// @Summary Kubernetes API proxy
+// @Description Forwards requests under /deployments/{name}/k8s to the Kubernetes API server of the deployment, so it can be reached
+// @Description without Mycelium. Watches, exec, attach and port-forward are supported. The request is authenticated with a user
+// @Description token or a k8s API token of the deployment, the kubeconfig returned with endpoint=proxy is set up to use one.
+// @Description Requests reach the cluster with the owner credential of the deployment.
+// @Tags deployments
+// @Security BearerAuth
+// @Param name path string true "Deployment name"
+// @Param path path string true "Kubernetes API path"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 403 {object} APIResponse "API token not allowed"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 502 {object} APIResponse "Kubernetes API server unreachable"
+// @Router /deployments/{name}/k8s/{path} [get]
+func (h *Handler) HandleK8sProxy(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	cluster, clusterResult, ok := h.getUserDeployment(c, statemanager.ClientConfig{UserID: userID})
+	if !ok {
+		return
+	}
+
+	adminKubeconfig, err := h.getAdminKubeconfig(&cluster, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to retrieve admin kubeconfig")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
+		return
+	}
+
+	// requests are forwarded with the owner credential, the admin kubeconfig never leaves the backend
+	credential, err := h.getOwnerCredential(c.Request.Context(), userID, cluster.ProjectName, adminKubeconfig, clusterResult)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to issue owner kubeconfig")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to retrieve kubeconfig: " + err.Error()})
+		return
+	}
+
+	proxy, err := h.k8sProxies.get(cluster.ProjectName, leaderKubeconfig(credential.Kubeconfig, clusterResult))
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to create Kubernetes API proxy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reach the cluster"})
+		return
+	}
+
+	c.Request.URL.Path = c.Param("path")
+	c.Request.URL.RawPath = ""
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// proxyKubeconfig builds a kubeconfig pointing at the Kubernetes API proxy of a deployment
+func proxyKubeconfig(serverURL, deploymentName, token string) (string, error) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters[deploymentName] = &clientcmdapi.Cluster{Server: serverURL}
+	config.AuthInfos[deploymentName] = &clientcmdapi.AuthInfo{Token: token}
+	config.Contexts[deploymentName] = &clientcmdapi.Context{Cluster: deploymentName, AuthInfo: deploymentName}
+	config.CurrentContext = deploymentName
+
+	kubeconfig, err := clientcmd.Write(*config)
+	if err != nil {
+		return "", fmt.Errorf("failed to write kubeconfig: %w", err)
+	}
+	return string(kubeconfig), nil
+}
+
+// k8sProxyURL returns the address of the Kubernetes API proxy of a deployment as seen by the caller
+func k8sProxyURL(c *gin.Context, deploymentName string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/v1/deployments/%s/k8s", scheme, c.Request.Host, url.PathEscape(deploymentName))
+}
+
+// issueProxyKubeconfig creates a kubeconfig for the Kubernetes API proxy of a deployment along with the API token it uses.
+// A deployment has a single proxy kubeconfig token, the one of the previously generated kubeconfig is deleted.
+func (h *Handler) issueProxyKubeconfig(c *gin.Context, userID int, deploymentName string) (string, error) {
+	tokenName := "kubeconfig-" + deploymentName
+	projectName := kubedeployer.GetProjectName(userID, deploymentName)
+
+	tokens, err := h.db.ListUserAPITokens(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list API tokens: %w", err)
+	}
+	for _, token := range tokens {
+		if token.Name != tokenName {
+			continue
+		}
+		if err := h.db.DeleteAPIToken(token.ID, userID); err != nil {
+			return "", fmt.Errorf("failed to delete previous kubeconfig token: %w", err)
+		}
+	}
+
+	expiresAt := time.Now().Add(proxyKubeconfigTTL)
+	_, token, err := h.createAPIToken(userID, tokenName, models.APITokenScopeK8s, projectName, &expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to create kubeconfig token: %w", err)
+	}
+
+	return proxyKubeconfig(k8sProxyURL(c, deploymentName), deploymentName, token)
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	maxClusterLabels          = 64
+	maxClusterDescriptionSize = 1024
+
+	defaultDeploymentsPageSize = 50
+	maxDeploymentsPageSize     = 200
+	// clusters read at once while looking for the ones matching a label selector
+	deploymentsSelectorBatchSize = 100
+)
+
+// DeploymentMetadataInput holds the description and labels of a deployment
+type DeploymentMetadataInput struct {
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// deploymentListQuery is a parsed GET /deployments query
+type deploymentListQuery struct {
+	filter   models.ClusterFilter
+	selector labels.Selector
+	limit    int
+}
+
+// deploymentCursor is the opaque next_cursor of the deployments list, tied to the order it was issued for
+type deploymentCursor struct {
+	Order string `json:"o"`
+	models.ClusterCursor
+}
+
+// validateClusterMetadata checks labels follow the Kubernetes label syntax so that they can be matched by label selectors
+func validateClusterMetadata(input DeploymentMetadataInput) error {
+	if len(input.Description) > maxClusterDescriptionSize {
+		return fmt.Errorf("description must be at most %d characters", maxClusterDescriptionSize)
+	}
+	if len(input.Labels) > maxClusterLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxClusterLabels)
+	}
+	for key, value := range input.Labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value of label %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// order identifies the order of the listed deployments, e.g. created_at:desc
+func (q *deploymentListQuery) order() string {
+	if q.filter.Descending {
+		return q.filter.SortBy + ":desc"
+	}
+	return q.filter.SortBy + ":asc"
+}
+
+func encodeDeploymentCursor(order string, cursor models.ClusterCursor) string {
+	data, _ := json.Marshal(deploymentCursor{Order: order, ClusterCursor: cursor})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeDeploymentCursor(order string, encoded string) (*models.ClusterCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor deploymentCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if cursor.Order != order {
+		return nil, fmt.Errorf("cursor was issued for another order (%s)", cursor.Order)
+	}
+	return &cursor.ClusterCursor, nil
+}
+
+func parseQueryTime(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+	return &t, nil
+}
+
+// parseDeploymentListQuery reads the filters, order and page of GET /deployments
+func parseDeploymentListQuery(c *gin.Context, userID int) (deploymentListQuery, error) {
+	query := deploymentListQuery{limit: defaultDeploymentsPageSize}
+
+	selector, err := labels.Parse(c.Query("label_selector"))
+	if err != nil {
+		return query, fmt.Errorf("invalid label_selector: %w", err)
+	}
+	query.selector = selector
+
+	if prefix := c.Query("name_prefix"); prefix != "" {
+		query.filter.ProjectNamePrefix = kubedeployer.GetProjectName(userID, prefix)
+	}
+
+	for _, status := range strings.Split(c.Query("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			query.filter.Statuses = append(query.filter.Statuses, status)
+		}
+	}
+
+	if query.filter.CreatedAfter, err = parseQueryTime(c, "created_after"); err != nil {
+		return query, err
+	}
+	if query.filter.CreatedBefore, err = parseQueryTime(c, "created_before"); err != nil {
+		return query, err
+	}
+
+	query.filter.SortBy = c.DefaultQuery("sort", models.ClusterSortCreatedAt)
+	switch query.filter.SortBy {
+	case models.ClusterSortCreatedAt, models.ClusterSortUpdatedAt, models.ClusterSortName:
+	default:
+		return query, fmt.Errorf("sort must be one of created_at, updated_at or name")
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		query.filter.Descending = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		query.limit, err = strconv.Atoi(limit)
+		if err != nil || query.limit < 1 || query.limit > maxDeploymentsPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxDeploymentsPageSize)
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if query.filter.After, err = decodeDeploymentCursor(query.order(), cursor); err != nil {
+			return query, err
+		}
+	}
+
+	return query, nil
+}
+
+// listDeploymentsPage returns a page of the clusters of a user matching the query, and the cursor of the next page if any.
+// Labels are matched as clusters are read, so clusters are read in batches until the page is full.
+func (h *Handler) listDeploymentsPage(userID int, query deploymentListQuery) ([]models.Cluster, string, error) {
+	filter := query.filter
+	filter.Limit = query.limit + 1
+	if !query.selector.Empty() {
+		filter.Limit = max(filter.Limit, deploymentsSelectorBatchSize)
+	}
+
+	var page []models.Cluster
+	for {
+		clusters, err := h.db.ListUserClustersPage(userID, filter)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, cluster := range clusters {
+			if !query.selector.Matches(labels.Set(cluster.Labels)) {
+				continue
+			}
+			page = append(page, cluster)
+			if len(page) > query.limit {
+				next := page[query.limit-1].Cursor(filter.SortBy)
+				return page[:query.limit], encodeDeploymentCursor(query.order(), next), nil
+			}
+		}
+
+		if len(clusters) < filter.Limit {
+			return page, "", nil
+		}
+		last := clusters[len(clusters)-1].Cursor(filter.SortBy)
+		filter.After = &last
+	}
+}
+
+// @Summary Set deployment metadata
+// @Description Replaces the description and labels of a deployment. Label keys and values follow the Kubernetes label syntax,
+// @Description so that deployments can be listed with a label selector.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param metadata body DeploymentMetadataInput true "Description and labels"
+// @Success 200 {object} DeploymentMetadataInput "Deployment metadata updated successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/metadata [put]
+func (h *Handler) HandleSetDeploymentMetadata(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var input DeploymentMetadataInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+		return
+	}
+
+	if err := validateClusterMetadata(input); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, statemanager.ClientConfig{UserID: userID})
+	if !ok {
+		return
+	}
+
+	if input.Labels == nil {
+		input.Labels = map[string]string{}
+	}
+	if err := h.db.UpdateClusterMetadata(cluster.ID, input.Description, input.Labels); err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to update deployment metadata")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update deployment metadata"})
+		return
+	}
+
+	c.JSON(http.StatusOK, input)
+}
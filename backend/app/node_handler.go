@@ -43,6 +43,17 @@ type ListNodesWithDiscountResponse struct {
 	Nodes []NodesWithDiscount `json:"nodes"`
 }
 
+// nodeRentMonthlyUSD is the monthly rent of a node. The grid proxy lists the price of the whole node,
+// a rented node gets a 50% discount on it.
+func nodeRentMonthlyUSD(node proxyTypes.Node) float64 {
+	return node.PriceUsd * 0.5
+}
+
+// nodeRentHourlyUSDMillicent is the rent of a node for one hour, the least balance a user needs to reserve it
+func nodeRentHourlyUSDMillicent(node proxyTypes.Node) uint64 {
+	return internal.FromUSDToUSDMillicent(nodeRentMonthlyUSD(node)) / 24 / 30
+}
+
 // ReserveNodeResponse holds the response for reserve node response
 type ReserveNodeResponse struct {
 	WorkflowID string `json:"workflow_id"`
@@ -273,8 +284,7 @@ func (h *Handler) ReserveNodeHandler(c *gin.Context) {
 		return
 	}
 
-	//TODO: check price in month constant
-	if usdMillicentBalance-user.Debt < internal.FromUSDToUSDMillicent(node.PriceUsd)/24/30 {
+	if usdMillicentBalance-user.Debt < nodeRentHourlyUSDMillicent(node) {
 		Error(c, http.StatusBadRequest, "You should at least have enough balance for one hour", "")
 		return
 	}
@@ -335,7 +345,7 @@ func (h *Handler) ListRentableNodesHandler(c *gin.Context) {
 	for _, node := range nodes {
 		nodesWithDiscount = append(nodesWithDiscount, NodesWithDiscount{
 			Node:          node,
-			DiscountPrice: node.PriceUsd * 0.5,
+			DiscountPrice: nodeRentMonthlyUSD(node),
 		})
 	}
 	Success(c, http.StatusOK, "Nodes are retrieved successfully", ListNodesWithDiscountResponse{
@@ -367,7 +377,7 @@ func (h *Handler) ListRentedNodesHandler(c *gin.Context) {
 	for _, node := range nodes {
 		nodesWithDiscount = append(nodesWithDiscount, NodesWithDiscount{
 			Node:          node,
-			DiscountPrice: node.PriceUsd * 0.5,
+			DiscountPrice: nodeRentMonthlyUSD(node),
 		})
 	}
 	Success(c, http.StatusOK, "Nodes are retrieved successfully", ListNodesWithDiscountResponse{
@@ -376,6 +386,93 @@ func (h *Handler) ListRentedNodesHandler(c *gin.Context) {
 	})
 }
 
+// RentedNodeGPUs holds the GPUs of a rented node
+type RentedNodeGPUs struct {
+	NodeID uint32     `json:"node_id"`
+	FarmID int        `json:"farm_id"`
+	GPUs   []GPUEntry `json:"gpus"`
+}
+
+// GPUEntry describes a GPU of a rented node and the cluster node using it, if any
+type GPUEntry struct {
+	ID     string `json:"id"`
+	Vendor string `json:"vendor"`
+	Device string `json:"device"`
+	VRAM   uint64 `json:"vram"`
+	// Attached is set when the GPU is used by a VM, ContractID is the contract of that VM
+	Attached   bool   `json:"attached"`
+	ContractID int    `json:"contract_id,omitempty"`
+	Cluster    string `json:"cluster,omitempty"`
+	NodeName   string `json:"node_name,omitempty"`
+}
+
+// @Summary List GPUs of rented nodes
+// @Description Returns the GPUs of each rented node of the user, and the cluster node they are attached to
+// @Tags nodes
+// @ID list-rented-node-gpus
+// @Produce json
+// @Success 200 {object} APIResponse{data=[]RentedNodeGPUs}
+// @Failure 500 {object} APIResponse
+// @Security UserMiddleware
+// @Router /user/nodes/rented/gpus [get]
+// ListRentedNodeGPUsHandler lists the GPUs of the nodes rented by the user
+func (h *Handler) ListRentedNodeGPUsHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	nodes, _, err := h.getRentedNodesForUser(c.Request.Context(), userID, false)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("Failed to list rented nodes")
+		InternalServerError(c)
+		return
+	}
+
+	clusters, err := h.db.ListUserClusters(userID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("Failed to list user clusters")
+		InternalServerError(c)
+		return
+	}
+
+	type gpuUser struct{ cluster, node string }
+	users := map[string]gpuUser{}
+	for _, cluster := range clusters {
+		result, err := cluster.GetClusterResult()
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to deserialize cluster result")
+			continue
+		}
+		for _, node := range result.Nodes {
+			for _, gpuID := range node.GPUIDs {
+				users[fmt.Sprintf("%d/%s", node.NodeID, gpuID)] = gpuUser{cluster: result.Name, node: node.OriginalName}
+			}
+		}
+	}
+
+	inventory := []RentedNodeGPUs{}
+	for _, node := range nodes {
+		if len(node.GPUs) == 0 {
+			continue
+		}
+
+		entry := RentedNodeGPUs{NodeID: uint32(node.NodeID), FarmID: node.FarmID}
+		for _, gpu := range node.GPUs {
+			user := users[fmt.Sprintf("%d/%s", node.NodeID, gpu.ID)]
+			entry.GPUs = append(entry.GPUs, GPUEntry{
+				ID:         gpu.ID,
+				Vendor:     gpu.Vendor,
+				Device:     gpu.Device,
+				VRAM:       gpu.Vram,
+				Attached:   gpu.Contract != 0,
+				ContractID: gpu.Contract,
+				Cluster:    user.cluster,
+				NodeName:   user.node,
+			})
+		}
+		inventory = append(inventory, entry)
+	}
+
+	Success(c, http.StatusOK, "GPUs are retrieved successfully", inventory)
+}
+
 // @Summary Unreserve node
 // @Description Unreserve a node for a user
 // @Tags nodes
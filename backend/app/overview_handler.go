@@ -0,0 +1,219 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kubecloud/internal/kubestats"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	clusterOverviewTTL             = 30 * time.Second
+	clusterOverviewTimeout         = 30 * time.Second
+	clusterOverviewMetricsInterval = 5 * time.Minute
+	clusterOverviewMetricsWorkers  = 5
+)
+
+// clusterOverviewCache keeps the last overview of each cluster for a short time, so that dashboards polling it
+// don't hit the API server on every request. Concurrent requests for the same cluster share one collection.
+type clusterOverviewCache struct {
+	mu        sync.Mutex
+	overviews map[string]kubestats.Overview
+	group     singleflight.Group
+}
+
+func newClusterOverviewCache() *clusterOverviewCache {
+	return &clusterOverviewCache{overviews: make(map[string]kubestats.Overview)}
+}
+
+// get returns the cached overview of a cluster, collecting it again once it is older than clusterOverviewTTL
+func (oc *clusterOverviewCache) get(projectName string, collect func() (kubestats.Overview, error)) (kubestats.Overview, error) {
+	oc.mu.Lock()
+	overview, ok := oc.overviews[projectName]
+	oc.mu.Unlock()
+	if ok && time.Since(overview.CollectedAt) < clusterOverviewTTL {
+		return overview, nil
+	}
+
+	value, err, _ := oc.group.Do(projectName, func() (interface{}, error) {
+		overview, err := collect()
+		if err != nil {
+			return nil, err
+		}
+
+		oc.mu.Lock()
+		oc.overviews[projectName] = overview
+		oc.mu.Unlock()
+		return overview, nil
+	})
+	if err != nil {
+		return kubestats.Overview{}, err
+	}
+	return value.(kubestats.Overview), nil
+}
+
+// forget drops the overviews of the clusters not in projectNames
+func (oc *clusterOverviewCache) forget(projectNames map[string]bool) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	for projectName := range oc.overviews {
+		if !projectNames[projectName] {
+			delete(oc.overviews, projectName)
+		}
+	}
+}
+
+// getClusterOverview returns the overview of a deployment and exports it as metrics when it was collected again
+func (h *Handler) getClusterOverview(cluster *models.Cluster) (kubestats.Overview, error) {
+	clusterResult, err := cluster.GetClusterResult()
+	if err != nil {
+		return kubestats.Overview{}, err
+	}
+
+	return h.clusterOverviews.get(cluster.ProjectName, func() (kubestats.Overview, error) {
+		adminKubeconfig, err := h.getAdminKubeconfig(cluster, clusterResult)
+		if err != nil {
+			return kubestats.Overview{}, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), clusterOverviewTimeout)
+		defer cancel()
+
+		overview, err := kubestats.Collect(ctx, leaderKubeconfig(adminKubeconfig, clusterResult))
+		if err != nil {
+			return kubestats.Overview{}, err
+		}
+
+		h.recordClusterOverview(cluster.UserID, clusterResult.Name, overview)
+		return overview, nil
+	})
+}
+
+// recordClusterOverview exports the overview of a cluster as Prometheus gauges labelled by user and cluster
+func (h *Handler) recordClusterOverview(userID int, clusterName string, overview kubestats.Overview) {
+	user := strconv.Itoa(userID)
+	h.metrics.ResetClusterResources(user, clusterName)
+
+	for _, node := range overview.Nodes {
+		h.metrics.SetClusterNodeUsage(user, clusterName, node.Name,
+			float64(node.CPUUsageMillicores)/1000, float64(node.CPUAllocatableMillicores)/1000,
+			float64(node.MemoryUsageBytes), float64(node.MemoryAllocatableBytes),
+		)
+	}
+
+	for _, namespace := range overview.Namespaces {
+		h.metrics.SetClusterPods(user, clusterName, namespace.Namespace, "running", namespace.Running)
+		h.metrics.SetClusterPods(user, clusterName, namespace.Namespace, "pending", namespace.Pending)
+		h.metrics.SetClusterPods(user, clusterName, namespace.Namespace, "succeeded", namespace.Succeeded)
+		h.metrics.SetClusterPods(user, clusterName, namespace.Namespace, "failed", namespace.Failed)
+	}
+
+	h.metrics.SetClusterFailingPods(user, clusterName, len(overview.FailingPods))
+
+	for _, volume := range overview.Volumes {
+		var used *float64
+		if volume.UsedBytes != nil {
+			usedBytes := float64(*volume.UsedBytes)
+			used = &usedBytes
+		}
+		h.metrics.SetClusterVolume(user, clusterName, volume.Namespace, volume.Name, float64(volume.CapacityBytes), used)
+	}
+}
+
+// TrackClusterOverviews periodically collects the overview of every cluster so that its metrics stay current,
+// and removes the metrics of deleted clusters
+func (h *Handler) TrackClusterOverviews() {
+	ticker := time.NewTicker(clusterOverviewMetricsInterval)
+	defer ticker.Stop()
+
+	// clusters whose metrics were exported, by project name
+	exported := make(map[string]models.Cluster)
+
+	for range ticker.C {
+		clusters, err := h.db.ListAllClusters()
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Msg("Failed to list clusters to collect their overview")
+			continue
+		}
+
+		current := make(map[string]bool, len(clusters))
+		for _, cluster := range clusters {
+			current[cluster.ProjectName] = true
+		}
+
+		for projectName, cluster := range exported {
+			if current[projectName] {
+				continue
+			}
+			if clusterResult, err := cluster.GetClusterResult(); err == nil {
+				h.metrics.ResetClusterResources(strconv.Itoa(cluster.UserID), clusterResult.Name)
+			}
+			delete(exported, projectName)
+		}
+		h.clusterOverviews.forget(current)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		jobs := make(chan models.Cluster)
+		for i := 0; i < clusterOverviewMetricsWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for cluster := range jobs {
+					if _, err := h.getClusterOverview(&cluster); err != nil {
+						logger.GetLogger().Warn().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to collect cluster overview")
+						continue
+					}
+					mu.Lock()
+					exported[cluster.ProjectName] = cluster
+					mu.Unlock()
+				}
+			}()
+		}
+
+		for _, cluster := range clusters {
+			jobs <- cluster
+		}
+		close(jobs)
+		wg.Wait()
+	}
+}
+
+// @Summary Get deployment overview
+// @Description Returns the CPU and memory usage of the nodes (from metrics-server), pod counts per namespace, failing pods,
+// @Description persistent volume claim usage and the most recent Kubernetes events of a deployment. The overview is cached for 30 seconds.
+// @Tags deployments
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 200 {object} kubestats.Overview
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 502 {object} APIResponse "Cluster unreachable"
+// @Router /deployments/{name}/overview [get]
+func (h *Handler) HandleGetDeploymentOverview(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	cluster, _, ok := h.getUserDeployment(c, statemanager.ClientConfig{UserID: userID})
+	if !ok {
+		return
+	}
+
+	overview, err := h.getClusterOverview(&cluster)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to collect cluster overview")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to collect cluster overview: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, overview)
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"kubecloud/internal"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+// errInsufficientRentBalance is returned when the user can't pay the first hour of the nodes that have to be reserved
+var errInsufficientRentBalance = errors.New("you should at least have enough balance for one hour of the nodes that need to be reserved")
+
+// errRetryNodeNotRented is returned when a node whose deployment failed is moved to a grid node the user didn't rent
+var errRetryNodeNotRented = errors.New("the node can only be moved to a healthy node rented by you")
+
+// placeClusterNodes picks a grid node for every cluster node that has no node_id.
+// The user's healthy rented nodes are tried first; when they don't have enough free capacity
+// and fallbackRentable is set, healthy rentable nodes are considered as well.
+func (h *Handler) placeClusterNodes(ctx context.Context, userID int, cluster *kubedeployer.Cluster, fallbackRentable bool) ([]kubedeployer.Placement, map[uint32]proxyTypes.Node, error) {
+	needsPlacement := false
+	for _, node := range cluster.Nodes {
+		if node.NodeID == 0 {
+			needsPlacement = true
+			break
+		}
+	}
+	if !needsPlacement {
+		return nil, nil, nil
+	}
+
+	rentedNodes, _, err := h.getRentedNodesForUser(ctx, userID, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list rented nodes: %w", err)
+	}
+
+	candidates := make([]kubedeployer.NodeCapacity, 0, len(rentedNodes))
+	for _, node := range rentedNodes {
+		candidates = append(candidates, nodeCapacity(node, true))
+	}
+
+	placements, err := kubedeployer.ScheduleNodes(cluster.Nodes, candidates)
+	var unschedulable *kubedeployer.UnschedulableError
+	if err == nil || !errors.As(err, &unschedulable) || !fallbackRentable {
+		return placements, nil, err
+	}
+
+	rentableNodes, err := h.getRentableNodes(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list rentable nodes: %w", err)
+	}
+
+	rentable := make(map[uint32]proxyTypes.Node, len(rentableNodes))
+	for _, node := range rentableNodes {
+		candidates = append(candidates, nodeCapacity(node, false))
+		rentable[uint32(node.NodeID)] = node
+	}
+
+	placements, err = kubedeployer.ScheduleNodes(cluster.Nodes, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return placements, rentable, nil
+}
+
+// placeRetryNode checks that the node whose deployment failed can be moved to nodeID, a healthy node rented by the user.
+// The scheduler checks its free capacity the same way it does for the nodes placed by deploy requests.
+func (h *Handler) placeRetryNode(ctx context.Context, userID int, node kubedeployer.Node, nodeID uint32) error {
+	rentedNodes, _, err := h.getRentedNodesForUser(ctx, userID, true)
+	if err != nil {
+		return fmt.Errorf("failed to list rented nodes: %w", err)
+	}
+
+	idx := slices.IndexFunc(rentedNodes, func(rented proxyTypes.Node) bool { return uint32(rented.NodeID) == nodeID })
+	if idx == -1 {
+		return errRetryNodeNotRented
+	}
+
+	node.NodeID = 0
+	_, err = kubedeployer.ScheduleNodes([]kubedeployer.Node{node}, []kubedeployer.NodeCapacity{nodeCapacity(rentedNodes[idx], true)})
+	return err
+}
+
+// nodesToReserve returns the grid nodes chosen from the rentable fallback, the deploy workflow rents them before
+// deploying the cluster. The user must be able to pay their rent for one hour.
+func (h *Handler) nodesToReserve(user models.User, placements []kubedeployer.Placement, rentable map[uint32]proxyTypes.Node) ([]uint32, error) {
+	var nodeIDs []uint32
+	seen := make(map[uint32]bool)
+	var hourlyPriceMillicent uint64
+	for _, placement := range placements {
+		if !placement.RequiresRent || seen[placement.NodeID] {
+			continue
+		}
+		seen[placement.NodeID] = true
+		nodeIDs = append(nodeIDs, placement.NodeID)
+		hourlyPriceMillicent += nodeRentHourlyUSDMillicent(rentable[placement.NodeID])
+	}
+	if len(nodeIDs) == 0 {
+		return nil, nil
+	}
+
+	usdMillicentBalance, err := internal.GetUserBalanceUSDMillicent(h.substrateClient, user.Mnemonic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user balance: %w", err)
+	}
+	if usdMillicentBalance < user.Debt || usdMillicentBalance-user.Debt < hourlyPriceMillicent {
+		return nil, errInsufficientRentBalance
+	}
+
+	return nodeIDs, nil
+}
+
+func (h *Handler) getRentableNodes(ctx context.Context) ([]proxyTypes.Node, error) {
+	healthy := true
+	rentable := true
+	filter := proxyTypes.NodeFilter{
+		Healthy:  &healthy,
+		Rentable: &rentable,
+		Features: zos3NodeFeatures,
+	}
+
+	nodes, _, err := h.proxyClient.Nodes(ctx, filter, proxyTypes.DefaultLimit())
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// nodeCapacity converts the grid proxy resources of a node to the free capacity used by the scheduler
+func nodeCapacity(node proxyTypes.Node, rented bool) kubedeployer.NodeCapacity {
+	capacity := kubedeployer.NodeCapacity{
+		NodeID:      uint32(node.NodeID),
+		FarmID:      uint32(node.FarmID),
+		FarmFreeIPs: uint64(node.FarmFreeIps),
+		Rented:      rented,
+	}
+
+	total, used := node.TotalResources, node.UsedResources
+	if total.CRU > used.CRU {
+		capacity.CPU = total.CRU - used.CRU
+	}
+	if total.MRU > used.MRU {
+		capacity.Memory = uint64(total.MRU-used.MRU) / (1024 * 1024)
+	}
+	if total.SRU > used.SRU {
+		capacity.Disk = uint64(total.SRU-used.SRU) / (1024 * 1024)
+	}
+
+	for _, gpu := range node.GPUs {
+		if gpu.Contract == 0 {
+			capacity.GPUs = append(capacity.GPUs, gpu.ID)
+		}
+	}
+
+	return capacity
+}
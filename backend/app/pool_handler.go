@@ -0,0 +1,275 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"kubecloud/internal/activities"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xmonader/ewf"
+)
+
+// NodePoolResponse represents the response of a node pool update
+type NodePoolResponse struct {
+	WorkflowIDs []string              `json:"task_ids"`
+	Message     string                `json:"message"`
+	Pool        kubedeployer.NodePool `json:"pool"`
+	Added       []string              `json:"added,omitempty"`
+	Removed     []string              `json:"removed,omitempty"`
+}
+
+// @Summary Create, update or scale a node pool
+// @Description Creates the node pool or updates it, then adds or removes worker nodes until the pool has its number of replicas.
+// @Description Pool nodes are named after the pool followed by an index and placed on the rented nodes of the user, limited to the pool candidates when set.
+// @Description The nodes are added or removed by a single update workflow that resumes after a restart, labels and taints are applied to all the pool nodes.
+// @Description Only one node pool workflow of a deployment runs at a time, the request fails with 409 while one runs.
+// @Tags deployments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param pool path string true "Pool name"
+// @Param pool_spec body kubedeployer.NodePool true "Node pool"
+// @Success 200 {object} NodePoolResponse "Node pool is up to date"
+// @Success 202 {object} NodePoolResponse "Node pool workflow started successfully"
+// @Failure 400 {object} APIResponse "Invalid request format or not enough capacity for the new nodes"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 409 {object} APIResponse "A workflow changing the nodes of the deployment is already running"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/pools/{pool} [put]
+func (h *Handler) HandlePutNodePool(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var pool kubedeployer.NodePool
+	if err := c.ShouldBindJSON(&pool); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+		return
+	}
+	pool.Name = c.Param("pool")
+
+	if err := pool.Validate(); err != nil {
+		Error(c, http.StatusBadRequest, "Validation failed", err.Error())
+		return
+	}
+
+	cluster, cl, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+	// the pools of the stored cluster are not up to date while one of their workflows runs
+	staleClaim, ok := h.stalePoolsClaim(c, &cluster)
+	if !ok {
+		return
+	}
+
+	previous, existed := cl.Pool(pool.Name)
+	added, removed := kubedeployer.PlanPoolScale(cl, pool)
+
+	if len(added) > 0 {
+		if err := h.placePoolNodes(c.Request.Context(), config.UserID, cl, pool, added); err != nil {
+			var unschedulable *kubedeployer.UnschedulableError
+			if errors.As(err, &unschedulable) {
+				Error(c, http.StatusBadRequest, "Insufficient capacity", err.Error())
+				return
+			}
+			logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Str("pool", pool.Name).Msg("Failed to place pool nodes")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to place pool nodes"})
+			return
+		}
+	}
+
+	response := NodePoolResponse{Pool: pool, WorkflowIDs: []string{}}
+	for _, node := range added {
+		response.Added = append(response.Added, node.OriginalName)
+	}
+	response.Removed = removed
+
+	updateNodes := existed && poolNodesChanged(previous, pool) && len(cl.PoolNodes(pool.Name)) > 0
+	cl.SetPool(pool)
+
+	var wf *ewf.Workflow
+	switch {
+	case len(added) > 0 || len(removed) > 0:
+		// added nodes get the labels and taints of the pool, and the remaining ones are updated, by the update workflow
+		wf, err = h.newPoolScaleWorkflow(config, cl, poolScalePlan(added, removed))
+	case updateNodes:
+		wf, err = h.ewfEngine.NewWorkflow(constants.WorkflowUpdateNodePools)
+		if err == nil {
+			wf.State = ewf.State{
+				"config":  config,
+				"cluster": cl,
+			}
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	if wf != nil && !h.claimClusterPools(c, &cluster, wf, staleClaim) {
+		return
+	}
+
+	if err := cluster.SetClusterResult(cl); err != nil {
+		h.releaseClusterPools(wf)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save node pool"})
+		return
+	}
+	if err := h.db.UpdateCluster(&cluster); err != nil {
+		h.releaseClusterPools(wf)
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to save node pool")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save node pool"})
+		return
+	}
+
+	if wf == nil {
+		response.Message = "Node pool is up to date"
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	response.WorkflowIDs = append(response.WorkflowIDs, wf.UUID)
+	response.Message = "Node pool workflow started successfully"
+	c.JSON(http.StatusAccepted, response)
+}
+
+// poolScalePlan returns the update plan adding and removing pool nodes
+func poolScalePlan(added []kubedeployer.Node, removed []string) kubedeployer.UpdatePlan {
+	plan := kubedeployer.UpdatePlan{Operations: make([]kubedeployer.NodeOperation, 0, len(added)+len(removed))}
+	for i := range added {
+		plan.Operations = append(plan.Operations, kubedeployer.NodeOperation{
+			Action:   kubedeployer.NodeActionAdd,
+			NodeName: added[i].OriginalName,
+			Node:     &added[i],
+		})
+	}
+	for _, nodeName := range removed {
+		plan.Operations = append(plan.Operations, kubedeployer.NodeOperation{Action: kubedeployer.NodeActionRemove, NodeName: nodeName})
+	}
+	return plan
+}
+
+// newPoolScaleWorkflow creates the update workflow applying a pool scaling plan, it is persisted so it resumes after a restart
+func (h *Handler) newPoolScaleWorkflow(config statemanager.ClientConfig, cl kubedeployer.Cluster, plan kubedeployer.UpdatePlan) (*ewf.Workflow, error) {
+	wfName := activities.GetUpdateWorkflowName(len(plan.Operations))
+	activities.NewDynamicUpdateWorkflowTemplate(h.ewfEngine, h.metrics, h.db, h.notificationService, h.config.SSH.PrivateKeyPath, wfName, len(plan.Operations))
+
+	wf, err := h.ewfEngine.NewWorkflow(wfName)
+	if err != nil {
+		return nil, err
+	}
+	wf.State = ewf.State{
+		"config":  config,
+		"cluster": cl,
+		"plan":    plan,
+	}
+	return wf, nil
+}
+
+// stalePoolsClaim returns the node pools claim of the cluster that can be taken over, empty when there is none.
+// Besides pool scaling, the update, upgrade and leader promotion workflows hold the claim since they change the nodes of the cluster too.
+// It responds with 409 while the workflow holding the claim runs, a claim whose workflow ended or was never started
+// by its server is stale.
+func (h *Handler) stalePoolsClaim(c *gin.Context, cluster *models.Cluster) (string, bool) {
+	holder := cluster.PoolWorkflowID
+	running, err := activities.PoolsClaimRunning(c.Request.Context(), h.workflowStore, holder)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", holder).Msg("Failed to load node pool workflow")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup node pool workflow"})
+		return "", false
+	}
+	if running {
+		c.JSON(http.StatusConflict, gin.H{"error": "a workflow changing the nodes of this deployment is already running", "task_id": holder})
+		return "", false
+	}
+	return holder, true
+}
+
+// claimClusterPools makes wf the only workflow changing the node pools of the cluster, taking over the stale claim.
+// It responds with 409 when another request claimed them first. The workflow is saved right away so it is known to the next requests.
+func (h *Handler) claimClusterPools(c *gin.Context, cluster *models.Cluster, wf *ewf.Workflow, staleClaim string) bool {
+	claimed, err := h.db.ClaimClusterPools(cluster.ID, wf.UUID, staleClaim)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to claim node pools")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return false
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "a workflow changing the nodes of this deployment is already running"})
+		return false
+	}
+
+	if err := h.ewfEngine.Store().SaveWorkflow(c.Request.Context(), wf); err != nil {
+		h.releaseClusterPools(wf)
+		logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to save node pool workflow")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return false
+	}
+	return true
+}
+
+// releaseClusterPools clears the node pools claim of a workflow that won't run
+func (h *Handler) releaseClusterPools(wf *ewf.Workflow) {
+	if wf == nil {
+		return
+	}
+	if err := h.db.ReleaseClusterPools(wf.UUID); err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to release node pools claim")
+	}
+}
+
+// placePoolNodes picks a rented grid node for every new pool node, only among the pool candidates when it has some.
+// A grid node already hosting a node of the cluster is not picked.
+func (h *Handler) placePoolNodes(ctx context.Context, userID int, cl kubedeployer.Cluster, pool kubedeployer.NodePool, nodes []kubedeployer.Node) error {
+	rentedNodes, _, err := h.getRentedNodesForUser(ctx, userID, true)
+	if err != nil {
+		return fmt.Errorf("failed to list rented nodes: %w", err)
+	}
+
+	candidates := make([]kubedeployer.NodeCapacity, 0, len(rentedNodes))
+	for _, node := range rentedNodes {
+		if len(pool.Candidates) > 0 && !slices.Contains(pool.Candidates, uint32(node.NodeID)) {
+			continue
+		}
+		candidates = append(candidates, nodeCapacity(node, true))
+	}
+
+	// the capacity of the deployed nodes is already used on the grid nodes, they are only passed to block their grid nodes
+	scheduled := append(slices.Clone(cl.Nodes), nodes...)
+	if _, err := kubedeployer.ScheduleNodes(scheduled, candidates); err != nil {
+		return err
+	}
+	for i := range nodes {
+		nodes[i].NodeID = scheduled[len(cl.Nodes)+i].NodeID
+	}
+	return nil
+}
+
+// poolNodesChanged reports whether the pool nodes have to be updated for the new pool spec
+func poolNodesChanged(previous, pool kubedeployer.NodePool) bool {
+	if len(previous.Labels) != len(pool.Labels) || !slices.Equal(previous.Taints, pool.Taints) {
+		return true
+	}
+	for key, value := range pool.Labels {
+		if previousValue, ok := previous.Labels[key]; !ok || previousValue != value {
+			return true
+		}
+	}
+	return false
+}
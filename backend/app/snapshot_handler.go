@@ -0,0 +1,389 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"kubecloud/internal/backup"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xmonader/ewf"
+	"gorm.io/gorm"
+)
+
+// SnapshotListResponse holds the snapshots of a deployment
+type SnapshotListResponse struct {
+	Snapshots []models.ClusterSnapshot `json:"snapshots"`
+	Count     int                      `json:"count"`
+}
+
+// SnapshotScheduleInput holds how often snapshots of a deployment are taken
+type SnapshotScheduleInput struct {
+	Enabled       bool `json:"enabled"`
+	IntervalHours int  `json:"interval_hours" binding:"required,min=1,max=720"`
+	// Retention is the number of scheduled snapshots kept, manual snapshots are never pruned
+	Retention int `json:"retention" binding:"required,min=1,max=100"`
+}
+
+// getUserDeployment looks up the deployment of the user in the path, writing the error response when it can't be found
+func (h *Handler) getUserDeployment(c *gin.Context, config statemanager.ClientConfig) (models.Cluster, kubedeployer.Cluster, bool) {
+	deploymentName := c.Param("name")
+	if deploymentName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deployment name is required"})
+		return models.Cluster{}, kubedeployer.Cluster{}, false
+	}
+
+	projectName := kubedeployer.GetProjectName(config.UserID, deploymentName)
+	cluster, err := h.db.GetClusterByName(config.UserID, projectName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		} else {
+			logger.GetLogger().Error().Err(err).Int("user_id", config.UserID).Str("project_name", projectName).Msg("Database error when looking up deployment")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		}
+		return models.Cluster{}, kubedeployer.Cluster{}, false
+	}
+
+	cl, err := cluster.GetClusterResult()
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to deserialize cluster result")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deployment details"})
+		return models.Cluster{}, kubedeployer.Cluster{}, false
+	}
+
+	return cluster, cl, true
+}
+
+// getSnapshot looks up the snapshot in the path, writing the error response when it can't be found
+func (h *Handler) getSnapshot(c *gin.Context, userID int, projectName string) (models.ClusterSnapshot, bool) {
+	snapshotID, err := strconv.Atoi(c.Param("snapshot_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid snapshot ID"})
+		return models.ClusterSnapshot{}, false
+	}
+
+	snapshot, err := h.db.GetClusterSnapshot(userID, projectName, snapshotID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+		} else {
+			logger.GetLogger().Error().Err(err).Int("snapshot_id", snapshotID).Msg("Failed to get snapshot")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup snapshot"})
+		}
+		return models.ClusterSnapshot{}, false
+	}
+
+	return snapshot, true
+}
+
+// @Summary List deployment snapshots
+// @Description Lists the datastore snapshots of a deployment, newest first
+// @Tags snapshots
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 200 {object} SnapshotListResponse "Snapshots retrieved successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/snapshots [get]
+func (h *Handler) HandleListSnapshots(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	snapshots, err := h.db.ListClusterSnapshots(config.UserID, cluster.ProjectName)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to list snapshots")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve snapshots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SnapshotListResponse{
+		Snapshots: snapshots,
+		Count:     len(snapshots),
+	})
+}
+
+// @Summary Take deployment snapshot
+// @Description Takes a datastore snapshot of a deployment from its control plane and stores it in the backup store
+// @Tags snapshots
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 202 {object} Response "Snapshot workflow started successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/snapshots [post]
+func (h *Handler) HandleCreateSnapshot(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, cl, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowSnapshotCluster)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":    config,
+		"cluster":   cl,
+		"scheduled": false,
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Snapshot workflow started successfully",
+	})
+}
+
+// @Summary Get deployment snapshot schedule
+// @Description Returns how often snapshots of a deployment are taken, a disabled schedule is returned when none is set
+// @Tags snapshots
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Success 200 {object} models.SnapshotSchedule "Snapshot schedule retrieved successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/snapshots/schedule [get]
+func (h *Handler) HandleGetSnapshotSchedule(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	schedule, err := h.db.GetSnapshotSchedule(config.UserID, cluster.ProjectName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to get snapshot schedule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve snapshot schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// @Summary Set deployment snapshot schedule
+// @Description Sets how often snapshots of a deployment are taken and how many scheduled snapshots are kept
+// @Tags snapshots
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param schedule body SnapshotScheduleInput true "Snapshot schedule"
+// @Success 200 {object} models.SnapshotSchedule "Snapshot schedule updated successfully"
+// @Failure 400 {object} APIResponse "Invalid request format"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/snapshots/schedule [put]
+func (h *Handler) HandleSetSnapshotSchedule(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var input SnapshotScheduleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	schedule := models.SnapshotSchedule{
+		UserID:        config.UserID,
+		ProjectName:   cluster.ProjectName,
+		Enabled:       input.Enabled,
+		IntervalHours: input.IntervalHours,
+		Retention:     input.Retention,
+	}
+	if err := h.db.SaveSnapshotSchedule(&schedule); err != nil {
+		logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to save snapshot schedule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update snapshot schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// @Summary Download deployment snapshot
+// @Description Streams a datastore snapshot of a deployment from the backup store
+// @Tags snapshots
+// @Security BearerAuth
+// @Produce octet-stream
+// @Param name path string true "Deployment name"
+// @Param snapshot_id path int true "Snapshot ID"
+// @Success 200 {file} binary "Snapshot file"
+// @Failure 400 {object} APIResponse "Invalid snapshot ID"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment or snapshot not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/snapshots/{snapshot_id}/download [get]
+func (h *Handler) HandleDownloadSnapshot(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	snapshot, ok := h.getSnapshot(c, config.UserID, cluster.ProjectName)
+	if !ok {
+		return
+	}
+
+	reader, size, err := h.snapshotStore.Get(c.Request.Context(), snapshot.StorageKey)
+	if err != nil {
+		if errors.Is(err, backup.ErrBlobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snapshot file not found in store"})
+			return
+		}
+		logger.GetLogger().Error().Err(err).Int("snapshot_id", snapshot.ID).Msg("Failed to read snapshot from store")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read snapshot"})
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(http.StatusOK, size, "application/octet-stream", reader, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%s.db", snapshot.Name),
+	})
+}
+
+// @Summary Restore deployment snapshot
+// @Description Rebuilds the control plane of a deployment from a snapshot. k3s is stopped on every master, the leader datastore is reset to the snapshot and the other masters rejoin it.
+// @Tags snapshots
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param snapshot_id path int true "Snapshot ID"
+// @Success 202 {object} Response "Restore workflow started successfully"
+// @Failure 400 {object} APIResponse "Invalid snapshot ID"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment or snapshot not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/snapshots/{snapshot_id}/restore [post]
+func (h *Handler) HandleRestoreSnapshot(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, cl, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	snapshot, ok := h.getSnapshot(c, config.UserID, cluster.ProjectName)
+	if !ok {
+		return
+	}
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowRestoreSnapshot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	wf.State = ewf.State{
+		"config":      config,
+		"cluster":     cl,
+		"snapshot_id": snapshot.ID,
+	}
+
+	h.ewfEngine.RunAsync(c, wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Restore workflow started successfully",
+	})
+}
+
+// @Summary Delete deployment snapshot
+// @Description Deletes a snapshot of a deployment from the backup store
+// @Tags snapshots
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Deployment name"
+// @Param snapshot_id path int true "Snapshot ID"
+// @Success 200 {object} APIResponse "Snapshot deleted successfully"
+// @Failure 400 {object} APIResponse "Invalid snapshot ID"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Deployment or snapshot not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /deployments/{name}/snapshots/{snapshot_id} [delete]
+func (h *Handler) HandleDeleteSnapshot(c *gin.Context) {
+	config, err := h.getClientConfig(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, _, ok := h.getUserDeployment(c, config)
+	if !ok {
+		return
+	}
+
+	snapshot, ok := h.getSnapshot(c, config.UserID, cluster.ProjectName)
+	if !ok {
+		return
+	}
+
+	if err := h.snapshotStore.Delete(c.Request.Context(), snapshot.StorageKey); err != nil {
+		logger.GetLogger().Error().Err(err).Int("snapshot_id", snapshot.ID).Msg("Failed to delete snapshot from store")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete snapshot"})
+		return
+	}
+
+	if err := h.db.DeleteClusterSnapshot(snapshot.ID); err != nil {
+		logger.GetLogger().Error().Err(err).Int("snapshot_id", snapshot.ID).Msg("Failed to delete snapshot record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot deleted successfully"})
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+
+	"github.com/xmonader/ewf"
+	"gorm.io/gorm"
+)
+
+const defaultSnapshotScheduleCheckInterval = 5 * time.Minute
+
+// TrackSnapshotSchedules periodically starts a snapshot workflow for every cluster whose schedule is due
+func (h *Handler) TrackSnapshotSchedules() {
+	interval := time.Duration(h.config.Backup.ScheduleCheckIntervalInMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultSnapshotScheduleCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		schedules, err := h.db.ListEnabledSnapshotSchedules()
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Msg("Failed to list snapshot schedules")
+			continue
+		}
+
+		now := time.Now()
+		for _, schedule := range schedules {
+			if !schedule.IsDue(now) {
+				continue
+			}
+
+			cluster, err := h.db.GetClusterByName(schedule.UserID, schedule.ProjectName)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// the cluster was deleted, its snapshots are kept but no new ones are taken
+				if err := h.db.DeleteSnapshotSchedule(schedule.UserID, schedule.ProjectName); err != nil {
+					logger.GetLogger().Error().Err(err).Str("project_name", schedule.ProjectName).Msg("Failed to delete snapshot schedule of removed cluster")
+				}
+				continue
+			}
+			if err != nil {
+				logger.GetLogger().Error().Err(err).Str("project_name", schedule.ProjectName).Msg("Failed to get cluster for scheduled snapshot")
+				continue
+			}
+
+			cl, err := cluster.GetClusterResult()
+			if err != nil {
+				logger.GetLogger().Error().Err(err).Int("cluster_id", cluster.ID).Msg("Failed to get cluster result for scheduled snapshot")
+				continue
+			}
+
+			wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowSnapshotCluster)
+			if err != nil {
+				logger.GetLogger().Error().Err(err).Msg("Failed to create snapshot workflow")
+				continue
+			}
+			wf.State = ewf.State{
+				"config":    statemanager.ClientConfig{UserID: schedule.UserID},
+				"cluster":   cl,
+				"scheduled": true,
+			}
+
+			if err := h.db.UpdateSnapshotScheduleLastRun(schedule.ID, now); err != nil {
+				logger.GetLogger().Error().Err(err).Str("project_name", schedule.ProjectName).Msg("Failed to update snapshot schedule")
+				continue
+			}
+
+			h.ewfEngine.RunAsync(context.Background(), wf)
+		}
+	}
+}
@@ -1,4 +1,663 @@
 package app
 
-// TODO: implement task handlers
-// List, GetStatus
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kubecloud/internal/activities"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xmonader/ewf"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultWorkflowsPageSize = 50
+	maxWorkflowsPageSize     = 200
+	pendingRollbacksInterval = time.Minute
+)
+
+// cancelRequestInterval is how often a cancellable workflow checks whether its cancellation was requested on another server
+var cancelRequestInterval = 5 * time.Second
+
+// RetryWorkflowInput changes a failed workflow before it is retried
+type RetryWorkflowInput struct {
+	NodeID uint32 `json:"node_id"` // rented grid node to deploy the node whose deployment failed on instead
+}
+
+// parseRetryWindow reads the optional retry_window query of the deploy requests, e.g. 2h.
+// It is zero when not given, failed workflows are then rolled back right away instead of being kept for retry.
+func parseRetryWindow(c *gin.Context) (time.Duration, error) {
+	value := c.Query("retry_window")
+	if value == "" {
+		return 0, nil
+	}
+	retryWindow, err := time.ParseDuration(value)
+	if err != nil || retryWindow < 0 || retryWindow > activities.MaxRetryWindow {
+		return 0, fmt.Errorf("retry_window must be a duration between 0 and %s, e.g. 2h", activities.MaxRetryWindow)
+	}
+	return retryWindow, nil
+}
+
+// WorkflowResponse describes a workflow of the user, its state is left out as it holds credentials
+type WorkflowResponse struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Status      string                 `json:"status"`
+	CreatedAt   time.Time              `json:"created_at"`
+	CurrentStep string                 `json:"current_step,omitempty"` // the step running, or the one that failed
+	Cluster     string                 `json:"cluster,omitempty"`      // name of the deployment the workflow acts on
+	Node        string                 `json:"node,omitempty"`         // name of the cluster node the workflow acts on
+	NodeID      uint32                 `json:"node_id,omitempty"`      // grid node the workflow acts on
+	RollbackAt  *time.Time             `json:"rollback_at,omitempty"`  // until when a failed workflow can be retried
+	Steps       []WorkflowStepResponse `json:"steps,omitempty"`
+}
+
+// WorkflowStepResponse describes how a step of a workflow ran
+type WorkflowStepResponse struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"` // pending, running, completed or failed
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// WorkflowListResponse is a page of the workflows of the user
+type WorkflowListResponse struct {
+	Workflows  []WorkflowResponse `json:"workflows"`
+	Count      int                `json:"count"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// newWorkflowResponse describes a workflow, with the detail of its steps when withSteps is set
+func newWorkflowResponse(wf *ewf.Workflow, userID int, withSteps bool) WorkflowResponse {
+	response := WorkflowResponse{
+		ID:          wf.UUID,
+		Name:        wf.Name,
+		Description: activities.GetWorkflowDescription(wf.Name),
+		Status:      string(wf.Status),
+		CreatedAt:   wf.CreatedAt,
+	}
+	if wf.Status != ewf.StatusCompleted && wf.CurrentStep < len(wf.Steps) {
+		response.CurrentStep = wf.Steps[wf.CurrentStep].Name
+	}
+	response.Cluster, response.Node, response.NodeID = workflowSubject(wf, userID)
+	if rollbackAt, ok := activities.PendingRollback(wf); ok && wf.Status == ewf.StatusFailed {
+		response.RollbackAt = &rollbackAt
+	}
+
+	if !withSteps {
+		return response
+	}
+
+	runs := statemanager.GetStepRuns(wf.State)
+	response.Steps = make([]WorkflowStepResponse, 0, len(wf.Steps))
+	for i, step := range wf.Steps {
+		stepResponse := WorkflowStepResponse{Name: step.Name, Status: string(ewf.StatusPending)}
+		switch {
+		case i < wf.CurrentStep:
+			stepResponse.Status = string(ewf.StatusCompleted)
+		case i == wf.CurrentStep && wf.Status == ewf.StatusFailed:
+			stepResponse.Status = string(ewf.StatusFailed)
+		case i == wf.CurrentStep && wf.Status == ewf.StatusRunning:
+			stepResponse.Status = string(ewf.StatusRunning)
+		}
+
+		// a step that didn't run yet in this execution may have a run left by a previous one
+		if run, ok := runs[step.Name]; ok && stepResponse.Status != string(ewf.StatusPending) {
+			if !run.StartedAt.IsZero() {
+				stepResponse.StartedAt = &run.StartedAt
+			}
+			stepResponse.EndedAt = run.EndedAt
+			stepResponse.Attempts = run.Attempts
+			stepResponse.LastError = run.LastError
+		}
+		response.Steps = append(response.Steps, stepResponse)
+	}
+	return response
+}
+
+// workflowSubject returns the deployment, cluster node and grid node a workflow acts on, from its state
+func workflowSubject(wf *ewf.Workflow, userID int) (cluster string, node string, nodeID uint32) {
+	if cl, err := statemanager.GetCluster(wf.State); err == nil {
+		cluster = cl.Name
+	} else if projectName, ok := wf.State["project_name"].(string); ok {
+		cluster = strings.TrimPrefix(projectName, kubedeployer.GetProjectName(userID, ""))
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"node":      wf.State["node"],
+		"node_name": wf.State["node_name"],
+		"node_id":   wf.State["node_id"],
+	})
+	if err != nil {
+		return cluster, "", 0
+	}
+	var subject struct {
+		Node struct {
+			OriginalName string `json:"original_name"`
+			NodeID       uint32 `json:"node_id"`
+		} `json:"node"`
+		NodeName string `json:"node_name"`
+		NodeID   uint32 `json:"node_id"`
+	}
+	if err := json.Unmarshal(data, &subject); err != nil {
+		return cluster, "", 0
+	}
+
+	node, nodeID = subject.NodeName, subject.NodeID
+	if subject.Node.OriginalName != "" {
+		node, nodeID = subject.Node.OriginalName, subject.Node.NodeID
+	}
+	return cluster, node, nodeID
+}
+
+func encodeWorkflowCursor(cursor models.WorkflowCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeWorkflowCursor(encoded string) (*models.WorkflowCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor models.WorkflowCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// parseWorkflowListQuery reads the filters and page of GET /workflows
+func parseWorkflowListQuery(c *gin.Context) (models.WorkflowFilter, error) {
+	filter := models.WorkflowFilter{
+		NamePrefix: c.Query("name"),
+		Limit:      defaultWorkflowsPageSize,
+	}
+
+	for _, status := range strings.Split(c.Query("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseQueryTime(c, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseQueryTime(c, "created_before"); err != nil {
+		return filter, err
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxWorkflowsPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxWorkflowsPageSize)
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if filter.After, err = decodeWorkflowCursor(cursor); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+// @Summary List workflows
+// @Description Retrieves a page of the workflows of the authenticated user matching the filters, newest first.
+// @Description Pass the returned next_cursor as cursor, with the same filters, to get the next page.
+// @Tags workflow
+// @Security BearerAuth
+// @Produce json
+// @Param name query string false "Workflow name or name prefix, e.g. deploy- or add-node"
+// @Param status query string false "Comma separated statuses, e.g. running,failed"
+// @Param created_after query string false "RFC 3339 time the workflows were started at or after"
+// @Param created_before query string false "RFC 3339 time the workflows were started before"
+// @Param limit query int false "Page size, 50 by default and at most 200"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} WorkflowListResponse "Workflows retrieved successfully"
+// @Failure 400 {object} APIResponse "Invalid query"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /workflows [get]
+func (h *Handler) HandleListWorkflows(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	filter, err := parseWorkflowListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	workflows, err := h.workflowStore.ListUserWorkflows(c.Request.Context(), userID, filter)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Msg("Failed to list user workflows")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve workflows"})
+		return
+	}
+
+	response := WorkflowListResponse{Workflows: make([]WorkflowResponse, 0, len(workflows))}
+	if len(workflows) > limit {
+		workflows = workflows[:limit]
+		response.NextCursor = encodeWorkflowCursor(models.NewWorkflowCursor(workflows[limit-1]))
+	}
+	for _, wf := range workflows {
+		response.Workflows = append(response.Workflows, newWorkflowResponse(wf, userID, false))
+	}
+	response.Count = len(response.Workflows)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Get workflow
+// @Description Retrieves a workflow of the authenticated user with every step's status, start and end time,
+// @Description attempts and last error, and the deployment or node it acts on.
+// @Tags workflow
+// @Security BearerAuth
+// @Produce json
+// @Param workflow_id path string true "Workflow ID"
+// @Success 200 {object} WorkflowResponse "Workflow retrieved successfully"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Workflow not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /workflows/{workflow_id} [get]
+func (h *Handler) HandleGetWorkflow(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	workflowID := c.Param("workflow_id")
+
+	wf, err := h.workflowStore.LoadUserWorkflow(c.Request.Context(), userID, workflowID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "workflow not found"})
+		return
+	} else if err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", workflowID).Msg("Failed to load workflow")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load workflow"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newWorkflowResponse(wf, userID, true))
+}
+
+// workflowCancels keeps the cancel function of the workflows that can be cancelled while they run on this server,
+// the ones running on other servers are cancelled through the cancel request saved in the workflow store
+type workflowCancels struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newWorkflowCancels() *workflowCancels {
+	return &workflowCancels{cancels: make(map[string]context.CancelFunc)}
+}
+
+// register returns the context to run a workflow with, cancelled once the workflow is cancelled or done
+func (wc *workflowCancels) register(ctx context.Context, workflowID string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	wc.mu.Lock()
+	wc.cancels[workflowID] = cancel
+	wc.mu.Unlock()
+
+	return ctx, func() {
+		wc.mu.Lock()
+		delete(wc.cancels, workflowID)
+		wc.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the context of a running workflow, it returns false when the workflow isn't running on this server
+func (wc *workflowCancels) cancel(workflowID string) bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	cancel, ok := wc.cancels[workflowID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// startCancellableWorkflow runs a workflow in the background with a context cancelled by HandleCancelWorkflow.
+// The workflow is registered before returning, so it can be cancelled as soon as its ID is handed out.
+// Cancellations requested on other servers are picked up from the workflow store.
+func (h *Handler) startCancellableWorkflow(wf *ewf.Workflow) {
+	ctx, done := h.workflowCancels.register(context.Background(), wf.UUID)
+	go h.watchCancelRequest(ctx, wf.UUID)
+	go func() {
+		defer done()
+		if err := h.ewfEngine.RunSync(ctx, wf); err != nil {
+			logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Str("workflow_name", wf.Name).Msg("Workflow failed")
+		}
+	}()
+}
+
+// watchCancelRequest cancels a workflow running on this server once its cancellation is requested in the store,
+// until the context of the workflow is done
+func (h *Handler) watchCancelRequest(ctx context.Context, workflowID string) {
+	ticker := time.NewTicker(cancelRequestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		requested, err := h.workflowStore.CancelRequested(ctx, workflowID)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.GetLogger().Error().Err(err).Str("workflow_id", workflowID).Msg("Failed to check workflow cancel request")
+			}
+			continue
+		}
+		if requested {
+			logger.GetLogger().Info().Str("workflow_id", workflowID).Msg("Cancelling workflow on request")
+			h.workflowCancels.cancel(workflowID)
+			return
+		}
+	}
+}
+
+// registerDynamicTemplate registers the template of a deploy, update or upgrade workflow,
+// these templates are registered on demand so they may be gone since the server restarted
+func (h *Handler) registerDynamicTemplate(wfName string) {
+	var count int
+	switch {
+	case scanWorkflowName(wfName, "deploy-%d-nodes", &count):
+		activities.NewDynamicDeployWorkflowTemplate(h.ewfEngine, h.metrics, h.db, h.notificationService, wfName, count)
+	case scanWorkflowName(wfName, constants.WorkflowUpdateCluster+"-%d-operations", &count):
+		activities.NewDynamicUpdateWorkflowTemplate(h.ewfEngine, h.metrics, h.db, h.notificationService, h.config.SSH.PrivateKeyPath, wfName, count)
+	case scanWorkflowName(wfName, constants.WorkflowUpgradeCluster+"-%d-nodes", &count):
+		activities.NewDynamicUpgradeWorkflowTemplate(h.ewfEngine, h.db, h.notificationService, h.config.SSH.PrivateKeyPath, wfName, count)
+	}
+}
+
+// scanWorkflowName parses the count of a dynamic workflow name, the whole name has to match the format
+func scanWorkflowName(wfName, format string, count *int) bool {
+	_, err := fmt.Sscanf(wfName, format, count)
+	return err == nil && fmt.Sprintf(format, *count) == wfName
+}
+
+// ResumeRunningWorkflows resumes the workflows that were running when the server stopped,
+// the ones rolling back on failure can be cancelled again
+func (h *Handler) ResumeRunningWorkflows() {
+	ctx := context.Background()
+	log := logger.GetLogger()
+
+	ids, err := h.ewfEngine.Store().ListWorkflowUUIDsByStatus(ctx, ewf.StatusRunning)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list running workflows to resume")
+		return
+	}
+
+	for _, id := range ids {
+		wf, err := h.ewfEngine.Store().LoadWorkflowByUUID(ctx, id)
+		if err != nil {
+			log.Error().Err(err).Str("workflow_id", id).Msg("Failed to load workflow to resume")
+			continue
+		}
+
+		log.Info().Str("workflow_id", wf.UUID).Str("workflow_name", wf.Name).Msg("Resuming workflow")
+		h.registerDynamicTemplate(wf.Name)
+		if activities.HasFailureRollback(wf.Name) {
+			h.startCancellableWorkflow(wf)
+		} else {
+			h.ewfEngine.RunAsync(ctx, wf)
+		}
+	}
+}
+
+// @Summary Cancel workflow
+// @Description Cancels a running deploy or add-node workflow. Its running step is interrupted and the workflow fails,
+// @Description which rolls back the deployment or the added node so the contracts it already created are cancelled.
+// @Description A workflow running on another server is cancelled within a few seconds.
+// @Description A failed workflow kept for retry is rolled back right away.
+// @Tags workflow
+// @Security BearerAuth
+// @Produce json
+// @Param workflow_id path string true "Workflow ID"
+// @Success 202 {object} Response "Workflow cancellation requested"
+// @Failure 400 {object} APIResponse "Workflow can't be cancelled"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Workflow not found"
+// @Failure 409 {object} APIResponse "Workflow is not running nor kept for retry"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /workflow/{workflow_id}/cancel [post]
+func (h *Handler) HandleCancelWorkflow(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	workflowID := c.Param("workflow_id")
+
+	wf, err := h.workflowStore.LoadUserWorkflow(c.Request.Context(), userID, workflowID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "workflow not found"})
+		return
+	} else if err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", workflowID).Msg("Failed to load workflow")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load workflow"})
+		return
+	}
+
+	if !activities.HasFailureRollback(wf.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only deploy and add-node workflows can be cancelled"})
+		return
+	}
+
+	// a failed workflow kept for retry is rolled back right away
+	if _, pending := activities.PendingRollback(wf); pending && wf.Status == ewf.StatusFailed {
+		claimed, err := h.workflowStore.ClaimRollback(c.Request.Context(), wf.UUID)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to claim workflow rollback")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel workflow"})
+			return
+		}
+		if claimed {
+			go h.rollbackFailedWorkflow(wf)
+			c.JSON(http.StatusAccepted, Response{
+				WorkflowID: wf.UUID,
+				Status:     string(wf.Status),
+				Message:    "Workflow cancelled, its resources are being rolled back",
+			})
+			return
+		}
+	}
+
+	// the request reaches the workflow on whichever server runs it
+	requested, err := h.workflowStore.RequestCancel(c.Request.Context(), wf.UUID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to request workflow cancellation")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel workflow"})
+		return
+	}
+	if !requested {
+		c.JSON(http.StatusConflict, gin.H{"error": "workflow is not running"})
+		return
+	}
+	h.workflowCancels.cancel(wf.UUID)
+
+	logger.GetLogger().Info().Int("user_id", userID).Str("workflow_id", wf.UUID).Str("workflow_name", wf.Name).Msg("Workflow cancelled")
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(wf.Status),
+		Message:    "Workflow cancelled, its resources are being rolled back",
+	})
+}
+
+// @Summary Retry workflow
+// @Description Retries a failed deploy or add-node workflow from the step that failed, with the state it persisted.
+// @Description The workflow must have been started with a retry_window, its contracts are kept until then. Workflows started
+// @Description without one are rolled back as soon as they fail and can't be retried.
+// @Description The node whose deployment failed can be moved with node_id to another healthy grid node rented by the user
+// @Description that has enough free capacity for it.
+// @Tags workflow
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param workflow_id path string true "Workflow ID"
+// @Param input body RetryWorkflowInput false "Changes to apply before retrying"
+// @Success 202 {object} Response "Workflow retry started"
+// @Failure 400 {object} APIResponse "Workflow can't be retried with these changes"
+// @Failure 401 {object} APIResponse "Unauthorized"
+// @Failure 404 {object} APIResponse "Workflow not found"
+// @Failure 409 {object} APIResponse "Workflow is not failed or was already rolled back"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /workflow/{workflow_id}/retry [post]
+func (h *Handler) HandleRetryWorkflow(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	workflowID := c.Param("workflow_id")
+
+	var input RetryWorkflowInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request json format"})
+			return
+		}
+	}
+
+	wf, err := h.workflowStore.LoadUserWorkflow(c.Request.Context(), userID, workflowID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "workflow not found"})
+		return
+	} else if err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", workflowID).Msg("Failed to load workflow")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load workflow"})
+		return
+	}
+
+	if !activities.HasFailureRollback(wf.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only deploy and add-node workflows can be retried"})
+		return
+	}
+
+	if wf.Status != ewf.StatusFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": "workflow is not failed"})
+		return
+	}
+
+	if _, pending := activities.PendingRollback(wf); !pending {
+		c.JSON(http.StatusConflict, gin.H{"error": "workflow was already rolled back"})
+		return
+	}
+
+	if input.NodeID != 0 {
+		node, err := activities.RetryNode(wf)
+		if err != nil {
+			Error(c, http.StatusBadRequest, "Failed to change node", err.Error())
+			return
+		}
+
+		var unschedulable *kubedeployer.UnschedulableError
+		if err := h.placeRetryNode(c.Request.Context(), userID, node, input.NodeID); errors.Is(err, errRetryNodeNotRented) || errors.As(err, &unschedulable) {
+			Error(c, http.StatusBadRequest, "Failed to change node", err.Error())
+			return
+		} else if err != nil {
+			logger.GetLogger().Error().Err(err).Int("user_id", userID).Uint32("node_id", input.NodeID).Msg("Failed to place retried node")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retry workflow"})
+			return
+		}
+
+		if err := activities.SetRetryNodeID(wf, input.NodeID); err != nil {
+			Error(c, http.StatusBadRequest, "Failed to change node", err.Error())
+			return
+		}
+	}
+
+	// claiming the rollback keeps the contracts, a concurrent rollback wins before that
+	claimed, err := h.workflowStore.ClaimRollback(c.Request.Context(), wf.UUID)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to claim workflow rollback")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retry workflow"})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "workflow was already rolled back"})
+		return
+	}
+	delete(wf.State, "rollback_at")
+
+	h.registerDynamicTemplate(wf.Name)
+
+	logger.GetLogger().Info().Int("user_id", userID).Str("workflow_id", wf.UUID).Str("workflow_name", wf.Name).Msg("Retrying workflow")
+	h.startCancellableWorkflow(wf)
+
+	c.JSON(http.StatusAccepted, Response{
+		WorkflowID: wf.UUID,
+		Status:     string(ewf.StatusRunning),
+		Message:    "Workflow retry started",
+	})
+}
+
+// deploymentKeptForRetry returns the failed deploy workflow of a user kept for retry deploying the named cluster, if any
+func (h *Handler) deploymentKeptForRetry(ctx context.Context, userID int, clusterName string) (string, error) {
+	workflows, err := h.workflowStore.ListUserPendingRollbacks(ctx, userID, "deploy-")
+	if err != nil {
+		return "", err
+	}
+
+	for _, wf := range workflows {
+		cluster, err := statemanager.GetCluster(wf.State)
+		if err != nil {
+			logger.GetLogger().Warn().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to get cluster of failed deployment")
+			continue
+		}
+		if cluster.Name == clusterName {
+			return wf.UUID, nil
+		}
+	}
+	return "", nil
+}
+
+// rollbackFailedWorkflow cancels the contracts of a failed workflow kept for retry, its rollback must be claimed first
+func (h *Handler) rollbackFailedWorkflow(wf *ewf.Workflow) {
+	if err := activities.RollbackFailedWorkflow(h.ewfEngine, h.metrics, wf); err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Str("workflow_name", wf.Name).Msg("Failed to roll back failed workflow")
+	}
+}
+
+// TrackPendingRollbacks rolls back the failed workflows kept for retry that weren't retried in time
+func (h *Handler) TrackPendingRollbacks() {
+	ticker := time.NewTicker(pendingRollbacksInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		workflows, err := h.workflowStore.ListWorkflowsDueForRollback(context.Background(), time.Now())
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Msg("Failed to list workflows due for rollback")
+			continue
+		}
+
+		for _, wf := range workflows {
+			// claiming the rollback makes it final, a concurrent retry wins before that
+			claimed, err := h.workflowStore.ClaimRollback(context.Background(), wf.UUID)
+			if err != nil {
+				logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to claim workflow rollback")
+				continue
+			}
+			if !claimed {
+				continue
+			}
+
+			h.rollbackFailedWorkflow(wf)
+		}
+	}
+}
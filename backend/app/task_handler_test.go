@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kubecloud/internal/constants"
+	"kubecloud/models"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmonader/ewf"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestWorkflowCancels(t *testing.T) {
+	cancels := newWorkflowCancels()
+
+	ctx, done := cancels.register(context.Background(), "deploy")
+	require.True(t, cancels.cancel("deploy"))
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+	done()
+	require.False(t, cancels.cancel("deploy"), "a done workflow can't be cancelled anymore")
+
+	ctx, done = cancels.register(context.Background(), "add-node")
+	done()
+	require.ErrorIs(t, ctx.Err(), context.Canceled, "the context is released once the workflow is done")
+	require.False(t, cancels.cancel("add-node"))
+	require.False(t, cancels.cancel("unknown"))
+}
+
+// newCancelTestHandler returns a handler running add-node workflows whose only step waits to be cancelled
+func newCancelTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "ewf.db")), &gorm.Config{})
+	require.NoError(t, err)
+	store := models.NewGormStore(db)
+	require.NoError(t, store.Setup())
+
+	engine, err := ewf.NewEngine(store)
+	require.NoError(t, err)
+	engine.Register("wait-cancel", func(ctx context.Context, state ewf.State) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	engine.RegisterTemplate(constants.WorkflowAddNode, &ewf.WorkflowTemplate{Steps: []ewf.Step{{Name: "wait-cancel"}}})
+
+	return &Handler{ewfEngine: engine, workflowStore: store, workflowCancels: newWorkflowCancels()}
+}
+
+func waitWorkflowStatus(t *testing.T, h *Handler, workflowID string, status ewf.WorkflowStatus) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		wf, err := h.workflowStore.LoadWorkflowByUUID(context.Background(), workflowID)
+		return err == nil && wf.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestResumeRunningWorkflows(t *testing.T) {
+	cancelRequestInterval = 10 * time.Millisecond
+	t.Cleanup(func() { cancelRequestInterval = 5 * time.Second })
+
+	h := newCancelTestHandler(t)
+	ctx := context.Background()
+
+	// a workflow left running by a stopped server
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowAddNode)
+	require.NoError(t, err)
+	wf.Status = ewf.StatusRunning
+	require.NoError(t, h.workflowStore.SaveWorkflow(ctx, wf))
+
+	h.ResumeRunningWorkflows()
+	require.Eventually(t, func() bool {
+		h.workflowCancels.mu.Lock()
+		defer h.workflowCancels.mu.Unlock()
+		_, registered := h.workflowCancels.cancels[wf.UUID]
+		return registered
+	}, 5*time.Second, 10*time.Millisecond, "the resumed workflow can be cancelled")
+
+	// the cancellation requested on another server reaches it through the store
+	requested, err := h.workflowStore.RequestCancel(ctx, wf.UUID)
+	require.NoError(t, err)
+	require.True(t, requested)
+	waitWorkflowStatus(t, h, wf.UUID, ewf.StatusFailed)
+}
+
+func TestStartCancellableWorkflow(t *testing.T) {
+	h := newCancelTestHandler(t)
+
+	wf, err := h.ewfEngine.NewWorkflow(constants.WorkflowAddNode)
+	require.NoError(t, err)
+	h.startCancellableWorkflow(wf)
+	waitWorkflowStatus(t, h, wf.UUID, ewf.StatusRunning)
+
+	require.True(t, h.workflowCancels.cancel(wf.UUID))
+	waitWorkflowStatus(t, h, wf.UUID, ewf.StatusFailed)
+	require.Eventually(t, func() bool { return !h.workflowCancels.cancel(wf.UUID) }, 5*time.Second, 10*time.Millisecond,
+		"the workflow is unregistered once done")
+}
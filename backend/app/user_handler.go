@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"kubecloud/internal"
+	"kubecloud/internal/backup"
 	"kubecloud/internal/metrics"
 	"kubecloud/internal/notification"
 	"kubecloud/models"
@@ -46,6 +47,7 @@ type Handler struct {
 	redis               *internal.RedisClient
 	sseManager          *internal.SSEManager
 	ewfEngine           *ewf.Engine
+	workflowStore       *models.EWFGormStore
 	gridNet             string // Network name for the grid
 	sshPublicKey        string // SSH public key loaded at startup
 	systemIdentity      substrate.Identity
@@ -55,6 +57,10 @@ type Handler struct {
 	metrics             *metrics.Metrics
 	notificationService *notification.NotificationService
 	gridClient          deployer.TFPluginClient
+	snapshotStore       backup.Store
+	k8sProxies          *k8sProxyCache
+	clusterOverviews    *clusterOverviewCache
+	workflowCancels     *workflowCancels
 }
 
 // NewHandler create new handler
@@ -62,10 +68,11 @@ func NewHandler(tokenManager internal.TokenManager, db models.DB,
 	config internal.Configuration, mailService internal.MailService,
 	gridproxy proxy.Client, substrateClient *substrate.Substrate,
 	graphqlClient graphql.GraphQl, firesquidClient graphql.GraphQl,
-	redis *internal.RedisClient, sseManager *internal.SSEManager, ewfEngine *ewf.Engine,
+	redis *internal.RedisClient, sseManager *internal.SSEManager, ewfEngine *ewf.Engine, workflowStore *models.EWFGormStore,
 	gridNet string, sshPublicKey string, systemIdentity substrate.Identity,
 	kycClient *internal.KYCClient, sponsorKeyPair subkey.KeyPair, sponsorAddress string,
-	metrics *metrics.Metrics, notificationService *notification.NotificationService, gridClient deployer.TFPluginClient) *Handler {
+	metrics *metrics.Metrics, notificationService *notification.NotificationService, gridClient deployer.TFPluginClient,
+	snapshotStore backup.Store) *Handler {
 
 	return &Handler{
 		tokenManager:        tokenManager,
@@ -79,6 +86,7 @@ func NewHandler(tokenManager internal.TokenManager, db models.DB,
 		redis:               redis,
 		sseManager:          sseManager,
 		ewfEngine:           ewfEngine,
+		workflowStore:       workflowStore,
 		gridNet:             gridNet,
 		sshPublicKey:        sshPublicKey,
 		systemIdentity:      systemIdentity,
@@ -88,6 +96,10 @@ func NewHandler(tokenManager internal.TokenManager, db models.DB,
 		metrics:             metrics,
 		notificationService: notificationService,
 		gridClient:          gridClient,
+		snapshotStore:       snapshotStore,
+		k8sProxies:          newK8sProxyCache(),
+		clusterOverviews:    newClusterOverviewCache(),
+		workflowCancels:     newWorkflowCancels(),
 	}
 }
 
@@ -242,7 +254,7 @@ func (h *Handler) RegisterHandler(c *gin.Context) {
 
 	h.ewfEngine.RunAsync(context.Background(), wf)
 
-	Success(c, http.StatusAccepted, "Registration in progress. You can check its status using the workflow id.", RegisterUserResponse{
+	Success(c, http.StatusAccepted, "Registration in progress. You can check its status at /user/register/workflow/{workflow_id}.", RegisterUserResponse{
 		WorkflowID: wf.UUID,
 		Email:      request.Email,
 	})
@@ -1051,7 +1063,7 @@ func (h *Handler) DeleteSSHKeyHandler(c *gin.Context) {
 }
 
 // @Summary Get workflow status
-// @Description Returns the status of a workflow by its ID.
+// @Description Returns the status of a workflow of the authenticated user by its ID.
 // @Tags workflow
 // @ID get-workflow-status
 // @Accept json
@@ -1059,10 +1071,13 @@ func (h *Handler) DeleteSSHKeyHandler(c *gin.Context) {
 // @Param workflow_id path string true "Workflow ID"
 // @Success 200 {object} string "Workflow status returned successfully"
 // @Failure 400 {object} APIResponse "Invalid request or missing workflow ID"
+// @Failure 401 {object} APIResponse "Unauthorized"
 // @Failure 404 {object} APIResponse "Workflow not found"
 // @Failure 500 {object} APIResponse "Internal server error"
+// @Security BearerAuth
 // @Router /workflow/{workflow_id} [get]
 func (h *Handler) GetWorkflowStatus(c *gin.Context) {
+	userID := c.GetInt("user_id")
 
 	workflowID := c.Param("workflow_id")
 	if workflowID == "" {
@@ -1070,8 +1085,42 @@ func (h *Handler) GetWorkflowStatus(c *gin.Context) {
 		return
 	}
 
-	workflow, err := h.ewfEngine.Store().LoadWorkflowByUUID(c, workflowID)
-	if err != nil {
+	workflow, err := h.workflowStore.LoadUserWorkflow(c.Request.Context(), userID, workflowID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		Error(c, http.StatusNotFound, "Workflow not found", "")
+		return
+	} else if err != nil {
+		InternalServerError(c)
+		return
+	}
+	Success(c, http.StatusOK, "Status returned successfully", workflow.Status)
+}
+
+// @Summary Get registration status
+// @Description Returns the status of a registration workflow by its ID, so it can be polled before the user can log in.
+// @Description Other workflows are not found through this endpoint.
+// @Tags users
+// @ID get-registration-status
+// @Accept json
+// @Produce json
+// @Param workflow_id path string true "Registration workflow ID"
+// @Success 200 {object} string "Registration status returned successfully"
+// @Failure 400 {object} APIResponse "Invalid request or missing workflow ID"
+// @Failure 404 {object} APIResponse "Workflow not found"
+// @Failure 500 {object} APIResponse "Internal server error"
+// @Router /user/register/workflow/{workflow_id} [get]
+func (h *Handler) GetRegistrationStatusHandler(c *gin.Context) {
+	workflowID := c.Param("workflow_id")
+	if workflowID == "" {
+		Error(c, http.StatusBadRequest, "Invalid request", "Workflow ID is required")
+		return
+	}
+
+	workflow, err := h.workflowStore.LoadWorkflowByUUID(c.Request.Context(), workflowID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && workflow.Name != constants.WorkflowUserRegistration) {
+		Error(c, http.StatusNotFound, "Workflow not found", "")
+		return
+	} else if err != nil {
 		InternalServerError(c)
 		return
 	}
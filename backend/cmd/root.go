@@ -157,6 +157,9 @@ func addFlags() error {
 	if err := bindIntFlag(rootCmd, "reserved_node_health_check_workers_num", 10, "Reserved node health check workers number"); err != nil {
 		return fmt.Errorf("failed to bind reserved_node_health_check_workers_num flag: %w", err)
 	}
+	if err := bindIntFlag(rootCmd, "contract_drift_check_interval_in_minutes", 60, "Interval of the contract drift check between chain and database (minutes)"); err != nil {
+		return fmt.Errorf("failed to bind contract_drift_check_interval_in_minutes flag: %w", err)
+	}
 
 	// === Invoice ===
 	if err := bindStringFlag(rootCmd, "invoice.name", "", "Invoice company name"); err != nil {
@@ -169,6 +172,37 @@ func addFlags() error {
 		return fmt.Errorf("failed to bind invoice.governorate flag: %w", err)
 	}
 
+	// === K3s ===
+	if err := bindStringFlag(rootCmd, "k3s_flist", "", "K3s flist used for new nodes and cluster upgrades"); err != nil {
+		return fmt.Errorf("failed to bind k3s_flist flag: %w", err)
+	}
+
+	// === Backup ===
+	if err := bindStringFlag(rootCmd, "backup.store", "filesystem", "Cluster snapshot store (filesystem or s3)"); err != nil {
+		return fmt.Errorf("failed to bind backup.store flag: %w", err)
+	}
+	if err := bindStringFlag(rootCmd, "backup.filesystem_path", "./snapshots", "Directory of the filesystem snapshot store"); err != nil {
+		return fmt.Errorf("failed to bind backup.filesystem_path flag: %w", err)
+	}
+	if err := bindStringFlag(rootCmd, "backup.s3.endpoint", "", "S3 endpoint URL (e.g., http://localhost:9000)"); err != nil {
+		return fmt.Errorf("failed to bind backup.s3.endpoint flag: %w", err)
+	}
+	if err := bindStringFlag(rootCmd, "backup.s3.region", "us-east-1", "S3 region"); err != nil {
+		return fmt.Errorf("failed to bind backup.s3.region flag: %w", err)
+	}
+	if err := bindStringFlag(rootCmd, "backup.s3.bucket", "", "S3 bucket"); err != nil {
+		return fmt.Errorf("failed to bind backup.s3.bucket flag: %w", err)
+	}
+	if err := bindStringFlag(rootCmd, "backup.s3.access_key", "", "S3 access key"); err != nil {
+		return fmt.Errorf("failed to bind backup.s3.access_key flag: %w", err)
+	}
+	if err := bindStringFlag(rootCmd, "backup.s3.secret_key", "", "S3 secret key"); err != nil {
+		return fmt.Errorf("failed to bind backup.s3.secret_key flag: %w", err)
+	}
+	if err := bindIntFlag(rootCmd, "backup.schedule_check_interval_in_minutes", 5, "Snapshot schedules check interval (minutes)"); err != nil {
+		return fmt.Errorf("failed to bind backup.schedule_check_interval_in_minutes flag: %w", err)
+	}
+
 	// === Debug ===
 	if err := bindBoolFlag(rootCmd, "debug", false, "Enable debug logging"); err != nil {
 		return fmt.Errorf("failed to bind debug flag: %w", err)
@@ -0,0 +1,77 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+
+	"kubecloud/internal/addons"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+
+	"github.com/xmonader/ewf"
+)
+
+// InstallAddonsStep installs the add-ons of the cluster, updating the ones already installed,
+// and uninstalls the add-ons listed in removed_addons
+func InstallAddonsStep() ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster: %w", err)
+		}
+
+		removed, err := getRemovedAddons(state)
+		if err != nil {
+			return err
+		}
+
+		if len(cluster.Addons) == 0 && len(removed) == 0 {
+			return nil
+		}
+
+		kubeconfig, ok := state["kubeconfig"].(string)
+		if !ok || kubeconfig == "" {
+			return fmt.Errorf("kubeconfig not found in workflow state")
+		}
+
+		config, err := getConfig(state)
+		if err != nil {
+			return err
+		}
+
+		mnemonic, err := kubedeployer.EncryptMnemonic(cluster.Token, config.Mnemonic)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt mnemonic: %w", err)
+		}
+		params := addons.Params{Mnemonic: mnemonic, Network: config.Network, Token: cluster.Token}
+
+		installer, err := addons.NewInstaller(kubeconfig)
+		if err != nil {
+			return err
+		}
+
+		for _, addon := range removed {
+			if err := installer.Uninstall(ctx, addon, params); err != nil {
+				return err
+			}
+			logger.GetLogger().Info().Str("cluster", cluster.Name).Str("addon", addon.Name).Msg("Addon uninstalled")
+		}
+
+		for _, addon := range cluster.Addons {
+			if err := installer.Install(ctx, addon, params); err != nil {
+				return err
+			}
+			logger.GetLogger().Info().Str("cluster", cluster.Name).Str("addon", addon.Name).Msg("Addon installed")
+		}
+
+		return nil
+	}
+}
+
+func getRemovedAddons(state ewf.State) ([]kubedeployer.Addon, error) {
+	if _, ok := state["removed_addons"]; !ok {
+		return nil, nil
+	}
+	return getFromState[[]kubedeployer.Addon](state, "removed_addons")
+}
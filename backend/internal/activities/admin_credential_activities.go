@@ -0,0 +1,200 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"kubecloud/internal"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/kubeaccess"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/notification"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/xmonader/ewf"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RotateClientCAStep replaces the client CA of the cluster through its leader, which invalidates the k3s admin
+// kubeconfig and every other client certificate once the nodes are restarted
+func RotateClientCAStep(privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		if rotated, _ := state["client_ca_rotated"].(bool); rotated {
+			return nil
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		leader, err := cluster.GetLeaderNode()
+		if err != nil {
+			return fmt.Errorf("failed to get leader node: %w", ewf.ErrFailWorkflowNow)
+		}
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		if err := internal.RotateClientCA(string(privateKeyBytes), &leader, cluster.Token); err != nil {
+			return err
+		}
+
+		logger.GetLogger().Info().Str("cluster", cluster.Name).Str("leader", leader.Name).Msg("Cluster client CA rotated")
+		state["client_ca_rotated"] = true
+		return nil
+	}
+}
+
+// RestartClusterNodesStep restarts k3s on the control plane and then on the workers, so they all load the rotated CA.
+// Restarted nodes are recorded in 'restarted_nodes' and skipped when the step is retried.
+func RestartClusterNodesStep(privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		restarted, _ := getFromState[[]string](state, "restarted_nodes")
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		nodes := cluster.GetControlPlaneNodes()
+		for _, node := range cluster.Nodes {
+			if node.Type == kubedeployer.NodeTypeWorker {
+				nodes = append(nodes, node)
+			}
+		}
+
+		for _, node := range nodes {
+			if slices.Contains(restarted, node.Name) {
+				continue
+			}
+			if err := internal.RestartK3s(string(privateKeyBytes), &node, node.Type != kubedeployer.NodeTypeWorker); err != nil {
+				return err
+			}
+			restarted = append(restarted, node.Name)
+			state["restarted_nodes"] = restarted
+		}
+		return nil
+	}
+}
+
+// RefreshAdminKubeconfigStep fetches the admin kubeconfig regenerated with the rotated client CA and stores it on
+// the cluster, replacing the one signed by the previous CA
+func RefreshAdminKubeconfigStep(db models.DB, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		kubeconfig, _, err := internal.GetKubeconfigFromControlPlane(string(privateKeyBytes), cluster)
+		if err != nil {
+			return err
+		}
+
+		// k3s rewrites its kubeconfig while starting, make sure the new admin certificate is accepted
+		clientset, err := newClientset(kubeconfig)
+		if err != nil {
+			return err
+		}
+		if _, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{}); err != nil {
+			return fmt.Errorf("admin kubeconfig is not accepted yet: %w", err)
+		}
+
+		dbCluster, err := db.GetClusterByName(config.UserID, kubedeployer.GetProjectName(config.UserID, cluster.Name))
+		if err != nil {
+			return fmt.Errorf("failed to get cluster from database: %w", err)
+		}
+		dbCluster.Kubeconfig = kubeconfig
+		if err := db.UpdateCluster(&dbCluster); err != nil {
+			return fmt.Errorf("failed to save admin kubeconfig: %w", err)
+		}
+
+		state["kubeconfig"] = kubeconfig
+		return nil
+	}
+}
+
+// RevokeCertificateCredentialsStep marks the certificate credentials of the cluster as revoked and removes their
+// role bindings, their certificates were signed by the rotated client CA and can't authenticate anymore
+func RevokeCertificateCredentialsStep(db models.DB) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+		projectName := kubedeployer.GetProjectName(config.UserID, cluster.Name)
+
+		kubeconfig, ok := state["kubeconfig"].(string)
+		if !ok || kubeconfig == "" {
+			return fmt.Errorf("kubeconfig not found in workflow state")
+		}
+
+		credentials, err := db.ListKubeconfigCredentials(config.UserID, projectName)
+		if err != nil {
+			return fmt.Errorf("failed to list kubeconfig credentials: %w", err)
+		}
+
+		var issuer *kubeaccess.Issuer
+		for _, credential := range credentials {
+			if credential.Kind != kubeaccess.KindCertificate || credential.RevokedAt != nil {
+				continue
+			}
+			if issuer == nil {
+				if issuer, err = kubeaccess.NewIssuer(kubeconfig); err != nil {
+					return err
+				}
+			}
+			if err := issuer.Revoke(ctx, credential.Subject, credential.Kind, credential.Namespace); err != nil {
+				return err
+			}
+			if err := db.RevokeKubeconfigCredential(credential.ID); err != nil {
+				return fmt.Errorf("failed to mark credential %d as revoked: %w", credential.ID, err)
+			}
+		}
+		return nil
+	}
+}
+
+func registerAdminCredentialActivities(engine *ewf.Engine, db models.DB, notificationService *notification.NotificationService, config internal.Configuration) {
+	registerStep(engine, constants.StepRotateClientCA, RotateClientCAStep(config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepRestartClusterNodes, RestartClusterNodesStep(config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepRefreshAdminKubeconfig, RefreshAdminKubeconfigStep(db, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepRevokeCertCredentials, RevokeCertificateCredentialsStep(db))
+
+	rotateWFTemplate := newKubecloudWorkflowTemplate(notificationService)
+	rotateWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepRotateClientCA, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepRestartClusterNodes, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepRefreshAdminKubeconfig, RetryPolicy: longExponentialRetryPolicy},
+		{Name: constants.StepVerifyClusterReady, RetryPolicy: longExponentialRetryPolicy},
+		{Name: constants.StepRevokeCertCredentials, RetryPolicy: standardRetryPolicy},
+	}
+	engine.RegisterTemplate(constants.WorkflowRotateAdminCredential, &rotateWFTemplate)
+}
@@ -2,7 +2,6 @@ package activities
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"kubecloud/internal"
@@ -149,7 +148,7 @@ func UpdateNetworkStep(metrics *metrics.Metrics) ewf.StepFn {
 	}
 }
 
-func AddNodeStep(metrics *metrics.Metrics) ewf.StepFn {
+func AddNodeStep(db models.DB, metrics *metrics.Metrics) ewf.StepFn {
 	return func(ctx context.Context, state ewf.State) error {
 		ensureClient(state)
 
@@ -173,7 +172,12 @@ func AddNodeStep(metrics *metrics.Metrics) ewf.StepFn {
 			return err
 		}
 
-		if err := node.AssignNodeIP(ctx, kubeClient.GridClient, cluster.Network.Name); err != nil {
+		if err := redeployOutdatedNetwork(ctx, state, kubeClient, &cluster); err != nil {
+			metrics.IncrementClusterDeploymentFailure()
+			return err
+		}
+
+		if err := assignNodeIP(ctx, db, kubeClient, &cluster, &node); err != nil {
 			metrics.IncrementClusterDeploymentFailure()
 			return fmt.Errorf("failed to assign IP for node %s: %w", node.Name, err)
 		}
@@ -192,7 +196,7 @@ func AddNodeStep(metrics *metrics.Metrics) ewf.StepFn {
 	}
 }
 
-func DeployNodeStep(metrics *metrics.Metrics) ewf.StepFn {
+func DeployNodeStep(db models.DB, metrics *metrics.Metrics) ewf.StepFn {
 	return func(ctx context.Context, state ewf.State) error {
 		ensureClient(state)
 
@@ -211,13 +215,22 @@ func DeployNodeStep(metrics *metrics.Metrics) ewf.StepFn {
 			return err
 		}
 
-		nodeIdx, ok := state["node_index"].(int)
-		if !ok {
+		// the index goes through JSON when the workflow is resumed or retried
+		nodeIdx, err := getFromState[int](state, "node_index")
+		if err != nil {
 			nodeIdx = 0
 		}
+		if nodeIdx >= len(cluster.Nodes) {
+			return fmt.Errorf("invalid node index %d: %w", nodeIdx, ewf.ErrFailWorkflowNow)
+		}
+
+		if err := redeployOutdatedNetwork(ctx, state, kubeClient, &cluster); err != nil {
+			metrics.IncrementClusterDeploymentFailure()
+			return err
+		}
 		node := cluster.Nodes[nodeIdx]
 
-		if err := node.AssignNodeIP(ctx, kubeClient.GridClient, cluster.Network.Name); err != nil {
+		if err := assignNodeIP(ctx, db, kubeClient, &cluster, &node); err != nil {
 			metrics.IncrementClusterDeploymentFailure()
 			return fmt.Errorf("failed to assign node IPs: %w", err)
 		}
@@ -330,11 +343,40 @@ func CancelDeploymentStep(db models.DB, metrics *metrics.Metrics) ewf.StepFn {
 			return fmt.Errorf("failed to cancel deployment: %w", err)
 		}
 
+		if err := db.ReleaseClusterIPAllocations(cluster.ProjectName); err != nil {
+			logger.GetLogger().Warn().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to release IP allocations of canceled cluster")
+		}
+
 		metrics.DecActiveClusterCount()
 		return nil
 	}
 }
 
+// releaseDeletionHook marks a cluster as active again when its deletion failed, a deleted cluster is already gone
+func releaseDeletionHook(db models.DB) ewf.AfterWorkflowHook {
+	return func(ctx context.Context, wf *ewf.Workflow, err error) {
+		if err == nil {
+			return
+		}
+
+		cfg, cfgErr := getConfig(wf.State)
+		if cfgErr != nil {
+			logger.GetLogger().Error().Err(cfgErr).Str("workflow_name", wf.Name).Msg("Failed to get config from state")
+			return
+		}
+
+		projectName, ok := wf.State["project_name"].(string)
+		if !ok {
+			logger.GetLogger().Error().Str("workflow_name", wf.Name).Msg("Missing project name in state")
+			return
+		}
+
+		if err := db.ReleaseClusterDeletion(cfg.UserID, projectName); err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", projectName).Msg("Failed to release cluster deletion")
+		}
+	}
+}
+
 func RemoveClusterFromDBStep(db models.DB) ewf.StepFn {
 	return func(ctx context.Context, state ewf.State) error {
 		config, err := getConfig(state)
@@ -347,6 +389,14 @@ func RemoveClusterFromDBStep(db models.DB) ewf.StepFn {
 			return fmt.Errorf("missing or invalid 'project_name' in state")
 		}
 
+		if err := db.DeleteClusterKubeconfigCredentials(config.UserID, projectName); err != nil {
+			return fmt.Errorf("failed to delete kubeconfig credentials of cluster: %w", err)
+		}
+
+		if err := db.DeleteClusterAPITokens(config.UserID, projectName); err != nil {
+			return fmt.Errorf("failed to delete API tokens of cluster: %w", err)
+		}
+
 		if err := db.DeleteCluster(config.UserID, projectName); err != nil {
 			return fmt.Errorf("failed to delete cluster from database: %w", err)
 		}
@@ -444,6 +494,22 @@ func DeleteAllUserClustersStep(db models.DB) ewf.StepFn {
 			return err
 		}
 
+		clusters, err := db.ListUserClusters(config.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to list user clusters: %w", err)
+		}
+		for _, cluster := range clusters {
+			if err := db.ReleaseClusterIPAllocations(cluster.ProjectName); err != nil {
+				return fmt.Errorf("failed to release IP allocations of cluster %s: %w", cluster.ProjectName, err)
+			}
+			if err := db.DeleteClusterKubeconfigCredentials(config.UserID, cluster.ProjectName); err != nil {
+				return fmt.Errorf("failed to delete kubeconfig credentials of cluster %s: %w", cluster.ProjectName, err)
+			}
+			if err := db.DeleteClusterAPITokens(config.UserID, cluster.ProjectName); err != nil {
+				return fmt.Errorf("failed to delete API tokens of cluster %s: %w", cluster.ProjectName, err)
+			}
+		}
+
 		if err := db.DeleteAllUserClusters(config.UserID); err != nil {
 			return fmt.Errorf("failed to delete all user clusters from database: %w", err)
 		}
@@ -452,7 +518,7 @@ func DeleteAllUserClustersStep(db models.DB) ewf.StepFn {
 	}
 }
 
-func RemoveDeploymentNodeStep() ewf.StepFn {
+func RemoveDeploymentNodeStep(db models.DB) ewf.StepFn {
 	return func(ctx context.Context, state ewf.State) error {
 		ensureClient(state)
 
@@ -476,9 +542,9 @@ func RemoveDeploymentNodeStep() ewf.StepFn {
 			return fmt.Errorf("missing or invalid 'node_name' in state")
 		}
 
-		nodeName = kubedeployer.GetNodeName(config.UserID, existingCluster.Name, nodeName)
+		nodeName = existingCluster.DeployedNodeName(config.UserID, nodeName)
 
-		if err := kubeClient.RemoveNode(ctx, &existingCluster, nodeName); err != nil {
+		if err := removeClusterNode(ctx, db, kubeClient, &existingCluster, nodeName); err != nil {
 			return fmt.Errorf("failed to remove node %s from existing cluster: %w", nodeName, err)
 		}
 
@@ -489,25 +555,213 @@ func RemoveDeploymentNodeStep() ewf.StepFn {
 	}
 }
 
-func NewDynamicDeployWorkflowTemplate(engine *ewf.Engine, metrics *metrics.Metrics, notificationService *notification.NotificationService, wfName string, nodesNum int) {
+func getUpdatePlan(state ewf.State) (kubedeployer.UpdatePlan, error) {
+	return getFromState[kubedeployer.UpdatePlan](state, "plan")
+}
+
+func findClusterNode(cluster kubedeployer.Cluster, nodeName string) (kubedeployer.Node, bool) {
+	for _, node := range cluster.Nodes {
+		if node.Name == nodeName {
+			return node, true
+		}
+	}
+	return kubedeployer.Node{}, false
+}
+
+// ApplyNodeOperationStep applies the operation at 'operation_index' of the update plan.
+// Removed and replaced nodes are drained first following the drain policy in the state.
+// Every part of an operation is skipped if already done, so a retried step picks up where the failed attempt stopped.
+func ApplyNodeOperationStep(db models.DB, metrics *metrics.Metrics, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		ensureClient(state)
+
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		kubeClient, err := statemanager.GetKubeClient(state, config)
+		if err != nil {
+			return err
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		plan, err := getUpdatePlan(state)
+		if err != nil {
+			return err
+		}
+
+		// the index is a float64 once the state was reloaded from the store
+		opIdx, _ := getFromState[int](state, "operation_index")
+		if opIdx >= len(plan.Operations) {
+			return fmt.Errorf("operation index %d out of range for plan with %d operations: %w", opIdx, len(plan.Operations), ewf.ErrFailWorkflowNow)
+		}
+		op := plan.Operations[opIdx]
+		nodeName := cluster.DeployedNodeName(config.UserID, op.NodeName)
+
+		removedKey := fmt.Sprintf("operation_%d_removed", opIdx)
+		if removed, _ := state[removedKey].(bool); !removed && op.Action != kubedeployer.NodeActionAdd {
+			if _, found := findClusterNode(cluster, nodeName); found {
+				policy := getDrainPolicy(state)
+				clientset, err := removalClientset(state, db, privateKeyPath, cluster, nodeName)
+				if err != nil {
+					if !policy.Force {
+						return fmt.Errorf("failed to reach cluster to drain node %s: %w", nodeName, err)
+					}
+					logger.GetLogger().Warn().Err(err).Str("node", nodeName).Msg("Failed to reach cluster, removing node without draining it")
+				}
+				if err := drainAndRemoveClusterNode(ctx, db, kubeClient, clientset, &cluster, nodeName, policy); err != nil {
+					return fmt.Errorf("failed to remove node %s: %w", nodeName, err)
+				}
+				statemanager.SaveGridClientState(state, kubeClient)
+				statemanager.StoreCluster(state, cluster)
+			}
+			state[removedKey] = true
+		}
+
+		if op.Action == kubedeployer.NodeActionAdd || op.Action == kubedeployer.NodeActionReplace {
+			if op.Node == nil {
+				return fmt.Errorf("missing node spec for %s operation on node %s: %w", op.Action, op.NodeName, ewf.ErrFailWorkflowNow)
+			}
+
+			existing, found := findClusterNode(cluster, nodeName)
+			if !found || existing.ContractID == 0 {
+				node := *op.Node
+				node.Name = nodeName
+				node.OriginalName = op.NodeName
+				if !found {
+					cluster.Nodes = append(cluster.Nodes, node)
+				}
+
+				if err := kubeClient.DeployNetwork(ctx, &cluster); err != nil {
+					metrics.IncrementClusterDeploymentFailure()
+					return fmt.Errorf("failed to update network: %w", err)
+				}
+				statemanager.SaveGridClientState(state, kubeClient)
+				statemanager.StoreCluster(state, cluster)
+
+				if err := assignNodeIP(ctx, db, kubeClient, &cluster, &node); err != nil {
+					metrics.IncrementClusterDeploymentFailure()
+					return fmt.Errorf("failed to assign IP for node %s: %w", node.Name, err)
+				}
+
+				if err := kubeClient.DeployNode(ctx, &cluster, node, config.SSHPublicKey); err != nil {
+					metrics.IncrementClusterDeploymentFailure()
+					return fmt.Errorf("failed to deploy node %s: %w", node.Name, err)
+				}
+				metrics.IncrementClusterDeploymentSuccess()
+			}
+		}
+
+		statemanager.SaveGridClientState(state, kubeClient)
+		statemanager.StoreCluster(state, cluster)
+		state["operation_index"] = opIdx + 1
+		return nil
+	}
+}
+
+func NewDynamicUpdateWorkflowTemplate(engine *ewf.Engine, metrics *metrics.Metrics, db models.DB, notificationService *notification.NotificationService, privateKeyPath string, wfName string, operationsNum int) {
+	steps := make([]ewf.Step, 0, operationsNum+6)
+	for i := 0; i < operationsNum; i++ {
+		stepName := getApplyOperationStepName(i + 1)
+		registerStep(engine, stepName, ApplyNodeOperationStep(db, metrics, privateKeyPath))
+
+		steps = append(steps, ewf.Step{Name: stepName, RetryPolicy: standardRetryPolicy})
+	}
+
+	steps = append(steps, ewf.Step{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepVerifyClusterReady, RetryPolicy: longExponentialRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepSetupGPUNodes, RetryPolicy: standardRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepApplyNodePools, RetryPolicy: standardRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepInstallAddons, RetryPolicy: standardRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy})
+
+	workflow := newKubecloudWorkflowTemplate(notificationService)
+	workflow.AfterWorkflowHooks = append(workflow.AfterWorkflowHooks,
+		updateFailureHook(db),
+		releasePoolsHook(db),
+		closeClient,
+	)
+	workflow.Steps = steps
+
+	engine.RegisterTemplate(wfName, &workflow)
+}
+
+// updateFailureHook persists the partially updated cluster so the database keeps matching the contracts on chain
+func updateFailureHook(db models.DB) ewf.AfterWorkflowHook {
+	return func(ctx context.Context, wf *ewf.Workflow, err error) {
+		if err == nil || !isUpdateWorkflow(wf.Name) {
+			return
+		}
+
+		config, cfgErr := getConfig(wf.State)
+		if cfgErr != nil {
+			logger.GetLogger().Error().Err(cfgErr).Str("workflow_name", wf.Name).Msg("Failed to get config from state")
+			return
+		}
+
+		cluster, clusterErr := statemanager.GetCluster(wf.State)
+		if clusterErr != nil {
+			logger.GetLogger().Error().Err(clusterErr).Str("workflow_name", wf.Name).Msg("nothing to persist")
+			return
+		}
+
+		// drop nodes that were appended to the cluster but never got a contract
+		deployedNodes := make([]kubedeployer.Node, 0, len(cluster.Nodes))
+		for _, node := range cluster.Nodes {
+			if node.ContractID != 0 {
+				deployedNodes = append(deployedNodes, node)
+			}
+		}
+		cluster.Nodes = deployedNodes
+
+		dbCluster, dbErr := db.GetClusterByName(config.UserID, cluster.ProjectName)
+		if dbErr != nil {
+			logger.GetLogger().Error().Err(dbErr).Str("project_name", cluster.ProjectName).Msg("Failed to get cluster from database")
+			return
+		}
+
+		if err := dbCluster.SetClusterResult(cluster); err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to set cluster result")
+			return
+		}
+
+		if err := db.UpdateCluster(&dbCluster); err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to persist partially updated cluster")
+			return
+		}
+
+		logger.GetLogger().Info().Str("project_name", cluster.ProjectName).Int("nodes", len(cluster.Nodes)).Msg("Persisted partially updated cluster after failed update")
+	}
+}
+
+func NewDynamicDeployWorkflowTemplate(engine *ewf.Engine, metrics *metrics.Metrics, db models.DB, notificationService *notification.NotificationService, wfName string, nodesNum int) {
 	steps := []ewf.Step{
+		{Name: constants.StepReservePlacedNodes, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepDeployNetwork, RetryPolicy: criticalRetryPolicy},
 	}
 
 	for i := 0; i < nodesNum; i++ {
 		stepName := getDeployNodeStepName(i + 1)
-		engine.Register(stepName, DeployNodeStep(metrics))
+		registerStep(engine, stepName, DeployNodeStep(db, metrics))
 
 		steps = append(steps, ewf.Step{Name: stepName, RetryPolicy: criticalRetryPolicy})
 	}
 
 	steps = append(steps, ewf.Step{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy})
 	steps = append(steps, ewf.Step{Name: constants.StepVerifyClusterReady, RetryPolicy: longExponentialRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepSetupGPUNodes, RetryPolicy: standardRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepInstallAddons, RetryPolicy: standardRetryPolicy})
 	steps = append(steps, ewf.Step{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy})
 
 	workflow := createDeployerWorkflowTemplate(notificationService, engine, metrics)
 	workflow.Steps = steps
 	workflow.AfterStepHooks = []ewf.AfterStepHook{
+		recordStepDone,
 		notifyStepHook(notificationService),
 	}
 
@@ -530,37 +784,86 @@ func closeClient(ctx context.Context, wf *ewf.Workflow, err error) {
 func deploymentFailureHook(engine *ewf.Engine, metrics *metrics.Metrics) ewf.AfterWorkflowHook {
 	return func(ctx context.Context, wf *ewf.Workflow, err error) {
 		if err != nil && isDeployWorkflow(wf.Name) {
-			cluster, clusterErr := statemanager.GetCluster(wf.State)
-			if clusterErr != nil || cluster.ProjectName == "" {
-				logger.GetLogger().Error().Err(clusterErr).Str("workflow_name", wf.Name).Msg("nothing to rollback")
+			if keepForRetry(ctx, engine, wf) {
 				return
 			}
+			if err := rollbackFailedDeployment(engine, metrics, wf); err != nil {
+				logger.GetLogger().Error().Err(err).Str("workflow_name", wf.Name).Msg("Failed to roll back failed deployment")
+			}
+		}
+	}
+}
 
-			logger.GetLogger().Info().Str("project_name", cluster.ProjectName).Str("workflow_name", wf.Name).Msg("Triggering rollback workflow for failed deployment")
+// rollbackFailedDeployment cancels the contracts a failed deploy workflow created and unreserves the nodes it rented
+func rollbackFailedDeployment(engine *ewf.Engine, metrics *metrics.Metrics, wf *ewf.Workflow) error {
+	cluster, clusterErr := statemanager.GetCluster(wf.State)
+	if clusterErr != nil || cluster.ProjectName == "" {
+		logger.GetLogger().Error().Err(clusterErr).Str("workflow_name", wf.Name).Msg("nothing to rollback")
+		unreservePlacedNodes(engine, wf)
+		return nil
+	}
 
-			rollbackWf, rollbackErr := engine.NewWorkflow(constants.WorkflowRollbackFailedDeployment)
-			if rollbackErr != nil {
-				logger.GetLogger().Error().Err(rollbackErr).Str("project_name", cluster.ProjectName).Msg("Failed to create rollback workflow")
-				return
-			}
+	logger.GetLogger().Info().Str("project_name", cluster.ProjectName).Str("workflow_name", wf.Name).Msg("Triggering rollback workflow for failed deployment")
 
-			rollbackWf.State["config"] = wf.State["config"]
-			rollbackWf.State["cluster"] = wf.State["cluster"]
-			rollbackWf.State["kubeclient"] = wf.State["kubeclient"]
-			rollbackWf.State["project_name"] = cluster.ProjectName
+	rollbackWf, err := engine.NewWorkflow(constants.WorkflowRollbackFailedDeployment)
+	if err != nil {
+		return fmt.Errorf("failed to create rollback workflow: %w", err)
+	}
 
-			rollbackCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-			defer cancel()
+	rollbackWf.State["config"] = wf.State["config"]
+	rollbackWf.State["cluster"] = wf.State["cluster"]
+	rollbackWf.State["kubeclient"] = wf.State["kubeclient"]
+	rollbackWf.State["gridclient_state"] = wf.State["gridclient_state"]
+	rollbackWf.State["project_name"] = cluster.ProjectName
 
-			// wait the rollback workflow to finish before closing the client
-			if err := engine.RunSync(rollbackCtx, rollbackWf); err != nil {
-				logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to run rollback workflow")
-				return
-			}
+	rollbackCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	// wait the rollback workflow to finish before closing the client
+	if err := engine.RunSync(rollbackCtx, rollbackWf); err != nil {
+		return fmt.Errorf("failed to run rollback workflow: %w", err)
+	}
+
+	// the rent contracts are only cancelled once the contracts deployed on the nodes are gone
+	unreservePlacedNodes(engine, wf)
+
+	metrics.DecActiveClusterCount()
+	return nil
+}
 
-			metrics.DecActiveClusterCount()
+// unreservePlacedNodes cancels the rent contracts of the nodes a failed deploy workflow reserved
+func unreservePlacedNodes(engine *ewf.Engine, wf *ewf.Workflow) {
+	reserved, err := getFromState[[]ReservedNode](wf.State, "reserved_nodes")
+	if err != nil || len(reserved) == 0 {
+		return
+	}
+
+	config, err := getConfig(wf.State)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_name", wf.Name).Msg("Failed to get config to unreserve nodes")
+		return
+	}
+
+	for _, node := range reserved {
+		unreserveWf, err := engine.NewWorkflow(constants.WorkflowUnreserveNode)
+		if err != nil {
+			logger.GetLogger().Error().Err(err).Uint32("node_id", node.NodeID).Msg("Failed to create unreserve node workflow")
+			continue
+		}
+
+		unreserveWf.State = ewf.State{
+			"user_id":       config.UserID,
+			"mnemonic":      config.Mnemonic,
+			"contract_id":   uint32(node.ContractID),
+			"node_id":       node.NodeID,
+			"target_status": constants.NodeRentable,
 		}
+
+		logger.GetLogger().Info().Uint32("node_id", node.NodeID).Str("workflow_name", wf.Name).Msg("Unreserving node of failed deployment")
+		engine.RunAsync(context.Background(), unreserveWf)
 	}
+
+	delete(wf.State, "reserved_nodes")
 }
 
 func createDeployerWorkflowTemplate(notificationService *notification.NotificationService, engine *ewf.Engine, metrics *metrics.Metrics) ewf.WorkflowTemplate {
@@ -592,26 +895,37 @@ func createAddNodeWorkflowTemplate(notificationService *notification.Notificatio
 }
 
 func registerDeploymentActivities(engine *ewf.Engine, metrics *metrics.Metrics, db models.DB, notificationService *notification.NotificationService, config internal.Configuration) {
-	engine.Register(constants.StepDeployNetwork, DeployNetworkStep(metrics))
-	engine.Register(constants.StepDeployNode, DeployNodeStep(metrics))
-	engine.Register(constants.StepRemoveCluster, CancelDeploymentStep(db, metrics))
-	engine.Register(constants.StepAddNode, AddNodeStep(metrics))
-	engine.Register(constants.StepUpdateNetwork, UpdateNetworkStep(metrics))
-	engine.Register(constants.StepRemoveNode, RemoveDeploymentNodeStep())
-	engine.Register(constants.StepStoreDeployment, StoreDeploymentStep(db, metrics))
-	engine.Register(constants.StepFetchKubeconfig, FetchKubeconfigStep(db, config.SSH.PrivateKeyPath))
-	engine.Register(constants.StepVerifyClusterReady, VerifyClusterReadyStep())
-	engine.Register(constants.StepVerifyNewNodes, VerifyAddedNodeStep(db, config.SSH.PrivateKeyPath))
-	engine.Register(constants.StepRemoveClusterFromDB, RemoveClusterFromDBStep(db))
-	engine.Register(constants.StepGatherAllContractIDs, GatherAllContractIDsStep(db))
-	engine.Register(constants.StepBatchCancelContracts, BatchCancelContractsStep())
-	engine.Register(constants.StepDeleteAllUserClusters, DeleteAllUserClustersStep(db))
+	registerStep(engine, constants.StepDeployNetwork, DeployNetworkStep(metrics))
+	registerStep(engine, constants.StepDeployNode, DeployNodeStep(db, metrics))
+	registerStep(engine, constants.StepRemoveCluster, CancelDeploymentStep(db, metrics))
+	registerStep(engine, constants.StepAddNode, AddNodeStep(db, metrics))
+	registerStep(engine, constants.StepUpdateNetwork, UpdateNetworkStep(metrics))
+	registerStep(engine, constants.StepRemoveNode, RemoveDeploymentNodeStep(db))
+	registerStep(engine, constants.StepStoreDeployment, StoreDeploymentStep(db, metrics))
+	registerStep(engine, constants.StepFetchKubeconfig, FetchKubeconfigStep(db, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepVerifyClusterReady, VerifyClusterReadyStep())
+	registerStep(engine, constants.StepVerifyNewNodes, VerifyAddedNodeStep(db, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepSetupGPUNodes, SetupGPUNodesStep())
+	registerStep(engine, constants.StepInstallAddons, InstallAddonsStep())
+	registerStep(engine, constants.StepApplyNodePools, ApplyNodePoolsStep())
+	registerStep(engine, constants.StepCordonNode, CordonNodeStep(db, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepDrainNode, DrainNodeStep())
+	registerStep(engine, constants.StepDeleteKubernetesNode, DeleteKubernetesNodeStep())
+	registerStep(engine, constants.StepRemoveClusterFromDB, RemoveClusterFromDBStep(db))
+	registerStep(engine, constants.StepGatherAllContractIDs, GatherAllContractIDsStep(db))
+	registerStep(engine, constants.StepBatchCancelContracts, BatchCancelContractsStep())
+	registerStep(engine, constants.StepDeleteAllUserClusters, DeleteAllUserClustersStep(db))
+	registerStep(engine, constants.StepApplyNodeOperation, ApplyNodeOperationStep(db, metrics, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepPromoteLeader, PromoteLeaderStep(db, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepUpgradeNode, UpgradeNodeStep(db, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepRollbackUpgrade, RollbackUpgradeStep(db, config.SSH.PrivateKeyPath))
 
 	deleteWFTemplate := createDeployerWorkflowTemplate(notificationService, engine, metrics)
 	deleteWFTemplate.Steps = []ewf.Step{
 		{Name: constants.StepRemoveCluster, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepRemoveClusterFromDB, RetryPolicy: standardRetryPolicy},
 	}
+	deleteWFTemplate.AfterWorkflowHooks = append(deleteWFTemplate.AfterWorkflowHooks, releaseDeletionHook(db))
 	engine.RegisterTemplate(constants.WorkflowDeleteCluster, &deleteWFTemplate)
 
 	deleteAllDeploymentsWFTemplate := createDeployerWorkflowTemplate(notificationService, engine, metrics)
@@ -628,13 +942,17 @@ func registerDeploymentActivities(engine *ewf.Engine, metrics *metrics.Metrics,
 		{Name: constants.StepAddNode, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy},
 		{Name: constants.StepVerifyNewNodes, RetryPolicy: longExponentialRetryPolicy},
+		{Name: constants.StepSetupGPUNodes, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
 	}
 	engine.RegisterTemplate(constants.WorkflowAddNode, &addNodeWFTemplate)
 
 	removeNodeWFTemplate := createDeployerWorkflowTemplate(notificationService, engine, metrics)
 	removeNodeWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepCordonNode, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepDrainNode, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepRemoveNode, RetryPolicy: standardRetryPolicy},
+		{Name: constants.StepDeleteKubernetesNode, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy},
 		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
 	}
@@ -648,52 +966,50 @@ func registerDeploymentActivities(engine *ewf.Engine, metrics *metrics.Metrics,
 
 	rollbackAddNodeWFTemplate := createBaseDeployerWorkflowTemplate(notificationService, engine, metrics)
 	rollbackAddNodeWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepCordonNode, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepDrainNode, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepRemoveNode, RetryPolicy: standardRetryPolicy},
+		{Name: constants.StepDeleteKubernetesNode, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
 	}
 	engine.RegisterTemplate(constants.WorkflowRollbackFailedAddNode, &rollbackAddNodeWFTemplate)
-}
-
-func getFromState[T any](state ewf.State, key string) (T, error) {
-	value, ok := state[key]
-	if !ok {
-		var zero T
-		return zero, fmt.Errorf("missing '%s' in state", key)
-	}
-
-	val, ok := value.(T)
-	if !ok {
-		var zero T
-		logger.GetLogger().Error().Msgf("Expected '%s' to be of %+v, but got %+v", key, zero, value)
-		return zero, fmt.Errorf("invalid '%s' in state", key)
-	}
-	return val, nil
-}
 
-func getConfig(state ewf.State) (statemanager.ClientConfig, error) {
-	value, ok := state["config"]
-	if !ok {
-		return statemanager.ClientConfig{}, fmt.Errorf("missing 'config' in state")
+	promoteLeaderWFTemplate := createBaseDeployerWorkflowTemplate(notificationService, engine, metrics)
+	promoteLeaderWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepPromoteLeader, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
 	}
+	promoteLeaderWFTemplate.AfterWorkflowHooks = append(promoteLeaderWFTemplate.AfterWorkflowHooks, releaseFailoverHook(db), releasePoolsHook(db))
+	engine.RegisterTemplate(constants.WorkflowPromoteLeader, &promoteLeaderWFTemplate)
 
-	// Try direct type assertion first (for newly created configs)
-	if config, ok := value.(statemanager.ClientConfig); ok {
-		return config, nil
+	rollbackUpgradeWFTemplate := createBaseDeployerWorkflowTemplate(notificationService, engine, metrics)
+	rollbackUpgradeWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepRollbackUpgrade, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
 	}
+	engine.RegisterTemplate(constants.WorkflowRollbackFailedUpgrade, &rollbackUpgradeWFTemplate)
 
-	// Handle the case where config was serialized/deserialized and became a map
-	// Use JSON marshaling/unmarshaling to convert map to struct
-	configBytes, err := json.Marshal(value)
-	if err != nil {
-		return statemanager.ClientConfig{}, fmt.Errorf("failed to marshal config value: %w", err)
+	updateAddonsWFTemplate := createBaseDeployerWorkflowTemplate(notificationService, engine, metrics)
+	updateAddonsWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepInstallAddons, RetryPolicy: standardRetryPolicy},
+		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
 	}
+	engine.RegisterTemplate(constants.WorkflowUpdateAddons, &updateAddonsWFTemplate)
 
-	var config statemanager.ClientConfig
-	if err := json.Unmarshal(configBytes, &config); err != nil {
-		return statemanager.ClientConfig{}, fmt.Errorf("failed to unmarshal config: %w", err)
+	updateNodePoolsWFTemplate := createBaseDeployerWorkflowTemplate(notificationService, engine, metrics)
+	updateNodePoolsWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepApplyNodePools, RetryPolicy: standardRetryPolicy},
+		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
 	}
+	updateNodePoolsWFTemplate.AfterWorkflowHooks = append(updateNodePoolsWFTemplate.AfterWorkflowHooks, releasePoolsHook(db))
+	engine.RegisterTemplate(constants.WorkflowUpdateNodePools, &updateNodePoolsWFTemplate)
+}
 
-	return config, nil
+func getConfig(state ewf.State) (statemanager.ClientConfig, error) {
+	return getFromState[statemanager.ClientConfig](state, "config")
 }
 
 func retrieveKubeconfig(state ewf.State, db models.DB, privateKeyPath string) (string, error) {
@@ -722,21 +1038,12 @@ func retrieveKubeconfig(state ewf.State, db models.DB, privateKeyPath string) (s
 		return existingCluster.Kubeconfig, nil
 	}
 
-	var master kubedeployer.Node
+	target := cluster
 	if existingCluster.ID != 0 {
-		existingClusterResult, err := existingCluster.GetClusterResult()
+		target, err = existingCluster.GetClusterResult()
 		if err != nil {
 			return "", fmt.Errorf("failed to get cluster result: %w", err)
 		}
-		master, err = existingClusterResult.GetLeaderNode()
-		if err != nil {
-			return "", fmt.Errorf("failed to get leader node: %w", err)
-		}
-	} else {
-		master, err = cluster.GetLeaderNode()
-		if err != nil {
-			return "", fmt.Errorf("failed to get leader node: %w", err)
-		}
 	}
 
 	privateKeyBytes, err := os.ReadFile(privateKeyPath)
@@ -744,10 +1051,13 @@ func retrieveKubeconfig(state ewf.State, db models.DB, privateKeyPath string) (s
 		return "", fmt.Errorf("failed to read SSH private key: %w", err)
 	}
 
-	logger.GetLogger().Debug().Msg("Fetching kubeconfig from leader node via SSH")
-	return internal.GetKubeconfigViaSSH(string(privateKeyBytes), &master)
+	logger.GetLogger().Debug().Msg("Fetching kubeconfig from control plane via SSH")
+	kubeconfig, _, err := internal.GetKubeconfigFromControlPlane(string(privateKeyBytes), target)
+	return kubeconfig, err
 }
 
+// FetchKubeconfigStep retrieves the kubeconfig of the cluster and records the SSH host keys of its nodes,
+// so that later connections to them are verified against the keys seen on first contact
 func FetchKubeconfigStep(db models.DB, privateKeyPath string) ewf.StepFn {
 	return func(ctx context.Context, state ewf.State) error {
 		kubeconfig, err := retrieveKubeconfig(state, db, privateKeyPath)
@@ -755,6 +1065,27 @@ func FetchKubeconfigStep(db models.DB, privateKeyPath string) ewf.StepFn {
 			return err
 		}
 		state["kubeconfig"] = kubeconfig
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		for _, node := range cluster.Nodes {
+			err := internal.RecordHostKey(string(privateKeyBytes), &node)
+			var changedErr *internal.HostKeyChangedError
+			if errors.As(err, &changedErr) {
+				return fmt.Errorf("%s: %w", changedErr.Error(), ewf.ErrFailWorkflowNow)
+			}
+			if err != nil {
+				logger.GetLogger().Warn().Err(err).Str("node", node.Name).Msg("Failed to record SSH host key of node")
+			}
+		}
 		return nil
 	}
 }
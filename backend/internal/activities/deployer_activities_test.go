@@ -0,0 +1,136 @@
+package activities
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmonader/ewf"
+)
+
+func TestDeleteAllUserClustersStep(t *testing.T) {
+	db, err := models.NewSqliteDB(filepath.Join(t.TempDir(), "kubecloud.db"))
+	require.NoError(t, err)
+
+	for _, projectName := range []string{"kc1-first", "kc1-second"} {
+		require.NoError(t, db.CreateCluster(1, &models.Cluster{ProjectName: projectName}))
+		require.NoError(t, db.CreateAPIToken(&models.APIToken{UserID: 1, Name: projectName, TokenHash: projectName, ProjectName: projectName}))
+	}
+	require.NoError(t, db.CreateAPIToken(&models.APIToken{UserID: 1, Name: "account", TokenHash: "account"}))
+	require.NoError(t, db.CreateCluster(2, &models.Cluster{ProjectName: "kc2-other"}))
+	require.NoError(t, db.CreateAPIToken(&models.APIToken{UserID: 2, Name: "other", TokenHash: "other", ProjectName: "kc2-other"}))
+
+	state := ewf.State{"config": statemanager.ClientConfig{UserID: 1}}
+	require.NoError(t, DeleteAllUserClustersStep(db)(context.Background(), state))
+
+	clusters, err := db.ListUserClusters(1)
+	require.NoError(t, err)
+	require.Empty(t, clusters)
+
+	tokens, err := db.ListUserAPITokens(1)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1, "tokens bound to the deleted clusters are removed")
+	require.Equal(t, "account", tokens[0].Name)
+
+	tokens, err = db.ListUserAPITokens(2)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1, "tokens of other users are kept")
+}
+
+func TestRollbackFailedDeploymentUnreservesNodes(t *testing.T) {
+	store := newTestWorkflowStore(t)
+	engine, err := ewf.NewEngine(store)
+	require.NoError(t, err)
+
+	unreserved := make(chan ewf.State, 2)
+	engine.Register(constants.StepUnreserveNode, func(ctx context.Context, state ewf.State) error {
+		unreserved <- state
+		return nil
+	})
+	engine.RegisterTemplate(constants.WorkflowUnreserveNode, &ewf.WorkflowTemplate{
+		Steps: []ewf.Step{{Name: constants.StepUnreserveNode}},
+	})
+
+	// the deployment failed reserving its second node, before any contract was deployed
+	wf := newFailedWorkflow(t, store, "deploy-2-nodes", constants.StepReservePlacedNodes, ewf.State{
+		"config":         statemanager.ClientConfig{UserID: 1, Mnemonic: "mnemonic"},
+		"reserve_nodes":  []uint32{11, 12},
+		"reserved_nodes": []ReservedNode{{NodeID: 11, ContractID: 100}},
+	})
+	require.NoError(t, rollbackFailedDeployment(engine, nil, wf))
+
+	select {
+	case state := <-unreserved:
+		require.Equal(t, uint32(100), state["contract_id"])
+		require.Equal(t, uint32(11), state["node_id"])
+		require.Equal(t, 1, state["user_id"])
+		require.Equal(t, constants.NodeRentable, state["target_status"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reserved node isn't unreserved")
+	}
+	require.NotContains(t, wf.State, "reserved_nodes")
+
+	select {
+	case state := <-unreserved:
+		t.Fatalf("unexpected unreserve of node %v", state["node_id"])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReservePlacedNodesStepWithoutRentableNodes(t *testing.T) {
+	state := ewf.State{"config": statemanager.ClientConfig{UserID: 1, Mnemonic: "mnemonic"}}
+	require.NoError(t, ReservePlacedNodesStep(nil, nil)(context.Background(), state))
+	require.NotContains(t, state, "reserved_nodes")
+}
+
+func TestPoolsClaimRunning(t *testing.T) {
+	store := newTestWorkflowStore(t)
+	ctx := context.Background()
+	save := func(status ewf.WorkflowStatus, createdAt time.Time) string {
+		wf := ewf.NewWorkflow(constants.WorkflowUpgradeCluster)
+		wf.Status = status
+		wf.CreatedAt = createdAt
+		require.NoError(t, store.SaveWorkflow(ctx, wf))
+		return wf.UUID
+	}
+
+	for name, tc := range map[string]struct {
+		holder  string
+		running bool
+	}{
+		"no claim":               {holder: "", running: false},
+		"unknown workflow":       {holder: "unknown", running: false},
+		"running workflow":       {holder: save(ewf.StatusRunning, time.Now()), running: true},
+		"workflow being started": {holder: save(ewf.StatusPending, time.Now()), running: true},
+		"never started workflow": {holder: save(ewf.StatusPending, time.Now().Add(-2*PoolWorkflowStartTimeout)), running: false},
+		"ended workflow":         {holder: save(ewf.StatusCompleted, time.Now()), running: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			running, err := PoolsClaimRunning(ctx, store, tc.holder)
+			require.NoError(t, err)
+			require.Equal(t, tc.running, running)
+		})
+	}
+}
+
+func TestUpgradeOrder(t *testing.T) {
+	cluster := kubedeployer.Cluster{
+		Nodes: []kubedeployer.Node{
+			{Name: "worker", Type: kubedeployer.NodeTypeWorker, Flist: "old"},
+			{Name: "master", Type: kubedeployer.NodeTypeMaster, Flist: "new"},
+			{Name: "leader", Type: kubedeployer.NodeTypeLeader, Flist: "old"},
+			{Name: "upgraded", Type: kubedeployer.NodeTypeWorker, Flist: "new"},
+		},
+	}
+
+	require.Equal(t, []string{"leader", "worker"}, UpgradeOrder(cluster, "new"))
+	require.Equal(t, []string{"master", "upgraded"}, UpgradeOrder(cluster, "old"))
+	require.Len(t, UpgradeOrder(cluster, "other"), 4)
+}
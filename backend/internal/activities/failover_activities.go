@@ -0,0 +1,283 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"kubecloud/internal"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/xmonader/ewf"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FailoverClaimTimeout is how long a leader promotion of a cluster blocks other ones, a longer one is considered lost
+const FailoverClaimTimeout = 30 * time.Minute
+
+// PromoteLeaderStep promotes the first healthy master to leader and fetches a kubeconfig pointing at it.
+// The previous leader is demoted to master, or removed from the cluster if its contract is gone.
+func PromoteLeaderStep(db models.DB, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		ensureClient(state)
+
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		kubeClient, err := statemanager.GetKubeClient(state, config)
+		if err != nil {
+			return err
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		oldLeader, err := cluster.GetLeaderNode()
+		if err != nil {
+			return fmt.Errorf("failed to get leader node: %w", ewf.ErrFailWorkflowNow)
+		}
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		newLeaderIdx := -1
+		var kubeconfig string
+		for i, node := range cluster.Nodes {
+			if node.Type != kubedeployer.NodeTypeMaster || node.Name == oldLeader.Name {
+				continue
+			}
+			if !kubeClient.IsNodeContractActive(node) {
+				logger.GetLogger().Warn().Str("node_name", node.Name).Msg("Master contract is not active, skipping it for promotion")
+				continue
+			}
+
+			kc, err := internal.GetKubeconfigViaSSH(string(privateKeyBytes), &node)
+			if err != nil {
+				logger.GetLogger().Warn().Err(err).Str("node_name", node.Name).Msg("Master is not reachable, skipping it for promotion")
+				continue
+			}
+
+			newLeaderIdx = i
+			kubeconfig = kc
+			break
+		}
+
+		if newLeaderIdx == -1 {
+			return fmt.Errorf("no healthy master available to promote in cluster %s", cluster.Name)
+		}
+
+		for i := range cluster.Nodes {
+			if cluster.Nodes[i].Type == kubedeployer.NodeTypeLeader {
+				cluster.Nodes[i].Type = kubedeployer.NodeTypeMaster
+			}
+		}
+		cluster.Nodes[newLeaderIdx].Type = kubedeployer.NodeTypeLeader
+		newLeader := cluster.Nodes[newLeaderIdx]
+
+		if !kubeClient.IsNodeContractActive(oldLeader) {
+			// the lost leader is drained through the new one, its pods are rescheduled once its Node object is deleted
+			var clientset kubernetes.Interface
+			if newClient, err := newClientset(kubeconfig); err == nil {
+				clientset = newClient
+			} else {
+				logger.GetLogger().Warn().Err(err).Str("node_name", oldLeader.Name).Msg("Failed to reach the new leader, removing the lost leader without draining it")
+			}
+			policy := DrainPolicy{Timeout: defaultDrainTimeout, Force: true}
+			if err := drainAndRemoveClusterNode(ctx, db, kubeClient, clientset, &cluster, oldLeader.Name, policy); err != nil {
+				return fmt.Errorf("failed to remove lost leader %s: %w", oldLeader.Name, err)
+			}
+			statemanager.SaveGridClientState(state, kubeClient)
+		}
+
+		logger.GetLogger().Info().
+			Str("cluster", cluster.Name).
+			Str("previous_leader", oldLeader.Name).
+			Str("new_leader", newLeader.Name).
+			Msg("Promoted new cluster leader")
+
+		state["kubeconfig"] = kubeconfig
+		state["previous_leader"] = oldLeader.OriginalName
+		state["new_leader"] = newLeader.OriginalName
+		statemanager.StoreCluster(state, cluster)
+		return nil
+	}
+}
+
+// leaderFailoverHook starts a leader promotion when a health check of an HA cluster fails because the leader is unreachable
+func leaderFailoverHook(engine *ewf.Engine, db models.DB, appConfig internal.Configuration) ewf.AfterWorkflowHook {
+	return func(ctx context.Context, wf *ewf.Workflow, err error) {
+		if err == nil || errors.Is(err, ewf.ErrFailWorkflowNow) {
+			return
+		}
+
+		cluster, clusterErr := statemanager.GetCluster(wf.State)
+		if clusterErr != nil {
+			logger.GetLogger().Error().Err(clusterErr).Str("workflow_name", wf.Name).Msg("Failed to get cluster from state")
+			return
+		}
+
+		if len(cluster.GetControlPlaneNodes()) < 2 {
+			return
+		}
+
+		leader, leaderErr := cluster.GetLeaderNode()
+		if leaderErr != nil {
+			logger.GetLogger().Error().Err(leaderErr).Str("cluster", cluster.Name).Msg("Failed to get leader node")
+			return
+		}
+
+		privateKeyBytes, keyErr := os.ReadFile(appConfig.SSH.PrivateKeyPath)
+		if keyErr != nil {
+			logger.GetLogger().Error().Err(keyErr).Msg("Failed to read SSH private key")
+			return
+		}
+
+		if _, sshErr := internal.GetKubeconfigViaSSH(string(privateKeyBytes), &leader); sshErr == nil {
+			// the leader is fine, the health check failed for another reason
+			return
+		}
+
+		cfg, cfgErr := getConfig(wf.State)
+		if cfgErr != nil {
+			logger.GetLogger().Error().Err(cfgErr).Str("workflow_name", wf.Name).Msg("Failed to get config from state")
+			return
+		}
+
+		user, userErr := db.GetUserByID(cfg.UserID)
+		if userErr != nil {
+			logger.GetLogger().Error().Err(userErr).Int("user_id", cfg.UserID).Msg("Failed to get cluster owner")
+			return
+		}
+
+		sshPublicKeyBytes, keyErr := os.ReadFile(appConfig.SSH.PublicKeyPath)
+		if keyErr != nil {
+			logger.GetLogger().Error().Err(keyErr).Msg("Failed to read SSH public key")
+			return
+		}
+
+		stored, dbErr := db.GetClusterByName(cfg.UserID, cluster.ProjectName)
+		if dbErr != nil {
+			logger.GetLogger().Error().Err(dbErr).Str("project_name", cluster.ProjectName).Msg("Failed to get cluster for leader promotion")
+			return
+		}
+
+		if stored.Status == models.ClusterStatusDeleting || stored.PendingDeletion() {
+			return
+		}
+
+		// the promotion stores the whole cluster, a workflow changing its nodes may also be why the leader is unreachable
+		staleClaim := stored.PoolWorkflowID
+		running, claimErr := PoolsClaimRunning(ctx, engine.Store(), staleClaim)
+		if claimErr != nil {
+			logger.GetLogger().Error().Err(claimErr).Str("workflow_id", staleClaim).Msg("Failed to load node pool workflow")
+			return
+		}
+		if running {
+			logger.GetLogger().Info().Str("cluster", cluster.Name).Str("workflow_id", staleClaim).Msg("Leader is unreachable while a workflow changes the cluster nodes, skipping leader promotion")
+			return
+		}
+
+		// health checks of the cluster keep failing until the new leader is promoted, start a single promotion
+		now := time.Now()
+		claimed, claimErr := db.ClaimClusterFailover(stored.ID, now, now.Add(-FailoverClaimTimeout))
+		if claimErr != nil {
+			logger.GetLogger().Error().Err(claimErr).Str("project_name", cluster.ProjectName).Msg("Failed to claim leader promotion")
+			return
+		}
+		if !claimed {
+			return
+		}
+
+		failoverWf, wfErr := engine.NewWorkflow(constants.WorkflowPromoteLeader)
+		if wfErr != nil {
+			logger.GetLogger().Error().Err(wfErr).Str("cluster", cluster.Name).Msg("Failed to create leader promotion workflow")
+			if err := db.ReleaseClusterFailover(stored.ID); err != nil {
+				logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to release leader promotion")
+			}
+			return
+		}
+
+		failoverWf.State = ewf.State{
+			"config": statemanager.ClientConfig{
+				SSHPublicKey: strings.TrimSpace(string(sshPublicKeyBytes)),
+				Mnemonic:     user.Mnemonic,
+				UserID:       cfg.UserID,
+				Network:      appConfig.SystemAccount.Network,
+				Debug:        appConfig.Debug,
+			},
+			"cluster": cluster,
+		}
+
+		if !claimPoolsForFailover(ctx, engine, db, stored.ID, failoverWf, staleClaim) {
+			if err := db.ReleaseClusterFailover(stored.ID); err != nil {
+				logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to release leader promotion")
+			}
+			return
+		}
+
+		logger.GetLogger().Warn().Str("cluster", cluster.Name).Str("leader", leader.Name).Msg("Leader is unreachable, starting leader promotion")
+		engine.RunAsync(context.Background(), failoverWf)
+	}
+}
+
+// claimPoolsForFailover makes the leader promotion the only workflow changing the nodes of the cluster and saves it,
+// so that update, upgrade and node pool requests see it running
+func claimPoolsForFailover(ctx context.Context, engine *ewf.Engine, db models.DB, clusterID int, wf *ewf.Workflow, staleClaim string) bool {
+	claimed, err := db.ClaimClusterPools(clusterID, wf.UUID, staleClaim)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("cluster_id", clusterID).Msg("Failed to claim node pools for leader promotion")
+		return false
+	}
+	if !claimed {
+		return false
+	}
+
+	if err := engine.Store().SaveWorkflow(ctx, wf); err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to save leader promotion workflow")
+		if err := db.ReleaseClusterPools(wf.UUID); err != nil {
+			logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to release node pools claim")
+		}
+		return false
+	}
+	return true
+}
+
+// releaseFailoverHook clears the leader promotion claim of the cluster once its promotion ended
+func releaseFailoverHook(db models.DB) ewf.AfterWorkflowHook {
+	return func(ctx context.Context, wf *ewf.Workflow, err error) {
+		cfg, cfgErr := getConfig(wf.State)
+		if cfgErr != nil {
+			logger.GetLogger().Error().Err(cfgErr).Str("workflow_name", wf.Name).Msg("Failed to get config from state")
+			return
+		}
+
+		cluster, clusterErr := statemanager.GetCluster(wf.State)
+		if clusterErr != nil {
+			logger.GetLogger().Error().Err(clusterErr).Str("workflow_name", wf.Name).Msg("Failed to get cluster from state")
+			return
+		}
+
+		stored, dbErr := db.GetClusterByName(cfg.UserID, cluster.ProjectName)
+		if dbErr != nil {
+			logger.GetLogger().Error().Err(dbErr).Str("project_name", cluster.ProjectName).Msg("Failed to get cluster to release leader promotion")
+			return
+		}
+
+		if err := db.ReleaseClusterFailover(stored.ID); err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to release leader promotion")
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package activities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+
+	"github.com/xmonader/ewf"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	gpuPresentLabel = "nvidia.com/gpu.present"
+	gpuCountLabel   = "kubecloud.io/gpu-count"
+
+	nvidiaRuntimeClass       = "nvidia"
+	nvidiaDevicePluginName   = "nvidia-device-plugin-daemonset"
+	nvidiaDevicePluginImage  = "nvcr.io/nvidia/k8s-device-plugin:v0.17.0"
+	nvidiaDevicePluginLabel  = "nvidia-device-plugin-ds"
+	kubeletDevicePluginsPath = "/var/lib/kubelet/device-plugins"
+	gpuResourceName          = v1.ResourceName("nvidia.com/gpu")
+)
+
+var (
+	gpuAllocatableTimeout     = 5 * time.Minute
+	gpuAllocatableRetryPeriod = 10 * time.Second
+)
+
+// SetupGPUNodesStep labels the nodes having GPUs attached and installs the NVIDIA device plugin on them,
+// then waits for the GPUs to be exposed as nvidia.com/gpu resources. Clusters without GPUs are left untouched.
+func SetupGPUNodesStep() ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster: %w", err)
+		}
+
+		gpuCounts := make(map[string]int, len(cluster.Nodes))
+		hasGPUs := false
+		for _, node := range cluster.Nodes {
+			gpuCounts[node.Name] = len(node.GPUIDs)
+			hasGPUs = hasGPUs || len(node.GPUIDs) > 0
+		}
+
+		kubeconfig, ok := state["kubeconfig"].(string)
+		if !ok || kubeconfig == "" {
+			return fmt.Errorf("kubeconfig not found in workflow state")
+		}
+
+		clientset, err := newClientset(kubeconfig)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list nodes: %w", err)
+		}
+
+		for _, node := range nodes.Items {
+			if err := labelGPUNode(ctx, clientset, &node, gpuCounts[node.Name]); err != nil {
+				return err
+			}
+		}
+
+		if !hasGPUs {
+			return nil
+		}
+
+		if err := ensureNvidiaRuntimeClass(ctx, clientset); err != nil {
+			return err
+		}
+		if err := ensureNvidiaDevicePlugin(ctx, clientset); err != nil {
+			return err
+		}
+		if err := waitGPUsAllocatable(ctx, clientset, gpuCounts); err != nil {
+			return err
+		}
+
+		logger.GetLogger().Info().Str("cluster", cluster.Name).Msg("NVIDIA device plugin is set up on GPU nodes")
+		return nil
+	}
+}
+
+// labelGPUNode sets the GPU labels of a node, or removes them when it has no GPU anymore
+func labelGPUNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, gpus int) error {
+	labels := map[string]interface{}{
+		gpuPresentLabel: nil,
+		gpuCountLabel:   nil,
+	}
+	if gpus > 0 {
+		labels[gpuPresentLabel] = "true"
+		labels[gpuCountLabel] = strconv.Itoa(gpus)
+	} else if _, labeled := node.Labels[gpuPresentLabel]; !labeled {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build label patch: %w", err)
+	}
+
+	if _, err := clientset.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to label node %s: %w", node.Name, err)
+	}
+	return nil
+}
+
+// ensureNvidiaRuntimeClass creates the runtime class k3s registers the NVIDIA container runtime under
+func ensureNvidiaRuntimeClass(ctx context.Context, clientset kubernetes.Interface) error {
+	runtimeClass := &nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: nvidiaRuntimeClass},
+		Handler:    nvidiaRuntimeClass,
+	}
+
+	_, err := clientset.NodeV1().RuntimeClasses().Create(ctx, runtimeClass, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create runtime class %s: %w", nvidiaRuntimeClass, err)
+	}
+	return nil
+}
+
+// ensureNvidiaDevicePlugin creates or updates the device plugin daemon set running on the labeled GPU nodes
+func ensureNvidiaDevicePlugin(ctx context.Context, clientset kubernetes.Interface) error {
+	daemonSets := clientset.AppsV1().DaemonSets(metav1.NamespaceSystem)
+	desired := nvidiaDevicePluginDaemonSet()
+
+	existing, err := daemonSets.Get(ctx, desired.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := daemonSets.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create NVIDIA device plugin: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get NVIDIA device plugin: %w", err)
+	}
+
+	existing.Spec = desired.Spec
+	if _, err := daemonSets.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update NVIDIA device plugin: %w", err)
+	}
+	return nil
+}
+
+// waitGPUsAllocatable waits for every node with GPUs to run the NVIDIA runtime and to have its GPUs allocatable,
+// the device plugin can't start without the NVIDIA driver and container toolkit of the node image
+func waitGPUsAllocatable(ctx context.Context, clientset kubernetes.Interface, gpuCounts map[string]int) error {
+	ctx, cancel := context.WithTimeout(ctx, gpuAllocatableTimeout)
+	defer cancel()
+
+	for {
+		pending, err := pendingGPUNodes(ctx, clientset, gpuCounts)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("GPUs are not allocatable on %s, check the NVIDIA driver and container toolkit of the node image: %w", strings.Join(pending, ", "), ctx.Err())
+		case <-time.After(gpuAllocatableRetryPeriod):
+		}
+	}
+}
+
+// pendingGPUNodes returns the nodes with GPUs that don't expose them yet, with the reason
+func pendingGPUNodes(ctx context.Context, clientset kubernetes.Interface, gpuCounts map[string]int) ([]string, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: gpuPresentLabel + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GPU nodes: %w", err)
+	}
+
+	var pending []string
+	for _, node := range nodes.Items {
+		if gpuCounts[node.Name] == 0 {
+			continue
+		}
+		if !hasRuntimeHandler(&node, nvidiaRuntimeClass) {
+			pending = append(pending, fmt.Sprintf("%s (no %s runtime)", node.Name, nvidiaRuntimeClass))
+			continue
+		}
+		if gpus, ok := node.Status.Allocatable[gpuResourceName]; !ok || gpus.IsZero() {
+			pending = append(pending, fmt.Sprintf("%s (no %s)", node.Name, gpuResourceName))
+		}
+	}
+	return pending, nil
+}
+
+// hasRuntimeHandler reports whether the container runtime of the node has the handler, nodes whose kubelet doesn't
+// report runtime handlers are assumed to have it
+func hasRuntimeHandler(node *v1.Node, handler string) bool {
+	if len(node.Status.RuntimeHandlers) == 0 {
+		return true
+	}
+	for _, runtimeHandler := range node.Status.RuntimeHandlers {
+		if runtimeHandler.Name == handler {
+			return true
+		}
+	}
+	return false
+}
+
+func nvidiaDevicePluginDaemonSet() *appsv1.DaemonSet {
+	runtimeClass := nvidiaRuntimeClass
+	noPrivilegeEscalation := false
+	podLabels := map[string]string{"name": nvidiaDevicePluginLabel}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nvidiaDevicePluginName,
+			Namespace: metav1.NamespaceSystem,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels},
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				Type: appsv1.RollingUpdateDaemonSetStrategyType,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec: v1.PodSpec{
+					NodeSelector:      map[string]string{gpuPresentLabel: "true"},
+					RuntimeClassName:  &runtimeClass,
+					PriorityClassName: "system-node-critical",
+					Tolerations: []v1.Toleration{
+						{Key: "nvidia.com/gpu", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+					},
+					Containers: []v1.Container{
+						{
+							Name:  "nvidia-device-plugin-ctr",
+							Image: nvidiaDevicePluginImage,
+							Env: []v1.EnvVar{
+								{Name: "FAIL_ON_INIT_ERROR", Value: "false"},
+							},
+							SecurityContext: &v1.SecurityContext{
+								AllowPrivilegeEscalation: &noPrivilegeEscalation,
+								Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+							},
+							VolumeMounts: []v1.VolumeMount{
+								{Name: "device-plugin", MountPath: kubeletDevicePluginsPath},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "device-plugin",
+							VolumeSource: v1.VolumeSource{
+								HostPath: &v1.HostPathVolumeSource{Path: kubeletDevicePluginsPath},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package activities
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLabelGPUNode(t *testing.T) {
+	ctx := context.Background()
+	gpuNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu"}}
+	plainNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "plain", Labels: map[string]string{"role": "worker"}}}
+	clientset := fake.NewSimpleClientset(gpuNode, plainNode)
+
+	require.NoError(t, labelGPUNode(ctx, clientset, gpuNode, 2))
+	node, err := clientset.CoreV1().Nodes().Get(ctx, "gpu", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "true", node.Labels[gpuPresentLabel])
+	require.Equal(t, "2", node.Labels[gpuCountLabel])
+
+	t.Run("nodes without GPUs are not patched", func(t *testing.T) {
+		clientset.ClearActions()
+		require.NoError(t, labelGPUNode(ctx, clientset, plainNode, 0))
+		require.Empty(t, clientset.Actions())
+	})
+
+	t.Run("labels are removed once the GPUs are detached", func(t *testing.T) {
+		require.NoError(t, labelGPUNode(ctx, clientset, node, 0))
+		node, err := clientset.CoreV1().Nodes().Get(ctx, "gpu", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, node.Labels, gpuPresentLabel)
+		require.NotContains(t, node.Labels, gpuCountLabel)
+	})
+}
+
+func TestEnsureNvidiaDevicePlugin(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+
+	require.NoError(t, ensureNvidiaRuntimeClass(ctx, clientset))
+	require.NoError(t, ensureNvidiaRuntimeClass(ctx, clientset))
+	runtimeClass, err := clientset.NodeV1().RuntimeClasses().Get(ctx, nvidiaRuntimeClass, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, nvidiaRuntimeClass, runtimeClass.Handler)
+
+	require.NoError(t, ensureNvidiaDevicePlugin(ctx, clientset))
+	daemonSets := clientset.AppsV1().DaemonSets(metav1.NamespaceSystem)
+	ds, err := daemonSets.Get(ctx, nvidiaDevicePluginName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{gpuPresentLabel: "true"}, ds.Spec.Template.Spec.NodeSelector)
+	require.Equal(t, nvidiaRuntimeClass, *ds.Spec.Template.Spec.RuntimeClassName)
+
+	// an outdated daemon set is brought back to the desired spec
+	ds.Spec.Template.Spec.Containers[0].Image = "nvcr.io/nvidia/k8s-device-plugin:v0.1.0"
+	_, err = daemonSets.Update(ctx, ds, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, ensureNvidiaDevicePlugin(ctx, clientset))
+	ds, err = daemonSets.Get(ctx, nvidiaDevicePluginName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, nvidiaDevicePluginImage, ds.Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestWaitGPUsAllocatable(t *testing.T) {
+	gpuAllocatableTimeout = 200 * time.Millisecond
+	gpuAllocatableRetryPeriod = 10 * time.Millisecond
+	t.Cleanup(func() {
+		gpuAllocatableTimeout = 5 * time.Minute
+		gpuAllocatableRetryPeriod = 10 * time.Second
+	})
+
+	ctx := context.Background()
+	gpuNode := func(handlers ...string) *v1.Node {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu", Labels: map[string]string{gpuPresentLabel: "true"}}}
+		for _, handler := range handlers {
+			node.Status.RuntimeHandlers = append(node.Status.RuntimeHandlers, v1.NodeRuntimeHandler{Name: handler})
+		}
+		return node
+	}
+	gpuCounts := map[string]int{"gpu": 1}
+
+	t.Run("nodes without the nvidia runtime fail", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(gpuNode("runc"))
+		err := waitGPUsAllocatable(ctx, clientset, gpuCounts)
+		require.ErrorContains(t, err, "gpu (no nvidia runtime)")
+	})
+
+	t.Run("nodes that never expose their GPUs fail", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(gpuNode("runc", "nvidia"))
+		err := waitGPUsAllocatable(ctx, clientset, gpuCounts)
+		require.ErrorContains(t, err, "gpu (no nvidia.com/gpu)")
+	})
+
+	t.Run("waits for the device plugin to expose the GPUs", func(t *testing.T) {
+		node := gpuNode("runc", "nvidia")
+		clientset := fake.NewSimpleClientset(node)
+
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			node.Status.Allocatable = v1.ResourceList{gpuResourceName: resource.MustParse("1")}
+			_, _ = clientset.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{})
+		}()
+
+		require.NoError(t, waitGPUsAllocatable(ctx, clientset, gpuCounts))
+	})
+
+	t.Run("nodes without GPUs are ignored", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(gpuNode("runc"))
+		require.NoError(t, waitGPUsAllocatable(ctx, clientset, map[string]int{"gpu": 0}))
+	})
+}
@@ -41,7 +41,7 @@ func hookWorkflowStarted(n *notification.NotificationService) ewf.BeforeWorkflow
 			logger.GetLogger().Debug().Int("user_id", userID).Msg("hookWorkflowStarted")
 		}
 
-		workflowDesc := getWorkflowDescription(w.Name)
+		workflowDesc := GetWorkflowDescription(w.Name)
 		subject := fmt.Sprintf("%s Started", workflowDesc)
 		message := fmt.Sprintf("%s has been started", workflowDesc)
 
@@ -82,6 +82,46 @@ func hookStepDone(_ context.Context, w *ewf.Workflow, step *ewf.Step, err error)
 		logger.GetLogger().Info().Str("workflow_name", w.Name).Str("step_name", step.Name).Msg("Step completed successfully")
 	}
 }
+
+// recordStepStarted starts a new run of the step in the workflow state, replacing the one of a previous execution
+func recordStepStarted(_ context.Context, w *ewf.Workflow, step *ewf.Step) {
+	statemanager.UpdateStepRun(w.State, step.Name, func(run *statemanager.StepRun) {
+		*run = statemanager.StepRun{StartedAt: time.Now().UTC()}
+	})
+}
+
+// recordStepDone ends the run of the step in the workflow state
+func recordStepDone(_ context.Context, w *ewf.Workflow, step *ewf.Step, err error) {
+	statemanager.UpdateStepRun(w.State, step.Name, func(run *statemanager.StepRun) {
+		endedAt := time.Now().UTC()
+		run.EndedAt = &endedAt
+		if err != nil {
+			run.LastError = err.Error()
+		}
+	})
+}
+
+// recordAttempts counts the attempts of a step and keeps the error of the last failed one in the workflow state,
+// the retries of a step happen within the engine where hooks don't see them
+func recordAttempts(fn ewf.StepFn) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		stepName, _ := ctx.Value(ewf.StepNameContextKey).(string)
+		err := fn(ctx, state)
+		statemanager.UpdateStepRun(state, stepName, func(run *statemanager.StepRun) {
+			run.Attempts++
+			if err != nil {
+				run.LastError = err.Error()
+			}
+		})
+		return err
+	}
+}
+
+// registerStep registers the activity of a step, recording its attempts
+func registerStep(engine *ewf.Engine, name string, fn ewf.StepFn) {
+	engine.Register(name, recordAttempts(fn))
+}
+
 func hookClusterHealthCheck(notificationService *notification.NotificationService) ewf.AfterWorkflowHook {
 	return func(ctx context.Context, wf *ewf.Workflow, err error) {
 		if err == nil {
@@ -103,7 +143,7 @@ func hookClusterHealthCheck(notificationService *notification.NotificationServic
 			Status:  "failed",
 			Error:   err.Error(),
 		}, map[string]string{
-			"workflow_name": getWorkflowDescription(wf.Name),
+			"workflow_name": GetWorkflowDescription(wf.Name),
 			"timestamp":     time.Now().UTC().Format(TimestampFormat),
 		})
 		cluster, errCluster := statemanager.GetCluster(wf.State)
@@ -139,9 +179,11 @@ func newKubecloudWorkflowTemplate(n *notification.NotificationService) ewf.Workf
 		},
 		BeforeStepHooks: []ewf.BeforeStepHook{
 			hookStepStarted,
+			recordStepStarted,
 		},
 		AfterStepHooks: []ewf.AfterStepHook{
 			hookStepDone,
+			recordStepDone,
 		},
 	}
 }
@@ -168,38 +210,63 @@ func getDeployNodeStepName(index int) string {
 	return fmt.Sprintf("deploy-%d%s-node", index, getOrdinalSuffix(index))
 }
 
+func getApplyOperationStepName(index int) string {
+	return fmt.Sprintf("apply-%d%s-operation", index, getOrdinalSuffix(index))
+}
+
+func getUpgradeNodeStepName(index int) string {
+	return fmt.Sprintf("upgrade-%d%s-node", index, getOrdinalSuffix(index))
+}
+
 func addNodeFailureHook(engine *ewf.Engine, metrics *metrics.Metrics) ewf.AfterWorkflowHook {
 	return func(ctx context.Context, wf *ewf.Workflow, err error) {
 		if err == nil || wf.Name != constants.WorkflowAddNode {
 			return
 		}
-
-		node, err := getFromState[kubedeployer.Node](wf.State, "node")
-		if err != nil {
-			logger.GetLogger().Error().Msg("missing or invalid 'node' in workflow state")
+		if keepForRetry(ctx, engine, wf) {
 			return
 		}
-
-		rollbackWf, rollbackErr := engine.NewWorkflow(constants.WorkflowRollbackFailedAddNode)
-		if rollbackErr != nil {
-			logger.GetLogger().Error().Err(rollbackErr).Msg("Failed to create rollback workflow")
-			return
+		if err := rollbackFailedAddNode(engine, metrics, wf); err != nil {
+			logger.GetLogger().Error().Err(err).Msg("Failed to roll back failed node addition")
 		}
+	}
+}
 
-		rollbackWf.State["config"] = wf.State["config"]
-		rollbackWf.State["cluster"] = wf.State["cluster"]
-		rollbackWf.State["kubeclient"] = wf.State["kubeclient"]
-		rollbackWf.State["node_name"] = node.OriginalName
+// rollbackFailedAddNode removes the node a failed add-node workflow deployed
+func rollbackFailedAddNode(engine *ewf.Engine, metrics *metrics.Metrics, wf *ewf.Workflow) error {
+	node, err := getFromState[kubedeployer.Node](wf.State, "node")
+	if err != nil {
+		logger.GetLogger().Error().Msg("missing or invalid 'node' in workflow state")
+		return nil
+	}
 
-		rollbackCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-		defer cancel()
+	rollbackWf, err := engine.NewWorkflow(constants.WorkflowRollbackFailedAddNode)
+	if err != nil {
+		return fmt.Errorf("failed to create rollback workflow: %w", err)
+	}
 
-		// wait the rollback workflow to finish before closing the client
-		if err := engine.RunSync(rollbackCtx, rollbackWf); err != nil {
-			logger.GetLogger().Error().Err(err).Msg("Failed to run rollback workflow")
-			return
-		}
+	rollbackWf.State["config"] = wf.State["config"]
+	rollbackWf.State["cluster"] = wf.State["cluster"]
+	rollbackWf.State["kubeclient"] = wf.State["kubeclient"]
+	rollbackWf.State["gridclient_state"] = wf.State["gridclient_state"]
+	rollbackWf.State["node_name"] = node.OriginalName
+	// the node may have joined the cluster, its pods get a chance to move but the rollback doesn't wait on them
+	rollbackWf.State["drain_policy"] = DrainPolicy{Timeout: rollbackDrainTimeout, Force: true}
 
-		metrics.DecActiveClusterCount()
+	rollbackCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	// wait the rollback workflow to finish before closing the client
+	if err := engine.RunSync(rollbackCtx, rollbackWf); err != nil {
+		return fmt.Errorf("failed to run rollback workflow: %w", err)
 	}
+
+	metrics.DecActiveClusterCount()
+	return nil
+}
+
+// HasFailureRollback reports whether a failed workflow rolls back the contracts it created,
+// which makes it safe to cancel while it runs
+func HasFailureRollback(workflowName string) bool {
+	return isDeployWorkflow(workflowName) || workflowName == constants.WorkflowAddNode
 }
@@ -0,0 +1,120 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/notification"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+
+	"github.com/xmonader/ewf"
+)
+
+// AdoptClusterStep replaces the cluster in state with the one rebuilt from the user's contracts on chain.
+// The contracts are looked up under "source_project" when set, the project of an exported document
+// created under another user ID, otherwise under the user's own project.
+// When the cluster in state comes from an exported document, every contract it lists must still be active.
+func AdoptClusterStep() ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		if adopted, _ := state["adopted"].(bool); adopted {
+			return nil
+		}
+
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		kubeClient, err := statemanager.GetKubeClient(state, config)
+		if err != nil {
+			return fmt.Errorf("failed to get kubeclient: %w", err)
+		}
+
+		imported, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		projectName, _ := state["source_project"].(string)
+		if projectName == "" {
+			projectName = kubedeployer.GetProjectName(config.UserID, imported.Name)
+		}
+
+		cluster, err := kubeClient.LoadClusterFromGrid(ctx, projectName, imported.Name)
+		if errors.Is(err, kubedeployer.ErrNoDeploymentsFound) {
+			return fmt.Errorf("%w: %w", err, ewf.ErrFailWorkflowNow)
+		}
+		if err != nil {
+			return err
+		}
+
+		if missing := imported.MissingContracts(cluster); len(missing) > 0 {
+			return fmt.Errorf("contracts %v of cluster %s are not active on chain for this user: %w", missing, imported.Name, ewf.ErrFailWorkflowNow)
+		}
+
+		logger.GetLogger().Info().Str("project_name", cluster.ProjectName).Int("nodes", len(cluster.Nodes)).Msg("Cluster adopted from chain")
+
+		statemanager.StoreCluster(state, cluster)
+		statemanager.SaveGridClientState(state, kubeClient)
+		state["adopted"] = true
+		return nil
+	}
+}
+
+// RenameProjectStep moves the contracts of an adopted cluster to the user's project,
+// so they are found under the project name the deployment record is stored with.
+func RenameProjectStep() ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		projectName := kubedeployer.GetProjectName(config.UserID, cluster.Name)
+		if cluster.ProjectName == projectName {
+			return nil
+		}
+
+		kubeClient, err := statemanager.GetKubeClient(state, config)
+		if err != nil {
+			return fmt.Errorf("failed to get kubeclient: %w", err)
+		}
+
+		err = kubeClient.RenameProject(&cluster, projectName)
+		if errors.Is(err, kubedeployer.ErrContractNotOwned) {
+			return fmt.Errorf("%w: %w", err, ewf.ErrFailWorkflowNow)
+		}
+		if err != nil {
+			return err
+		}
+
+		logger.GetLogger().Info().Str("project_name", projectName).Msg("Adopted cluster moved to the user's project")
+
+		statemanager.StoreCluster(state, cluster)
+		return nil
+	}
+}
+
+func registerImportActivities(engine *ewf.Engine, notificationService *notification.NotificationService) {
+	registerStep(engine, constants.StepAdoptCluster, AdoptClusterStep())
+	registerStep(engine, constants.StepRenameProject, RenameProjectStep())
+
+	// no rollback on failure, the contracts being imported were not created by this workflow
+	importWFTemplate := newKubecloudWorkflowTemplate(notificationService)
+	importWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepAdoptCluster, RetryPolicy: standardRetryPolicy},
+		{Name: constants.StepRenameProject, RetryPolicy: standardRetryPolicy},
+		{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy},
+	}
+	engine.RegisterTemplate(constants.WorkflowImportCluster, &importWFTemplate)
+}
@@ -0,0 +1,55 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"gorm.io/gorm"
+)
+
+// assignNodeIP reserves the private IP of a node in the database before it is deployed.
+// A retried step reuses the reservation of the previous attempt, unless the node was moved to another grid node.
+func assignNodeIP(ctx context.Context, db models.DB, kubeClient *kubedeployer.Client, cluster *kubedeployer.Cluster, node *kubedeployer.Node) error {
+	allocation, err := db.GetIPAllocation(cluster.ProjectName, node.Name)
+	switch {
+	case err == nil && allocation.NodeID == node.NodeID:
+		node.IP = allocation.IP
+		return nil
+	case err == nil:
+		// the subnet of the network differs per grid node
+		if err := db.ReleaseIPAllocation(cluster.ProjectName, node.Name); err != nil {
+			return fmt.Errorf("failed to release previous IP of node %s: %w", node.Name, err)
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return fmt.Errorf("failed to get IP allocation of node %s: %w", node.Name, err)
+	}
+
+	reserve := func(ip string) error {
+		return db.ReserveIPAllocation(&models.IPAllocation{
+			ProjectName: cluster.ProjectName,
+			NodeName:    node.Name,
+			NodeID:      node.NodeID,
+			IP:          ip,
+		})
+	}
+
+	return node.AssignNodeIP(ctx, kubeClient.GridClient, cluster, reserve)
+}
+
+// removeClusterNode cancels the contracts of a node, removes it from the cluster and releases its private IP
+func removeClusterNode(ctx context.Context, db models.DB, kubeClient *kubedeployer.Client, cluster *kubedeployer.Cluster, nodeName string) error {
+	if err := kubeClient.RemoveNode(ctx, cluster, nodeName); err != nil {
+		return err
+	}
+
+	if err := db.ReleaseIPAllocation(cluster.ProjectName, nodeName); err != nil {
+		// the IP stays reserved until the cluster is deleted, which only shrinks the pool
+		logger.GetLogger().Warn().Err(err).Str("project_name", cluster.ProjectName).Str("node_name", nodeName).Msg("Failed to release IP allocation of removed node")
+	}
+	return nil
+}
@@ -68,6 +68,69 @@ func ReserveNodeStep(db models.DB, substrateClient *substrate.Substrate) ewf.Ste
 	}
 }
 
+// ReservedNode is a grid node a deploy workflow rented for the cluster nodes placed on it
+type ReservedNode struct {
+	NodeID     uint32 `json:"node_id"`
+	ContractID uint64 `json:"contract_id"`
+}
+
+// ReservePlacedNodesStep rents the grid nodes the cluster nodes were placed on from the rentable fallback.
+// Every rented node is recorded in the state, so the rollback of a failed deployment unreserves it.
+func ReservePlacedNodesStep(db models.DB, substrateClient *substrate.Substrate) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		if _, ok := state["reserve_nodes"]; !ok {
+			// no cluster node was placed on a rentable node
+			return nil
+		}
+		nodeIDs, err := getFromState[[]uint32](state, "reserve_nodes")
+		if err != nil {
+			return err
+		}
+
+		config, err := getConfig(state)
+		if err != nil {
+			return err
+		}
+
+		identity, err := substrate.NewIdentityFromSr25519Phrase(config.Mnemonic)
+		if err != nil {
+			return fmt.Errorf("failed to create identity: %w", err)
+		}
+
+		// nodes reserved by a previous attempt of the step are kept
+		reserved, _ := getFromState[[]ReservedNode](state, "reserved_nodes")
+		isReserved := make(map[uint32]bool, len(reserved))
+		for _, node := range reserved {
+			isReserved[node.NodeID] = true
+		}
+
+		for _, nodeID := range nodeIDs {
+			if isReserved[nodeID] {
+				continue
+			}
+
+			contractID, err := substrateClient.CreateRentContract(identity, nodeID, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create rent contract for node %d: %w", nodeID, err)
+			}
+			reserved = append(reserved, ReservedNode{NodeID: nodeID, ContractID: contractID})
+			state["reserved_nodes"] = reserved
+
+			err = db.CreateUserNode(&models.UserNodes{
+				UserID:     config.UserID,
+				ContractID: contractID,
+				NodeID:     nodeID,
+				CreatedAt:  time.Now(),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create user node: %w", err)
+			}
+		}
+
+		return nil
+	}
+}
+
 func UnreserveNodeStep(db models.DB, substrateClient *substrate.Substrate) ewf.StepFn {
 	return func(ctx context.Context, state ewf.State) error {
 		contractID, ok := state["contract_id"].(uint32)
@@ -0,0 +1,146 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"kubecloud/internal/logger"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const defaultDrainTimeout = 5 * time.Minute
+
+// evictionRetryPeriod is how often blocked evictions and terminating pods are checked again
+var evictionRetryPeriod = 5 * time.Second
+
+func newClientset(kubeconfig string) (*kubernetes.Clientset, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	return clientset, nil
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady && cond.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// setNodeSchedulable cordons or uncordons a node
+func setNodeSchedulable(ctx context.Context, clientset kubernetes.Interface, nodeName string, schedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, !schedulable)
+	_, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// drainNode evicts every pod running on the node except DaemonSet and static pods.
+// Evictions go through the eviction API, so PodDisruptionBudgets are honored and
+// blocked evictions are retried until the timeout expires.
+func drainNode(ctx context.Context, clientset kubernetes.Interface, nodeName string, timeout time.Duration) error {
+	drainCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pods, err := clientset.CoreV1().Pods(v1.NamespaceAll).List(drainCtx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+	}
+
+	var evicted []v1.Pod
+	for _, pod := range pods.Items {
+		if !shouldEvict(pod) {
+			continue
+		}
+		if err := evictPod(drainCtx, clientset, pod); err != nil {
+			return err
+		}
+		evicted = append(evicted, pod)
+	}
+
+	for _, pod := range evicted {
+		if err := waitPodDeleted(drainCtx, clientset, pod); err != nil {
+			return err
+		}
+	}
+
+	logger.GetLogger().Info().Str("node", nodeName).Int("evicted_pods", len(evicted)).Msg("Node drained")
+	return nil
+}
+
+func shouldEvict(pod v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if _, mirror := pod.Annotations[v1.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+func evictPod(ctx context.Context, clientset kubernetes.Interface, pod v1.Pod) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	}
+
+	for {
+		err := clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil, apierrors.IsNotFound(err):
+			return nil
+		case apierrors.IsTooManyRequests(err):
+			// a PodDisruptionBudget blocks the eviction for now
+			logger.GetLogger().Debug().Str("pod", pod.Namespace+"/"+pod.Name).Msg("Eviction blocked by disruption budget, retrying")
+		default:
+			return fmt.Errorf("failed to evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out evicting pod %s/%s: %w", pod.Namespace, pod.Name, ctx.Err())
+		case <-time.After(evictionRetryPeriod):
+		}
+	}
+}
+
+func waitPodDeleted(ctx context.Context, clientset kubernetes.Interface, pod v1.Pod) error {
+	for {
+		current, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for pod %s/%s to terminate: %w", pod.Namespace, pod.Name, ctx.Err())
+		case <-time.After(evictionRetryPeriod):
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmonader/ewf"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func drainTestNode(ready bool) *v1.Node {
+	status := v1.ConditionTrue
+	if !ready {
+		status = v1.ConditionFalse
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}},
+	}
+}
+
+func drainTestPod(name string, mutate func(pod *v1.Pod)) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)},
+		Spec:       v1.PodSpec{NodeName: "worker"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+// evictions deletes evicted pods like the API server does, once the first blocked evictions were refused
+func evictions(clientset *fake.Clientset, blocked int) *[]string {
+	var evicted []string
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		if blocked > 0 {
+			blocked--
+			return true, nil, apierrors.NewTooManyRequests("disruption budget", 1)
+		}
+		name := action.(k8stesting.CreateAction).GetObject().(metav1.Object).GetName()
+		evicted = append(evicted, name)
+		err := clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), action.GetNamespace(), name)
+		return true, nil, err
+	})
+	return &evicted
+}
+
+func unschedulable(t *testing.T, clientset *fake.Clientset) bool {
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "worker", metav1.GetOptions{})
+	require.NoError(t, err)
+	return node.Spec.Unschedulable
+}
+
+func TestDrainNodeForRemoval(t *testing.T) {
+	ctx := context.Background()
+	evictionRetryPeriod = 10 * time.Millisecond
+	t.Cleanup(func() { evictionRetryPeriod = 5 * time.Second })
+	policy := DrainPolicy{Timeout: time.Second}
+
+	t.Run("pods are evicted except daemon set, static and finished pods", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(
+			drainTestNode(true),
+			drainTestPod("web", nil),
+			drainTestPod("logs", func(pod *v1.Pod) {
+				pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "logs"}}
+			}),
+			drainTestPod("etcd", func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "mirror"}
+			}),
+			drainTestPod("job", func(pod *v1.Pod) { pod.Status.Phase = v1.PodSucceeded }),
+		)
+		evicted := evictions(clientset, 0)
+
+		require.NoError(t, drainNodeForRemoval(ctx, clientset, "worker", policy))
+		require.Equal(t, []string{"web"}, *evicted)
+		require.True(t, unschedulable(t, clientset))
+
+		pods, err := clientset.CoreV1().Pods("default").List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, pods.Items, 3)
+	})
+
+	t.Run("evictions blocked by a disruption budget are retried", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(drainTestNode(true), drainTestPod("web", nil))
+		evicted := evictions(clientset, 2)
+
+		require.NoError(t, drainNodeForRemoval(ctx, clientset, "worker", policy))
+		require.Equal(t, []string{"web"}, *evicted)
+	})
+
+	t.Run("a timed out drain uncordons the node and fails the removal", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(drainTestNode(true), drainTestPod("web", nil))
+		evictions(clientset, 1000)
+
+		err := drainNodeForRemoval(ctx, clientset, "worker", DrainPolicy{Timeout: 50 * time.Millisecond})
+		require.Error(t, err)
+		require.True(t, errors.Is(err, ewf.ErrFailWorkflowNow))
+		require.False(t, unschedulable(t, clientset))
+	})
+
+	t.Run("a forced drain removes the node anyway", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(drainTestNode(true), drainTestPod("web", nil))
+		evictions(clientset, 1000)
+
+		require.NoError(t, drainNodeForRemoval(ctx, clientset, "worker", DrainPolicy{Timeout: 50 * time.Millisecond, Force: true}))
+		require.True(t, unschedulable(t, clientset))
+	})
+
+	t.Run("a node that is not ready is only cordoned", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(drainTestNode(false), drainTestPod("web", nil))
+		evicted := evictions(clientset, 0)
+
+		require.NoError(t, drainNodeForRemoval(ctx, clientset, "worker", policy))
+		require.Empty(t, *evicted)
+		require.True(t, unschedulable(t, clientset))
+	})
+
+	t.Run("a node missing from the cluster has nothing to drain", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		require.NoError(t, drainNodeForRemoval(ctx, clientset, "worker", policy))
+	})
+}
+
+func TestWaitPodDeleted(t *testing.T) {
+	ctx := context.Background()
+	evictionRetryPeriod = 10 * time.Millisecond
+	t.Cleanup(func() { evictionRetryPeriod = 5 * time.Second })
+
+	pod := drainTestPod("web", nil)
+	clientset := fake.NewSimpleClientset(pod)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.Error(t, waitPodDeleted(timeoutCtx, clientset, *pod), "the pod is still running")
+
+	// a pod recreated under the same name by its controller is another pod
+	recreated := drainTestPod("web", func(pod *v1.Pod) { pod.UID = "web-2" })
+	_, err := clientset.CoreV1().Pods("default").Update(ctx, recreated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, waitPodDeleted(ctx, clientset, *pod))
+}
+
+func TestDeleteKubernetesNode(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(drainTestNode(true))
+
+	deleteKubernetesNode(ctx, clientset, "worker")
+	_, err := clientset.CoreV1().Nodes().Get(ctx, "worker", metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+
+	// deleting it again only logs
+	deleteKubernetesNode(ctx, clientset, "worker")
+}
+
+func TestDrainAndRemoveClusterNodeWithoutCluster(t *testing.T) {
+	err := drainAndRemoveClusterNode(context.Background(), nil, nil, nil, nil, "worker", DrainPolicy{Timeout: time.Second})
+	require.Error(t, err, "a node is only removed without draining it when the policy forces it")
+}
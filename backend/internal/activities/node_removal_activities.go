@@ -0,0 +1,225 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/xmonader/ewf"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MaxDrainTimeout is the longest a node removal waits for the node to be drained
+const MaxDrainTimeout = time.Hour
+
+// rollbackDrainTimeout is how long the rollback of a failed node addition waits for the node to be drained
+const rollbackDrainTimeout = 2 * time.Minute
+
+// DrainPolicy controls how a node is drained before it is removed or upgraded
+type DrainPolicy struct {
+	Timeout time.Duration `json:"timeout"`
+	// Force removes or upgrades the node even if it couldn't be cordoned or drained, its remaining pods are killed with the VM
+	Force bool `json:"force"`
+}
+
+// getDrainPolicy returns the drain policy in the state, or the default one
+func getDrainPolicy(state ewf.State) DrainPolicy {
+	policy, err := getFromState[DrainPolicy](state, "drain_policy")
+	if err != nil || policy.Timeout <= 0 {
+		policy.Timeout = defaultDrainTimeout
+	}
+	return policy
+}
+
+// removedKubernetesNodeName returns the Kubernetes name of the node in 'node_name' being removed
+func removedKubernetesNodeName(state ewf.State) (string, kubedeployer.Cluster, error) {
+	config, err := getConfig(state)
+	if err != nil {
+		return "", kubedeployer.Cluster{}, fmt.Errorf("failed to get config from state: %w", err)
+	}
+
+	cluster, err := statemanager.GetCluster(state)
+	if err != nil {
+		return "", kubedeployer.Cluster{}, err
+	}
+
+	nodeName, ok := state["node_name"].(string)
+	if !ok {
+		return "", kubedeployer.Cluster{}, fmt.Errorf("missing or invalid 'node_name' in state: %w", ewf.ErrFailWorkflowNow)
+	}
+
+	return cluster.DeployedNodeName(config.UserID, nodeName), cluster, nil
+}
+
+// CordonNodeStep marks the node being removed as unschedulable, so no new pods land on it while it is drained.
+// The kubeconfig kept in the state points at another control plane node when the removed one is a control plane node.
+func CordonNodeStep(db models.DB, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		nodeName, cluster, err := removedKubernetesNodeName(state)
+		if err != nil {
+			return err
+		}
+		policy := getDrainPolicy(state)
+
+		kubeconfig, err := kubeconfigAvoiding(state, db, privateKeyPath, cluster, nodeName)
+		if err == nil {
+			state["kubeconfig"] = kubeconfig
+			err = cordonNode(ctx, kubeconfig, nodeName)
+		}
+		if err != nil {
+			if policy.Force {
+				logger.GetLogger().Warn().Err(err).Str("node", nodeName).Msg("Failed to cordon node, removing it anyway")
+				return nil
+			}
+			return fmt.Errorf("failed to cordon node %s: %w", nodeName, err)
+		}
+		return nil
+	}
+}
+
+func cordonNode(ctx context.Context, kubeconfig, nodeName string) error {
+	clientset, err := newClientset(kubeconfig)
+	if err != nil {
+		return err
+	}
+	return setNodeSchedulable(ctx, clientset, nodeName, false)
+}
+
+// DrainNodeStep evicts the pods of the node being removed, honoring their PodDisruptionBudgets.
+// When the drain doesn't finish within the timeout of the drain policy the node is uncordoned and
+// the removal fails, unless the policy forces it.
+func DrainNodeStep() ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		nodeName, _, err := removedKubernetesNodeName(state)
+		if err != nil {
+			return err
+		}
+		policy := getDrainPolicy(state)
+
+		kubeconfig, ok := state["kubeconfig"].(string)
+		if !ok || kubeconfig == "" {
+			if policy.Force {
+				return nil
+			}
+			return fmt.Errorf("kubeconfig not found in workflow state")
+		}
+
+		clientset, err := newClientset(kubeconfig)
+		if err != nil {
+			return err
+		}
+		return drainNodeForRemoval(ctx, clientset, nodeName, policy)
+	}
+}
+
+// DeleteKubernetesNodeStep deletes the Node object of the removed node, which would otherwise stay NotReady.
+// Its contract is already cancelled at this point, so failing to reach the cluster doesn't fail the removal.
+func DeleteKubernetesNodeStep() ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		nodeName, _, err := removedKubernetesNodeName(state)
+		if err != nil {
+			return err
+		}
+
+		kubeconfig, ok := state["kubeconfig"].(string)
+		if !ok || kubeconfig == "" {
+			logger.GetLogger().Warn().Str("node", nodeName).Msg("No kubeconfig in state, the Node object of the removed node is left in the cluster")
+			return nil
+		}
+
+		clientset, err := newClientset(kubeconfig)
+		if err != nil {
+			logger.GetLogger().Warn().Err(err).Str("node", nodeName).Msg("Failed to delete the Node object of the removed node")
+			return nil
+		}
+		deleteKubernetesNode(ctx, clientset, nodeName)
+		return nil
+	}
+}
+
+// drainNodeForRemoval cordons a node and evicts its pods before the node is removed.
+// The pods of a node that is not Ready can't terminate, they are rescheduled once its Node object is deleted.
+// When the drain fails the node is uncordoned and the removal fails, unless the drain policy forces it.
+func drainNodeForRemoval(ctx context.Context, clientset kubernetes.Interface, nodeName string, policy DrainPolicy) error {
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err == nil {
+		err = setNodeSchedulable(ctx, clientset, nodeName, false)
+	}
+	if err != nil {
+		if policy.Force {
+			logger.GetLogger().Warn().Err(err).Str("node", nodeName).Msg("Failed to cordon node, removing it anyway")
+			return nil
+		}
+		return fmt.Errorf("failed to cordon node %s: %w", nodeName, err)
+	}
+
+	if !isNodeReady(node) {
+		logger.GetLogger().Info().Str("node", nodeName).Msg("Node is not ready, removing it without draining")
+		return nil
+	}
+
+	if err := drainNode(ctx, clientset, nodeName, policy.Timeout); err != nil {
+		if policy.Force {
+			logger.GetLogger().Warn().Err(err).Str("node", nodeName).Msg("Failed to drain node, removing it anyway")
+			return nil
+		}
+		if uncordonErr := setNodeSchedulable(ctx, clientset, nodeName, true); uncordonErr != nil {
+			logger.GetLogger().Error().Err(uncordonErr).Str("node", nodeName).Msg("Failed to uncordon node after failed drain")
+		}
+		return fmt.Errorf("failed to drain node %s: %w: %w", nodeName, err, ewf.ErrFailWorkflowNow)
+	}
+	return nil
+}
+
+// deleteKubernetesNode deletes the Node object of a removed node, failures are only logged since the node is already gone
+func deleteKubernetesNode(ctx context.Context, clientset kubernetes.Interface, nodeName string) {
+	err := clientset.CoreV1().Nodes().Delete(ctx, nodeName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.GetLogger().Warn().Err(err).Str("node", nodeName).Msg("Failed to delete the Node object of the removed node")
+	}
+}
+
+// drainAndRemoveClusterNode drains a node through clientset, cancels its contracts and deletes its Node object.
+// A nil clientset removes the node without draining it, which only a forced drain policy allows.
+func drainAndRemoveClusterNode(ctx context.Context, db models.DB, kubeClient *kubedeployer.Client, clientset kubernetes.Interface, cluster *kubedeployer.Cluster, nodeName string, policy DrainPolicy) error {
+	if clientset == nil && !policy.Force {
+		return fmt.Errorf("no access to the cluster to drain node %s", nodeName)
+	}
+	if clientset != nil {
+		if err := drainNodeForRemoval(ctx, clientset, nodeName, policy); err != nil {
+			return err
+		}
+	}
+
+	if err := removeClusterNode(ctx, db, kubeClient, cluster, nodeName); err != nil {
+		return err
+	}
+
+	if clientset != nil {
+		deleteKubernetesNode(ctx, clientset, nodeName)
+	}
+	return nil
+}
+
+// removalClientset returns a client reaching the cluster through a control plane node other than the removed one
+func removalClientset(state ewf.State, db models.DB, privateKeyPath string, cluster kubedeployer.Cluster, nodeName string) (kubernetes.Interface, error) {
+	kubeconfig, err := kubeconfigAvoiding(state, db, privateKeyPath, cluster, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := newClientset(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return clientset, nil
+}
@@ -80,7 +80,7 @@ func CreateDeploymentWorkflowNotification(ctx context.Context, wf *ewf.Workflow,
 		return &models.Notification{}
 	}
 
-	workflowDesc := getWorkflowDescription(wf.Name)
+	workflowDesc := GetWorkflowDescription(wf.Name)
 	var notificationPayload map[string]string
 
 	if err != nil {
@@ -113,7 +113,7 @@ func CreateDeploymentWorkflowNotification(ctx context.Context, wf *ewf.Workflow,
 			Subject: fmt.Sprintf("%s completed successfully", workflowDesc),
 			Status:  "succeeded",
 		}, map[string]string{
-			"workflow_name": getWorkflowDescription(wf.Name),
+			"workflow_name": GetWorkflowDescription(wf.Name),
 			"cluster_name":  cluster.Name,
 			"timestamp":     time.Now().Local().Format(TimestampFormat),
 		})
@@ -260,8 +260,8 @@ func calculateCurrentStep(stepName string) int {
 	return 0
 }
 
-// getWorkflowDescription returns a user-friendly description for the workflow
-func getWorkflowDescription(workflowName string) string {
+// GetWorkflowDescription returns a user-friendly description for the workflow
+func GetWorkflowDescription(workflowName string) string {
 	if desc, exists := workflowsDescriptions[workflowName]; exists {
 		return desc
 	}
@@ -271,6 +271,16 @@ func getWorkflowDescription(workflowName string) string {
 		return "Deploying Cluster"
 	}
 
+	// Handle update-cluster-X-operations workflows
+	if isUpdateWorkflow(workflowName) {
+		return "Updating Cluster"
+	}
+
+	// Handle upgrade-cluster-X-nodes workflows
+	if isUpgradeWorkflow(workflowName) {
+		return "Upgrading Cluster"
+	}
+
 	// Fallback to workflow name
 	return workflowName
 }
@@ -279,6 +289,24 @@ func isDeployWorkflow(name string) bool {
 	return strings.HasPrefix(name, "deploy-") && strings.HasSuffix(name, "-nodes")
 }
 
+// GetUpdateWorkflowName returns the name of the dynamic update workflow applying the given number of operations
+func GetUpdateWorkflowName(operationsNum int) string {
+	return fmt.Sprintf("%s-%d-operations", constants.WorkflowUpdateCluster, operationsNum)
+}
+
+func isUpdateWorkflow(name string) bool {
+	return strings.HasPrefix(name, constants.WorkflowUpdateCluster+"-") && strings.HasSuffix(name, "-operations")
+}
+
+// GetUpgradeWorkflowName returns the name of the dynamic upgrade workflow going through the given number of nodes
+func GetUpgradeWorkflowName(nodesNum int) string {
+	return fmt.Sprintf("%s-%d-nodes", constants.WorkflowUpgradeCluster, nodesNum)
+}
+
+func isUpgradeWorkflow(name string) bool {
+	return strings.HasPrefix(name, constants.WorkflowUpgradeCluster+"-") && strings.HasSuffix(name, "-nodes")
+}
+
 func isDeployStep(stepName string) bool {
 	return strings.HasPrefix(stepName, "deploy-") && strings.HasSuffix(stepName, "-node")
 }
@@ -319,13 +347,13 @@ func CreateBillingWorkflowNotifications(ctx context.Context, wf *ewf.Workflow, e
 			payloadData.Message = fmt.Sprintf("Money transfer to user %d's account failed", userID)
 			payloadData.Subject = "Money transfer to user's account failed"
 			adminNotif := models.NewNotification(adminID, models.NotificationTypeBilling, notification.MergePayload(payloadData, map[string]string{
-				"workflow_name": getWorkflowDescription(wf.Name),
+				"workflow_name": GetWorkflowDescription(wf.Name),
 				"timestamp":     time.Now().Local().Format(TimestampFormat),
 			}), models.WithSeverity(severity), models.WithChannels(notification.ChannelUI))
 			return []*models.Notification{adminNotif}
 		}
 		adminNotif := models.NewNotification(adminID, models.NotificationTypeBilling, notification.MergePayload(payloadData, map[string]string{
-			"workflow_name": getWorkflowDescription(wf.Name),
+			"workflow_name": GetWorkflowDescription(wf.Name),
 			"timestamp":     time.Now().Local().Format(TimestampFormat),
 		}), models.WithSeverity(severity), models.WithChannels(notification.ChannelUI))
 		// also notify the user about success
@@ -334,7 +362,7 @@ func CreateBillingWorkflowNotifications(ctx context.Context, wf *ewf.Workflow, e
 			Subject: "Your Account Has Been Credited",
 			Status:  "succeeded",
 		}, map[string]string{
-			"workflow_name": getWorkflowDescription(wf.Name),
+			"workflow_name": GetWorkflowDescription(wf.Name),
 			"timestamp":     time.Now().Local().Format(TimestampFormat),
 			"amount":        fmt.Sprintf("%.2f", amountUSD),
 		})
@@ -372,7 +400,7 @@ func CreateBillingWorkflowNotifications(ctx context.Context, wf *ewf.Workflow, e
 		}
 	}
 	payloadData := map[string]string{
-		"workflow_name": getWorkflowDescription(wf.Name),
+		"workflow_name": GetWorkflowDescription(wf.Name),
 		"timestamp":     time.Now().Local().Format(TimestampFormat),
 	}
 	if amountUSD > 0 {
@@ -445,7 +473,7 @@ func CreateNodeWorkflowNotification(ctx context.Context, wf *ewf.Workflow, err e
 
 	// Build payload data
 	payloadData := map[string]string{
-		"workflow_name": getWorkflowDescription(wf.Name),
+		"workflow_name": GetWorkflowDescription(wf.Name),
 		"timestamp":     time.Now().Local().Format(TimestampFormat),
 	}
 	if nodeID > 0 {
@@ -506,7 +534,7 @@ func CreateUserWorkflowNotification(ctx context.Context, wf *ewf.Workflow, err e
 	}
 
 	payloadData := map[string]string{
-		"workflow_name": getWorkflowDescription(wf.Name),
+		"workflow_name": GetWorkflowDescription(wf.Name),
 		"timestamp":     time.Now().Local().Format(TimestampFormat),
 	}
 
@@ -0,0 +1,200 @@
+package activities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"kubecloud/internal/logger"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/xmonader/ewf"
+	"gorm.io/gorm"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	nodePoolLabel = "kubecloud.io/node-pool"
+	// the labels and taints last applied from the pool spec, so the ones removed from the spec get removed from the node
+	poolLabelsAnnotation = "kubecloud.io/pool-labels"
+	poolTaintsAnnotation = "kubecloud.io/pool-taints"
+)
+
+// PoolWorkflowStartTimeout is how long a workflow that was saved but never started keeps the node pools claim of its cluster,
+// the server that created it stopped before running it
+const PoolWorkflowStartTimeout = time.Minute
+
+// PoolsClaimRunning reports whether the workflow holding a node pools claim still runs.
+// A claim whose workflow ended or was never started by its server is stale and can be taken over.
+func PoolsClaimRunning(ctx context.Context, store ewf.Store, holder string) (bool, error) {
+	if holder == "" {
+		return false, nil
+	}
+
+	wf, err := store.LoadWorkflowByUUID(ctx, holder)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return wf.Status == ewf.StatusRunning || (wf.Status == ewf.StatusPending && time.Since(wf.CreatedAt) < PoolWorkflowStartTimeout), nil
+}
+
+// releasePoolsHook clears the node pools claim a workflow holds on its cluster once it ended
+func releasePoolsHook(db models.DB) ewf.AfterWorkflowHook {
+	return func(ctx context.Context, wf *ewf.Workflow, err error) {
+		if releaseErr := db.ReleaseClusterPools(wf.UUID); releaseErr != nil {
+			logger.GetLogger().Error().Err(releaseErr).Str("workflow_id", wf.UUID).Msg("Failed to release node pools claim")
+		}
+	}
+}
+
+// ApplyNodePoolsStep sets the labels and taints of their pool on the pool nodes of the cluster
+func ApplyNodePoolsStep() ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster: %w", err)
+		}
+		if len(cluster.Pools) == 0 {
+			return nil
+		}
+
+		kubeconfig, ok := state["kubeconfig"].(string)
+		if !ok || kubeconfig == "" {
+			return fmt.Errorf("kubeconfig not found in workflow state")
+		}
+
+		clientset, err := newClientset(kubeconfig)
+		if err != nil {
+			return err
+		}
+
+		for _, node := range cluster.Nodes {
+			if node.Pool == "" {
+				continue
+			}
+			pool, found := cluster.Pool(node.Pool)
+			if !found {
+				continue
+			}
+			if err := applyNodePool(ctx, clientset, node.Name, pool); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// applyNodePool updates the labels and taints of a node to match its pool
+func applyNodePool(ctx context.Context, clientset *kubernetes.Clientset, nodeName string, pool kubedeployer.NodePool) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if err := setPoolLabels(node, pool); err != nil {
+			return err
+		}
+		if err := setPoolTaints(node, pool); err != nil {
+			return err
+		}
+
+		_, err = clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("node %s of pool %s is not registered in the cluster", nodeName, pool.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply pool %s to node %s: %w", pool.Name, nodeName, err)
+	}
+	return nil
+}
+
+func setPoolLabels(node *v1.Node, pool kubedeployer.NodePool) error {
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+
+	var applied []string
+	if value, ok := node.Annotations[poolLabelsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &applied); err != nil {
+			return fmt.Errorf("invalid %s annotation of node %s: %w", poolLabelsAnnotation, node.Name, err)
+		}
+	}
+	for _, key := range applied {
+		if _, kept := pool.Labels[key]; !kept {
+			delete(node.Labels, key)
+		}
+	}
+
+	keys := make([]string, 0, len(pool.Labels))
+	for key, value := range pool.Labels {
+		node.Labels[key] = value
+		keys = append(keys, key)
+	}
+	node.Labels[nodePoolLabel] = pool.Name
+	slices.Sort(keys)
+
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	node.Annotations[poolLabelsAnnotation] = string(data)
+	return nil
+}
+
+func setPoolTaints(node *v1.Node, pool kubedeployer.NodePool) error {
+	var applied []kubedeployer.Taint
+	if value, ok := node.Annotations[poolTaintsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &applied); err != nil {
+			return fmt.Errorf("invalid %s annotation of node %s: %w", poolTaintsAnnotation, node.Name, err)
+		}
+	}
+
+	// drop the taints set from the pool before, taints set by Kubernetes or users are kept
+	taints := make([]v1.Taint, 0, len(node.Spec.Taints)+len(pool.Taints))
+	for _, taint := range node.Spec.Taints {
+		fromPool := slices.ContainsFunc(applied, func(t kubedeployer.Taint) bool {
+			return t.Key == taint.Key && t.Effect == string(taint.Effect)
+		})
+		if !fromPool {
+			taints = append(taints, taint)
+		}
+	}
+
+	for _, taint := range pool.Taints {
+		idx := slices.IndexFunc(taints, func(t v1.Taint) bool {
+			return t.Key == taint.Key && string(t.Effect) == taint.Effect
+		})
+		poolTaint := v1.Taint{Key: taint.Key, Value: taint.Value, Effect: v1.TaintEffect(taint.Effect)}
+		if idx == -1 {
+			taints = append(taints, poolTaint)
+		} else {
+			taints[idx] = poolTaint
+		}
+	}
+	node.Spec.Taints = taints
+
+	data, err := json.Marshal(pool.Taints)
+	if err != nil {
+		return err
+	}
+	node.Annotations[poolTaintsAnnotation] = string(data)
+	return nil
+}
@@ -0,0 +1,186 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/metrics"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+
+	"github.com/xmonader/ewf"
+)
+
+// MaxRetryWindow is the longest the contracts of a failed workflow are kept for it to be retried
+const MaxRetryWindow = 24 * time.Hour
+
+// ErrNodeNotRetryable is returned when the node of a workflow is changed while its failed step doesn't deploy a node
+var ErrNodeNotRetryable = errors.New("the node can only be changed when the deployment of a node failed")
+
+// keepForRetry keeps the contracts of a failed workflow started with a retry window, so it can be retried from its
+// failed step until its rollback is due. A cancelled workflow is rolled back right away.
+func keepForRetry(ctx context.Context, engine *ewf.Engine, wf *ewf.Workflow) bool {
+	window, err := getFromState[time.Duration](wf.State, "retry_window")
+	if err != nil || window <= 0 || ctx.Err() != nil {
+		return false
+	}
+
+	// the kubeclient isn't persisted, the deployments it tracks are needed to retry or roll back
+	if kubeClient, ok := wf.State["kubeclient"].(*kubedeployer.Client); ok {
+		statemanager.SaveGridClientState(wf.State, kubeClient)
+	}
+
+	rollbackAt := time.Now().Add(window).UTC()
+	wf.State["rollback_at"] = rollbackAt
+	if err := engine.Store().SaveWorkflow(context.WithoutCancel(ctx), wf); err != nil {
+		logger.GetLogger().Error().Err(err).Str("workflow_id", wf.UUID).Msg("Failed to keep failed workflow for retry, rolling it back")
+		delete(wf.State, "rollback_at")
+		return false
+	}
+
+	logger.GetLogger().Info().Str("workflow_id", wf.UUID).Str("workflow_name", wf.Name).Time("rollback_at", rollbackAt).Msg("Kept failed workflow for retry")
+	return true
+}
+
+// PendingRollback returns when the contracts of a failed workflow kept for retry are rolled back
+func PendingRollback(wf *ewf.Workflow) (time.Time, bool) {
+	rollbackAt, err := getFromState[time.Time](wf.State, "rollback_at")
+	return rollbackAt, err == nil
+}
+
+// RollbackFailedWorkflow cancels the contracts a failed deploy or add-node workflow kept for retry created.
+// The caller claims the rollback first so that the workflow isn't retried meanwhile.
+func RollbackFailedWorkflow(engine *ewf.Engine, metrics *metrics.Metrics, wf *ewf.Workflow) error {
+	delete(wf.State, "rollback_at")
+
+	var err error
+	switch {
+	case isDeployWorkflow(wf.Name):
+		err = rollbackFailedDeployment(engine, metrics, wf)
+	case wf.Name == constants.WorkflowAddNode:
+		err = rollbackFailedAddNode(engine, metrics, wf)
+	default:
+		return fmt.Errorf("workflow %s has no rollback", wf.Name)
+	}
+	if err != nil {
+		return err
+	}
+	return engine.Store().SaveWorkflow(context.Background(), wf)
+}
+
+// RetryNode returns the node whose deployment failed, the one a retry can move to another grid node
+func RetryNode(wf *ewf.Workflow) (kubedeployer.Node, error) {
+	if wf.CurrentStep >= len(wf.Steps) {
+		return kubedeployer.Node{}, ErrNodeNotRetryable
+	}
+	failedStep := wf.Steps[wf.CurrentStep].Name
+
+	switch {
+	case isDeployWorkflow(wf.Name) && strings.HasPrefix(failedStep, "deploy-") && strings.HasSuffix(failedStep, "-node"):
+		cluster, err := statemanager.GetCluster(wf.State)
+		if err != nil {
+			return kubedeployer.Node{}, err
+		}
+		nodeIdx, _ := getFromState[int](wf.State, "node_index")
+		if nodeIdx < 0 || nodeIdx >= len(cluster.Nodes) {
+			return kubedeployer.Node{}, ErrNodeNotRetryable
+		}
+		return cluster.Nodes[nodeIdx], nil
+
+	case wf.Name == constants.WorkflowAddNode && (failedStep == constants.StepUpdateNetwork || failedStep == constants.StepAddNode):
+		return getFromState[kubedeployer.Node](wf.State, "node")
+
+	default:
+		return kubedeployer.Node{}, ErrNodeNotRetryable
+	}
+}
+
+// SetRetryNodeID places the node whose deployment failed on another grid node before its workflow is retried.
+// The network of the cluster is redeployed with the new node when the failed step runs again.
+func SetRetryNodeID(wf *ewf.Workflow, nodeID uint32) error {
+	if wf.CurrentStep >= len(wf.Steps) {
+		return ErrNodeNotRetryable
+	}
+	failedStep := wf.Steps[wf.CurrentStep].Name
+
+	cluster, err := statemanager.GetCluster(wf.State)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case isDeployWorkflow(wf.Name) && strings.HasPrefix(failedStep, "deploy-") && strings.HasSuffix(failedStep, "-node"):
+		nodeIdx, _ := getFromState[int](wf.State, "node_index")
+		if nodeIdx < 0 || nodeIdx >= len(cluster.Nodes) {
+			return ErrNodeNotRetryable
+		}
+		if err := checkNodeIDAvailable(cluster, cluster.Nodes[nodeIdx].Name, nodeID); err != nil {
+			return err
+		}
+		cluster.Nodes[nodeIdx].NodeID = nodeID
+		wf.State["network_outdated"] = true
+
+	case wf.Name == constants.WorkflowAddNode && failedStep == constants.StepUpdateNetwork:
+		// the network is deployed with the node when the step runs again
+		node, err := getFromState[kubedeployer.Node](wf.State, "node")
+		if err != nil {
+			return err
+		}
+		if err := checkNodeIDAvailable(cluster, node.Name, nodeID); err != nil {
+			return err
+		}
+		node.NodeID = nodeID
+		wf.State["node"] = node
+
+	case wf.Name == constants.WorkflowAddNode && failedStep == constants.StepAddNode:
+		node, err := getFromState[kubedeployer.Node](wf.State, "node")
+		if err != nil {
+			return err
+		}
+		if err := checkNodeIDAvailable(cluster, node.Name, nodeID); err != nil {
+			return err
+		}
+		node.NodeID = nodeID
+		wf.State["node"] = node
+		for i := range cluster.Nodes {
+			if cluster.Nodes[i].Name == node.Name {
+				cluster.Nodes[i].NodeID = nodeID
+			}
+		}
+		wf.State["network_outdated"] = true
+
+	default:
+		return ErrNodeNotRetryable
+	}
+
+	statemanager.StoreCluster(wf.State, cluster)
+	return nil
+}
+
+// checkNodeIDAvailable fails when another node of the cluster is already deployed on the grid node
+func checkNodeIDAvailable(cluster kubedeployer.Cluster, nodeName string, nodeID uint32) error {
+	for _, node := range cluster.Nodes {
+		if node.NodeID == nodeID && node.Name != nodeName {
+			return fmt.Errorf("node id %d is already assigned to this cluster", nodeID)
+		}
+	}
+	return nil
+}
+
+// redeployOutdatedNetwork redeploys the network of the cluster when a retry moved the node being deployed to another grid node
+func redeployOutdatedNetwork(ctx context.Context, state ewf.State, kubeClient *kubedeployer.Client, cluster *kubedeployer.Cluster) error {
+	if outdated, _ := state["network_outdated"].(bool); !outdated {
+		return nil
+	}
+	if err := kubeClient.DeployNetwork(ctx, cluster); err != nil {
+		return fmt.Errorf("failed to update network: %w", err)
+	}
+	delete(state, "network_outdated")
+	statemanager.SaveGridClientState(state, kubeClient)
+	return nil
+}
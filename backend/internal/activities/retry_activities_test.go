@@ -0,0 +1,199 @@
+package activities
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kubecloud/internal/constants"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmonader/ewf"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestWorkflowStore(t *testing.T) *models.EWFGormStore {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "ewf.db")), &gorm.Config{})
+	require.NoError(t, err)
+
+	store := models.NewGormStore(db)
+	require.NoError(t, store.Setup())
+	return store
+}
+
+// newFailedWorkflow returns a workflow failed at failedStep as it is loaded from the store to be retried
+func newFailedWorkflow(t *testing.T, store *models.EWFGormStore, name, failedStep string, state ewf.State) *ewf.Workflow {
+	wf := ewf.NewWorkflow(name)
+	wf.Steps = []ewf.Step{{Name: "reserve"}, {Name: failedStep}}
+	wf.CurrentStep = 1
+	wf.Status = ewf.StatusFailed
+	wf.State = state
+	require.NoError(t, store.SaveWorkflow(context.Background(), wf))
+
+	loaded, err := store.LoadWorkflowByUUID(context.Background(), wf.UUID)
+	require.NoError(t, err)
+	return loaded
+}
+
+func TestSetRetryNodeID(t *testing.T) {
+	store := newTestWorkflowStore(t)
+	newCluster := func() ewf.State {
+		state := ewf.State{}
+		statemanager.StoreCluster(state, kubedeployer.Cluster{
+			Name: "cluster",
+			Nodes: []kubedeployer.Node{
+				{Name: "leader", Type: kubedeployer.NodeTypeLeader, NodeID: 11},
+				{Name: "worker", Type: kubedeployer.NodeTypeWorker, NodeID: 12},
+			},
+		})
+		return state
+	}
+	nodeIDs := func(wf *ewf.Workflow) []uint32 {
+		cluster, err := statemanager.GetCluster(wf.State)
+		require.NoError(t, err)
+		ids := make([]uint32, 0, len(cluster.Nodes))
+		for _, node := range cluster.Nodes {
+			ids = append(ids, node.NodeID)
+		}
+		return ids
+	}
+
+	t.Run("deploy failed deploying a node", func(t *testing.T) {
+		state := newCluster()
+		state["node_index"] = 1
+		wf := newFailedWorkflow(t, store, "deploy-2-nodes", getDeployNodeStepName(1), state)
+		require.IsType(t, float64(0), wf.State["node_index"], "the index is decoded from JSON")
+
+		require.NoError(t, SetRetryNodeID(wf, 20))
+		require.Equal(t, []uint32{11, 20}, nodeIDs(wf))
+		require.Equal(t, true, wf.State["network_outdated"])
+	})
+
+	t.Run("add node failed updating the network", func(t *testing.T) {
+		state := newCluster()
+		state["node"] = kubedeployer.Node{Name: "new", Type: kubedeployer.NodeTypeWorker, NodeID: 13}
+		wf := newFailedWorkflow(t, store, constants.WorkflowAddNode, constants.StepUpdateNetwork, state)
+
+		require.NoError(t, SetRetryNodeID(wf, 20))
+		node, err := getFromState[kubedeployer.Node](wf.State, "node")
+		require.NoError(t, err)
+		require.Equal(t, uint32(20), node.NodeID)
+		require.Equal(t, []uint32{11, 12}, nodeIDs(wf))
+		require.NotContains(t, wf.State, "network_outdated", "the failed step deploys the network")
+	})
+
+	t.Run("add node failed deploying the node", func(t *testing.T) {
+		state := newCluster()
+		cluster, err := statemanager.GetCluster(state)
+		require.NoError(t, err)
+		newNode := kubedeployer.Node{Name: "new", Type: kubedeployer.NodeTypeWorker, NodeID: 13}
+		cluster.Nodes = append(cluster.Nodes, newNode)
+		statemanager.StoreCluster(state, cluster)
+		state["node"] = newNode
+		wf := newFailedWorkflow(t, store, constants.WorkflowAddNode, constants.StepAddNode, state)
+
+		require.NoError(t, SetRetryNodeID(wf, 20))
+		node, err := getFromState[kubedeployer.Node](wf.State, "node")
+		require.NoError(t, err)
+		require.Equal(t, uint32(20), node.NodeID)
+		require.Equal(t, []uint32{11, 12, 20}, nodeIDs(wf))
+		require.Equal(t, true, wf.State["network_outdated"])
+	})
+
+	t.Run("other failed steps keep their node", func(t *testing.T) {
+		wf := newFailedWorkflow(t, store, "deploy-2-nodes", constants.StepStoreDeployment, newCluster())
+		require.ErrorIs(t, SetRetryNodeID(wf, 20), ErrNodeNotRetryable)
+
+		state := newCluster()
+		state["node"] = kubedeployer.Node{Name: "new", Type: kubedeployer.NodeTypeWorker, NodeID: 13}
+		wf = newFailedWorkflow(t, store, constants.WorkflowAddNode, constants.StepStoreDeployment, state)
+		require.ErrorIs(t, SetRetryNodeID(wf, 20), ErrNodeNotRetryable)
+	})
+
+	t.Run("grid node used by another node of the cluster", func(t *testing.T) {
+		state := newCluster()
+		state["node_index"] = 1
+		wf := newFailedWorkflow(t, store, "deploy-2-nodes", getDeployNodeStepName(1), state)
+
+		require.ErrorContains(t, SetRetryNodeID(wf, 11), "already assigned")
+		require.Equal(t, []uint32{11, 12}, nodeIDs(wf))
+		require.NotContains(t, wf.State, "network_outdated")
+	})
+}
+
+func TestRetryNode(t *testing.T) {
+	store := newTestWorkflowStore(t)
+	state := ewf.State{"node_index": 1}
+	statemanager.StoreCluster(state, kubedeployer.Cluster{
+		Name: "cluster",
+		Nodes: []kubedeployer.Node{
+			{Name: "leader", Type: kubedeployer.NodeTypeLeader, NodeID: 11},
+			{Name: "worker", Type: kubedeployer.NodeTypeWorker, NodeID: 12, CPU: 2},
+		},
+	})
+
+	wf := newFailedWorkflow(t, store, "deploy-2-nodes", getDeployNodeStepName(1), state)
+	node, err := RetryNode(wf)
+	require.NoError(t, err)
+	require.Equal(t, "worker", node.Name)
+	require.Equal(t, uint8(2), node.CPU)
+
+	state = ewf.State{"node": kubedeployer.Node{Name: "new", Type: kubedeployer.NodeTypeWorker, NodeID: 13}}
+	wf = newFailedWorkflow(t, store, constants.WorkflowAddNode, constants.StepAddNode, state)
+	node, err = RetryNode(wf)
+	require.NoError(t, err)
+	require.Equal(t, "new", node.Name)
+
+	wf = newFailedWorkflow(t, store, constants.WorkflowAddNode, constants.StepStoreDeployment, state)
+	_, err = RetryNode(wf)
+	require.ErrorIs(t, err, ErrNodeNotRetryable)
+}
+
+func TestKeepForRetry(t *testing.T) {
+	store := newTestWorkflowStore(t)
+	engine, err := ewf.NewEngine(store)
+	require.NoError(t, err)
+
+	newWorkflow := func(state ewf.State) *ewf.Workflow {
+		wf := ewf.NewWorkflow("deploy-1-nodes")
+		wf.Status = ewf.StatusFailed
+		wf.State = state
+		return wf
+	}
+
+	t.Run("kept until its rollback is due", func(t *testing.T) {
+		wf := newWorkflow(ewf.State{"retry_window": time.Hour})
+		require.True(t, keepForRetry(context.Background(), engine, wf))
+
+		loaded, err := store.LoadWorkflowByUUID(context.Background(), wf.UUID)
+		require.NoError(t, err)
+		rollbackAt, ok := PendingRollback(loaded)
+		require.True(t, ok)
+		require.WithinDuration(t, time.Now().Add(time.Hour), rollbackAt, time.Minute)
+	})
+
+	t.Run("cancelled workflows are rolled back right away", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		wf := newWorkflow(ewf.State{"retry_window": time.Hour})
+		require.False(t, keepForRetry(ctx, engine, wf))
+		_, ok := PendingRollback(wf)
+		require.False(t, ok)
+
+		_, err := store.LoadWorkflowByUUID(context.Background(), wf.UUID)
+		require.Error(t, err, "the workflow isn't saved for retry")
+	})
+
+	t.Run("workflows without a retry window are rolled back right away", func(t *testing.T) {
+		wf := newWorkflow(ewf.State{})
+		require.False(t, keepForRetry(context.Background(), engine, wf))
+		_, ok := PendingRollback(wf)
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,293 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"kubecloud/internal"
+	"kubecloud/internal/backup"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/notification"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/xmonader/ewf"
+)
+
+// SnapshotStorageKey returns the key a snapshot of a cluster is stored under
+func SnapshotStorageKey(userID int, projectName, snapshotName string) string {
+	return fmt.Sprintf("%d/%s/%s.db", userID, projectName, snapshotName)
+}
+
+// TakeSnapshotStep takes a datastore snapshot from the control plane, leader first, and uploads it to the backup store
+func TakeSnapshotStep(db models.DB, store backup.Store, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		if _, done := state["snapshot_id"]; done {
+			return nil
+		}
+
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+		projectName := kubedeployer.GetProjectName(config.UserID, cluster.Name)
+		scheduled, _ := state["scheduled"].(bool)
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		file, err := os.CreateTemp("", "snapshot-*.db")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary snapshot file: %w", err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		name := fmt.Sprintf("%s-%s", cluster.Name, time.Now().UTC().Format("20060102-150405"))
+
+		var snapshotErr error
+		for _, node := range cluster.GetControlPlaneNodes() {
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+
+			snapshotErr = internal.SaveDatastoreSnapshot(string(privateKeyBytes), &node, name, file)
+			if snapshotErr == nil {
+				break
+			}
+			logger.GetLogger().Warn().Err(snapshotErr).Str("node", node.Name).Msg("Failed to take snapshot from control plane node, trying next")
+		}
+		if snapshotErr != nil {
+			return fmt.Errorf("failed to take snapshot of cluster %s: %w", cluster.Name, snapshotErr)
+		}
+
+		size, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return fmt.Errorf("snapshot of cluster %s is empty", cluster.Name)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		key := SnapshotStorageKey(config.UserID, projectName, name)
+		if err := store.Put(ctx, key, file, size); err != nil {
+			return fmt.Errorf("failed to upload snapshot: %w", err)
+		}
+
+		snapshot := models.ClusterSnapshot{
+			UserID:      config.UserID,
+			ProjectName: projectName,
+			Name:        name,
+			StorageKey:  key,
+			Size:        size,
+			Scheduled:   scheduled,
+		}
+		if err := db.CreateClusterSnapshot(&snapshot); err != nil {
+			_ = store.Delete(ctx, key)
+			return fmt.Errorf("failed to save snapshot record: %w", err)
+		}
+		state["snapshot_id"] = snapshot.ID
+
+		logger.GetLogger().Info().Str("cluster", projectName).Str("snapshot", name).Int64("size", size).Msg("Cluster snapshot taken")
+
+		if scheduled {
+			pruneScheduledSnapshots(ctx, db, store, config.UserID, projectName)
+		}
+		return nil
+	}
+}
+
+// pruneScheduledSnapshots removes the oldest scheduled snapshots beyond the schedule retention, manual snapshots are kept
+func pruneScheduledSnapshots(ctx context.Context, db models.DB, store backup.Store, userID int, projectName string) {
+	schedule, err := db.GetSnapshotSchedule(userID, projectName)
+	if err != nil || schedule.Retention <= 0 {
+		return
+	}
+
+	snapshots, err := db.ListClusterSnapshots(userID, projectName)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Str("cluster", projectName).Msg("Failed to list snapshots for pruning")
+		return
+	}
+
+	kept := 0
+	for _, snapshot := range snapshots {
+		if !snapshot.Scheduled {
+			continue
+		}
+		kept++
+		if kept <= schedule.Retention {
+			continue
+		}
+
+		if err := store.Delete(ctx, snapshot.StorageKey); err != nil {
+			logger.GetLogger().Error().Err(err).Str("snapshot", snapshot.Name).Msg("Failed to delete expired snapshot from store")
+			continue
+		}
+		if err := db.DeleteClusterSnapshot(snapshot.ID); err != nil {
+			logger.GetLogger().Error().Err(err).Str("snapshot", snapshot.Name).Msg("Failed to delete expired snapshot record")
+		}
+	}
+}
+
+// StopControlPlaneStep stops k3s on every control plane node before the datastore is restored
+func StopControlPlaneStep(privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		for _, node := range cluster.GetControlPlaneNodes() {
+			if err := internal.StopK3s(string(privateKeyBytes), &node); err != nil {
+				return fmt.Errorf("failed to stop k3s on node %s: %w", node.Name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// RestoreSnapshotStep resets the datastore of the leader to the snapshot in 'snapshot_id'
+func RestoreSnapshotStep(db models.DB, store backup.Store, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		if restored, _ := state["snapshot_restored"].(bool); restored {
+			return nil
+		}
+
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		snapshotID, err := getFromState[int](state, "snapshot_id")
+		if err != nil {
+			return fmt.Errorf("%w: %w", err, ewf.ErrFailWorkflowNow)
+		}
+
+		snapshot, err := db.GetClusterSnapshot(config.UserID, kubedeployer.GetProjectName(config.UserID, cluster.Name), snapshotID)
+		if err != nil {
+			return fmt.Errorf("failed to get snapshot %d: %w", snapshotID, err)
+		}
+
+		leader, err := cluster.GetLeaderNode()
+		if err != nil {
+			return fmt.Errorf("failed to get leader node: %w", ewf.ErrFailWorkflowNow)
+		}
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		reader, _, err := store.Get(ctx, snapshot.StorageKey)
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot %s from store: %w", snapshot.Name, err)
+		}
+		defer reader.Close()
+
+		if err := internal.RestoreDatastoreSnapshot(string(privateKeyBytes), &leader, cluster.Token, reader); err != nil {
+			return err
+		}
+
+		logger.GetLogger().Info().Str("cluster", cluster.Name).Str("snapshot", snapshot.Name).Str("leader", leader.Name).Msg("Datastore restored on leader")
+		state["snapshot_restored"] = true
+		return nil
+	}
+}
+
+// RejoinControlPlaneStep wipes the etcd data of the other masters so they join the restored leader as new members
+func RejoinControlPlaneStep(privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		privateKeyBytes, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH private key: %w", err)
+		}
+
+		for _, node := range cluster.Nodes {
+			if node.Type != kubedeployer.NodeTypeMaster {
+				continue
+			}
+			if err := internal.ResetEtcdMember(string(privateKeyBytes), &node); err != nil {
+				return fmt.Errorf("failed to rejoin master %s: %w", node.Name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// snapshotNotifyHook notifies the user about manual snapshots, scheduled ones only when they fail
+func snapshotNotifyHook(notificationService *notification.NotificationService) ewf.AfterWorkflowHook {
+	return func(ctx context.Context, wf *ewf.Workflow, err error) {
+		if scheduled, _ := wf.State["scheduled"].(bool); scheduled && err == nil {
+			return
+		}
+
+		n := CreateDeploymentWorkflowNotification(ctx, wf, err)
+		if n == nil {
+			return
+		}
+		if err := notificationService.Send(ctx, n); err != nil {
+			logger.GetLogger().Error().Err(err).Str("workflow_name", wf.Name).Msg("Failed to send snapshot notification")
+		}
+	}
+}
+
+func registerSnapshotActivities(engine *ewf.Engine, db models.DB, store backup.Store, notificationService *notification.NotificationService, config internal.Configuration) {
+	registerStep(engine, constants.StepTakeSnapshot, TakeSnapshotStep(db, store, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepStopControlPlane, StopControlPlaneStep(config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepRestoreSnapshot, RestoreSnapshotStep(db, store, config.SSH.PrivateKeyPath))
+	registerStep(engine, constants.StepRejoinControlPlane, RejoinControlPlaneStep(config.SSH.PrivateKeyPath))
+
+	snapshotWFTemplate := ewf.WorkflowTemplate{
+		AfterWorkflowHooks: []ewf.AfterWorkflowHook{hookWorkflowDone, snapshotNotifyHook(notificationService)},
+		BeforeStepHooks:    []ewf.BeforeStepHook{hookStepStarted, recordStepStarted},
+		AfterStepHooks:     []ewf.AfterStepHook{hookStepDone, recordStepDone},
+	}
+	snapshotWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepTakeSnapshot, RetryPolicy: standardRetryPolicy},
+	}
+	engine.RegisterTemplate(constants.WorkflowSnapshotCluster, &snapshotWFTemplate)
+
+	restoreWFTemplate := newKubecloudWorkflowTemplate(notificationService)
+	restoreWFTemplate.Steps = []ewf.Step{
+		{Name: constants.StepStopControlPlane, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepRestoreSnapshot, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepRejoinControlPlane, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy},
+		{Name: constants.StepVerifyClusterReady, RetryPolicy: longExponentialRetryPolicy},
+	}
+	engine.RegisterTemplate(constants.WorkflowRestoreSnapshot, &restoreWFTemplate)
+}
@@ -0,0 +1,31 @@
+package activities
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/xmonader/ewf"
+)
+
+// getFromState reads a value from the state, converting it back if the state was persisted and reloaded as JSON
+func getFromState[T any](state ewf.State, key string) (T, error) {
+	var result T
+	value, ok := state[key]
+	if !ok {
+		return result, fmt.Errorf("missing '%s' in state", key)
+	}
+
+	if typed, ok := value.(T); ok {
+		return typed, nil
+	}
+
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return result, fmt.Errorf("failed to marshal '%s': %w", key, err)
+	}
+	if err := json.Unmarshal(valueBytes, &result); err != nil {
+		return result, fmt.Errorf("failed to unmarshal '%s': %w", key, err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,292 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"kubecloud/internal"
+	"kubecloud/internal/constants"
+	"kubecloud/internal/logger"
+	"kubecloud/internal/notification"
+	"kubecloud/internal/statemanager"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/xmonader/ewf"
+)
+
+// UpgradeOrder returns the names of the cluster nodes not running flist yet in the order they are upgraded:
+// the leader, the other masters, then workers. Nodes already on flist are left alone.
+func UpgradeOrder(cluster kubedeployer.Cluster, flist string) []string {
+	order := make([]string, 0, len(cluster.Nodes))
+	for _, node := range cluster.GetControlPlaneNodes() {
+		if node.Flist != flist {
+			order = append(order, node.Name)
+		}
+	}
+	for _, node := range cluster.Nodes {
+		if node.Type == kubedeployer.NodeTypeWorker && node.Flist != flist {
+			order = append(order, node.Name)
+		}
+	}
+	return order
+}
+
+// kubeconfigAvoiding fetches a kubeconfig from a control plane node other than nodeName,
+// so the API server stays reachable while nodeName is being redeployed
+func kubeconfigAvoiding(state ewf.State, db models.DB, privateKeyPath string, cluster kubedeployer.Cluster, nodeName string) (string, error) {
+	others := cluster
+	others.Nodes = slices.DeleteFunc(slices.Clone(cluster.Nodes), func(n kubedeployer.Node) bool { return n.Name == nodeName })
+	if len(others.GetControlPlaneNodes()) == 0 {
+		return retrieveKubeconfig(state, db, privateKeyPath)
+	}
+
+	privateKeyBytes, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read SSH private key: %w", err)
+	}
+
+	kubeconfig, _, err := internal.GetKubeconfigFromControlPlane(string(privateKeyBytes), others)
+	return kubeconfig, err
+}
+
+// UpgradeNodeStep moves the node at 'upgrade_index' of 'upgrade_order' to the flist in 'flist'.
+// The node is cordoned and drained following 'drain_policy', its VM redeployed keeping the data disk, then uncordoned once Ready.
+// Each phase is recorded in the state so a retried step continues where the failed attempt stopped.
+func UpgradeNodeStep(db models.DB, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		ensureClient(state)
+
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		kubeClient, err := statemanager.GetKubeClient(state, config)
+		if err != nil {
+			return err
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		flist, ok := state["flist"].(string)
+		if !ok || flist == "" {
+			return fmt.Errorf("missing or invalid 'flist' in state: %w", ewf.ErrFailWorkflowNow)
+		}
+
+		order, err := getFromState[[]string](state, "upgrade_order")
+		if err != nil {
+			return fmt.Errorf("%w: %w", err, ewf.ErrFailWorkflowNow)
+		}
+
+		// the index is a float64 once the state was reloaded from the store
+		idx, _ := getFromState[int](state, "upgrade_index")
+		if idx >= len(order) {
+			return fmt.Errorf("upgrade index %d out of range for %d nodes: %w", idx, len(order), ewf.ErrFailWorkflowNow)
+		}
+		nodeName := order[idx]
+
+		node, found := findClusterNode(cluster, nodeName)
+		if !found {
+			return fmt.Errorf("node %s not found in cluster: %w", nodeName, ewf.ErrFailWorkflowNow)
+		}
+
+		kubeconfig, err := kubeconfigAvoiding(state, db, privateKeyPath, cluster, nodeName)
+		if err != nil {
+			return fmt.Errorf("failed to get kubeconfig: %w", err)
+		}
+
+		clientset, err := newClientset(kubeconfig)
+		if err != nil {
+			return err
+		}
+
+		drainedKey := fmt.Sprintf("upgrade_%d_drained", idx)
+		if drained, _ := state[drainedKey].(bool); !drained {
+			if err := setNodeSchedulable(ctx, clientset, nodeName, false); err != nil {
+				return fmt.Errorf("failed to cordon node %s: %w", nodeName, err)
+			}
+			policy := getDrainPolicy(state)
+			if err := drainNode(ctx, clientset, nodeName, policy.Timeout); err != nil {
+				if !policy.Force {
+					return fmt.Errorf("failed to drain node %s: %w", nodeName, err)
+				}
+				logger.GetLogger().Warn().Err(err).Str("node", nodeName).Msg("Failed to drain node, upgrading it anyway")
+			}
+			state[drainedKey] = true
+		}
+
+		redeployedKey := fmt.Sprintf("upgrade_%d_redeployed", idx)
+		if redeployed, _ := state[redeployedKey].(bool); !redeployed && node.Flist != flist {
+			if err := kubeClient.RedeployNode(ctx, &cluster, nodeName, flist); err != nil {
+				return fmt.Errorf("failed to redeploy node %s: %w", nodeName, err)
+			}
+			// the new VM keeps the contract of the node but has its own SSH host key
+			if err := internal.ForgetHostKey(nodeName); err != nil {
+				return err
+			}
+			statemanager.SaveGridClientState(state, kubeClient)
+			statemanager.StoreCluster(state, cluster)
+			state[redeployedKey] = true
+		}
+
+		k8sNode, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s from cluster: %w", nodeName, err)
+		}
+		if !isNodeReady(k8sNode) {
+			return fmt.Errorf("upgraded node %s is not ready", nodeName)
+		}
+
+		if err := setNodeSchedulable(ctx, clientset, nodeName, true); err != nil {
+			return fmt.Errorf("failed to uncordon node %s: %w", nodeName, err)
+		}
+
+		logger.GetLogger().Info().Str("cluster", cluster.Name).Str("node", nodeName).Str("flist", flist).Msg("Node upgraded")
+
+		// the kubeconfig may point at a node that is upgraded later
+		delete(state, "kubeconfig")
+		state["upgrade_index"] = idx + 1
+		return nil
+	}
+}
+
+// RollbackUpgradeStep moves every node that was already upgraded back to the flist it had before the upgrade, in reverse order
+func RollbackUpgradeStep(db models.DB, privateKeyPath string) ewf.StepFn {
+	return func(ctx context.Context, state ewf.State) error {
+		ensureClient(state)
+
+		config, err := getConfig(state)
+		if err != nil {
+			return fmt.Errorf("failed to get config from state: %w", err)
+		}
+
+		kubeClient, err := statemanager.GetKubeClient(state, config)
+		if err != nil {
+			return err
+		}
+
+		cluster, err := statemanager.GetCluster(state)
+		if err != nil {
+			return err
+		}
+
+		order, err := getFromState[[]string](state, "upgrade_order")
+		if err != nil {
+			return fmt.Errorf("%w: %w", err, ewf.ErrFailWorkflowNow)
+		}
+
+		previousFlists, err := getFromState[map[string]string](state, "previous_flists")
+		if err != nil {
+			return fmt.Errorf("%w: %w", err, ewf.ErrFailWorkflowNow)
+		}
+
+		for i := len(order) - 1; i >= 0; i-- {
+			nodeName := order[i]
+			node, found := findClusterNode(cluster, nodeName)
+			if !found || node.Flist == previousFlists[nodeName] {
+				continue
+			}
+
+			logger.GetLogger().Info().Str("cluster", cluster.Name).Str("node", nodeName).Msg("Rolling back node to its previous flist")
+			if err := kubeClient.RedeployNode(ctx, &cluster, nodeName, previousFlists[nodeName]); err != nil {
+				return fmt.Errorf("failed to roll back node %s: %w", nodeName, err)
+			}
+			if err := internal.ForgetHostKey(nodeName); err != nil {
+				return err
+			}
+			statemanager.SaveGridClientState(state, kubeClient)
+			statemanager.StoreCluster(state, cluster)
+		}
+
+		// nodes left cordoned by the failed upgrade are made schedulable again
+		kubeconfig, err := retrieveKubeconfig(state, db, privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to get kubeconfig: %w", err)
+		}
+
+		clientset, err := newClientset(kubeconfig)
+		if err != nil {
+			return err
+		}
+
+		for _, nodeName := range order {
+			if err := setNodeSchedulable(ctx, clientset, nodeName, true); err != nil {
+				return fmt.Errorf("failed to uncordon node %s: %w", nodeName, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// NewDynamicUpgradeWorkflowTemplate registers an upgrade workflow with one step per node
+func NewDynamicUpgradeWorkflowTemplate(engine *ewf.Engine, db models.DB, notificationService *notification.NotificationService, privateKeyPath string, wfName string, nodesNum int) {
+	steps := make([]ewf.Step, 0, nodesNum+3)
+	for i := 0; i < nodesNum; i++ {
+		stepName := getUpgradeNodeStepName(i + 1)
+		registerStep(engine, stepName, UpgradeNodeStep(db, privateKeyPath))
+
+		steps = append(steps, ewf.Step{Name: stepName, RetryPolicy: longExponentialRetryPolicy})
+	}
+
+	steps = append(steps, ewf.Step{Name: constants.StepFetchKubeconfig, RetryPolicy: criticalRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepVerifyClusterReady, RetryPolicy: longExponentialRetryPolicy})
+	steps = append(steps, ewf.Step{Name: constants.StepStoreDeployment, RetryPolicy: standardRetryPolicy})
+
+	// the upgrade holds the node pools claim of the cluster until its rollback is done
+	workflow := newKubecloudWorkflowTemplate(notificationService)
+	workflow.AfterWorkflowHooks = append(workflow.AfterWorkflowHooks,
+		upgradeFailureHook(engine),
+		releasePoolsHook(db),
+		closeClient,
+	)
+	workflow.Steps = steps
+
+	engine.RegisterTemplate(wfName, &workflow)
+}
+
+// upgradeFailureHook rolls back the nodes of a failed upgrade to their previous flist
+func upgradeFailureHook(engine *ewf.Engine) ewf.AfterWorkflowHook {
+	return func(ctx context.Context, wf *ewf.Workflow, err error) {
+		if err == nil || !isUpgradeWorkflow(wf.Name) {
+			return
+		}
+
+		cluster, clusterErr := statemanager.GetCluster(wf.State)
+		if clusterErr != nil {
+			logger.GetLogger().Error().Err(clusterErr).Str("workflow_name", wf.Name).Msg("nothing to rollback")
+			return
+		}
+
+		logger.GetLogger().Info().Str("project_name", cluster.ProjectName).Str("workflow_name", wf.Name).Msg("Triggering rollback workflow for failed upgrade")
+
+		rollbackWf, rollbackErr := engine.NewWorkflow(constants.WorkflowRollbackFailedUpgrade)
+		if rollbackErr != nil {
+			logger.GetLogger().Error().Err(rollbackErr).Str("project_name", cluster.ProjectName).Msg("Failed to create rollback workflow")
+			return
+		}
+
+		rollbackWf.State["config"] = wf.State["config"]
+		rollbackWf.State["cluster"] = wf.State["cluster"]
+		rollbackWf.State["kubeclient"] = wf.State["kubeclient"]
+		rollbackWf.State["upgrade_order"] = wf.State["upgrade_order"]
+		rollbackWf.State["previous_flists"] = wf.State["previous_flists"]
+
+		rollbackCtx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+
+		// wait the rollback workflow to finish before closing the client
+		if err := engine.RunSync(rollbackCtx, rollbackWf); err != nil {
+			logger.GetLogger().Error().Err(err).Str("project_name", cluster.ProjectName).Msg("Failed to run rollback workflow")
+		}
+	}
+}
@@ -2,6 +2,7 @@ package activities
 
 import (
 	"kubecloud/internal"
+	"kubecloud/internal/backup"
 	"kubecloud/internal/constants"
 	"kubecloud/internal/metrics"
 	"kubecloud/internal/notification"
@@ -28,6 +29,14 @@ var workflowsDescriptions = map[string]string{
 	constants.WorkflowReserveNode:              "Reserve Node",
 	constants.WorkflowUnreserveNode:            "Unreserve Node",
 	constants.WorkflowTrackClusterHealth:       "Cluster Health Check",
+	constants.WorkflowPromoteLeader:            "Promoting Cluster Leader",
+	constants.WorkflowRollbackFailedUpgrade:    "Rolling Back Cluster Upgrade",
+	constants.WorkflowSnapshotCluster:          "Cluster Snapshot",
+	constants.WorkflowRestoreSnapshot:          "Restoring Cluster Snapshot",
+	constants.WorkflowImportCluster:            "Importing Cluster",
+	constants.WorkflowUpdateAddons:             "Updating Cluster Add-ons",
+	constants.WorkflowUpdateNodePools:          "Updating Node Pools",
+	constants.WorkflowRotateAdminCredential:    "Rotating Cluster Admin Credential",
 }
 
 func RegisterEWFWorkflows(
@@ -42,25 +51,27 @@ func RegisterEWFWorkflows(
 	metrics *metrics.Metrics,
 	notificationService *notification.NotificationService,
 	proxyClient proxy.Client,
+	snapshotStore backup.Store,
 ) {
-	engine.Register(constants.StepSendVerificationEmail, SendVerificationEmailStep(mail, config))
-	engine.Register(constants.StepCreateUser, CreateUserStep(config, db))
-	engine.Register(constants.StepUpdateCode, UpdateCodeStep(db))
-	engine.Register(constants.StepSetupTFChain, SetupTFChainStep(substrate, config, notificationService, db))
-	engine.Register(constants.StepCreateStripeCustomer, CreateStripeCustomerStep(db))
-	engine.Register(constants.StepCreateKYCSponsorship, CreateKYCSponsorship(kycClient, notificationService, sponsorAddress, sponsorKeyPair, db))
-	engine.Register(constants.StepSendWelcomeEmail, SendWelcomeEmailStep(mail, config, metrics))
-	engine.Register(constants.StepCreatePaymentIntent, CreatePaymentIntentStep(config.Currency, metrics, notificationService))
-	engine.Register(constants.StepCreatePendingRecord, CreatePendingRecord(substrate, db, config.SystemAccount.Mnemonic))
-	engine.Register(constants.StepUpdateCreditCardBalance, UpdateCreditCardBalanceStep(db))
-	engine.Register(constants.StepCreateIdentity, CreateIdentityStep())
-	engine.Register(constants.StepReserveNode, ReserveNodeStep(db, substrate))
-	engine.Register(constants.StepUnreserveNode, UnreserveNodeStep(db, substrate))
-	engine.Register(constants.StepUpdateCreditedBalance, UpdateCreditedBalanceStep(db))
-	engine.Register(constants.StepSendEmailNotification, SendNotification(db, notificationService.GetNotifiers()[notification.ChannelEmail]))
-	engine.Register(constants.StepSendUINotification, SendNotification(db, notificationService.GetNotifiers()[notification.ChannelUI]))
-	engine.Register(constants.StepVerifyNodeState, VerifyNodeStateStep(proxyClient))
-	engine.Register(constants.StepVerifyClusterInDB, VerifyClusterInDBStep(db))
+	registerStep(engine, constants.StepSendVerificationEmail, SendVerificationEmailStep(mail, config))
+	registerStep(engine, constants.StepCreateUser, CreateUserStep(config, db))
+	registerStep(engine, constants.StepUpdateCode, UpdateCodeStep(db))
+	registerStep(engine, constants.StepSetupTFChain, SetupTFChainStep(substrate, config, notificationService, db))
+	registerStep(engine, constants.StepCreateStripeCustomer, CreateStripeCustomerStep(db))
+	registerStep(engine, constants.StepCreateKYCSponsorship, CreateKYCSponsorship(kycClient, notificationService, sponsorAddress, sponsorKeyPair, db))
+	registerStep(engine, constants.StepSendWelcomeEmail, SendWelcomeEmailStep(mail, config, metrics))
+	registerStep(engine, constants.StepCreatePaymentIntent, CreatePaymentIntentStep(config.Currency, metrics, notificationService))
+	registerStep(engine, constants.StepCreatePendingRecord, CreatePendingRecord(substrate, db, config.SystemAccount.Mnemonic))
+	registerStep(engine, constants.StepUpdateCreditCardBalance, UpdateCreditCardBalanceStep(db))
+	registerStep(engine, constants.StepCreateIdentity, CreateIdentityStep())
+	registerStep(engine, constants.StepReserveNode, ReserveNodeStep(db, substrate))
+	registerStep(engine, constants.StepUnreserveNode, UnreserveNodeStep(db, substrate))
+	registerStep(engine, constants.StepReservePlacedNodes, ReservePlacedNodesStep(db, substrate))
+	registerStep(engine, constants.StepUpdateCreditedBalance, UpdateCreditedBalanceStep(db))
+	registerStep(engine, constants.StepSendEmailNotification, SendNotification(db, notificationService.GetNotifiers()[notification.ChannelEmail]))
+	registerStep(engine, constants.StepSendUINotification, SendNotification(db, notificationService.GetNotifiers()[notification.ChannelUI]))
+	registerStep(engine, constants.StepVerifyNodeState, VerifyNodeStateStep(proxyClient))
+	registerStep(engine, constants.StepVerifyClusterInDB, VerifyClusterInDBStep(db))
 
 	registerWorkflowTemplate := newKubecloudWorkflowTemplate(notificationService)
 	registerWorkflowTemplate.BeforeWorkflowHooks = []ewf.BeforeWorkflowHook{
@@ -147,11 +158,14 @@ func RegisterEWFWorkflows(
 		{Name: constants.StepFetchKubeconfig, RetryPolicy: standardRetryPolicy},
 		{Name: constants.StepVerifyClusterReady, RetryPolicy: standardRetryPolicy},
 	}
-	trackClusterHealthWFTemplate.AfterWorkflowHooks = []ewf.AfterWorkflowHook{hookClusterHealthCheck(notificationService)}
+	trackClusterHealthWFTemplate.AfterWorkflowHooks = []ewf.AfterWorkflowHook{hookClusterHealthCheck(notificationService), leaderFailoverHook(engine, db, config)}
 	// trackClusterHealthWFTemplate.BeforeWorkflowHooks = []ewf.BeforeWorkflowHook{hookNotificationWorkflowStarted}
 	engine.RegisterTemplate(constants.WorkflowTrackClusterHealth, &trackClusterHealthWFTemplate)
 
 	registerDeploymentActivities(engine, metrics, db, notificationService, config)
+	registerSnapshotActivities(engine, db, snapshotStore, notificationService, config)
+	registerAdminCredentialActivities(engine, db, notificationService, config)
+	registerImportActivities(engine, notificationService)
 
 	notificationTemplate := ewf.WorkflowTemplate{
 		Steps: []ewf.Step{
@@ -0,0 +1,294 @@
+// Package addons installs optional components in clusters. Charts are installed through the helm controller
+// bundled with k3s by applying HelmChart resources, other add-ons are manifests bundled with the backend.
+package addons
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"kubecloud/kubedeployer"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	fieldManager    = "kubecloud"
+	managedByLabel  = "app.kubernetes.io/managed-by"
+	helmChartPrefix = "kubecloud-"
+)
+
+//go:embed manifests/tfgw-crd.yaml
+var tfgwCRDManifest string
+
+// chart describes the helm chart an add-on is installed from
+type chart struct {
+	repo      string
+	name      string
+	version   string
+	namespace string
+	values    map[string]string
+}
+
+var charts = map[string]chart{
+	kubedeployer.AddonCertManager: {
+		repo:      "https://charts.jetstack.io",
+		name:      "cert-manager",
+		version:   "v1.16.2",
+		namespace: "cert-manager",
+		values:    map[string]string{"crds.enabled": "true"},
+	},
+	kubedeployer.AddonMetricsServer: {
+		repo:      "https://kubernetes-sigs.github.io/metrics-server",
+		name:      "metrics-server",
+		version:   "3.12.2",
+		namespace: "kube-system",
+		values:    map[string]string{"args[0]": "--kubelet-insecure-tls"},
+	},
+	kubedeployer.AddonPrometheus: {
+		repo:      "https://prometheus-community.github.io/helm-charts",
+		name:      "kube-prometheus-stack",
+		version:   "66.3.1",
+		namespace: "monitoring",
+	},
+	kubedeployer.AddonFlux: {
+		repo:      "https://fluxcd-community.github.io/helm-charts",
+		name:      "flux2",
+		version:   "2.14.0",
+		namespace: "flux-system",
+	},
+	kubedeployer.AddonArgoCD: {
+		repo:      "https://argoproj.github.io/argo-helm",
+		name:      "argo-cd",
+		version:   "7.7.7",
+		namespace: "argocd",
+	},
+}
+
+// Params holds the cluster specific values bundled manifests are rendered with
+type Params struct {
+	// Mnemonic is encrypted with the cluster token, as passed to the nodes
+	Mnemonic string
+	Network  string
+	Token    string
+}
+
+// Installer applies and removes add-ons in a cluster
+type Installer struct {
+	client dynamic.Interface
+	mapper meta.ResettableRESTMapper
+}
+
+// NewInstaller creates an installer for the cluster of an admin kubeconfig
+func NewInstaller(kubeconfig string) (*Installer, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+
+	return &Installer{
+		client: client,
+		mapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+	}, nil
+}
+
+// Install applies the resources of an add-on, it is safe to call again to update it
+func (i *Installer) Install(ctx context.Context, addon kubedeployer.Addon, params Params) error {
+	if addon.Name == kubedeployer.AddonMetricsServer {
+		bundled, err := i.hasBundledMetricsServer(ctx)
+		if err != nil {
+			return err
+		}
+		if bundled {
+			return nil
+		}
+	}
+
+	objects, err := render(addon, params)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if err := i.apply(ctx, obj); err != nil {
+			return fmt.Errorf("failed to apply %s %s of addon %s: %w", obj.GetKind(), obj.GetName(), addon.Name, err)
+		}
+	}
+	return nil
+}
+
+// Uninstall deletes the resources of an add-on. The helm controller uninstalls the release of a deleted HelmChart.
+func (i *Installer) Uninstall(ctx context.Context, addon kubedeployer.Addon, params Params) error {
+	objects, err := render(addon, params)
+	if err != nil {
+		return err
+	}
+
+	for idx := len(objects) - 1; idx >= 0; idx-- {
+		obj := objects[idx]
+		resource, err := i.resourceFor(obj)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = resource.Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s of addon %s: %w", obj.GetKind(), obj.GetName(), addon.Name, err)
+		}
+	}
+	return nil
+}
+
+// hasBundledMetricsServer reports whether k3s deployed its own metrics server, which the chart would conflict with
+func (i *Installer) hasBundledMetricsServer(ctx context.Context) (bool, error) {
+	deployments := i.client.Resource(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}).Namespace(metav1.NamespaceSystem)
+
+	deployment, err := deployments.Get(ctx, "metrics-server", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up metrics server: %w", err)
+	}
+	return deployment.GetLabels()[managedByLabel] != "Helm", nil
+}
+
+func (i *Installer) apply(ctx context.Context, obj *unstructured.Unstructured) error {
+	resource, err := i.resourceFor(obj)
+	if meta.IsNoMatchError(err) {
+		// the kind may come from a CRD applied just before
+		i.mapper.Reset()
+		resource, err = i.resourceFor(obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to encode object: %w", err)
+	}
+
+	force := true
+	_, err = resource.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	return err
+}
+
+func (i *Installer) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := i.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace := obj.GetNamespace()
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		return i.client.Resource(mapping.Resource).Namespace(namespace), nil
+	}
+	return i.client.Resource(mapping.Resource), nil
+}
+
+// render returns the resources of an add-on in the order they are applied
+func render(addon kubedeployer.Addon, params Params) ([]*unstructured.Unstructured, error) {
+	if addon.Name == kubedeployer.AddonTFGWCRD {
+		manifest := strings.NewReplacer(
+			"${MNEMONIC}", strconv.Quote(params.Mnemonic),
+			"${NETWORK}", strconv.Quote(params.Network),
+			"${TOKEN}", strconv.Quote(params.Token),
+		).Replace(tfgwCRDManifest)
+		return decodeManifest(manifest)
+	}
+
+	c, ok := charts[addon.Name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported addon %q", addon.Name)
+	}
+	return []*unstructured.Unstructured{helmChart(addon, c)}, nil
+}
+
+// helmChart builds the HelmChart resource the k3s helm controller installs a chart from
+func helmChart(addon kubedeployer.Addon, c chart) *unstructured.Unstructured {
+	version := c.version
+	if addon.Version != "" {
+		version = addon.Version
+	}
+
+	set := make(map[string]interface{}, len(c.values)+len(addon.Values))
+	for key, value := range c.values {
+		set[key] = value
+	}
+	for key, value := range addon.Values {
+		set[key] = value
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "helm.cattle.io/v1",
+		"kind":       "HelmChart",
+		"metadata": map[string]interface{}{
+			"name":      helmChartPrefix + addon.Name,
+			"namespace": metav1.NamespaceSystem,
+			"labels":    map[string]interface{}{managedByLabel: fieldManager},
+		},
+		"spec": map[string]interface{}{
+			"repo":            c.repo,
+			"chart":           c.name,
+			"version":         version,
+			"targetNamespace": c.namespace,
+			"createNamespace": true,
+			"set":             set,
+		},
+	}}
+}
+
+func decodeManifest(manifest string) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewBufferString(manifest), 4096)
+
+	var objects []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		objects = append(objects, obj)
+	}
+}
@@ -0,0 +1,47 @@
+package addons
+
+import (
+	"testing"
+
+	"kubecloud/kubedeployer"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRenderChart(t *testing.T) {
+	objects, err := render(kubedeployer.Addon{
+		Name:   kubedeployer.AddonCertManager,
+		Values: map[string]string{"replicaCount": "2", "crds.enabled": "false"},
+	}, Params{})
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	chart := objects[0]
+	require.Equal(t, "HelmChart", chart.GetKind())
+	require.Equal(t, "kubecloud-cert-manager", chart.GetName())
+
+	version, _, _ := unstructured.NestedString(chart.Object, "spec", "version")
+	require.Equal(t, charts[kubedeployer.AddonCertManager].version, version)
+
+	set, _, _ := unstructured.NestedMap(chart.Object, "spec", "set")
+	require.Equal(t, map[string]interface{}{"replicaCount": "2", "crds.enabled": "false"}, set)
+}
+
+func TestRenderManifest(t *testing.T) {
+	objects, err := render(kubedeployer.Addon{Name: kubedeployer.AddonTFGWCRD}, Params{
+		Mnemonic: "c2VjcmV0: #",
+		Network:  "main",
+		Token:    "token",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Namespace", objects[0].GetKind())
+
+	deployment := objects[len(objects)-1]
+	require.Equal(t, "Deployment", deployment.GetKind())
+
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	env := containers[0].(map[string]interface{})["env"].([]interface{})
+	require.Equal(t, "c2VjcmV0: #", env[0].(map[string]interface{})["value"])
+	require.Equal(t, "main", env[1].(map[string]interface{})["value"])
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"kubecloud/internal"
+)
+
+const (
+	StoreFilesystem = "filesystem"
+	StoreS3         = "s3"
+
+	defaultFilesystemPath = "snapshots"
+)
+
+// ErrBlobNotFound is returned when a key does not exist in the store
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Store persists snapshot blobs under a key
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStore creates the snapshot store selected in the configuration, the filesystem store is used by default
+func NewStore(config internal.BackupConfig) (Store, error) {
+	switch config.Store {
+	case "", StoreFilesystem:
+		path := config.FilesystemPath
+		if path == "" {
+			path = defaultFilesystemPath
+		}
+		return NewFilesystemStore(path)
+	case StoreS3:
+		return NewS3Store(config.S3.Endpoint, config.S3.Region, config.S3.Bucket, config.S3.AccessKey, config.S3.SecretKey)
+	default:
+		return nil, fmt.Errorf("unsupported backup store %q", config.Store)
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"fmt"
+
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+)
+
+const k3sTLSDir = kubedeployer.K3S_DATA_DIR + "/server/tls"
+
+// RotateClientCA replaces the client CA of a cluster through a running server with a new self-signed one.
+// Client certificates signed by the previous CA, including the k3s admin kubeconfig, stop working once every
+// node has been restarted with RestartK3s. The current CAs are staged in a temporary directory on the node,
+// only the client CA is regenerated and the staged set is loaded into the datastore.
+func RotateClientCA(privateKey string, node *kubedeployer.Node, token string) error {
+	if node.MyceliumIP == "" {
+		return fmt.Errorf("no valid IP address found for node %s", node.Name)
+	}
+
+	command := fmt.Sprintf(
+		`stage=$(mktemp -d -p %s) && mkdir -p "$stage/server/tls" && cp -a %s/. "$stage/server/tls/" && cd "$stage/server/tls" && `+
+			`openssl ecparam -name prime256v1 -genkey -noout -out client-ca.key && `+
+			`openssl req -x509 -new -key client-ca.key -sha256 -days 3650 -subj "/CN=k3s-client-ca@$(date +%%s)" `+
+			`-addext "basicConstraints=critical,CA:TRUE" -addext "keyUsage=critical,digitalSignature,keyEncipherment,keyCertSign" -out client-ca.crt && `+
+			`{ [ ! -e client-ca.nochain.crt ] || cp client-ca.crt client-ca.nochain.crt; } && `+
+			`k3s certificate rotate-ca --data-dir %s --token %s --path "$stage/server" --force; rc=$?; rm -rf "$stage"; exit $rc`,
+		kubedeployer.K3S_DATA_DIR, k3sTLSDir, kubedeployer.K3S_DATA_DIR, shellQuote(token),
+	)
+
+	logger.GetLogger().Debug().Str("node", node.Name).Msg("Rotating cluster client CA")
+	if _, err := executeSSHCommand(privateKey, node, command); err != nil {
+		return fmt.Errorf("failed to rotate client CA on node %s: %w", node.Name, err)
+	}
+	return nil
+}
+
+// RestartK3s stops k3s on a node and starts it again. The certificates of servers are rotated while k3s is
+// stopped, so they are regenerated from the CAs in the datastore; agents fetch theirs again when they start.
+func RestartK3s(privateKey string, node *kubedeployer.Node, server bool) error {
+	rotate := ""
+	if server {
+		rotate = fmt.Sprintf("k3s certificate rotate --data-dir %s && ", kubedeployer.K3S_DATA_DIR)
+	}
+
+	command := fmt.Sprintf(
+		`zinit stop %s; stopped=; for i in $(seq 60); do pgrep -f "[k]3s (server|agent)" >/dev/null || { stopped=1; break; }; sleep 1; done; `+
+			`[ -n "$stopped" ] || { echo "k3s did not stop" >&2; exit 1; }; %szinit start %s`,
+		k3sService, rotate, k3sService,
+	)
+
+	logger.GetLogger().Debug().Str("node", node.Name).Bool("server", server).Msg("Restarting k3s")
+	if _, err := executeSSHCommand(privateKey, node, command); err != nil {
+		return fmt.Errorf("failed to restart k3s on node %s: %w", node.Name, err)
+	}
+	return nil
+}
@@ -32,6 +32,8 @@ type Configuration struct {
 	DeployerWorkersNum                      int                `json:"deployer_workers_num" default:"1"`
 	Invoice                                 InvoiceCompanyData `json:"invoice"`
 	SSH                                     SSHConfig          `json:"ssh" validate:"required,dive"`
+	K3sFlist                                string             `json:"k3s_flist"`
+	Backup                                  BackupConfig       `json:"backup"`
 	Debug                                   bool               `json:"debug"`
 	MonitorBalanceIntervalInMinutes         int                `json:"monitor_balance_interval_in_minutes" validate:"required,gt=0"`
 	NotifyAdminsForPendingRecordsInHours    int                `json:"notify_admins_for_pending_records_in_hours" validate:"required,gt=0"`
@@ -39,6 +41,7 @@ type Configuration struct {
 	ReservedNodeHealthCheckIntervalInHours  int                `json:"reserved_node_health_check_interval_in_hours" validate:"required,gt=0" default:"1"`
 	ReservedNodeHealthCheckTimeoutInMinutes int                `json:"reserved_node_health_check_timeout_in_minutes" validate:"required,gt=0" default:"1"`
 	ReservedNodeHealthCheckWorkersNum       int                `json:"reserved_node_health_check_workers_num" validate:"required,gt=0" default:"10"`
+	ContractDriftCheckIntervalInMinutes     int                `json:"contract_drift_check_interval_in_minutes" validate:"min=0" default:"60"`
 
 	// KYC Verifier config
 	KYCVerifierAPIURL  string `json:"kyc_verifier_api_url" validate:"required,url"`
@@ -57,6 +60,23 @@ type SSHConfig struct {
 	PublicKeyPath  string `json:"public_key_path" validate:"required"`
 }
 
+// BackupConfig holds where cluster datastore snapshots are stored and how often schedules are checked
+type BackupConfig struct {
+	Store                          string   `json:"store" validate:"omitempty,oneof=filesystem s3"`
+	FilesystemPath                 string   `json:"filesystem_path"`
+	S3                             S3Config `json:"s3"`
+	ScheduleCheckIntervalInMinutes int      `json:"schedule_check_interval_in_minutes" validate:"min=0"`
+}
+
+// S3Config holds the connection information of an S3 compatible object store
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
 // Server struct holds server's information
 type Server struct {
 	Host string `json:"host" validate:"required,hostname|ip|url"`
@@ -242,6 +262,13 @@ func LoadConfig() (Configuration, error) {
 		return Configuration{}, fmt.Errorf("failed to expand SSH public key path: %w", err)
 	}
 
+	if config.Backup.FilesystemPath != "" {
+		config.Backup.FilesystemPath, err = utils.ExpandPath(config.Backup.FilesystemPath)
+		if err != nil {
+			return Configuration{}, fmt.Errorf("failed to expand backup filesystem path: %w", err)
+		}
+	}
+
 	config.Logger.LogDir, err = utils.ExpandPath(config.Logger.LogDir)
 	if err != nil {
 		return Configuration{}, fmt.Errorf("failed to expand log directory path: %w", err)
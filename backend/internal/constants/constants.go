@@ -17,6 +17,16 @@ const (
 	WorkflowRollbackFailedDeployment = "rollback-failed-deployment"
 	WorkflowTrackClusterHealth       = "track-cluster-health"
 	WorkflowRollbackFailedAddNode    = "rollback-add-node"
+	WorkflowUpdateCluster            = "update-cluster"
+	WorkflowPromoteLeader            = "promote-leader"
+	WorkflowUpgradeCluster           = "upgrade-cluster"
+	WorkflowRollbackFailedUpgrade    = "rollback-failed-upgrade"
+	WorkflowSnapshotCluster          = "snapshot-cluster"
+	WorkflowRestoreSnapshot          = "restore-cluster-snapshot"
+	WorkflowImportCluster            = "import-cluster"
+	WorkflowUpdateAddons             = "update-addons"
+	WorkflowUpdateNodePools          = "update-node-pools"
+	WorkflowRotateAdminCredential    = "rotate-admin-credential"
 
 	// Step names
 	StepCreatePaymentIntent     = "create_payment_intent"
@@ -32,6 +42,7 @@ const (
 	StepCreateIdentity          = "create_identity"
 	StepReserveNode             = "reserve_node"
 	StepUnreserveNode           = "unreserve-node"
+	StepReservePlacedNodes      = "reserve-placed-nodes"
 	StepUpdateCreditedBalance   = "update-credited-balance"
 	StepRemoveNode              = "remove-node"
 	StepStoreDeployment         = "store-deployment"
@@ -51,6 +62,26 @@ const (
 	StepSendUINotification      = "send-ui-notification"
 	StepVerifyNodeState         = "verify-node-state"
 	StepVerifyClusterInDB       = "verify-cluster-in-db"
+	StepApplyNodeOperation      = "apply-node-operation"
+	StepPromoteLeader           = "promote-leader"
+	StepUpgradeNode             = "upgrade-node"
+	StepRollbackUpgrade         = "rollback-upgrade"
+	StepTakeSnapshot            = "take-snapshot"
+	StepStopControlPlane        = "stop-control-plane"
+	StepRestoreSnapshot         = "restore-snapshot"
+	StepRejoinControlPlane      = "rejoin-control-plane"
+	StepAdoptCluster            = "adopt-cluster"
+	StepRenameProject           = "rename-project"
+	StepSetupGPUNodes           = "setup-gpu-nodes"
+	StepInstallAddons           = "install-addons"
+	StepApplyNodePools          = "apply-node-pools"
+	StepCordonNode              = "cordon-node"
+	StepDrainNode               = "drain-node"
+	StepDeleteKubernetesNode    = "delete-kubernetes-node"
+	StepRotateClientCA          = "rotate-client-ca"
+	StepRestartClusterNodes     = "restart-cluster-nodes"
+	StepRefreshAdminKubeconfig  = "refresh-admin-kubeconfig"
+	StepRevokeCertCredentials   = "revoke-certificate-credentials"
 
 	NodeRentable = "rentable"
 	NodeRented   = "rented"
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+)
+
+const (
+	k3sService          = "k3s"
+	restoreSnapshotPath = kubedeployer.K3S_DATA_DIR + "/restore-snapshot.db"
+	etcdDataDir         = kubedeployer.K3S_DATA_DIR + "/server/db"
+)
+
+// SaveDatastoreSnapshot takes an etcd snapshot on a control plane node and streams it to w.
+// The snapshot is written to a temporary directory on the node which is removed afterwards.
+func SaveDatastoreSnapshot(privateKey string, node *kubedeployer.Node, name string, w io.Writer) error {
+	if node.MyceliumIP == "" {
+		return fmt.Errorf("no valid IP address found for node %s", node.Name)
+	}
+
+	command := fmt.Sprintf(
+		`dir=$(mktemp -d -p %s) && k3s etcd-snapshot save --data-dir %s --dir "$dir" --name %s >&2 && cat "$dir"/%s*; rc=$?; rm -rf "$dir"; exit $rc`,
+		kubedeployer.K3S_DATA_DIR, kubedeployer.K3S_DATA_DIR, shellQuote(name), shellQuote(name),
+	)
+
+	logger.GetLogger().Debug().Str("node", node.Name).Str("snapshot", name).Msg("Taking datastore snapshot")
+	return streamSSHCommand(privateKey, node, command, nil, w)
+}
+
+// StopK3s stops the k3s service of a node and waits for the server process to exit
+func StopK3s(privateKey string, node *kubedeployer.Node) error {
+	command := fmt.Sprintf(
+		`zinit stop %s; for i in $(seq 60); do pgrep -f "[k]3s server" >/dev/null || exit 0; sleep 1; done; echo "k3s did not stop" >&2; exit 1`,
+		k3sService,
+	)
+	_, err := executeSSHCommand(privateKey, node, command)
+	return err
+}
+
+// RestoreDatastoreSnapshot uploads a snapshot to a stopped control plane node, resets the etcd cluster
+// to a single member restored from it and starts k3s again. The other masters must rejoin with ResetEtcdMember.
+func RestoreDatastoreSnapshot(privateKey string, node *kubedeployer.Node, token string, snapshot io.Reader) error {
+	if node.MyceliumIP == "" {
+		return fmt.Errorf("no valid IP address found for node %s", node.Name)
+	}
+
+	upload := fmt.Sprintf("cat > %s", restoreSnapshotPath)
+	if err := streamSSHCommand(privateKey, node, upload, snapshot, io.Discard); err != nil {
+		return fmt.Errorf("failed to upload snapshot to node %s: %w", node.Name, err)
+	}
+
+	reset := fmt.Sprintf(
+		"k3s server --cluster-reset --cluster-reset-restore-path=%s --data-dir %s --token %s && rm -f %s && zinit start %s",
+		restoreSnapshotPath, kubedeployer.K3S_DATA_DIR, shellQuote(token), restoreSnapshotPath, k3sService,
+	)
+	if _, err := executeSSHCommand(privateKey, node, reset); err != nil {
+		return fmt.Errorf("failed to restore snapshot on node %s: %w", node.Name, err)
+	}
+
+	return nil
+}
+
+// ResetEtcdMember wipes the local etcd data of a stopped master and starts k3s so it joins the restored cluster
+func ResetEtcdMember(privateKey string, node *kubedeployer.Node) error {
+	command := fmt.Sprintf("rm -rf %s && zinit start %s", etcdDataDir, k3sService)
+	_, err := executeSSHCommand(privateKey, node, command)
+	return err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// HostKeyChangedError is returned when a node presents another SSH host key than the one trusted for it
+type HostKeyChangedError struct {
+	NodeName             string
+	Address              string
+	TrustedFingerprint   string
+	PresentedFingerprint string
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf(
+		"SSH host key of node %s at %s changed from %s to %s, refusing to connect as this may be a man-in-the-middle attack; an admin must re-trust the node if it was rebuilt",
+		e.NodeName, e.Address, e.TrustedFingerprint, e.PresentedFingerprint,
+	)
+}
+
+// HostKeyAlertFunc is called the first time a node presents a given changed host key
+type HostKeyAlertFunc func(err *HostKeyChangedError)
+
+// HostKeyVerifier checks the SSH host keys of cluster nodes against the ones recorded in the database.
+// The key presented by a node without a recorded key, or whose contract changed since it was recorded, is trusted on first use.
+type HostKeyVerifier struct {
+	db    models.DB
+	alert HostKeyAlertFunc
+}
+
+var (
+	hostKeyVerifierMu sync.RWMutex
+	hostKeyVerifier   *HostKeyVerifier
+)
+
+// NewHostKeyVerifier creates a verifier storing the host keys in db and calling alert when a key changes
+func NewHostKeyVerifier(db models.DB, alert HostKeyAlertFunc) *HostKeyVerifier {
+	return &HostKeyVerifier{db: db, alert: alert}
+}
+
+// SetHostKeyVerifier sets the verifier used by all SSH connections to cluster nodes
+func SetHostKeyVerifier(verifier *HostKeyVerifier) {
+	hostKeyVerifierMu.Lock()
+	defer hostKeyVerifierMu.Unlock()
+	hostKeyVerifier = verifier
+}
+
+// ForgetHostKey forgets the host key trusted for a node whose VM was replaced under the same contract,
+// like when it is upgraded to another flist, so the key of the new VM is trusted on first use
+func ForgetHostKey(nodeName string) error {
+	hostKeyVerifierMu.RLock()
+	defer hostKeyVerifierMu.RUnlock()
+	if hostKeyVerifier == nil {
+		return fmt.Errorf("SSH host key verification is not configured")
+	}
+	if err := hostKeyVerifier.db.DeleteNodeHostKey(nodeName); err != nil {
+		return fmt.Errorf("failed to forget host key of node %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+func hostKeyCallback(node *kubedeployer.Node) (ssh.HostKeyCallback, error) {
+	hostKeyVerifierMu.RLock()
+	defer hostKeyVerifierMu.RUnlock()
+	if hostKeyVerifier == nil {
+		return nil, fmt.Errorf("SSH host key verification is not configured")
+	}
+	return hostKeyVerifier.callback(*node), nil
+}
+
+func (v *HostKeyVerifier) callback(node kubedeployer.Node) ssh.HostKeyCallback {
+	return func(_ string, remote net.Addr, key ssh.PublicKey) error {
+		presented := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		trusted, err := v.db.GetNodeHostKey(node.Name)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to get trusted host key of node %s: %w", node.Name, err)
+		}
+
+		// a node deployed with a new contract is a new VM with its own host key
+		if errors.Is(err, gorm.ErrRecordNotFound) || (node.ContractID != 0 && trusted.ContractID != node.ContractID) {
+			record := models.NodeHostKey{
+				NodeName:    node.Name,
+				ContractID:  node.ContractID,
+				Address:     remote.String(),
+				HostKey:     presented,
+				Fingerprint: fingerprint,
+			}
+			if err := v.db.TrustNodeHostKey(&record); err != nil {
+				return fmt.Errorf("failed to record host key of node %s: %w", node.Name, err)
+			}
+			logger.GetLogger().Info().Str("node", node.Name).Str("fingerprint", fingerprint).Msg("Trusted SSH host key of node on first use")
+			return nil
+		}
+
+		if trusted.HostKey == presented {
+			return nil
+		}
+
+		changeErr := &HostKeyChangedError{
+			NodeName:             node.Name,
+			Address:              remote.String(),
+			TrustedFingerprint:   trusted.Fingerprint,
+			PresentedFingerprint: fingerprint,
+		}
+		logger.GetLogger().Error().Err(changeErr).Str("node", node.Name).Msg("SSH host key mismatch")
+
+		if trusted.PresentedFingerprint != fingerprint {
+			if err := v.db.RecordNodeHostKeyChange(node.Name, presented, fingerprint); err != nil {
+				logger.GetLogger().Error().Err(err).Str("node", node.Name).Msg("Failed to record changed host key")
+			}
+			if v.alert != nil {
+				v.alert(changeErr)
+			}
+		}
+
+		return changeErr
+	}
+}
+
+// RecordHostKey connects to a node so that its host key is trusted if none is recorded yet, or checked against the recorded one
+func RecordHostKey(privateKey string, node *kubedeployer.Node) error {
+	client, err := dialSSH(privateKey, node)
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
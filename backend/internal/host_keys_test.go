@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(public)
+	require.NoError(t, err)
+	return key
+}
+
+func TestHostKeyVerifier(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.20.2.2"), Port: 22}
+
+	setup := func(t *testing.T) (*HostKeyVerifier, models.DB, *[]*HostKeyChangedError) {
+		db, err := models.NewSqliteDB(filepath.Join(t.TempDir(), "hostkeys.db"))
+		require.NoError(t, err)
+		var alerts []*HostKeyChangedError
+		verifier := NewHostKeyVerifier(db, func(err *HostKeyChangedError) { alerts = append(alerts, err) })
+		return verifier, db, &alerts
+	}
+
+	t.Run("First key is trusted", func(t *testing.T) {
+		verifier, db, alerts := setup(t)
+		node := kubedeployer.Node{Name: "kc1prodleader", ContractID: 10}
+		key := newTestHostKey(t)
+
+		require.NoError(t, verifier.callback(node)("", remote, key))
+		require.NoError(t, verifier.callback(node)("", remote, key))
+
+		trusted, err := db.GetNodeHostKey(node.Name)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), trusted.ContractID)
+		require.Equal(t, ssh.FingerprintSHA256(key), trusted.Fingerprint)
+		require.Equal(t, remote.String(), trusted.Address)
+		require.Empty(t, *alerts)
+	})
+
+	t.Run("Mismatched key is rejected", func(t *testing.T) {
+		verifier, db, alerts := setup(t)
+		node := kubedeployer.Node{Name: "kc1prodleader", ContractID: 10}
+		key := newTestHostKey(t)
+		other := newTestHostKey(t)
+
+		require.NoError(t, verifier.callback(node)("", remote, key))
+
+		err := verifier.callback(node)("", remote, other)
+		var changeErr *HostKeyChangedError
+		require.True(t, errors.As(err, &changeErr))
+		require.Equal(t, ssh.FingerprintSHA256(key), changeErr.TrustedFingerprint)
+		require.Equal(t, ssh.FingerprintSHA256(other), changeErr.PresentedFingerprint)
+
+		// the same changed key is only alerted once
+		require.Error(t, verifier.callback(node)("", remote, other))
+		require.Len(t, *alerts, 1)
+
+		trusted, err := db.GetNodeHostKey(node.Name)
+		require.NoError(t, err)
+		require.Equal(t, ssh.FingerprintSHA256(key), trusted.Fingerprint)
+		require.Equal(t, ssh.FingerprintSHA256(other), trusted.PresentedFingerprint)
+		require.NotNil(t, trusted.ChangedAt)
+
+		// the trusted key is still accepted
+		require.NoError(t, verifier.callback(node)("", remote, key))
+	})
+
+	t.Run("Key is trusted again when the contract changes", func(t *testing.T) {
+		verifier, db, alerts := setup(t)
+		node := kubedeployer.Node{Name: "kc1prodleader", ContractID: 10}
+		key := newTestHostKey(t)
+		rebuilt := newTestHostKey(t)
+
+		require.NoError(t, verifier.callback(node)("", remote, key))
+
+		node.ContractID = 20
+		require.NoError(t, verifier.callback(node)("", remote, rebuilt))
+		require.Empty(t, *alerts)
+
+		trusted, err := db.GetNodeHostKey(node.Name)
+		require.NoError(t, err)
+		require.Equal(t, uint64(20), trusted.ContractID)
+		require.Equal(t, ssh.FingerprintSHA256(rebuilt), trusted.Fingerprint)
+
+		// the key of the previous VM is rejected now
+		require.Error(t, verifier.callback(node)("", remote, key))
+	})
+
+	t.Run("Node without a contract keeps the trusted key", func(t *testing.T) {
+		verifier, _, _ := setup(t)
+		node := kubedeployer.Node{Name: "kc1prodleader", ContractID: 10}
+		require.NoError(t, verifier.callback(node)("", remote, newTestHostKey(t)))
+
+		node.ContractID = 0
+		require.Error(t, verifier.callback(node)("", remote, newTestHostKey(t)))
+	})
+}
+
+func TestForgetHostKey(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.20.2.2"), Port: 22}
+	db, err := models.NewSqliteDB(filepath.Join(t.TempDir(), "hostkeys.db"))
+	require.NoError(t, err)
+	SetHostKeyVerifier(NewHostKeyVerifier(db, nil))
+	t.Cleanup(func() { SetHostKeyVerifier(nil) })
+
+	// connects to the node like fetching its kubeconfig does
+	connect := func(node kubedeployer.Node, key ssh.PublicKey) error {
+		callback, err := hostKeyCallback(&node)
+		require.NoError(t, err)
+		return callback("", remote, key)
+	}
+
+	node := kubedeployer.Node{Name: "kc1prodleader", ContractID: 10, Flist: "k3s-v1.flist"}
+	key := newTestHostKey(t)
+	require.NoError(t, connect(node, key))
+
+	// an upgrade boots a new VM with another host key under the same contract
+	node.Flist = "k3s-v2.flist"
+	upgraded := newTestHostKey(t)
+	var changeErr *HostKeyChangedError
+	require.ErrorAs(t, connect(node, upgraded), &changeErr)
+
+	require.NoError(t, ForgetHostKey(node.Name))
+	require.NoError(t, connect(node, upgraded), "the kubeconfig of the upgraded node can be fetched")
+
+	trusted, err := db.GetNodeHostKey(node.Name)
+	require.NoError(t, err)
+	require.Equal(t, ssh.FingerprintSHA256(upgraded), trusted.Fingerprint)
+	require.Nil(t, trusted.ChangedAt)
+
+	// the key of the previous VM is rejected now
+	require.Error(t, connect(node, key))
+}
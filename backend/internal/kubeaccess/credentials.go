@@ -0,0 +1,346 @@
+package kubeaccess
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// RoleClusterAdmin is only granted to the owner credential of a cluster
+	RoleClusterAdmin = "cluster-admin"
+	RoleAdmin        = "admin"
+	RoleEdit         = "edit"
+	RoleView         = "view"
+
+	KindToken       = "token"
+	KindCertificate = "certificate"
+
+	// Namespace holds the service accounts of the token credentials
+	Namespace = "kubecloud-access"
+
+	managedByLabel       = "app.kubernetes.io/managed-by"
+	managedByValue       = "kubecloud"
+	certificateCNPrefix  = "kubecloud:"
+	csrIssueTimeout      = 30 * time.Second
+	csrIssuePollInterval = time.Second
+)
+
+// UserRoles are the roles a user can grant to the credentials they create
+var UserRoles = []string{RoleAdmin, RoleEdit, RoleView}
+
+// Spec describes a credential to issue
+type Spec struct {
+	Role string
+	Kind string
+	// Namespace restricts the role to a namespace, the role applies to the whole cluster when empty
+	Namespace string
+	TTL       time.Duration
+}
+
+// Credential is an issued credential, Subject identifies it in the cluster to revoke it later
+type Credential struct {
+	Subject    string
+	Kubeconfig string
+	ExpiresAt  time.Time
+}
+
+// Issuer mints and revokes credentials through the API server of a cluster using its admin kubeconfig
+type Issuer struct {
+	clientset   kubernetes.Interface
+	adminConfig *clientcmdapi.Config
+}
+
+// NewIssuer creates an issuer acting with the given admin kubeconfig
+func NewIssuer(adminKubeconfig string) (*Issuer, error) {
+	adminConfig, err := clientcmd.Load([]byte(adminKubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse admin kubeconfig: %w", err)
+	}
+
+	restConfig, err := clientcmd.NewDefaultClientConfig(*adminConfig, nil).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build client config: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	return &Issuer{clientset: clientset, adminConfig: adminConfig}, nil
+}
+
+// ValidateSpec checks the role, kind and TTL of a credential requested by a user
+func ValidateSpec(spec Spec, maxTTL time.Duration) error {
+	if !slices.Contains(UserRoles, spec.Role) {
+		return fmt.Errorf("role must be one of %v", UserRoles)
+	}
+	if spec.Kind != KindToken && spec.Kind != KindCertificate {
+		return fmt.Errorf("kind must be %s or %s", KindToken, KindCertificate)
+	}
+	if spec.TTL <= 0 || spec.TTL > maxTTL {
+		return fmt.Errorf("ttl must be positive and at most %s", maxTTL)
+	}
+	return nil
+}
+
+// Issue creates the identity of a new credential, binds it to its role and returns a kubeconfig for it
+func (i *Issuer) Issue(ctx context.Context, spec Spec) (Credential, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return Credential{}, fmt.Errorf("failed to generate credential name: %w", err)
+	}
+	subject := fmt.Sprintf("kc-%s-%s", spec.Role, hex.EncodeToString(suffix))
+
+	var (
+		credential Credential
+		err        error
+	)
+	switch spec.Kind {
+	case KindToken:
+		credential, err = i.issueToken(ctx, subject, spec)
+	case KindCertificate:
+		credential, err = i.issueCertificate(ctx, subject, spec)
+	default:
+		return Credential{}, fmt.Errorf("unknown credential kind %q", spec.Kind)
+	}
+	if err != nil {
+		// nothing is left behind that could grant access
+		_ = i.Revoke(context.Background(), subject, spec.Kind, spec.Namespace)
+		return Credential{}, err
+	}
+
+	credential.Subject = subject
+	return credential, nil
+}
+
+// Revoke removes the role binding of a credential, and its service account for tokens, which invalidates the tokens.
+// A revoked certificate stays valid until it expires but is not granted any permission anymore.
+func (i *Issuer) Revoke(ctx context.Context, subject, kind, namespace string) error {
+	var err error
+	if namespace != "" {
+		err = i.clientset.RbacV1().RoleBindings(namespace).Delete(ctx, subject, metav1.DeleteOptions{})
+	} else {
+		err = i.clientset.RbacV1().ClusterRoleBindings().Delete(ctx, subject, metav1.DeleteOptions{})
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete role binding %s: %w", subject, err)
+	}
+
+	if kind == KindToken {
+		err := i.clientset.CoreV1().ServiceAccounts(Namespace).Delete(ctx, subject, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete service account %s: %w", subject, err)
+		}
+	}
+
+	return nil
+}
+
+func (i *Issuer) issueToken(ctx context.Context, subject string, spec Spec) (Credential, error) {
+	namespace := &v1.Namespace{ObjectMeta: objectMeta(Namespace, "")}
+	if _, err := i.clientset.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return Credential{}, fmt.Errorf("failed to create namespace %s: %w", Namespace, err)
+	}
+
+	serviceAccount := &v1.ServiceAccount{ObjectMeta: objectMeta(subject, Namespace)}
+	if _, err := i.clientset.CoreV1().ServiceAccounts(Namespace).Create(ctx, serviceAccount, metav1.CreateOptions{}); err != nil {
+		return Credential{}, fmt.Errorf("failed to create service account %s: %w", subject, err)
+	}
+
+	if err := i.bindRole(ctx, subject, spec, rbacv1.Subject{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      subject,
+		Namespace: Namespace,
+	}); err != nil {
+		return Credential{}, err
+	}
+
+	expirationSeconds := int64(spec.TTL.Seconds())
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
+	}
+	token, err := i.clientset.CoreV1().ServiceAccounts(Namespace).CreateToken(ctx, subject, tokenRequest, metav1.CreateOptions{})
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to create token for service account %s: %w", subject, err)
+	}
+
+	kubeconfig, err := i.kubeconfig(subject, &clientcmdapi.AuthInfo{Token: token.Status.Token}, spec.Namespace)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{Kubeconfig: kubeconfig, ExpiresAt: token.Status.ExpirationTimestamp.Time}, nil
+}
+
+func (i *Issuer) issueCertificate(ctx context.Context, subject string, spec Spec) (Credential, error) {
+	commonName := certificateCNPrefix + subject
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	if err := i.bindRole(ctx, subject, spec, rbacv1.Subject{
+		Kind:     rbacv1.UserKind,
+		Name:     commonName,
+		APIGroup: rbacv1.GroupName,
+	}); err != nil {
+		return Credential{}, err
+	}
+
+	expirationSeconds := int32(spec.TTL.Seconds())
+	csr := &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: objectMeta(subject, ""),
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}),
+			SignerName:        certificatesv1.KubeAPIServerClientSignerName,
+			ExpirationSeconds: &expirationSeconds,
+			Usages:            []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth},
+		},
+	}
+
+	csrClient := i.clientset.CertificatesV1().CertificateSigningRequests()
+	csr, err = csrClient.Create(ctx, csr, metav1.CreateOptions{})
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to create certificate signing request: %w", err)
+	}
+	defer func() {
+		_ = csrClient.Delete(context.Background(), subject, metav1.DeleteOptions{})
+	}()
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:    certificatesv1.CertificateApproved,
+		Status:  v1.ConditionTrue,
+		Reason:  "KubecloudIssued",
+		Message: "issued by kubecloud for a user kubeconfig",
+	})
+	if _, err := csrClient.UpdateApproval(ctx, subject, csr, metav1.UpdateOptions{}); err != nil {
+		return Credential{}, fmt.Errorf("failed to approve certificate signing request: %w", err)
+	}
+
+	var certificate []byte
+	err = wait.PollUntilContextTimeout(ctx, csrIssuePollInterval, csrIssueTimeout, true, func(ctx context.Context) (bool, error) {
+		signed, err := csrClient.Get(ctx, subject, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		certificate = signed.Status.Certificate
+		return len(certificate) > 0, nil
+	})
+	if err != nil {
+		return Credential{}, fmt.Errorf("certificate was not issued: %w", err)
+	}
+
+	block, _ := pem.Decode(certificate)
+	if block == nil {
+		return Credential{}, fmt.Errorf("issued certificate is not PEM encoded")
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	kubeconfig, err := i.kubeconfig(subject, &clientcmdapi.AuthInfo{
+		ClientCertificateData: certificate,
+		ClientKeyData:         pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, spec.Namespace)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{Kubeconfig: kubeconfig, ExpiresAt: parsed.NotAfter}, nil
+}
+
+// bindRole grants the built-in cluster role of the spec to the subject, in its namespace or in the whole cluster
+func (i *Issuer) bindRole(ctx context.Context, name string, spec Spec, subject rbacv1.Subject) error {
+	roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: spec.Role}
+
+	var err error
+	if spec.Namespace != "" {
+		binding := &rbacv1.RoleBinding{
+			ObjectMeta: objectMeta(name, spec.Namespace),
+			Subjects:   []rbacv1.Subject{subject},
+			RoleRef:    roleRef,
+		}
+		_, err = i.clientset.RbacV1().RoleBindings(spec.Namespace).Create(ctx, binding, metav1.CreateOptions{})
+	} else {
+		binding := &rbacv1.ClusterRoleBinding{
+			ObjectMeta: objectMeta(name, ""),
+			Subjects:   []rbacv1.Subject{subject},
+			RoleRef:    roleRef,
+		}
+		_, err = i.clientset.RbacV1().ClusterRoleBindings().Create(ctx, binding, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind role %s to %s: %w", spec.Role, name, err)
+	}
+	return nil
+}
+
+// kubeconfig builds a kubeconfig for the credential, reusing the API server and CA of the admin kubeconfig
+func (i *Issuer) kubeconfig(subject string, authInfo *clientcmdapi.AuthInfo, namespace string) (string, error) {
+	adminContext, ok := i.adminConfig.Contexts[i.adminConfig.CurrentContext]
+	if !ok {
+		return "", fmt.Errorf("admin kubeconfig has no current context")
+	}
+	cluster, ok := i.adminConfig.Clusters[adminContext.Cluster]
+	if !ok {
+		return "", fmt.Errorf("admin kubeconfig has no cluster %q", adminContext.Cluster)
+	}
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters[adminContext.Cluster] = cluster
+	config.AuthInfos[subject] = authInfo
+	config.Contexts[subject] = &clientcmdapi.Context{
+		Cluster:   adminContext.Cluster,
+		AuthInfo:  subject,
+		Namespace: namespace,
+	}
+	config.CurrentContext = subject
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return "", fmt.Errorf("failed to write kubeconfig: %w", err)
+	}
+	return string(data), nil
+}
+
+func objectMeta(name, namespace string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels:    map[string]string{managedByLabel: managedByValue},
+	}
+}
@@ -0,0 +1,133 @@
+package kubeaccess
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func testIssuer(t *testing.T, tokenErr error) (*Issuer, *fake.Clientset) {
+	adminConfig := clientcmdapi.NewConfig()
+	adminConfig.Clusters["default"] = &clientcmdapi.Cluster{Server: "https://[400::1]:6443", CertificateAuthorityData: []byte("ca")}
+	adminConfig.AuthInfos["default"] = &clientcmdapi.AuthInfo{Token: "admin-token"}
+	adminConfig.Contexts["default"] = &clientcmdapi.Context{Cluster: "default", AuthInfo: "default"}
+	adminConfig.CurrentContext = "default"
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		if tokenErr != nil {
+			return true, nil, tokenErr
+		}
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{
+			Token:               "issued-token",
+			ExpirationTimestamp: metav1.NewTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)),
+		}}, nil
+	})
+
+	return &Issuer{clientset: clientset, adminConfig: adminConfig}, clientset
+}
+
+func TestValidateSpec(t *testing.T) {
+	maxTTL := 24 * time.Hour
+	require.NoError(t, ValidateSpec(Spec{Role: RoleView, Kind: KindToken, TTL: time.Hour}, maxTTL))
+	require.NoError(t, ValidateSpec(Spec{Role: RoleEdit, Kind: KindCertificate, Namespace: "apps", TTL: maxTTL}, maxTTL))
+
+	require.Error(t, ValidateSpec(Spec{Role: RoleClusterAdmin, Kind: KindToken, TTL: time.Hour}, maxTTL))
+	require.Error(t, ValidateSpec(Spec{Role: RoleView, Kind: "password", TTL: time.Hour}, maxTTL))
+	require.Error(t, ValidateSpec(Spec{Role: RoleView, Kind: KindToken}, maxTTL))
+	require.Error(t, ValidateSpec(Spec{Role: RoleView, Kind: KindToken, TTL: 2 * maxTTL}, maxTTL))
+}
+
+func TestIssueToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("cluster wide", func(t *testing.T) {
+		issuer, clientset := testIssuer(t, nil)
+
+		credential, err := issuer.Issue(ctx, Spec{Role: RoleView, Kind: KindToken, TTL: time.Hour})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(credential.Subject, "kc-view-"))
+		require.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), credential.ExpiresAt.UTC())
+
+		binding, err := clientset.RbacV1().ClusterRoleBindings().Get(ctx, credential.Subject, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, RoleView, binding.RoleRef.Name)
+		require.Equal(t, credential.Subject, binding.Subjects[0].Name)
+		require.Equal(t, Namespace, binding.Subjects[0].Namespace)
+
+		_, err = clientset.CoreV1().ServiceAccounts(Namespace).Get(ctx, credential.Subject, metav1.GetOptions{})
+		require.NoError(t, err)
+
+		config, err := clientcmd.Load([]byte(credential.Kubeconfig))
+		require.NoError(t, err)
+		require.Equal(t, credential.Subject, config.CurrentContext)
+		require.Equal(t, "issued-token", config.AuthInfos[credential.Subject].Token)
+		require.Equal(t, "https://[400::1]:6443", config.Clusters["default"].Server)
+		require.Equal(t, []byte("ca"), config.Clusters["default"].CertificateAuthorityData)
+		require.NotContains(t, credential.Kubeconfig, "admin-token")
+	})
+
+	t.Run("namespaced", func(t *testing.T) {
+		issuer, clientset := testIssuer(t, nil)
+
+		credential, err := issuer.Issue(ctx, Spec{Role: RoleEdit, Kind: KindToken, Namespace: "apps", TTL: time.Hour})
+		require.NoError(t, err)
+
+		binding, err := clientset.RbacV1().RoleBindings("apps").Get(ctx, credential.Subject, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, RoleEdit, binding.RoleRef.Name)
+
+		bindings, err := clientset.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, bindings.Items)
+
+		config, err := clientcmd.Load([]byte(credential.Kubeconfig))
+		require.NoError(t, err)
+		require.Equal(t, "apps", config.Contexts[credential.Subject].Namespace)
+	})
+
+	t.Run("a failed issue leaves nothing behind", func(t *testing.T) {
+		issuer, clientset := testIssuer(t, errors.New("token requests are disabled"))
+
+		_, err := issuer.Issue(ctx, Spec{Role: RoleView, Kind: KindToken, TTL: time.Hour})
+		require.ErrorContains(t, err, "token requests are disabled")
+
+		bindings, err := clientset.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, bindings.Items)
+		accounts, err := clientset.CoreV1().ServiceAccounts(Namespace).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, accounts.Items)
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	issuer, clientset := testIssuer(t, nil)
+
+	credential, err := issuer.Issue(ctx, Spec{Role: RoleAdmin, Kind: KindToken, TTL: time.Hour})
+	require.NoError(t, err)
+
+	require.NoError(t, issuer.Revoke(ctx, credential.Subject, KindToken, ""))
+	_, err = clientset.RbacV1().ClusterRoleBindings().Get(ctx, credential.Subject, metav1.GetOptions{})
+	require.Error(t, err)
+	_, err = clientset.CoreV1().ServiceAccounts(Namespace).Get(ctx, credential.Subject, metav1.GetOptions{})
+	require.Error(t, err)
+
+	// revoking again is not an error
+	require.NoError(t, issuer.Revoke(ctx, credential.Subject, KindToken, ""))
+}
@@ -0,0 +1,396 @@
+// Package kubestats gathers the resource usage and workload state of a cluster from its API server
+package kubestats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// MaxEvents is the number of most recent events returned in an overview
+	MaxEvents = 50
+
+	nodeMetricsPath = "/apis/metrics.k8s.io/v1beta1/nodes"
+)
+
+// Overview is a snapshot of the resource usage and workloads of a cluster
+type Overview struct {
+	// MetricsAvailable is false when metrics-server is not running, node usage is then left at zero
+	MetricsAvailable bool            `json:"metrics_available"`
+	Nodes            []NodeUsage     `json:"nodes"`
+	Namespaces       []NamespacePods `json:"namespaces"`
+	FailingPods      []FailingPod    `json:"failing_pods"`
+	Volumes          []VolumeUsage   `json:"volumes"`
+	Events           []Event         `json:"events"`
+	CollectedAt      time.Time       `json:"collected_at"`
+}
+
+// NodeUsage is the CPU and memory usage of a node against its allocatable resources
+type NodeUsage struct {
+	Name                     string `json:"name"`
+	Ready                    bool   `json:"ready"`
+	Unschedulable            bool   `json:"unschedulable"`
+	CPUUsageMillicores       int64  `json:"cpu_usage_millicores"`
+	CPUAllocatableMillicores int64  `json:"cpu_allocatable_millicores"`
+	MemoryUsageBytes         int64  `json:"memory_usage_bytes"`
+	MemoryAllocatableBytes   int64  `json:"memory_allocatable_bytes"`
+}
+
+// NamespacePods counts the pods of a namespace by phase
+type NamespacePods struct {
+	Namespace string `json:"namespace"`
+	Total     int    `json:"total"`
+	Running   int    `json:"running"`
+	Pending   int    `json:"pending"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+}
+
+// FailingPod is a pod that failed, can't be scheduled or has a container that can't start
+type FailingPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Node      string `json:"node,omitempty"`
+	Phase     string `json:"phase"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message,omitempty"`
+	Restarts  int32  `json:"restarts"`
+}
+
+// VolumeUsage is the capacity of a persistent volume claim, and its usage as reported by the kubelet of the node mounting it
+type VolumeUsage struct {
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	Phase         string `json:"phase"`
+	StorageClass  string `json:"storage_class,omitempty"`
+	CapacityBytes int64  `json:"capacity_bytes"`
+	// UsedBytes is nil when the volume is not mounted by a running pod
+	UsedBytes *int64 `json:"used_bytes,omitempty"`
+}
+
+// Event is a Kubernetes event about an object of the cluster
+type Event struct {
+	Namespace string    `json:"namespace"`
+	Object    string    `json:"object"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// container waiting reasons that are part of a normal start
+var startingReasons = map[string]bool{
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+}
+
+// Collect gathers the overview of the cluster of a kubeconfig
+func Collect(ctx context.Context, kubeconfig string) (Overview, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return Overview{}, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return Overview{}, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Overview{}, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Overview{}, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Overview{}, fmt.Errorf("failed to list persistent volume claims: %w", err)
+	}
+
+	events, err := clientset.CoreV1().Events(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Overview{}, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	usage, metricsErr := nodeMetrics(ctx, clientset)
+	volumeUsage := make(map[string]int64)
+	for _, node := range nodes.Items {
+		if !isNodeReady(&node) {
+			continue
+		}
+		// a node whose kubelet can't be reached only loses the usage of its volumes
+		_ = kubeletVolumeUsage(ctx, clientset, node.Name, volumeUsage)
+	}
+
+	return Overview{
+		MetricsAvailable: metricsErr == nil,
+		Nodes:            summarizeNodes(nodes.Items, usage),
+		Namespaces:       summarizePods(pods.Items),
+		FailingPods:      failingPods(pods.Items),
+		Volumes:          summarizeVolumes(pvcs.Items, volumeUsage),
+		Events:           recentEvents(events.Items, MaxEvents),
+		CollectedAt:      time.Now().UTC(),
+	}, nil
+}
+
+// nodeMetrics returns the CPU and memory usage of each node as reported by metrics-server
+func nodeMetrics(ctx context.Context, clientset kubernetes.Interface) (map[string]v1.ResourceList, error) {
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath(nodeMetricsPath).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node metrics: %w", err)
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+			Usage    v1.ResourceList   `json:"usage"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode node metrics: %w", err)
+	}
+
+	usage := make(map[string]v1.ResourceList, len(list.Items))
+	for _, item := range list.Items {
+		usage[item.Metadata.Name] = item.Usage
+	}
+	return usage, nil
+}
+
+// kubeletVolumeUsage adds the used bytes of the persistent volume claims mounted on a node, keyed by namespace/name
+func kubeletVolumeUsage(ctx context.Context, clientset kubernetes.Interface, nodeName string, usage map[string]int64) error {
+	data, err := clientset.CoreV1().RESTClient().Get().
+		Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get stats summary of node %s: %w", nodeName, err)
+	}
+
+	var summary struct {
+		Pods []struct {
+			Volumes []struct {
+				UsedBytes *uint64 `json:"usedBytes"`
+				PVCRef    *struct {
+					Name      string `json:"name"`
+					Namespace string `json:"namespace"`
+				} `json:"pvcRef"`
+			} `json:"volume"`
+		} `json:"pods"`
+	}
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return fmt.Errorf("failed to decode stats summary of node %s: %w", nodeName, err)
+	}
+
+	for _, pod := range summary.Pods {
+		for _, volume := range pod.Volumes {
+			if volume.PVCRef == nil || volume.UsedBytes == nil {
+				continue
+			}
+			usage[volume.PVCRef.Namespace+"/"+volume.PVCRef.Name] = int64(*volume.UsedBytes)
+		}
+	}
+	return nil
+}
+
+func summarizeNodes(nodes []v1.Node, usage map[string]v1.ResourceList) []NodeUsage {
+	result := make([]NodeUsage, 0, len(nodes))
+	for _, node := range nodes {
+		nodeUsage := NodeUsage{
+			Name:                     node.Name,
+			Ready:                    isNodeReady(&node),
+			Unschedulable:            node.Spec.Unschedulable,
+			CPUAllocatableMillicores: node.Status.Allocatable.Cpu().MilliValue(),
+			MemoryAllocatableBytes:   node.Status.Allocatable.Memory().Value(),
+		}
+		if resources, ok := usage[node.Name]; ok {
+			nodeUsage.CPUUsageMillicores = resources.Cpu().MilliValue()
+			nodeUsage.MemoryUsageBytes = resources.Memory().Value()
+		}
+		result = append(result, nodeUsage)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func summarizePods(pods []v1.Pod) []NamespacePods {
+	byNamespace := make(map[string]*NamespacePods)
+	for _, pod := range pods {
+		counts, ok := byNamespace[pod.Namespace]
+		if !ok {
+			counts = &NamespacePods{Namespace: pod.Namespace}
+			byNamespace[pod.Namespace] = counts
+		}
+
+		counts.Total++
+		switch pod.Status.Phase {
+		case v1.PodRunning:
+			counts.Running++
+		case v1.PodPending:
+			counts.Pending++
+		case v1.PodSucceeded:
+			counts.Succeeded++
+		case v1.PodFailed:
+			counts.Failed++
+		}
+	}
+
+	result := make([]NamespacePods, 0, len(byNamespace))
+	for _, counts := range byNamespace {
+		result = append(result, *counts)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Namespace < result[j].Namespace })
+	return result
+}
+
+func failingPods(pods []v1.Pod) []FailingPod {
+	result := []FailingPod{}
+	for _, pod := range pods {
+		reason, message, failing := podFailure(&pod)
+		if !failing {
+			continue
+		}
+
+		var restarts int32
+		for _, status := range pod.Status.ContainerStatuses {
+			restarts += status.RestartCount
+		}
+
+		result = append(result, FailingPod{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Node:      pod.Spec.NodeName,
+			Phase:     string(pod.Status.Phase),
+			Reason:    reason,
+			Message:   message,
+			Restarts:  restarts,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// podFailure reports why a pod is failing: it failed, can't be scheduled or one of its containers can't start
+func podFailure(pod *v1.Pod) (reason, message string, failing bool) {
+	switch pod.Status.Phase {
+	case v1.PodSucceeded:
+		return "", "", false
+	case v1.PodFailed, v1.PodUnknown:
+		reason = pod.Status.Reason
+		if reason == "" {
+			reason = string(pod.Status.Phase)
+		}
+		return reason, pod.Status.Message, true
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse {
+			return condition.Reason, condition.Message, true
+		}
+	}
+
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil && !startingReasons[waiting.Reason] {
+			return waiting.Reason, waiting.Message, true
+		}
+	}
+
+	return "", "", false
+}
+
+func summarizeVolumes(pvcs []v1.PersistentVolumeClaim, usage map[string]int64) []VolumeUsage {
+	result := make([]VolumeUsage, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]
+		if !ok {
+			capacity = pvc.Spec.Resources.Requests[v1.ResourceStorage]
+		}
+
+		volume := VolumeUsage{
+			Namespace:     pvc.Namespace,
+			Name:          pvc.Name,
+			Phase:         string(pvc.Status.Phase),
+			CapacityBytes: capacity.Value(),
+		}
+		if pvc.Spec.StorageClassName != nil {
+			volume.StorageClass = *pvc.Spec.StorageClassName
+		}
+		if used, ok := usage[pvc.Namespace+"/"+pvc.Name]; ok {
+			volume.UsedBytes = &used
+		}
+		result = append(result, volume)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// recentEvents returns the most recent events first
+func recentEvents(events []v1.Event, limit int) []Event {
+	result := make([]Event, 0, len(events))
+	for _, event := range events {
+		result = append(result, Event{
+			Namespace: event.Namespace,
+			Object:    fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+			Type:      event.Type,
+			Reason:    event.Reason,
+			Message:   event.Message,
+			Count:     event.Count,
+			LastSeen:  eventTime(&event),
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].LastSeen.After(result[j].LastSeen) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// eventTime returns when an event was last seen, events recorded through the events API only set the event time
+func eventTime(event *v1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.FirstTimestamp.Time
+	}
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady && cond.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
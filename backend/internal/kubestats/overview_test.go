@@ -0,0 +1,132 @@
+package kubestats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPodFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  v1.PodStatus
+		reason  string
+		failing bool
+	}{
+		{
+			name:   "running",
+			status: v1.PodStatus{Phase: v1.PodRunning},
+		},
+		{
+			name:   "succeeded",
+			status: v1.PodStatus{Phase: v1.PodSucceeded},
+		},
+		{
+			name:    "failed",
+			status:  v1.PodStatus{Phase: v1.PodFailed, Reason: "Evicted"},
+			reason:  "Evicted",
+			failing: true,
+		},
+		{
+			name: "unschedulable",
+			status: v1.PodStatus{
+				Phase:      v1.PodPending,
+				Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: "Unschedulable"}},
+			},
+			reason:  "Unschedulable",
+			failing: true,
+		},
+		{
+			name: "creating container",
+			status: v1.PodStatus{
+				Phase:             v1.PodPending,
+				ContainerStatuses: []v1.ContainerStatus{{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}}},
+			},
+		},
+		{
+			name: "crash loop",
+			status: v1.PodStatus{
+				Phase:             v1.PodRunning,
+				ContainerStatuses: []v1.ContainerStatus{{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}},
+			},
+			reason:  "CrashLoopBackOff",
+			failing: true,
+		},
+		{
+			name: "init image pull",
+			status: v1.PodStatus{
+				Phase:                 v1.PodPending,
+				InitContainerStatuses: []v1.ContainerStatus{{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}},
+			},
+			reason:  "ImagePullBackOff",
+			failing: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, _, failing := podFailure(&v1.Pod{Status: tt.status})
+			assert.Equal(t, tt.failing, failing)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+func TestSummarizePods(t *testing.T) {
+	pod := func(namespace string, phase v1.PodPhase) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}, Status: v1.PodStatus{Phase: phase}}
+	}
+
+	namespaces := summarizePods([]v1.Pod{
+		pod("kube-system", v1.PodRunning),
+		pod("default", v1.PodPending),
+		pod("kube-system", v1.PodSucceeded),
+		pod("default", v1.PodRunning),
+	})
+
+	assert.Equal(t, []NamespacePods{
+		{Namespace: "default", Total: 2, Running: 1, Pending: 1},
+		{Namespace: "kube-system", Total: 2, Running: 1, Succeeded: 1},
+	}, namespaces)
+}
+
+func TestRecentEvents(t *testing.T) {
+	now := time.Now()
+	events := []v1.Event{
+		{Reason: "old", LastTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+		{Reason: "new", EventTime: metav1.NewMicroTime(now)},
+		{Reason: "middle", FirstTimestamp: metav1.NewTime(now.Add(-time.Minute))},
+	}
+
+	recent := recentEvents(events, 2)
+	assert.Len(t, recent, 2)
+	assert.Equal(t, "new", recent[0].Reason)
+	assert.Equal(t, "middle", recent[1].Reason)
+}
+
+func TestOOMKilledContainers(t *testing.T) {
+	terminated := func(reason string, finishedAt time.Time) v1.ContainerStatus {
+		return v1.ContainerStatus{
+			Name:                 "app",
+			LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: reason, FinishedAt: metav1.NewTime(finishedAt)}},
+		}
+	}
+	pod := func(statuses ...v1.ContainerStatus) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "pod"}, Status: v1.PodStatus{ContainerStatuses: statuses}}
+	}
+
+	now := time.Now().Truncate(time.Second)
+	terminations := make(map[types.UID]map[string]time.Time)
+
+	// terminations that happened before the pod was first seen are not reported
+	assert.Empty(t, oomKilledContainers(pod(terminated(ReasonOOMKilled, now.Add(-time.Hour))), terminations))
+	assert.Empty(t, oomKilledContainers(pod(terminated(ReasonOOMKilled, now.Add(-time.Hour))), terminations))
+
+	assert.Equal(t, []string{"app"}, oomKilledContainers(pod(terminated(ReasonOOMKilled, now)), terminations))
+	assert.Empty(t, oomKilledContainers(pod(terminated(ReasonOOMKilled, now)), terminations))
+	assert.Empty(t, oomKilledContainers(pod(terminated("Error", now.Add(time.Minute))), terminations))
+}
@@ -0,0 +1,280 @@
+package kubestats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+const (
+	// Reasons of the events derived from node and pod changes
+	ReasonNodeNotReady = "NodeNotReady"
+	ReasonNodeReady    = "NodeReady"
+	ReasonOOMKilled    = "OOMKilled"
+
+	KindEvent = "event"
+	KindNode  = "node"
+	KindPod   = "pod"
+
+	relistDelay = 5 * time.Second
+)
+
+// reasons of Kubernetes events that are reported as important
+var importantEventReasons = map[string]bool{
+	"Evicted":              true,
+	"TaintManagerEviction": true,
+	"OOMKilling":           true,
+}
+
+// ClusterEvent is a Kubernetes event, or a node or pod change worth reporting, of a watched cluster
+type ClusterEvent struct {
+	Kind      string    `json:"kind"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Namespace string    `json:"namespace,omitempty"`
+	Object    string    `json:"object"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+	// Important events are a node becoming NotReady, a container killed for running out of memory or a pod eviction
+	Important bool `json:"important"`
+}
+
+// Watch reports the Kubernetes events of the cluster of a kubeconfig, the readiness changes of its nodes and the
+// containers killed for running out of memory, until ctx is done. Only changes happening after Watch is called are reported.
+// An error is returned when the cluster can't be reached at first, later failures are retried.
+func Watch(ctx context.Context, kubeconfig string, report func(ClusterEvent)) error {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error { return watchEvents(ctx, clientset, report) })
+	group.Go(func() error { return watchNodes(ctx, clientset, report) })
+	group.Go(func() error { return watchPods(ctx, clientset, report) })
+	return group.Wait()
+}
+
+// watchResource lists a resource then watches it from the listed version, listing again when the watch expires.
+// list primes the state of the caller and returns the resource version to watch from.
+func watchResource(ctx context.Context, list func(ctx context.Context) (string, error), watchFunc cache.WatchFuncWithContext, handle func(watch.Event)) error {
+	first := true
+	for {
+		resourceVersion, err := list(ctx)
+		if err != nil && first {
+			return err
+		}
+
+		if err == nil {
+			watcher, err := watchtools.NewRetryWatcherWithContext(ctx, resourceVersion, &cache.ListWatch{WatchFuncWithContext: watchFunc})
+			if err != nil && first {
+				return err
+			}
+			if err == nil {
+				for event := range watcher.ResultChan() {
+					// the resource version expired, the state is listed again
+					if event.Type == watch.Error {
+						break
+					}
+					handle(event)
+				}
+				watcher.Stop()
+			}
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(relistDelay):
+		}
+	}
+}
+
+func watchEvents(ctx context.Context, clientset kubernetes.Interface, report func(ClusterEvent)) error {
+	events := clientset.CoreV1().Events(metav1.NamespaceAll)
+
+	return watchResource(ctx,
+		func(ctx context.Context) (string, error) {
+			list, err := events.List(ctx, metav1.ListOptions{Limit: 1})
+			if err != nil {
+				return "", fmt.Errorf("failed to list events: %w", err)
+			}
+			return list.ResourceVersion, nil
+		},
+		events.Watch,
+		func(watchEvent watch.Event) {
+			event, ok := watchEvent.Object.(*v1.Event)
+			if !ok || watchEvent.Type == watch.Deleted {
+				return
+			}
+			report(ClusterEvent{
+				Kind:      KindEvent,
+				Type:      event.Type,
+				Reason:    event.Reason,
+				Namespace: event.Namespace,
+				Object:    fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+				Message:   event.Message,
+				Time:      eventTime(event),
+				// a repeated event is only important the first time
+				Important: watchEvent.Type == watch.Added && importantEventReasons[event.Reason],
+			})
+		},
+	)
+}
+
+func watchNodes(ctx context.Context, clientset kubernetes.Interface, report func(ClusterEvent)) error {
+	nodes := clientset.CoreV1().Nodes()
+	ready := make(map[string]bool)
+
+	return watchResource(ctx,
+		func(ctx context.Context) (string, error) {
+			list, err := nodes.List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return "", fmt.Errorf("failed to list nodes: %w", err)
+			}
+			clear(ready)
+			for _, node := range list.Items {
+				ready[node.Name] = isNodeReady(&node)
+			}
+			return list.ResourceVersion, nil
+		},
+		nodes.Watch,
+		func(watchEvent watch.Event) {
+			node, ok := watchEvent.Object.(*v1.Node)
+			if !ok {
+				return
+			}
+			if watchEvent.Type == watch.Deleted {
+				delete(ready, node.Name)
+				return
+			}
+
+			wasReady, known := ready[node.Name]
+			nowReady := isNodeReady(node)
+			ready[node.Name] = nowReady
+			if !known || wasReady == nowReady {
+				return
+			}
+
+			event := ClusterEvent{
+				Kind:    KindNode,
+				Type:    v1.EventTypeNormal,
+				Reason:  ReasonNodeReady,
+				Object:  "Node/" + node.Name,
+				Message: fmt.Sprintf("Node %s is Ready", node.Name),
+				Time:    time.Now().UTC(),
+			}
+			if !nowReady {
+				event.Type = v1.EventTypeWarning
+				event.Reason = ReasonNodeNotReady
+				event.Message = fmt.Sprintf("Node %s is NotReady: %s", node.Name, nodeReadyMessage(node))
+				event.Important = true
+			}
+			report(event)
+		},
+	)
+}
+
+func watchPods(ctx context.Context, clientset kubernetes.Interface, report func(ClusterEvent)) error {
+	pods := clientset.CoreV1().Pods(metav1.NamespaceAll)
+	// the last termination seen of each container, by pod and container name
+	terminations := make(map[types.UID]map[string]time.Time)
+
+	return watchResource(ctx,
+		func(ctx context.Context) (string, error) {
+			list, err := pods.List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return "", fmt.Errorf("failed to list pods: %w", err)
+			}
+			clear(terminations)
+			for _, pod := range list.Items {
+				oomKilledContainers(&pod, terminations)
+			}
+			return list.ResourceVersion, nil
+		},
+		pods.Watch,
+		func(watchEvent watch.Event) {
+			pod, ok := watchEvent.Object.(*v1.Pod)
+			if !ok {
+				return
+			}
+			if watchEvent.Type == watch.Deleted {
+				delete(terminations, pod.UID)
+				return
+			}
+
+			for _, container := range oomKilledContainers(pod, terminations) {
+				report(ClusterEvent{
+					Kind:      KindPod,
+					Type:      v1.EventTypeWarning,
+					Reason:    ReasonOOMKilled,
+					Namespace: pod.Namespace,
+					Object:    "Pod/" + pod.Name,
+					Message:   fmt.Sprintf("Container %s of pod %s/%s was killed for running out of memory", container, pod.Namespace, pod.Name),
+					Time:      time.Now().UTC(),
+					Important: true,
+				})
+			}
+		},
+	)
+}
+
+// oomKilledContainers records the last termination of the containers of a pod and returns the ones newly killed for running out of memory
+func oomKilledContainers(pod *v1.Pod, terminations map[types.UID]map[string]time.Time) []string {
+	seen, ok := terminations[pod.UID]
+	if !ok {
+		seen = make(map[string]time.Time)
+		terminations[pod.UID] = seen
+	}
+
+	var killed []string
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		terminated := status.State.Terminated
+		if terminated == nil {
+			terminated = status.LastTerminationState.Terminated
+		}
+		if terminated == nil {
+			continue
+		}
+
+		finishedAt := terminated.FinishedAt.Time
+		previous, known := seen[status.Name]
+		seen[status.Name] = finishedAt
+		if known && previous.Equal(finishedAt) {
+			continue
+		}
+		if ok && terminated.Reason == ReasonOOMKilled {
+			killed = append(killed, status.Name)
+		}
+	}
+	return killed
+}
+
+func nodeReadyMessage(node *v1.Node) string {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			if cond.Message != "" {
+				return cond.Message
+			}
+			return cond.Reason
+		}
+	}
+	return "no Ready condition reported"
+}
@@ -27,6 +27,16 @@ type Metrics struct {
 	clusterDeploymentFailures  prometheus.Counter
 	activeClusterCount         prometheus.Gauge
 
+	// Cluster resource metrics, labelled by user and cluster
+	clusterNodeCPUUsage          *prometheus.GaugeVec
+	clusterNodeCPUAllocatable    *prometheus.GaugeVec
+	clusterNodeMemoryUsage       *prometheus.GaugeVec
+	clusterNodeMemoryAllocatable *prometheus.GaugeVec
+	clusterPods                  *prometheus.GaugeVec
+	clusterFailingPods           *prometheus.GaugeVec
+	clusterVolumeCapacity        *prometheus.GaugeVec
+	clusterVolumeUsed            *prometheus.GaugeVec
+
 	// User metrics
 	userRegistrations prometheus.Counter
 
@@ -102,6 +112,64 @@ func NewMetrics() *Metrics {
 			},
 		),
 
+		// Cluster resource metrics
+		clusterNodeCPUUsage: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_node_cpu_usage_cores",
+				Help: "CPU used by a cluster node in cores",
+			},
+			[]string{"user_id", "cluster", "node"},
+		),
+		clusterNodeCPUAllocatable: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_node_cpu_allocatable_cores",
+				Help: "CPU allocatable on a cluster node in cores",
+			},
+			[]string{"user_id", "cluster", "node"},
+		),
+		clusterNodeMemoryUsage: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_node_memory_usage_bytes",
+				Help: "Memory used by a cluster node in bytes",
+			},
+			[]string{"user_id", "cluster", "node"},
+		),
+		clusterNodeMemoryAllocatable: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_node_memory_allocatable_bytes",
+				Help: "Memory allocatable on a cluster node in bytes",
+			},
+			[]string{"user_id", "cluster", "node"},
+		),
+		clusterPods: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_pods",
+				Help: "Number of pods in a cluster namespace by phase",
+			},
+			[]string{"user_id", "cluster", "namespace", "phase"},
+		),
+		clusterFailingPods: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_failing_pods",
+				Help: "Number of failing pods in a cluster",
+			},
+			[]string{"user_id", "cluster"},
+		),
+		clusterVolumeCapacity: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_volume_capacity_bytes",
+				Help: "Capacity of a cluster persistent volume claim in bytes",
+			},
+			[]string{"user_id", "cluster", "namespace", "pvc"},
+		),
+		clusterVolumeUsed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cluster_volume_used_bytes",
+				Help: "Bytes used on a cluster persistent volume claim",
+			},
+			[]string{"user_id", "cluster", "namespace", "pvc"},
+		),
+
 		// User metrics
 		userRegistrations: prometheus.NewCounter(
 			prometheus.CounterOpts{
@@ -163,6 +231,14 @@ func NewMetrics() *Metrics {
 		m.clusterDeploymentSuccesses,
 		m.clusterDeploymentFailures,
 		m.activeClusterCount,
+		m.clusterNodeCPUUsage,
+		m.clusterNodeCPUAllocatable,
+		m.clusterNodeMemoryUsage,
+		m.clusterNodeMemoryAllocatable,
+		m.clusterPods,
+		m.clusterFailingPods,
+		m.clusterVolumeCapacity,
+		m.clusterVolumeUsed,
 		m.userRegistrations,
 		m.stripePaymentSuccesses,
 		m.stripePaymentFailures,
@@ -244,6 +320,45 @@ func (m *Metrics) DecActiveClusterCount() {
 	m.activeClusterCount.Dec()
 }
 
+// ResetClusterResources removes the resource metrics of a cluster, before they are set again or once it is deleted
+func (m *Metrics) ResetClusterResources(userID, cluster string) {
+	labels := prometheus.Labels{"user_id": userID, "cluster": cluster}
+	m.clusterNodeCPUUsage.DeletePartialMatch(labels)
+	m.clusterNodeCPUAllocatable.DeletePartialMatch(labels)
+	m.clusterNodeMemoryUsage.DeletePartialMatch(labels)
+	m.clusterNodeMemoryAllocatable.DeletePartialMatch(labels)
+	m.clusterPods.DeletePartialMatch(labels)
+	m.clusterFailingPods.DeletePartialMatch(labels)
+	m.clusterVolumeCapacity.DeletePartialMatch(labels)
+	m.clusterVolumeUsed.DeletePartialMatch(labels)
+}
+
+// SetClusterNodeUsage sets the CPU and memory usage and allocatable resources of a cluster node
+func (m *Metrics) SetClusterNodeUsage(userID, cluster, node string, cpuCores, cpuAllocatableCores, memoryBytes, memoryAllocatableBytes float64) {
+	m.clusterNodeCPUUsage.WithLabelValues(userID, cluster, node).Set(cpuCores)
+	m.clusterNodeCPUAllocatable.WithLabelValues(userID, cluster, node).Set(cpuAllocatableCores)
+	m.clusterNodeMemoryUsage.WithLabelValues(userID, cluster, node).Set(memoryBytes)
+	m.clusterNodeMemoryAllocatable.WithLabelValues(userID, cluster, node).Set(memoryAllocatableBytes)
+}
+
+// SetClusterPods sets the number of pods of a cluster namespace in a phase
+func (m *Metrics) SetClusterPods(userID, cluster, namespace, phase string, count int) {
+	m.clusterPods.WithLabelValues(userID, cluster, namespace, phase).Set(float64(count))
+}
+
+// SetClusterFailingPods sets the number of failing pods of a cluster
+func (m *Metrics) SetClusterFailingPods(userID, cluster string, count int) {
+	m.clusterFailingPods.WithLabelValues(userID, cluster).Set(float64(count))
+}
+
+// SetClusterVolume sets the capacity of a cluster persistent volume claim, and its usage when known
+func (m *Metrics) SetClusterVolume(userID, cluster, namespace, pvc string, capacityBytes float64, usedBytes *float64) {
+	m.clusterVolumeCapacity.WithLabelValues(userID, cluster, namespace, pvc).Set(capacityBytes)
+	if usedBytes != nil {
+		m.clusterVolumeUsed.WithLabelValues(userID, cluster, namespace, pvc).Set(*usedBytes)
+	}
+}
+
 // IncrementUserRegistration increments the user registration counter
 func (m *Metrics) IncrementUserRegistration() {
 	m.userRegistrations.Inc()
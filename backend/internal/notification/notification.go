@@ -153,7 +153,8 @@ func (s *NotificationService) Send(ctx context.Context, notification *models.Not
 	}
 
 	workflow.State["notification"] = notification
-	s.engine.RunAsync(ctx, workflow)
+	// the notification outlives the caller, e.g. the hooks of a cancelled workflow
+	s.engine.RunAsync(context.WithoutCancel(ctx), workflow)
 	return nil
 }
 
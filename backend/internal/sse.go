@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"kubecloud/models"
 	"net/http"
@@ -21,12 +22,30 @@ const (
 	Error   = "error"
 )
 
+// ClusterEventSource provides the Kubernetes events of the clusters users subscribe to
+type ClusterEventSource interface {
+	// HasCluster reports whether the user has a deployment with this name
+	HasCluster(userID int, cluster string) (bool, error)
+	// WatchCluster sends the events of a cluster of the user until ctx is done
+	WatchCluster(ctx context.Context, userID int, cluster string, send func(SSEMessage))
+}
+
+// clusterSubscription is the watcher of a cluster shared by its subscribed clients
+type clusterSubscription struct {
+	clients []chan SSEMessage
+	cancel  context.CancelFunc
+}
+
 // SSEManager handles Server-Sent Events for real-time notifications
 type SSEManager struct {
 	clients map[int][]chan SSEMessage // userID -> client channels
 	mu      sync.RWMutex
 	ctx     context.Context
 	cancel  context.CancelFunc
+
+	clusterSource ClusterEventSource
+	clusterSubs   map[string]*clusterSubscription // userID/cluster -> subscription
+	clusterMu     sync.RWMutex
 }
 
 // SSEMessage represents a server-sent event message
@@ -43,9 +62,10 @@ type SSEMessage struct {
 func NewSSEManager() *SSEManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	manager := &SSEManager{
-		clients: make(map[int][]chan SSEMessage),
-		ctx:     ctx,
-		cancel:  cancel,
+		clients:     make(map[int][]chan SSEMessage),
+		ctx:         ctx,
+		cancel:      cancel,
+		clusterSubs: make(map[string]*clusterSubscription),
 	}
 
 	return manager
@@ -66,6 +86,23 @@ func (s *SSEManager) Stop() {
 		delete(s.clients, userID)
 	}
 
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+
+	for key, sub := range s.clusterSubs {
+		sub.cancel()
+		for _, ch := range sub.clients {
+			close(ch)
+		}
+		delete(s.clusterSubs, key)
+	}
+}
+
+// SetClusterEventSource sets the source of the cluster events clients can subscribe to
+func (s *SSEManager) SetClusterEventSource(source ClusterEventSource) {
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+	s.clusterSource = source
 }
 
 // AddClient adds a new client channel for a user
@@ -98,6 +135,84 @@ func (s *SSEManager) RemoveClient(userID int, clientChan chan SSEMessage) {
 	}
 }
 
+// AddClusterClient adds a client channel receiving the events of a cluster of a user.
+// The cluster is watched from its first client until its last client is removed.
+func (s *SSEManager) AddClusterClient(userID int, cluster string) chan SSEMessage {
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+
+	key := clusterSubscriptionKey(userID, cluster)
+	sub, ok := s.clusterSubs[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(s.ctx)
+		sub = &clusterSubscription{cancel: cancel}
+		s.clusterSubs[key] = sub
+
+		go s.clusterSource.WatchCluster(ctx, userID, cluster, func(message SSEMessage) {
+			s.notifyCluster(userID, cluster, sub, message)
+		})
+		logger.GetLogger().Debug().Int("user_id", userID).Str("cluster", cluster).Msg("Started watching cluster events")
+	}
+
+	ch := make(chan SSEMessage, 10)
+	sub.clients = append(sub.clients, ch)
+
+	return ch
+}
+
+// RemoveClusterClient removes a client channel of a cluster, its watcher is stopped when it was the last one
+func (s *SSEManager) RemoveClusterClient(userID int, cluster string, clientChan chan SSEMessage) {
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+
+	key := clusterSubscriptionKey(userID, cluster)
+	sub, ok := s.clusterSubs[key]
+	if !ok {
+		return
+	}
+
+	for i, ch := range sub.clients {
+		if ch == clientChan {
+			sub.clients = append(sub.clients[:i], sub.clients[i+1:]...)
+			close(ch)
+			break
+		}
+	}
+
+	if len(sub.clients) == 0 {
+		sub.cancel()
+		delete(s.clusterSubs, key)
+		logger.GetLogger().Debug().Int("user_id", userID).Str("cluster", cluster).Msg("Stopped watching cluster events")
+	}
+}
+
+// notifyCluster sends a message of the watcher of a subscription to its clients. The lock is held while sending
+// so that a client channel can't be closed meanwhile.
+func (s *SSEManager) notifyCluster(userID int, cluster string, sub *clusterSubscription, message SSEMessage) {
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+
+	// the watcher of a subscription that ended may still be reporting
+	if s.clusterSubs[clusterSubscriptionKey(userID, cluster)] != sub {
+		return
+	}
+
+	for _, ch := range sub.clients {
+		select {
+		case ch <- message:
+		case <-time.After(2 * time.Second):
+			// Client not responding, it is closed once the message is sent to the others
+			go s.RemoveClusterClient(userID, cluster, ch)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func clusterSubscriptionKey(userID int, cluster string) string {
+	return fmt.Sprintf("%d/%s", userID, cluster)
+}
+
 // Notify sends a message to all clients of a specific user
 func (s *SSEManager) Notify(userID int, msgType string, severity models.NotificationSeverity, data map[string]string, id string, taskID ...string) {
 	message := SSEMessage{
@@ -135,7 +250,8 @@ func (s *SSEManager) Notify(userID int, msgType string, severity models.Notifica
 
 }
 
-// HandleSSE handles SSE HTTP connections
+// HandleSSE handles SSE HTTP connections. With the cluster query parameter, the Kubernetes events of that
+// cluster of the user are streamed instead of the user notifications.
 func (s *SSEManager) HandleSSE(c *gin.Context) {
 	userID := c.GetInt("user_id")
 	if userID == 0 {
@@ -143,10 +259,13 @@ func (s *SSEManager) HandleSSE(c *gin.Context) {
 		return
 	}
 
+	if cluster := c.Query("cluster"); cluster != "" {
+		s.handleClusterSSE(c, userID, cluster)
+		return
+	}
+
 	// Set SSE headers
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
+	setSSEHeaders(c)
 
 	// Add client and get channel
 	clientChan := s.AddClient(userID)
@@ -155,7 +274,52 @@ func (s *SSEManager) HandleSSE(c *gin.Context) {
 	// Send initial connection message
 	s.Notify(userID, "connected", models.NotificationSeverityInfo, map[string]string{"status": "connected"}, "")
 
-	// Stream messages to client
+	s.stream(c, userID, clientChan)
+}
+
+func (s *SSEManager) handleClusterSSE(c *gin.Context, userID int, cluster string) {
+	s.clusterMu.RLock()
+	source := s.clusterSource
+	s.clusterMu.RUnlock()
+	if source == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "cluster events are not available"})
+		return
+	}
+
+	exists, err := source.HasCluster(userID, cluster)
+	if err != nil {
+		logger.GetLogger().Error().Err(err).Int("user_id", userID).Str("cluster", cluster).Msg("Failed to look up cluster for events subscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lookup deployment"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		return
+	}
+
+	setSSEHeaders(c)
+
+	clientChan := s.AddClusterClient(userID, cluster)
+	defer s.RemoveClusterClient(userID, cluster, clientChan)
+
+	clientChan <- SSEMessage{
+		Type:      "connected",
+		Severity:  string(models.NotificationSeverityInfo),
+		Data:      map[string]string{"status": "connected", "cluster": cluster},
+		Timestamp: time.Now(),
+	}
+
+	s.stream(c, userID, clientChan)
+}
+
+func setSSEHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+}
+
+// stream writes the messages of a client channel until it is closed or the client disconnects
+func (s *SSEManager) stream(c *gin.Context, userID int, clientChan chan SSEMessage) {
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case message, ok := <-clientChan:
@@ -1,10 +1,14 @@
 package internal
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"kubecloud/internal/logger"
 	"kubecloud/kubedeployer"
 	"net"
+	"regexp"
 	"strings"
 	"time"
 
@@ -19,7 +23,7 @@ func GetKubeconfigViaSSH(privateKey string, node *kubedeployer.Node) (string, er
 
 	logger.GetLogger().Debug().Str("ip", ip).Str("node", node.Name).Msg("Attempting SSH connection")
 	command := "cat /etc/rancher/k3s/k3s.yaml"
-	kubeconfig, err := executeSSHCommand(privateKey, ip, command)
+	kubeconfig, err := executeSSHCommand(privateKey, node, command)
 	if err == nil && strings.Contains(kubeconfig, "apiVersion") && strings.Contains(kubeconfig, "clusters") {
 		processedKubeconfig, processErr := processKubeconfig(kubeconfig, ip)
 		if processErr != nil {
@@ -30,20 +34,53 @@ func GetKubeconfigViaSSH(privateKey string, node *kubedeployer.Node) (string, er
 	}
 	if err != nil {
 		logger.GetLogger().Debug().Err(err).Str("ip", ip).Str("command", command).Msg("Command failed, trying next")
+		return "", fmt.Errorf("failed to retrieve kubeconfig from node %s at IP %s: %w", node.Name, ip, err)
 	}
 
 	return "", fmt.Errorf("failed to retrieve kubeconfig from node %s at IP %s", node.Name, ip)
 }
 
-func executeSSHCommand(privateKey, address, command string) (string, error) {
+// GetKubeconfigFromControlPlane fetches the kubeconfig from the leader, falling back to the other masters in order.
+// It returns the kubeconfig along with the node it was retrieved from.
+func GetKubeconfigFromControlPlane(privateKey string, cluster kubedeployer.Cluster) (string, kubedeployer.Node, error) {
+	controlPlane := cluster.GetControlPlaneNodes()
+	if len(controlPlane) == 0 {
+		return "", kubedeployer.Node{}, fmt.Errorf("no leader or master node found in cluster %s", cluster.Name)
+	}
+
+	var lastErr error
+	for _, node := range controlPlane {
+		kubeconfig, err := GetKubeconfigViaSSH(privateKey, &node)
+		if err == nil {
+			return kubeconfig, node, nil
+		}
+		logger.GetLogger().Warn().Err(err).Str("node", node.Name).Msg("Failed to retrieve kubeconfig from master node, trying next")
+		lastErr = err
+	}
+
+	return "", kubedeployer.Node{}, fmt.Errorf("failed to retrieve kubeconfig from any master node of cluster %s: %w", cluster.Name, lastErr)
+}
+
+// dialSSH connects to a node over its Mycelium IP, verifying its host key with the configured HostKeyVerifier
+func dialSSH(privateKey string, node *kubedeployer.Node) (*ssh.Client, error) {
+	address := node.MyceliumIP
+	if address == "" {
+		return nil, fmt.Errorf("no valid IP address found for node %s", node.Name)
+	}
+
 	key, err := ssh.ParsePrivateKey([]byte(privateKey))
 	if err != nil {
-		return "", fmt.Errorf("could not parse SSH private key: %w", err)
+		return nil, fmt.Errorf("could not parse SSH private key: %w", err)
+	}
+
+	callback, err := hostKeyCallback(node)
+	if err != nil {
+		return nil, err
 	}
 
 	config := &ssh.ClientConfig{
 		User:            "root",
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: callback,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(key),
 		},
@@ -57,6 +94,10 @@ func executeSSHCommand(privateKey, address, command string) (string, error) {
 		if err == nil {
 			break
 		}
+		var changedErr *HostKeyChangedError
+		if errors.As(err, &changedErr) {
+			return nil, changedErr
+		}
 		if attempt < 3 {
 			logger.GetLogger().Debug().Err(err).Str("address", address).Int("attempt", attempt).Msg("SSH connection attempt failed, retrying")
 			time.Sleep(time.Duration(attempt) * time.Second)
@@ -64,7 +105,16 @@ func executeSSHCommand(privateKey, address, command string) (string, error) {
 	}
 
 	if err != nil {
-		return "", fmt.Errorf("could not establish SSH connection to %s after 3 attempts: %w", address, err)
+		return nil, fmt.Errorf("could not establish SSH connection to %s after 3 attempts: %w", address, err)
+	}
+
+	return client, nil
+}
+
+func executeSSHCommand(privateKey string, node *kubedeployer.Node, command string) (string, error) {
+	client, err := dialSSH(privateKey, node)
+	if err != nil {
+		return "", err
 	}
 	defer client.Close()
 
@@ -82,6 +132,48 @@ func executeSSHCommand(privateKey, address, command string) (string, error) {
 	return string(output), nil
 }
 
+// streamSSHCommand runs a command feeding it stdin and writing its output to stdout, for payloads too big to buffer
+func streamSSHCommand(privateKey string, node *kubedeployer.Node, command string, stdin io.Reader, stdout io.Writer) error {
+	client, err := dialSSH(privateKey, node)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("could not create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+
+	if err := session.Run(command); err != nil {
+		return fmt.Errorf("could not execute command '%s': %w, output: %s", command, err, stderr.String())
+	}
+
+	return nil
+}
+
+var kubeconfigServerRegex = regexp.MustCompile(`server: https://(\[[^\]]+\]|[^:/\s]+):`)
+
+// RewriteKubeconfigServer points the clusters of a kubeconfig at the given IP, keeping the API server port.
+// The kubeconfig is returned unchanged when ip is empty.
+func RewriteKubeconfigServer(kubeconfigYAML, ip string) string {
+	if ip == "" {
+		return kubeconfigYAML
+	}
+
+	host := ip
+	if strings.Contains(ip, ":") {
+		host = "[" + ip + "]"
+	}
+	return kubeconfigServerRegex.ReplaceAllLiteralString(kubeconfigYAML, "server: https://"+host+":")
+}
+
 func processKubeconfig(kubeconfigYAML, externalIP string) (string, error) {
 	updatedConfig := kubeconfigYAML
 	oldPattern := "server: https://127.0.0.1:"
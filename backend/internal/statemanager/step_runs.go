@@ -0,0 +1,47 @@
+package statemanager
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/xmonader/ewf"
+)
+
+const stepRunsKey = "step_runs"
+
+// StepRun records how a workflow step ran, it is kept in the workflow state to be persisted with the workflow
+type StepRun struct {
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// GetStepRuns returns the runs of the workflow steps that started, by step name
+func GetStepRuns(state ewf.State) map[string]StepRun {
+	value, ok := state[stepRunsKey]
+	if !ok {
+		return map[string]StepRun{}
+	}
+
+	// Try direct type assertion first (for workflows that didn't go through the store yet)
+	if runs, ok := value.(map[string]StepRun); ok {
+		return runs
+	}
+
+	// Fallback: handle the map the state was deserialized into
+	runs := map[string]StepRun{}
+	if data, err := json.Marshal(value); err == nil {
+		_ = json.Unmarshal(data, &runs)
+	}
+	return runs
+}
+
+// UpdateStepRun applies update to the run of a step and stores it in the state
+func UpdateStepRun(state ewf.State, stepName string, update func(run *StepRun)) {
+	runs := GetStepRuns(state)
+	run := runs[stepName]
+	update(&run)
+	runs[stepName] = run
+	state[stepRunsKey] = runs
+}
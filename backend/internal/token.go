@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -113,3 +117,27 @@ func (h *TokenHandler) AccessTokenFromRefresh(refreshToken string) (string, erro
 
 	return accessToken, nil
 }
+
+// APITokenPrefix starts every API token, telling them apart from JWTs
+const APITokenPrefix = "kcapi_"
+
+// GenerateAPIToken creates a random API token and returns it along with the hash to store
+func GenerateAPIToken() (token string, tokenHash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token = APITokenPrefix + hex.EncodeToString(secret)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the hash an API token is stored and looked up with
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIToken reports whether a bearer token is an API token rather than a JWT
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
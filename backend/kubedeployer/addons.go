@@ -0,0 +1,69 @@
+package kubedeployer
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	AddonTFGWCRD       = "tfgw-crd"
+	AddonCertManager   = "cert-manager"
+	AddonMetricsServer = "metrics-server"
+	AddonPrometheus    = "prometheus"
+	AddonFlux          = "flux"
+	AddonArgoCD        = "argocd"
+)
+
+// SupportedAddons lists the add-ons that can be installed in a cluster
+var SupportedAddons = []string{AddonTFGWCRD, AddonCertManager, AddonMetricsServer, AddonPrometheus, AddonFlux, AddonArgoCD}
+
+// Addon is an optional component installed in the cluster once its nodes are ready
+type Addon struct {
+	Name string `json:"name" binding:"required"`
+	// Version of the chart, the default version of the add-on when empty
+	Version string `json:"version,omitempty"`
+	// Values are chart values keyed by their dotted path, e.g. "server.replicas"
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// ValidateAddons checks that the add-ons are supported, listed once and do not conflict with each other
+func ValidateAddons(addons []Addon) error {
+	seen := make(map[string]struct{}, len(addons))
+	for _, addon := range addons {
+		if !slices.Contains(SupportedAddons, addon.Name) {
+			return fmt.Errorf("unsupported addon %q, supported addons are %v", addon.Name, SupportedAddons)
+		}
+		if _, exists := seen[addon.Name]; exists {
+			return fmt.Errorf("duplicate addon found: %s", addon.Name)
+		}
+		seen[addon.Name] = struct{}{}
+
+		if addon.Name == AddonTFGWCRD && (addon.Version != "" || len(addon.Values) > 0) {
+			return fmt.Errorf("addon %s is a bundled manifest and takes no version or values", addon.Name)
+		}
+	}
+
+	_, hasFlux := seen[AddonFlux]
+	_, hasArgoCD := seen[AddonArgoCD]
+	if hasFlux && hasArgoCD {
+		return fmt.Errorf("addons %s and %s cannot be installed together, pick one GitOps tool", AddonFlux, AddonArgoCD)
+	}
+
+	return nil
+}
+
+// RemovedAddons returns the add-ons of current that are not in desired
+func RemovedAddons(current, desired []Addon) []Addon {
+	var removed []Addon
+	for _, addon := range current {
+		if !slices.ContainsFunc(desired, func(a Addon) bool { return a.Name == addon.Name }) {
+			removed = append(removed, addon)
+		}
+	}
+	return removed
+}
+
+// EncryptMnemonic encrypts a mnemonic with the cluster token the same way it is passed to the nodes
+func EncryptMnemonic(token, mnemonic string) (string, error) {
+	return encrypt(token, mnemonic)
+}
@@ -0,0 +1,30 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAddons(t *testing.T) {
+	require.NoError(t, ValidateAddons(nil))
+	require.NoError(t, ValidateAddons([]Addon{
+		{Name: AddonTFGWCRD},
+		{Name: AddonCertManager, Version: "v1.16.2", Values: map[string]string{"crds.enabled": "true"}},
+		{Name: AddonFlux},
+	}))
+
+	require.Error(t, ValidateAddons([]Addon{{Name: "istio"}}))
+	require.Error(t, ValidateAddons([]Addon{{Name: AddonPrometheus}, {Name: AddonPrometheus}}))
+	require.Error(t, ValidateAddons([]Addon{{Name: AddonFlux}, {Name: AddonArgoCD}}))
+	require.Error(t, ValidateAddons([]Addon{{Name: AddonTFGWCRD, Version: "v1"}}))
+}
+
+func TestRemovedAddons(t *testing.T) {
+	current := []Addon{{Name: AddonCertManager}, {Name: AddonPrometheus}, {Name: AddonFlux}}
+	desired := []Addon{{Name: AddonCertManager, Version: "v1.16.2"}, {Name: AddonArgoCD}}
+
+	removed := RemovedAddons(current, desired)
+	require.Equal(t, []Addon{{Name: AddonPrometheus}, {Name: AddonFlux}}, removed)
+	require.Empty(t, RemovedAddons(nil, desired))
+}
@@ -0,0 +1,240 @@
+package kubedeployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"kubecloud/internal/logger"
+
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+var (
+	// ErrNoDeploymentsFound is returned when no active contracts exist on chain for a project
+	ErrNoDeploymentsFound = errors.New("no deployments found on chain")
+	// ErrContractNotOwned is returned when a contract of an adopted cluster belongs to another twin
+	ErrContractNotOwned = errors.New("contract is not owned by the twin")
+)
+
+// LoadClusterFromGrid rebuilds a cluster from the active contracts of a project on chain.
+// Node types and the cluster token are recovered from the k3s environment of the VMs,
+// so it can adopt clusters whose database record is missing or belongs to another backend.
+// Only the contracts of the client's twin are listed, the project may have been created under another user ID.
+func (c *Client) LoadClusterFromGrid(ctx context.Context, projectName, clusterName string) (Cluster, error) {
+	var networkName string
+
+	contracts, err := c.GridClient.ContractsGetter.ListContractsOfProjectName(projectName, true)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to list contracts of project %s: %v", projectName, err)
+	}
+
+	type vmDeployment struct {
+		nodeID uint32
+		name   string
+	}
+	var vms []vmDeployment
+
+	for _, contract := range contracts.NodeContracts {
+		contractID, err := strconv.ParseUint(contract.ContractID, 10, 64)
+		if err != nil {
+			return Cluster{}, fmt.Errorf("invalid contract ID %s: %v", contract.ContractID, err)
+		}
+
+		data, err := workloads.ParseDeploymentData(contract.DeploymentData)
+		if err != nil {
+			logger.GetLogger().Warn().Err(err).Uint64("contract_id", contractID).Msg("Skipping contract with invalid deployment data")
+			continue
+		}
+
+		if !slices.Contains(c.GridClient.State.CurrentNodeDeployments[contract.NodeID], contractID) {
+			c.GridClient.State.CurrentNodeDeployments[contract.NodeID] = append(c.GridClient.State.CurrentNodeDeployments[contract.NodeID], contractID)
+		}
+
+		// deployment names keep the project they were created in, a renamed project still holds the old ones
+		switch data.Type {
+		case "network":
+			networkName = data.Name
+		case "vm":
+			vms = append(vms, vmDeployment{nodeID: contract.NodeID, name: data.Name})
+		}
+	}
+
+	if len(vms) == 0 {
+		return Cluster{}, fmt.Errorf("%w for project %s", ErrNoDeploymentsFound, projectName)
+	}
+	if networkName == "" {
+		return Cluster{}, fmt.Errorf("network of project %s not found on chain", projectName)
+	}
+
+	network, err := c.GridClient.State.LoadNetworkFromGrid(ctx, networkName)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to load network %s: %v", networkName, err)
+	}
+
+	cluster := Cluster{
+		Name:        clusterName,
+		ProjectName: projectName,
+		Network:     network,
+		CIDR:        network.IPRange.String(),
+	}
+
+	for _, vm := range vms {
+		depl, err := c.GridClient.State.LoadDeploymentFromGrid(ctx, vm.nodeID, vm.name)
+		if err != nil {
+			return Cluster{}, fmt.Errorf("failed to load deployment %s on node %d: %v", vm.name, vm.nodeID, err)
+		}
+		if len(depl.Vms) == 0 || len(depl.Disks) == 0 {
+			// a partially deployed node, it is left out and reported by the drift checks
+			logger.GetLogger().Warn().Str("deployment", vm.name).Uint32("node_id", vm.nodeID).Msg("Skipping deployment without a VM or data disk")
+			continue
+		}
+
+		node, err := nodeFromDeployment(depl)
+		if err != nil {
+			return Cluster{}, fmt.Errorf("failed to get node from deployment %s: %v", vm.name, err)
+		}
+		node.OriginalName = originalNodeName(node.Name, clusterName)
+		node.Type = nodeTypeFromEnv(node.EnvVars)
+
+		if cluster.Token == "" {
+			cluster.Token = node.EnvVars["K3S_TOKEN"]
+		}
+		cluster.Nodes = append(cluster.Nodes, node)
+	}
+
+	if len(cluster.Nodes) == 0 {
+		return Cluster{}, fmt.Errorf("%w for project %s", ErrNoDeploymentsFound, projectName)
+	}
+
+	// a master promoted by a failover still carries the join URL of the old leader
+	if !slices.ContainsFunc(cluster.Nodes, func(n Node) bool { return n.Type == NodeTypeLeader }) {
+		for i, node := range cluster.Nodes {
+			if node.Type == NodeTypeMaster {
+				cluster.Nodes[i].Type = NodeTypeLeader
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(cluster.Nodes, func(i, j int) bool {
+		return nodeTypeRank(cluster.Nodes[i].Type) < nodeTypeRank(cluster.Nodes[j].Type)
+	})
+
+	if err := ValidateControlPlane(cluster.Nodes); err != nil {
+		return Cluster{}, fmt.Errorf("deployments of project %s do not form a valid cluster: %w", projectName, err)
+	}
+
+	return cluster, nil
+}
+
+// RenameProject moves the node contracts of an adopted cluster to another project.
+// Only the project name in the deployment data of the contracts changes, the deployments on the nodes are kept,
+// so the VMs and the network keep the names they were deployed with.
+// Every contract has to be owned by the client's twin.
+func (c *Client) RenameProject(cluster *Cluster, projectName string) error {
+	var contractIDs []uint64
+	for _, node := range cluster.Nodes {
+		contractIDs = append(contractIDs, node.ContractID)
+	}
+	for _, contractID := range cluster.Network.NodeDeploymentID {
+		contractIDs = append(contractIDs, contractID)
+	}
+
+	for _, contractID := range contractIDs {
+		if contractID == 0 {
+			continue
+		}
+
+		contract, err := c.GridClient.SubstrateConn.GetContract(contractID)
+		if err != nil {
+			return fmt.Errorf("failed to get contract %d: %v", contractID, err)
+		}
+		if contract.TwinID() != c.GridClient.TwinID {
+			return fmt.Errorf("%w: contract %d belongs to twin %d", ErrContractNotOwned, contractID, contract.TwinID())
+		}
+		if !contract.ContractType.IsNodeContract {
+			continue
+		}
+
+		nodeContract := contract.ContractType.NodeContract
+		data, err := workloads.ParseDeploymentData(nodeContract.DeploymentData)
+		if err != nil {
+			return fmt.Errorf("invalid deployment data of contract %d: %v", contractID, err)
+		}
+		if data.ProjectName == projectName {
+			continue
+		}
+
+		data.ProjectName = projectName
+		metadata, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode deployment data of contract %d: %v", contractID, err)
+		}
+
+		logger.GetLogger().Debug().Uint64("contract_id", contractID).Str("project_name", projectName).Msg("Renaming project of contract")
+		if _, err := c.GridClient.SubstrateConn.UpdateNodeContract(c.GridClient.Identity, contractID, string(metadata), nodeContract.DeploymentHash.String()); err != nil {
+			return fmt.Errorf("failed to update contract %d: %v", contractID, err)
+		}
+	}
+
+	cluster.ProjectName = projectName
+	return nil
+}
+
+// IsProjectOfCluster reports whether the project name was derived from the cluster name by GetProjectName,
+// whatever user ID it was created under
+func IsProjectOfCluster(projectName, clusterName string) bool {
+	return projectPrefix(clusterName).FindString(projectName) == projectName
+}
+
+// originalNodeName strips the project prefix from the name of a deployed node
+func originalNodeName(vmName, clusterName string) string {
+	return strings.TrimPrefix(vmName, projectPrefix(clusterName).FindString(vmName))
+}
+
+func projectPrefix(clusterName string) *regexp.Regexp {
+	return regexp.MustCompile("^kc[0-9]+" + regexp.QuoteMeta(clusterName))
+}
+
+// nodeTypeFromEnv derives the role of a deployed node from the k3s environment it was deployed with
+func nodeTypeFromEnv(envVars map[string]string) NodeType {
+	if envVars["MASTER"] != "true" {
+		return NodeTypeWorker
+	}
+	if envVars["K3S_URL"] == "" {
+		return NodeTypeLeader
+	}
+	return NodeTypeMaster
+}
+
+// MissingContracts returns the node and network contracts of the cluster that are not part of the loaded one
+func (c *Cluster) MissingContracts(loaded Cluster) []uint64 {
+	existing := map[uint64]struct{}{}
+	for _, node := range loaded.Nodes {
+		existing[node.ContractID] = struct{}{}
+	}
+	for _, contractID := range loaded.Network.NodeDeploymentID {
+		existing[contractID] = struct{}{}
+	}
+
+	var missing []uint64
+	for _, node := range c.Nodes {
+		if _, ok := existing[node.ContractID]; !ok && node.ContractID != 0 {
+			missing = append(missing, node.ContractID)
+		}
+	}
+	for _, contractID := range c.Network.NodeDeploymentID {
+		if _, ok := existing[contractID]; !ok && contractID != 0 {
+			missing = append(missing, contractID)
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
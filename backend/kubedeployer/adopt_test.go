@@ -0,0 +1,51 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeTypeFromEnv(t *testing.T) {
+	require.Equal(t, NodeTypeLeader, nodeTypeFromEnv(map[string]string{"MASTER": "true", "K3S_URL": ""}))
+	require.Equal(t, NodeTypeMaster, nodeTypeFromEnv(map[string]string{"MASTER": "true", "K3S_URL": "https://10.20.2.2:6443"}))
+	require.Equal(t, NodeTypeWorker, nodeTypeFromEnv(map[string]string{"MASTER": "false", "K3S_URL": "https://10.20.2.2:6443"}))
+	require.Equal(t, NodeTypeWorker, nodeTypeFromEnv(nil))
+}
+
+func TestMissingContracts(t *testing.T) {
+	exported := Cluster{
+		Nodes: []Node{
+			deployedNode("leader", NodeTypeLeader, 1),
+			deployedNode("worker", NodeTypeWorker, 2),
+		},
+	}
+	exported.Network.NodeDeploymentID = map[uint32]uint64{1: 11, 2: 21}
+
+	loaded := Cluster{Nodes: []Node{deployedNode("leader", NodeTypeLeader, 1)}}
+	loaded.Network.NodeDeploymentID = map[uint32]uint64{1: 11, 2: 21}
+
+	require.Equal(t, []uint64{20}, exported.MissingContracts(loaded))
+	require.Empty(t, exported.MissingContracts(exported))
+}
+
+func TestIsProjectOfCluster(t *testing.T) {
+	require.True(t, IsProjectOfCluster("kc12prod", "prod"))
+	require.True(t, IsProjectOfCluster("kc12345prod", "45prod"))
+	require.False(t, IsProjectOfCluster("kc12prod2", "prod"))
+	require.False(t, IsProjectOfCluster("kcprod", "prod"))
+	require.False(t, IsProjectOfCluster("xx12prod", "prod"))
+}
+
+func TestOriginalNodeName(t *testing.T) {
+	require.Equal(t, "worker1", originalNodeName("kc12prodworker1", "prod"))
+	require.Equal(t, "worker1", originalNodeName("kc7prodworker1", "prod"))
+	require.Equal(t, "worker1", originalNodeName("kc1245prodworker1", "45prod"))
+}
+
+func TestDeployedNodeName(t *testing.T) {
+	cluster := Cluster{Name: "prod", Nodes: []Node{{Name: "kc7prodleader", OriginalName: "leader"}}}
+
+	require.Equal(t, "kc7prodleader", cluster.DeployedNodeName(12, "leader"))
+	require.Equal(t, "kc12prodworker1", cluster.DeployedNodeName(12, "worker1"))
+}
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"sort"
+	"strings"
 
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 	zosTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
@@ -22,6 +24,21 @@ const (
 	K3S_IFACE      = "flannel-br"
 )
 
+// defaultFlist is used for nodes that don't specify their own flist
+var defaultFlist = K3S_FLIST
+
+// SetDefaultFlist overrides the k3s flist used for new nodes
+func SetDefaultFlist(flist string) {
+	if flist != "" {
+		defaultFlist = flist
+	}
+}
+
+// DefaultFlist returns the k3s flist used for nodes that don't specify their own
+func DefaultFlist() string {
+	return defaultFlist
+}
+
 func generateRandomString(length int) string {
 	chars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	result := make([]byte, length)
@@ -40,8 +57,9 @@ func deploymentFromNode(
 	masterSSH string,
 	mnemonic string,
 	gridNet string,
+	ipv6CIDR string,
 ) (workloads.Deployment, error) {
-	ipSeed, err := workloads.RandomMyceliumIPSeed()
+	ipSeed, err := myceliumIPSeed(node)
 	if err != nil {
 		return workloads.Deployment{}, err
 	}
@@ -72,6 +90,8 @@ func deploymentFromNode(
 		Entrypoint:     node.Entrypoint,
 		NetworkName:    networkName,
 		IP:             node.IP,
+		PublicIP:       node.PublicIPv4,
+		PublicIP6:      node.PublicIPv6,
 		MyceliumIPSeed: ipSeed,
 		Mounts: []workloads.Mount{
 			{
@@ -107,12 +127,22 @@ func deploymentFromNode(
 		vm.EnvVars["K3S_DATA_DIR"] = K3S_DATA_DIR
 	}
 	if vm.Flist == "" {
-		vm.Flist = K3S_FLIST
+		vm.Flist = defaultFlist
 	}
 	if vm.Entrypoint == "" {
 		vm.Entrypoint = K3S_ENTRYPOINT
 	}
 
+	// the leader sets the IPv6 pod and service ranges when it creates the cluster, masters join with the same ones
+	if ipv6CIDR != "" && vm.EnvVars["MASTER"] == "true" {
+		podCIDR, serviceCIDR, err := IPv6ClusterRanges(ipv6CIDR)
+		if err != nil {
+			return workloads.Deployment{}, err
+		}
+		vm.EnvVars["CLUSTER_CIDR_IPV6"] = podCIDR
+		vm.EnvVars["SERVICE_CIDR_IPV6"] = serviceCIDR
+	}
+
 	vm.EnvVars["SSH_KEY"] = node.EnvVars["SSH_KEY"] + "\n" + masterSSH
 
 	depl := workloads.NewDeployment(
@@ -147,6 +177,8 @@ func nodeFromDeployment(
 	node.EnvVars = vm.EnvVars
 	node.Flist = vm.Flist
 	node.Entrypoint = vm.Entrypoint
+	node.PublicIPv4 = vm.PublicIP
+	node.PublicIPv6 = vm.PublicIP6
 	node.DiskSize = depl.Disks[0].SizeGB * 1024
 	node.GPUIDs = make([]string, len(vm.GPUs))
 
@@ -158,11 +190,29 @@ func nodeFromDeployment(
 	node.IP = vm.IP
 	node.MyceliumIP = vm.MyceliumIP
 	node.PlanetaryIP = vm.PlanetaryIP
+	node.MyceliumIPSeed = vm.MyceliumIPSeed
+	node.PublicIP = stripPrefixLength(vm.ComputedIP)
+	node.PublicIP6 = stripPrefixLength(vm.ComputedIP6)
 	node.ContractID = depl.ContractID
 
 	return node, nil
 }
 
+// myceliumIPSeed returns the stored seed of the node so its mycelium IP survives a redeploy,
+// a new one is generated for nodes deployed for the first time
+func myceliumIPSeed(node Node) ([]byte, error) {
+	if len(node.MyceliumIPSeed) == zosTypes.MyceliumIPSeedLen {
+		return node.MyceliumIPSeed, nil
+	}
+	return workloads.RandomMyceliumIPSeed()
+}
+
+// stripPrefixLength returns the address of a computed public IP, which the grid reports in CIDR notation
+func stripPrefixLength(cidr string) string {
+	address, _, _ := strings.Cut(cidr, "/")
+	return address
+}
+
 func GetProjectName(userID int, clusterName string) string {
 	userIDStr := fmt.Sprintf("%d", userID)
 	return "kc" + userIDStr + clusterName
@@ -172,6 +222,17 @@ func GetNodeName(userID int, clusterName, nodeName string) string {
 	return GetProjectName(userID, clusterName) + nodeName
 }
 
+// DeployedNodeName returns the name a node of the cluster was deployed with,
+// nodes of an adopted cluster keep the prefix of the project they were created in
+func (c *Cluster) DeployedNodeName(userID int, nodeName string) string {
+	for _, node := range c.Nodes {
+		if node.OriginalName == nodeName {
+			return node.Name
+		}
+	}
+	return GetNodeName(userID, c.Name, nodeName)
+}
+
 func (c *Cluster) PrepareCluster(userID int) error {
 	projectName := GetProjectName(userID, c.Name)
 	networkName := projectName + "net"
@@ -197,9 +258,25 @@ func (c *Cluster) PrepareCluster(userID int) error {
 		}
 	}
 
+	// the leader initializes the embedded etcd, so it has to be deployed before any node joins it
+	sort.SliceStable(c.Nodes, func(i, j int) bool {
+		return nodeTypeRank(c.Nodes[i].Type) < nodeTypeRank(c.Nodes[j].Type)
+	})
+
 	return nil
 }
 
+func nodeTypeRank(nodeType NodeType) int {
+	switch nodeType {
+	case NodeTypeLeader:
+		return 0
+	case NodeTypeMaster:
+		return 1
+	default:
+		return 2
+	}
+}
+
 func encrypt(key, text string) (string, error) {
 	hash := sha256.Sum256([]byte(key)) // valid 32 bytes for AES-256
 	key = string(hash[:])
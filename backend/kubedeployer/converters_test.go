@@ -0,0 +1,26 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeploymentFromNodeIPv6Ranges(t *testing.T) {
+	token := "abcdefghijklmnopqrstuvwxyz012345"
+	deploy := func(node Node, ipv6CIDR string) map[string]string {
+		depl, err := deploymentFromNode(node, "kc1test", "kc1testnet", "10.20.2.2", token, "master-key", "mnemonic", "dev", ipv6CIDR)
+		require.NoError(t, err)
+		return depl.Vms[0].EnvVars
+	}
+
+	env := deploy(deployedNode("leader", NodeTypeLeader, 1), "fd12:3456:789a::/48")
+	require.Equal(t, "fd12:3456:789a::/56", env["CLUSTER_CIDR_IPV6"])
+	require.Equal(t, "fd12:3456:789a:100::/112", env["SERVICE_CIDR_IPV6"])
+
+	env = deploy(deployedNode("worker", NodeTypeWorker, 2), "fd12:3456:789a::/48")
+	require.NotContains(t, env, "CLUSTER_CIDR_IPV6")
+
+	env = deploy(deployedNode("master", NodeTypeMaster, 3), "")
+	require.NotContains(t, env, "CLUSTER_CIDR_IPV6")
+}
@@ -11,12 +11,79 @@ import (
 )
 
 func (c *Cluster) GetLeaderNode() (Node, error) {
-	return c.Nodes[0], nil
+	for _, node := range c.Nodes {
+		if node.Type == NodeTypeLeader {
+			return node, nil
+		}
+	}
+
+	// clusters without an explicit leader fall back to their first master
+	for _, node := range c.Nodes {
+		if node.Type == NodeTypeMaster {
+			return node, nil
+		}
+	}
+
+	return Node{}, fmt.Errorf("no leader or master node found in cluster %s", c.Name)
+}
+
+// GetControlPlaneNodes returns the leader followed by the other masters of the cluster
+func (c *Cluster) GetControlPlaneNodes() []Node {
+	var leaders, masters []Node
+	for _, node := range c.Nodes {
+		switch node.Type {
+		case NodeTypeLeader:
+			leaders = append(leaders, node)
+		case NodeTypeMaster:
+			masters = append(masters, node)
+		}
+	}
+	return append(leaders, masters...)
+}
+
+// PublicAPIAddress returns the public IP of the first control plane node that has one, preferring IPv4.
+// Every server adds its public IPs to its TLS SANs, so the address of a master is as valid as the leader's.
+// It is empty when no control plane node has a public IP.
+func (c *Cluster) PublicAPIAddress() string {
+	controlPlane := c.GetControlPlaneNodes()
+	for _, node := range controlPlane {
+		if node.PublicIP != "" {
+			return node.PublicIP
+		}
+	}
+	for _, node := range controlPlane {
+		if node.PublicIP6 != "" {
+			return node.PublicIP6
+		}
+	}
+	return ""
+}
+
+// IsNodeContractActive checks that the node contract still exists on chain
+func (c *Client) IsNodeContractActive(node Node) bool {
+	return node.ContractID != 0 && c.isContractActive(node.ContractID)
+}
+
+// getJoinNode returns the first control plane node with an active contract that nodeName can join through
+func (c *Client) getJoinNode(cluster *Cluster, nodeName string) (Node, error) {
+	for _, node := range cluster.GetControlPlaneNodes() {
+		if node.Name == nodeName || node.IP == "" {
+			continue
+		}
+		if c.IsNodeContractActive(node) {
+			return node, nil
+		}
+		logger.GetLogger().Warn().Str("node_name", node.Name).Msgf("Master node of cluster %s is not healthy, skipping it as join target", cluster.Name)
+	}
+
+	return Node{}, fmt.Errorf("no healthy master found in cluster %s", cluster.Name)
 }
 
-func (n *Node) AssignNodeIP(ctx context.Context, gridClient deployer.TFPluginClient, networkName string) error {
+// AssignNodeIP picks the private IP of the node in the cluster network, reserving it with reserve when it is set
+func (n *Node) AssignNodeIP(ctx context.Context, gridClient deployer.TFPluginClient, cluster *Cluster, reserve ReserveIPFunc) error {
+	networkName := cluster.Network.Name
 	logger.GetLogger().Debug().Msgf("Assigning IP for node %s in network %s", n.Name, networkName)
-	ip, err := getIpForVm(ctx, gridClient, networkName, n.NodeID)
+	ip, err := getIpForVm(ctx, gridClient, networkName, n.NodeID, reserve)
 	if err != nil {
 		return fmt.Errorf("failed to get IP for node %s: %v", n.Name, err)
 	}
@@ -30,13 +97,13 @@ func (c *Client) DeployNode(ctx context.Context, cluster *Cluster, node Node, ma
 	if node.Type == NodeTypeLeader {
 		leaderIP = ""
 	} else {
-		leaderNode, err := cluster.GetLeaderNode()
+		joinNode, err := c.getJoinNode(cluster, node.Name)
 		if err != nil {
-			logger.GetLogger().Error().Err(err).Msgf("Failed to get leader node for cluster %s", cluster.Name)
-			return fmt.Errorf("failed to get leader node IP: %v", err)
+			logger.GetLogger().Error().Err(err).Msgf("Failed to get a master node to join for cluster %s", cluster.Name)
+			return fmt.Errorf("failed to get master node IP: %v", err)
 		}
 
-		leaderIP = leaderNode.IP
+		leaderIP = joinNode.IP
 	}
 
 	if cluster.Token == "" {
@@ -52,6 +119,7 @@ func (c *Client) DeployNode(ctx context.Context, cluster *Cluster, node Node, ma
 		masterPubKey,
 		c.mnemonic,
 		c.GridClient.Network,
+		cluster.IPv6CIDR,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create VM for node: %v", err)
@@ -142,7 +210,7 @@ func (c *Client) DeployNetwork(ctx context.Context, cluster *Cluster) error {
 		logger.GetLogger().Debug().Msgf("Appending nodes %v to existing network %s. Total nodes: %v", nodeIDs, cluster.Network.Name, net.Nodes)
 	} else {
 		logger.GetLogger().Debug().Msgf("Creating new network workload for network: %s", cluster.Network.Name)
-		net, err = createNetworkWorkload(cluster.Network.Name, cluster.ProjectName, nodeIDs)
+		net, err = createNetworkWorkload(cluster.Network.Name, cluster.ProjectName, cluster.CIDR, nodeIDs)
 		if err != nil {
 			return fmt.Errorf("failed to create network workload: %v", err)
 		}
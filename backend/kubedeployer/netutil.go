@@ -11,7 +11,20 @@ import (
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
 )
 
-func getIpForVm(ctx context.Context, tfPluginClient deployer.TFPluginClient, networkName string, nodeID uint32) (string, error) {
+// DefaultClusterCIDR is the private network of a cluster that did not request one
+const DefaultClusterCIDR = "10.20.0.0/16"
+
+// k3sClusterCIDRs are the IPv4 pod and service ranges entrypoint.sh gives k3s, a node network inside them breaks the
+// routing of the cluster
+var k3sClusterCIDRs = []string{"10.42.0.0/16", "10.43.0.0/16"}
+
+// ErrIPReserved is returned by a ReserveIPFunc when the IP is already reserved for another node
+var ErrIPReserved = errors.New("ip is already reserved")
+
+// ReserveIPFunc records an IP for a node before it is deployed, so that concurrent deployments do not pick the same one
+type ReserveIPFunc func(ip string) error
+
+func getIpForVm(ctx context.Context, tfPluginClient deployer.TFPluginClient, networkName string, nodeID uint32, reserve ReserveIPFunc) (string, error) {
 	network := tfPluginClient.State.Networks.GetNetwork(networkName)
 	ipRange := network.GetNodeSubnet(nodeID)
 
@@ -25,25 +38,45 @@ func getIpForVm(ctx context.Context, tfPluginClient deployer.TFPluginClient, net
 		return "", errors.Wrapf(err, "failed to get used IPs for node %d", nodeID)
 	}
 
+	vmIP, err := pickFreeIP(ip, usedHostIDs, reserve)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to pick IP for network %s on node %d", networkName, nodeID)
+	}
+
+	return vmIP, nil
+}
+
+// pickFreeIP returns the first host of the node subnet that is neither used on the node nor reserved for another node
+func pickFreeIP(subnetIP net.IP, usedHostIDs []byte, reserve ReserveIPFunc) (string, error) {
+	used := make(map[byte]bool, len(usedHostIDs))
+	for _, hostID := range usedHostIDs {
+		used[hostID] = true
+	}
+
 	// skip 0, 1, and 255 as they are reserved
 	for hostID := byte(2); hostID < 255; hostID++ {
-		used := false
-		for _, usedID := range usedHostIDs {
-			if usedID == hostID {
-				used = true
-				break
-			}
+		if used[hostID] {
+			continue
 		}
-		if !used {
-			vmIP := make(net.IP, len(ip.To4()))
-			copy(vmIP, ip.To4())
-			vmIP[3] = hostID
 
-			return vmIP.String(), nil
+		vmIP := make(net.IP, net.IPv4len)
+		copy(vmIP, subnetIP.To4())
+		vmIP[3] = hostID
+
+		if reserve != nil {
+			err := reserve(vmIP.String())
+			if errors.Is(err, ErrIPReserved) {
+				continue
+			}
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to reserve IP %s", vmIP)
+			}
 		}
+
+		return vmIP.String(), nil
 	}
 
-	return "", fmt.Errorf("all IPs are exhausted for network %s on node %d", networkName, nodeID)
+	return "", fmt.Errorf("all IPs are exhausted")
 }
 
 func getUsedHostIDsFromGrid(ctx context.Context, tfPluginClient deployer.TFPluginClient, nodeID uint32, networkName string, ipRangeCIDR *net.IPNet) ([]byte, error) {
@@ -68,7 +101,18 @@ func getUsedHostIDsFromGrid(ctx context.Context, tfPluginClient deployer.TFPlugi
 	return usedHostIDs, nil
 }
 
-func createNetworkWorkload(networkName, projectName string, nodes []uint32) (workloads.ZNet, error) {
+func createNetworkWorkload(networkName, projectName, cidr string, nodes []uint32) (workloads.ZNet, error) {
+	if cidr == "" {
+		cidr = DefaultClusterCIDR
+	}
+	if err := ValidateClusterCIDR(cidr); err != nil {
+		return workloads.ZNet{}, err
+	}
+	_, ipRange, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return workloads.ZNet{}, err
+	}
+
 	keys := make(map[uint32][]byte)
 	for _, node := range nodes {
 		key, err := workloads.RandomMyceliumKey()
@@ -79,13 +123,101 @@ func createNetworkWorkload(networkName, projectName string, nodes []uint32) (wor
 	}
 
 	return workloads.ZNet{
-		Name:  networkName,
-		Nodes: nodes,
-		IPRange: zos.IPNet{IPNet: net.IPNet{
-			IP:   net.IPv4(10, 20, 0, 0),
-			Mask: net.CIDRMask(16, 32),
-		}},
+		Name:         networkName,
+		Nodes:        nodes,
+		IPRange:      zos.IPNet{IPNet: *ipRange},
 		MyceliumKeys: keys,
 		SolutionType: projectName,
 	}, nil
 }
+
+// ValidateClusterCIDR checks that cidr is a private IPv4 /16, the only size the grid network supports
+func ValidateClusterCIDR(cidr string) error {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid cidr %q: %v", cidr, err)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("cidr %q must be an IPv4 range", cidr)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones != 16 {
+		return fmt.Errorf("cidr %q must be a /16 range", cidr)
+	}
+	if !ip.Equal(ipNet.IP) {
+		return fmt.Errorf("cidr %q must be the network address, use %s", cidr, ipNet.String())
+	}
+	if !ip.IsPrivate() {
+		return fmt.Errorf("cidr %q must be a private range", cidr)
+	}
+	for _, reserved := range k3sClusterCIDRs {
+		if CIDRsOverlap(cidr, reserved) {
+			return fmt.Errorf("cidr %q is used by the pods and services of k3s", cidr)
+		}
+	}
+	return nil
+}
+
+// ValidateIPv6CIDR checks that cidr is a unique local IPv6 prefix holding the IPv6 pod and service ranges of k3s
+func ValidateIPv6CIDR(cidr string) error {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid ipv6_cidr %q: %v", cidr, err)
+	}
+	if ip.To4() != nil {
+		return fmt.Errorf("ipv6_cidr %q must be an IPv6 range", cidr)
+	}
+	if !ip.IsPrivate() {
+		return fmt.Errorf("ipv6_cidr %q must be a unique local range (fc00::/7)", cidr)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones < 48 || ones > 55 {
+		return fmt.Errorf("ipv6_cidr %q must have a prefix length between 48 and 55", cidr)
+	}
+	return nil
+}
+
+// IPv6ClusterRanges splits the IPv6 range of a cluster into the k3s pod and service ranges.
+// Pods get the first /56, so every node gets a /64, and services the first /112 of the next /56.
+func IPv6ClusterRanges(ipv6CIDR string) (string, string, error) {
+	if err := ValidateIPv6CIDR(ipv6CIDR); err != nil {
+		return "", "", err
+	}
+	_, ipNet, _ := net.ParseCIDR(ipv6CIDR)
+
+	pods := net.IPNet{IP: ipNet.IP, Mask: net.CIDRMask(56, 128)}
+
+	services := net.IPNet{IP: make(net.IP, net.IPv6len), Mask: net.CIDRMask(112, 128)}
+	copy(services.IP, ipNet.IP)
+	services.IP[6]++
+
+	return pods.String(), services.String(), nil
+}
+
+// CIDRsOverlap reports whether two ranges share any address
+func CIDRsOverlap(a, b string) bool {
+	_, netA, errA := net.ParseCIDR(a)
+	_, netB, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return netA.Contains(netB.IP) || netB.Contains(netA.IP)
+}
+
+// NextFreeClusterCIDR returns the first 10.x.0.0/16 range, starting from the default one, that does not overlap any of used
+// or the ranges of k3s
+func NextFreeClusterCIDR(used []string) (string, error) {
+	taken := append(append([]string{}, used...), k3sClusterCIDRs...)
+	for x := 20; x < 256; x++ {
+		candidate := fmt.Sprintf("10.%d.0.0/16", x)
+		free := true
+		for _, cidr := range taken {
+			if CIDRsOverlap(candidate, cidr) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free private range left")
+}
@@ -0,0 +1,102 @@
+package kubedeployer
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateClusterCIDR(t *testing.T) {
+	require.NoError(t, ValidateClusterCIDR("10.20.0.0/16"))
+	require.NoError(t, ValidateClusterCIDR("172.16.0.0/16"))
+	require.NoError(t, ValidateClusterCIDR("192.168.0.0/16"))
+
+	require.Error(t, ValidateClusterCIDR("10.20.0.0/24"))
+	require.Error(t, ValidateClusterCIDR("10.20.1.0/16"))
+	require.Error(t, ValidateClusterCIDR("8.8.0.0/16"))
+	require.Error(t, ValidateClusterCIDR("fd00::/16"))
+	require.Error(t, ValidateClusterCIDR("not-a-cidr"))
+	// the pod and service ranges of k3s
+	require.Error(t, ValidateClusterCIDR("10.42.0.0/16"))
+	require.Error(t, ValidateClusterCIDR("10.43.0.0/16"))
+}
+
+func TestValidateIPv6CIDR(t *testing.T) {
+	require.NoError(t, ValidateIPv6CIDR("fd12:3456:789a::/48"))
+	require.NoError(t, ValidateIPv6CIDR("fd12:3456:789a:200::/55"))
+	require.Error(t, ValidateIPv6CIDR("fd12:3456:789a::/64"))
+	require.Error(t, ValidateIPv6CIDR("2001:db8::/48"))
+	require.Error(t, ValidateIPv6CIDR("10.20.0.0/16"))
+}
+
+func TestIPv6ClusterRanges(t *testing.T) {
+	pods, services, err := IPv6ClusterRanges("fd12:3456:789a::/48")
+	require.NoError(t, err)
+	require.Equal(t, "fd12:3456:789a::/56", pods)
+	require.Equal(t, "fd12:3456:789a:100::/112", services)
+
+	pods, services, err = IPv6ClusterRanges("fd12:3456:789a:200::/55")
+	require.NoError(t, err)
+	require.Equal(t, "fd12:3456:789a:200::/56", pods)
+	require.Equal(t, "fd12:3456:789a:300::/112", services)
+	require.False(t, CIDRsOverlap(pods, services))
+
+	_, _, err = IPv6ClusterRanges("fd12:3456:789a::/64")
+	require.Error(t, err)
+}
+
+func TestNextFreeClusterCIDR(t *testing.T) {
+	cidr, err := NextFreeClusterCIDR(nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultClusterCIDR, cidr)
+
+	cidr, err = NextFreeClusterCIDR([]string{"10.20.0.0/16", "10.21.0.0/16", "192.168.0.0/16"})
+	require.NoError(t, err)
+	require.Equal(t, "10.22.0.0/16", cidr)
+
+	var used []string
+	for x := 20; x < 42; x++ {
+		used = append(used, fmt.Sprintf("10.%d.0.0/16", x))
+	}
+	cidr, err = NextFreeClusterCIDR(used)
+	require.NoError(t, err)
+	require.Equal(t, "10.44.0.0/16", cidr, "the pod and service ranges of k3s are skipped")
+	require.NoError(t, ValidateClusterCIDR(cidr))
+
+	require.True(t, CIDRsOverlap("10.0.0.0/8", "10.30.0.0/16"))
+	require.False(t, CIDRsOverlap("10.20.0.0/16", "10.21.0.0/16"))
+}
+
+func TestPickFreeIP(t *testing.T) {
+	subnet := net.ParseIP("10.20.3.0")
+
+	t.Run("skips IPs used on the node", func(t *testing.T) {
+		ip, err := pickFreeIP(subnet, []byte{2, 3}, nil)
+		require.NoError(t, err)
+		require.Equal(t, "10.20.3.4", ip)
+	})
+
+	t.Run("skips IPs reserved for other nodes", func(t *testing.T) {
+		reserved := map[string]bool{"10.20.3.2": true}
+		reserve := func(ip string) error {
+			if reserved[ip] {
+				return ErrIPReserved
+			}
+			reserved[ip] = true
+			return nil
+		}
+
+		ip, err := pickFreeIP(subnet, nil, reserve)
+		require.NoError(t, err)
+		require.Equal(t, "10.20.3.3", ip)
+		require.True(t, reserved["10.20.3.3"])
+	})
+
+	t.Run("fails when the subnet is exhausted", func(t *testing.T) {
+		reserve := func(ip string) error { return ErrIPReserved }
+		_, err := pickFreeIP(subnet, nil, reserve)
+		require.Error(t, err)
+	})
+}
@@ -134,6 +134,26 @@ func removeNodeFromCluster(cluster *Cluster, nodeIndex int) {
 	cluster.Nodes = updatedNodes
 }
 
+// ForgetContract removes the node or network deployment of a contract that no longer exists on chain from the cluster.
+// It reports whether the contract was part of the cluster.
+func (c *Cluster) ForgetContract(contractID uint64) bool {
+	for i, node := range c.Nodes {
+		if node.ContractID == contractID {
+			removeNodeFromCluster(c, i)
+			return true
+		}
+	}
+
+	for nodeID, id := range c.Network.NodeDeploymentID {
+		if id == contractID {
+			updateNetworkWorkload(c, nodeID, false)
+			return true
+		}
+	}
+
+	return false
+}
+
 // RemoveNode cancel the node contract on chain and remove it from the cluster in db
 // also cancel the network contract and clean up the network workload in db if not used by other nodes
 func (c *Client) RemoveNode(ctx context.Context, cluster *Cluster, nodeName string) error {
@@ -0,0 +1,42 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForgetContract(t *testing.T) {
+	newCluster := func() Cluster {
+		cluster := Cluster{Nodes: []Node{
+			deployedNode("leader", NodeTypeLeader, 1),
+			deployedNode("worker", NodeTypeWorker, 2),
+		}}
+		cluster.Network.Nodes = []uint32{1, 2}
+		cluster.Network.NodeDeploymentID = map[uint32]uint64{1: 11, 2: 21}
+		return cluster
+	}
+
+	t.Run("node contract", func(t *testing.T) {
+		cluster := newCluster()
+		require.True(t, cluster.ForgetContract(20))
+		require.Len(t, cluster.Nodes, 1)
+		require.Equal(t, "leader", cluster.Nodes[0].OriginalName)
+		// the network deployment is left for its own contract
+		require.Equal(t, map[uint32]uint64{1: 11, 2: 21}, cluster.Network.NodeDeploymentID)
+	})
+
+	t.Run("network contract", func(t *testing.T) {
+		cluster := newCluster()
+		require.True(t, cluster.ForgetContract(21))
+		require.Len(t, cluster.Nodes, 2)
+		require.Equal(t, []uint32{1}, cluster.Network.Nodes)
+		require.Equal(t, map[uint32]uint64{1: 11}, cluster.Network.NodeDeploymentID)
+	})
+
+	t.Run("unknown contract", func(t *testing.T) {
+		cluster := newCluster()
+		require.False(t, cluster.ForgetContract(99))
+		require.Len(t, cluster.Nodes, 2)
+	})
+}
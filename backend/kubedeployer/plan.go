@@ -0,0 +1,223 @@
+package kubedeployer
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type NodeAction string
+
+const (
+	NodeActionAdd     NodeAction = "add"
+	NodeActionRemove  NodeAction = "remove"
+	NodeActionReplace NodeAction = "replace"
+)
+
+// managedEnvVars are injected by deploymentFromNode and must not be compared against the desired spec
+var managedEnvVars = []string{
+	"K3S_NODE_NAME",
+	"DUAL_STACK",
+	"MASTER",
+	"HA",
+	"K3S_URL",
+	"K3S_TOKEN",
+	"TOKEN",
+	"MNEMONIC",
+	"NETWORK",
+	"K3S_FLANNEL_IFACE",
+	"K3S_DATA_DIR",
+	"SSH_KEY",
+	"CLUSTER_CIDR_IPV6",
+	"SERVICE_CIDR_IPV6",
+}
+
+// NodeOperation is a single step of a cluster update plan
+type NodeOperation struct {
+	Action   NodeAction `json:"action"`
+	NodeName string     `json:"node_name"`
+	// Node is the desired node spec, set for add and replace operations
+	Node    *Node    `json:"node,omitempty"`
+	Changes []string `json:"changes,omitempty"`
+}
+
+// UpdatePlan is the ordered list of operations needed to move a cluster to its desired spec
+type UpdatePlan struct {
+	Operations []NodeOperation `json:"operations"`
+}
+
+func (p UpdatePlan) IsEmpty() bool {
+	return len(p.Operations) == 0
+}
+
+// PlanClusterUpdate diffs the desired cluster spec against the deployed cluster.
+// Nodes are matched by their user facing name. New nodes are added first so the
+// cluster capacity only grows while changed nodes are replaced one by one, then
+// nodes missing from the desired spec are removed, except the nodes of node pools.
+func PlanClusterUpdate(current, desired Cluster) (UpdatePlan, error) {
+	if err := desired.Validate(); err != nil {
+		return UpdatePlan{}, err
+	}
+	for _, node := range desired.Nodes {
+		if node.NodeID == 0 {
+			return UpdatePlan{}, fmt.Errorf("node %q has no node_id, nodes of a deployed cluster are not placed automatically", node.Name)
+		}
+	}
+
+	currentNodes := make(map[string]Node, len(current.Nodes))
+	for _, node := range current.Nodes {
+		currentNodes[node.OriginalName] = node
+	}
+
+	desiredNames := make(map[string]struct{}, len(desired.Nodes))
+	var adds, workerReplaces, masterReplaces, removes []NodeOperation
+
+	for _, node := range desired.Nodes {
+		desiredNames[node.Name] = struct{}{}
+		existing, found := currentNodes[node.Name]
+
+		if !found {
+			if node.Type == NodeTypeLeader {
+				return UpdatePlan{}, fmt.Errorf("node %q cannot be added as a leader, the cluster already has one", node.Name)
+			}
+			adds = append(adds, NodeOperation{Action: NodeActionAdd, NodeName: node.Name, Node: desiredNode(node)})
+			continue
+		}
+
+		changes := nodeSpecChanges(existing, node)
+		if len(changes) == 0 {
+			continue
+		}
+		if existing.Type == NodeTypeLeader {
+			return UpdatePlan{}, fmt.Errorf("leader node %q cannot be changed: %s", node.Name, strings.Join(changes, ", "))
+		}
+
+		op := NodeOperation{Action: NodeActionReplace, NodeName: node.Name, Node: desiredNode(node), Changes: changes}
+		if node.Type == NodeTypeWorker {
+			workerReplaces = append(workerReplaces, op)
+		} else {
+			masterReplaces = append(masterReplaces, op)
+		}
+	}
+
+	for _, node := range current.Nodes {
+		if _, found := desiredNames[node.OriginalName]; found {
+			continue
+		}
+		// pool nodes are managed by scaling their pool
+		if node.Pool != "" {
+			continue
+		}
+		if node.Type == NodeTypeLeader {
+			return UpdatePlan{}, fmt.Errorf("leader node %q cannot be removed", node.OriginalName)
+		}
+		removes = append(removes, NodeOperation{Action: NodeActionRemove, NodeName: node.OriginalName})
+	}
+
+	plan := UpdatePlan{Operations: make([]NodeOperation, 0, len(adds)+len(workerReplaces)+len(masterReplaces)+len(removes))}
+	plan.Operations = append(plan.Operations, adds...)
+	plan.Operations = append(plan.Operations, workerReplaces...)
+	plan.Operations = append(plan.Operations, masterReplaces...)
+	plan.Operations = append(plan.Operations, removes...)
+
+	return plan, nil
+}
+
+func desiredNode(node Node) *Node {
+	node.OriginalName = node.Name
+	if node.EnvVars == nil {
+		node.EnvVars = make(map[string]string)
+	}
+	return &node
+}
+
+// nodeSpecChanges returns the names of the user configurable fields that differ between the deployed and desired node
+func nodeSpecChanges(existing, desired Node) []string {
+	var changes []string
+
+	if !sameNodeRole(existing.Type, desired.Type) {
+		changes = append(changes, "type")
+	}
+	if existing.NodeID != desired.NodeID {
+		changes = append(changes, "node_id")
+	}
+	if existing.CPU != desired.CPU {
+		changes = append(changes, "cpu")
+	}
+	if existing.Memory != desired.Memory {
+		changes = append(changes, "memory")
+	}
+	if existing.RootSize != desired.RootSize {
+		changes = append(changes, "root_size")
+	}
+	if existing.DiskSize != desired.DiskSize {
+		changes = append(changes, "disk_size")
+	}
+	if !sameGPUs(existing.GPUIDs, desired.GPUIDs) {
+		changes = append(changes, "gpu_ids")
+	}
+	if withDefault(existing.Flist, defaultFlist) != withDefault(desired.Flist, defaultFlist) {
+		changes = append(changes, "flist")
+	}
+	if withDefault(existing.Entrypoint, K3S_ENTRYPOINT) != withDefault(desired.Entrypoint, K3S_ENTRYPOINT) {
+		changes = append(changes, "entrypoint")
+	}
+	if existing.PublicIPv4 != desired.PublicIPv4 {
+		changes = append(changes, "public_ipv4")
+	}
+	if existing.PublicIPv6 != desired.PublicIPv6 {
+		changes = append(changes, "public_ipv6")
+	}
+	if !sameUserEnvVars(existing.EnvVars, desired.EnvVars) {
+		changes = append(changes, "env_vars")
+	}
+
+	return changes
+}
+
+func sameNodeRole(a, b NodeType) bool {
+	return isControlPlane(a) == isControlPlane(b)
+}
+
+func sameGPUs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := slices.Clone(a)
+	sortedB := slices.Clone(b)
+	slices.Sort(sortedA)
+	slices.Sort(sortedB)
+	return slices.Equal(sortedA, sortedB)
+}
+
+func withDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func sameUserEnvVars(deployed, desired map[string]string) bool {
+	deployedUser := make(map[string]string, len(deployed))
+	for key, value := range deployed {
+		if !slices.Contains(managedEnvVars, key) {
+			deployedUser[key] = value
+		}
+	}
+
+	desiredUser := make(map[string]string, len(desired))
+	for key, value := range desired {
+		if !slices.Contains(managedEnvVars, key) {
+			desiredUser[key] = value
+		}
+	}
+
+	// the deployed SSH_KEY has the master key appended to the user provided one
+	deployedSSHKey, _, _ := strings.Cut(deployed["SSH_KEY"], "\n")
+	if deployedSSHKey != desired["SSH_KEY"] {
+		return false
+	}
+
+	return maps.Equal(deployedUser, desiredUser)
+}
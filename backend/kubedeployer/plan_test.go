@@ -0,0 +1,169 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func deployedNode(name string, nodeType NodeType, nodeID uint32) Node {
+	return Node{
+		Name:         "kc1test" + name,
+		OriginalName: name,
+		Type:         nodeType,
+		NodeID:       nodeID,
+		CPU:          2,
+		Memory:       4096,
+		RootSize:     10240,
+		DiskSize:     20480,
+		EnvVars: map[string]string{
+			"SSH_KEY":       "user-key\nmaster-key",
+			"K3S_NODE_NAME": "kc1test" + name,
+			"MASTER":        "false",
+		},
+		Flist:      K3S_FLIST,
+		Entrypoint: K3S_ENTRYPOINT,
+		ContractID: uint64(nodeID) * 10,
+	}
+}
+
+func specNode(name string, nodeType NodeType, nodeID uint32) Node {
+	return Node{
+		Name:     name,
+		Type:     nodeType,
+		NodeID:   nodeID,
+		CPU:      2,
+		Memory:   4096,
+		RootSize: 10240,
+		DiskSize: 20480,
+		EnvVars:  map[string]string{"SSH_KEY": "user-key"},
+	}
+}
+
+func TestPlanClusterUpdate(t *testing.T) {
+	current := Cluster{
+		Name: "test",
+		Nodes: []Node{
+			deployedNode("leader", NodeTypeLeader, 1),
+			deployedNode("master", NodeTypeMaster, 2),
+			deployedNode("master2", NodeTypeMaster, 7),
+			deployedNode("worker1", NodeTypeWorker, 3),
+			deployedNode("worker2", NodeTypeWorker, 4),
+		},
+	}
+
+	t.Run("no changes", func(t *testing.T) {
+		desired := Cluster{Name: "test", Nodes: []Node{
+			specNode("leader", NodeTypeMaster, 1),
+			specNode("master", NodeTypeMaster, 2),
+			specNode("master2", NodeTypeMaster, 7),
+			specNode("worker1", NodeTypeWorker, 3),
+			specNode("worker2", NodeTypeWorker, 4),
+		}}
+
+		plan, err := PlanClusterUpdate(current, desired)
+		require.NoError(t, err)
+		require.True(t, plan.IsEmpty())
+	})
+
+	t.Run("adds, replaces and removes in order", func(t *testing.T) {
+		resizedMaster := specNode("master", NodeTypeMaster, 2)
+		resizedMaster.Memory = 8192
+		movedWorker := specNode("worker1", NodeTypeWorker, 5)
+
+		desired := Cluster{Name: "test", Nodes: []Node{
+			specNode("leader", NodeTypeLeader, 1),
+			resizedMaster,
+			specNode("master2", NodeTypeMaster, 7),
+			movedWorker,
+			specNode("worker3", NodeTypeWorker, 6),
+		}}
+
+		plan, err := PlanClusterUpdate(current, desired)
+		require.NoError(t, err)
+		require.Len(t, plan.Operations, 4)
+
+		require.Equal(t, NodeActionAdd, plan.Operations[0].Action)
+		require.Equal(t, "worker3", plan.Operations[0].NodeName)
+
+		require.Equal(t, NodeActionReplace, plan.Operations[1].Action)
+		require.Equal(t, "worker1", plan.Operations[1].NodeName)
+		require.Equal(t, []string{"node_id"}, plan.Operations[1].Changes)
+
+		require.Equal(t, NodeActionReplace, plan.Operations[2].Action)
+		require.Equal(t, "master", plan.Operations[2].NodeName)
+		require.Equal(t, []string{"memory"}, plan.Operations[2].Changes)
+
+		require.Equal(t, NodeActionRemove, plan.Operations[3].Action)
+		require.Equal(t, "worker2", plan.Operations[3].NodeName)
+	})
+
+	t.Run("requesting a public IP replaces the node", func(t *testing.T) {
+		publicWorker := specNode("worker2", NodeTypeWorker, 4)
+		publicWorker.PublicIPv4 = true
+
+		desired := Cluster{Name: "test", Nodes: []Node{
+			specNode("leader", NodeTypeLeader, 1),
+			specNode("master", NodeTypeMaster, 2),
+			specNode("master2", NodeTypeMaster, 7),
+			specNode("worker1", NodeTypeWorker, 3),
+			publicWorker,
+		}}
+
+		plan, err := PlanClusterUpdate(current, desired)
+		require.NoError(t, err)
+		require.Len(t, plan.Operations, 1)
+		require.Equal(t, NodeActionReplace, plan.Operations[0].Action)
+		require.Equal(t, []string{"public_ipv4"}, plan.Operations[0].Changes)
+	})
+
+	t.Run("leader cannot be removed", func(t *testing.T) {
+		desired := Cluster{Name: "test", Nodes: []Node{
+			specNode("master", NodeTypeMaster, 2),
+			specNode("master2", NodeTypeMaster, 7),
+			specNode("master3", NodeTypeMaster, 8),
+		}}
+
+		_, err := PlanClusterUpdate(current, desired)
+		require.Error(t, err)
+	})
+
+	t.Run("leader cannot be changed", func(t *testing.T) {
+		leader := specNode("leader", NodeTypeLeader, 1)
+		leader.CPU = 4
+		desired := Cluster{Name: "test", Nodes: []Node{
+			leader,
+			specNode("master", NodeTypeMaster, 2),
+			specNode("master2", NodeTypeMaster, 7),
+			specNode("worker1", NodeTypeWorker, 3),
+			specNode("worker2", NodeTypeWorker, 4),
+		}}
+
+		_, err := PlanClusterUpdate(current, desired)
+		require.Error(t, err)
+	})
+
+	t.Run("even number of masters is rejected", func(t *testing.T) {
+		desired := Cluster{Name: "test", Nodes: []Node{
+			specNode("leader", NodeTypeLeader, 1),
+			specNode("master", NodeTypeMaster, 2),
+			specNode("worker1", NodeTypeWorker, 3),
+		}}
+
+		_, err := PlanClusterUpdate(current, desired)
+		require.ErrorContains(t, err, "etcd quorum")
+	})
+	t.Run("added nodes need a node id", func(t *testing.T) {
+		desired := Cluster{Name: "test", Nodes: []Node{
+			specNode("leader", NodeTypeLeader, 1),
+			specNode("master", NodeTypeMaster, 2),
+			specNode("master2", NodeTypeMaster, 7),
+			specNode("worker1", NodeTypeWorker, 3),
+			specNode("worker2", NodeTypeWorker, 4),
+			specNode("worker3", NodeTypeWorker, 0),
+		}}
+
+		_, err := PlanClusterUpdate(current, desired)
+		require.ErrorContains(t, err, "no node_id")
+	})
+}
@@ -0,0 +1,193 @@
+package kubedeployer
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	MaxPoolReplicas = 100
+	maxPoolNameLen  = 16 // leaves room for the index of the pool nodes in the 20 characters of a node name
+)
+
+var poolNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*$`)
+
+// NodeTemplate is the spec every node of a pool is created from
+type NodeTemplate struct {
+	CPU      uint8             `json:"cpu" binding:"required,min=1"`
+	Memory   uint64            `json:"memory" binding:"required,min=2048"`     // Memory in MB
+	RootSize uint64            `json:"root_size" binding:"required,min=5120"`  // Storage in MB
+	DiskSize uint64            `json:"disk_size" binding:"required,min=10240"` // Storage in MB
+	EnvVars  map[string]string `json:"env_vars,omitempty"`
+
+	// Optional fields
+	Flist      string `json:"flist,omitempty"`
+	Entrypoint string `json:"entrypoint,omitempty"`
+	PublicIPv4 bool   `json:"public_ipv4,omitempty"`
+	PublicIPv6 bool   `json:"public_ipv6,omitempty"`
+}
+
+// Taint is a Kubernetes taint set on the nodes of a pool
+type Taint struct {
+	Key    string `json:"key" binding:"required"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect" binding:"required,oneof=NoSchedule PreferNoSchedule NoExecute"`
+}
+
+// NodePool is a group of identical worker nodes scaled by their replica count.
+// Its nodes are named after the pool followed by an index, e.g. web1, web2.
+type NodePool struct {
+	Name     string       `json:"name"`
+	Template NodeTemplate `json:"template"`
+	Replicas int          `json:"replicas"`
+	// Kubernetes labels and taints of the pool nodes
+	Labels map[string]string `json:"labels,omitempty"`
+	Taints []Taint           `json:"taints,omitempty"`
+	// Candidates are the grid nodes the pool nodes can be placed on, any rented node of the user when empty
+	Candidates []uint32 `json:"candidates,omitempty"`
+}
+
+// Validate checks the pool can be scaled and its labels and taints are valid Kubernetes ones
+func (p *NodePool) Validate() error {
+	if len(p.Name) < 3 || len(p.Name) > maxPoolNameLen || !poolNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("pool name must be 3 to %d alphanumeric characters starting with a letter", maxPoolNameLen)
+	}
+	if p.Replicas < 0 || p.Replicas > MaxPoolReplicas {
+		return fmt.Errorf("pool replicas must be between 0 and %d", MaxPoolReplicas)
+	}
+
+	for key, value := range p.Labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value of label %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
+
+	seen := make(map[string]struct{}, len(p.Taints))
+	for _, taint := range p.Taints {
+		if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+			return fmt.Errorf("invalid taint key %q: %s", taint.Key, strings.Join(errs, "; "))
+		}
+		if taint.Value != "" {
+			if errs := validation.IsValidLabelValue(taint.Value); len(errs) > 0 {
+				return fmt.Errorf("invalid value of taint %q: %s", taint.Key, strings.Join(errs, "; "))
+			}
+		}
+		switch taint.Effect {
+		case "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return fmt.Errorf("invalid effect %q of taint %q", taint.Effect, taint.Key)
+		}
+		id := taint.Key + ":" + taint.Effect
+		if _, duplicate := seen[id]; duplicate {
+			return fmt.Errorf("duplicate taint %s", id)
+		}
+		seen[id] = struct{}{}
+	}
+
+	return nil
+}
+
+// NewNode returns a worker node of the pool created from its template
+func (p *NodePool) NewNode(name string) Node {
+	return Node{
+		Name:         name,
+		OriginalName: name,
+		Type:         NodeTypeWorker,
+		Pool:         p.Name,
+		CPU:          p.Template.CPU,
+		Memory:       p.Template.Memory,
+		RootSize:     p.Template.RootSize,
+		DiskSize:     p.Template.DiskSize,
+		EnvVars:      cloneEnvVars(p.Template.EnvVars),
+		Flist:        p.Template.Flist,
+		Entrypoint:   p.Template.Entrypoint,
+		PublicIPv4:   p.Template.PublicIPv4,
+		PublicIPv6:   p.Template.PublicIPv6,
+	}
+}
+
+func cloneEnvVars(envVars map[string]string) map[string]string {
+	clone := make(map[string]string, len(envVars))
+	for key, value := range envVars {
+		clone[key] = value
+	}
+	return clone
+}
+
+// Pool returns the pool with this name
+func (c *Cluster) Pool(name string) (NodePool, bool) {
+	idx := slices.IndexFunc(c.Pools, func(p NodePool) bool { return p.Name == name })
+	if idx == -1 {
+		return NodePool{}, false
+	}
+	return c.Pools[idx], true
+}
+
+// SetPool adds a pool to the cluster or replaces the one with the same name
+func (c *Cluster) SetPool(pool NodePool) {
+	idx := slices.IndexFunc(c.Pools, func(p NodePool) bool { return p.Name == pool.Name })
+	if idx == -1 {
+		c.Pools = append(c.Pools, pool)
+		return
+	}
+	c.Pools[idx] = pool
+}
+
+// PoolNodes returns the nodes of a pool ordered by their index
+func (c *Cluster) PoolNodes(pool string) []Node {
+	var nodes []Node
+	for _, node := range c.Nodes {
+		if node.Pool == pool {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return poolNodeIndex(pool, nodes[i].OriginalName) < poolNodeIndex(pool, nodes[j].OriginalName)
+	})
+	return nodes
+}
+
+// poolNodeIndex returns the index of a pool node from its name, or 0 when it doesn't follow the pool naming
+func poolNodeIndex(pool, name string) int {
+	index, err := strconv.Atoi(strings.TrimPrefix(name, pool))
+	if err != nil || !strings.HasPrefix(name, pool) {
+		return 0
+	}
+	return index
+}
+
+// PlanPoolScale returns the nodes to add to, or the names of the nodes to remove from, the cluster so that the pool
+// has its number of replicas. New nodes take the lowest free indexes and the nodes with the highest indexes are removed first.
+func PlanPoolScale(cluster Cluster, pool NodePool) (add []Node, remove []string) {
+	current := cluster.PoolNodes(pool.Name)
+
+	if len(current) > pool.Replicas {
+		for i := len(current) - 1; i >= pool.Replicas; i-- {
+			remove = append(remove, current[i].OriginalName)
+		}
+		return nil, remove
+	}
+
+	used := make(map[string]struct{}, len(cluster.Nodes))
+	for _, node := range cluster.Nodes {
+		used[node.OriginalName] = struct{}{}
+	}
+
+	for index := 1; len(current)+len(add) < pool.Replicas; index++ {
+		name := fmt.Sprintf("%s%d", pool.Name, index)
+		if _, taken := used[name]; taken {
+			continue
+		}
+		add = append(add, pool.NewNode(name))
+	}
+	return add, nil
+}
@@ -0,0 +1,117 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func poolNode(pool, name string) Node {
+	return Node{Name: "kc1test" + name, OriginalName: name, Type: NodeTypeWorker, Pool: pool}
+}
+
+func nodeNames(nodes []Node) []string {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.OriginalName)
+	}
+	return names
+}
+
+func TestNodePoolValidate(t *testing.T) {
+	valid := NodePool{
+		Name:     "web",
+		Replicas: 3,
+		Labels:   map[string]string{"tier": "frontend"},
+		Taints:   []Taint{{Key: "dedicated", Value: "web", Effect: "NoSchedule"}},
+	}
+	require.NoError(t, valid.Validate())
+
+	for name, modify := range map[string]func(p *NodePool){
+		"short name":        func(p *NodePool) { p.Name = "ab" },
+		"name with dash":    func(p *NodePool) { p.Name = "web-pool" },
+		"too many replicas": func(p *NodePool) { p.Replicas = MaxPoolReplicas + 1 },
+		"negative replicas": func(p *NodePool) { p.Replicas = -1 },
+		"label key":         func(p *NodePool) { p.Labels = map[string]string{"bad key": "x"} },
+		"taint effect":      func(p *NodePool) { p.Taints = []Taint{{Key: "dedicated", Effect: "Evict"}} },
+		"duplicate taint": func(p *NodePool) {
+			p.Taints = []Taint{{Key: "dedicated", Effect: "NoSchedule"}, {Key: "dedicated", Value: "x", Effect: "NoSchedule"}}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pool := valid
+			modify(&pool)
+			assert.Error(t, pool.Validate())
+		})
+	}
+}
+
+func TestPlanPoolScale(t *testing.T) {
+	cluster := Cluster{
+		Name: "test",
+		Nodes: []Node{
+			deployedNode("leader", NodeTypeLeader, 1),
+			poolNode("web", "web1"),
+			poolNode("web", "web3"),
+			poolNode("web", "web10"),
+			poolNode("api", "api1"),
+			deployedNode("web2", NodeTypeWorker, 2),
+		},
+	}
+	pool := NodePool{
+		Name:     "web",
+		Template: NodeTemplate{CPU: 2, Memory: 4096, RootSize: 10240, DiskSize: 20480, EnvVars: map[string]string{"SSH_KEY": "key"}},
+	}
+
+	t.Run("scale up takes the free indexes", func(t *testing.T) {
+		pool.Replicas = 6
+		add, remove := PlanPoolScale(cluster, pool)
+		assert.Empty(t, remove)
+		assert.Equal(t, []string{"web4", "web5", "web6"}, nodeNames(add))
+		for _, node := range add {
+			assert.Equal(t, "web", node.Pool)
+			assert.Equal(t, NodeTypeWorker, node.Type)
+			assert.Equal(t, node.OriginalName, node.Name)
+			assert.Equal(t, uint8(2), node.CPU)
+		}
+
+		add[0].EnvVars["SSH_KEY"] = "changed"
+		assert.Equal(t, "key", pool.Template.EnvVars["SSH_KEY"], "pool nodes don't share the template env vars")
+	})
+
+	t.Run("scale down removes the highest indexes", func(t *testing.T) {
+		pool.Replicas = 1
+		add, remove := PlanPoolScale(cluster, pool)
+		assert.Empty(t, add)
+		assert.Equal(t, []string{"web10", "web3"}, remove)
+	})
+
+	t.Run("scale to zero", func(t *testing.T) {
+		pool.Replicas = 0
+		_, remove := PlanPoolScale(cluster, pool)
+		assert.Equal(t, []string{"web10", "web3", "web1"}, remove)
+	})
+
+	t.Run("nothing to do", func(t *testing.T) {
+		pool.Replicas = 3
+		add, remove := PlanPoolScale(cluster, pool)
+		assert.Empty(t, add)
+		assert.Empty(t, remove)
+	})
+}
+
+func TestPlanClusterUpdateKeepsPoolNodes(t *testing.T) {
+	current := Cluster{
+		Name: "test",
+		Nodes: []Node{
+			deployedNode("leader", NodeTypeLeader, 1),
+			deployedNode("web1", NodeTypeWorker, 2),
+		},
+	}
+	current.Nodes[1].Pool = "web"
+
+	plan, err := PlanClusterUpdate(current, Cluster{Name: "test", Nodes: []Node{specNode("leader", NodeTypeMaster, 1)}})
+	require.NoError(t, err)
+	assert.True(t, plan.IsEmpty())
+}
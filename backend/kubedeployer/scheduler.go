@@ -0,0 +1,150 @@
+package kubedeployer
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// NodeCapacity is the free capacity of a grid node that cluster nodes can be placed on
+type NodeCapacity struct {
+	NodeID uint32
+	CPU    uint64 // free vCPUs
+	Memory uint64 // free memory in MB
+	Disk   uint64 // free SSD storage in MB
+	GPUs   []string
+	// FarmID and FarmFreeIPs tell whether nodes asking for a public IPv4 fit, the farm's IPs are shared by its nodes
+	FarmID      uint32
+	FarmFreeIPs uint64
+	// Rented is false for rentable nodes that still have to be reserved by the user
+	Rented bool
+}
+
+// Placement records the grid node chosen for a cluster node
+type Placement struct {
+	NodeName string `json:"node_name"`
+	NodeID   uint32 `json:"node_id"`
+	// RequiresRent is set when the grid node is not rented by the user yet
+	RequiresRent bool `json:"requires_rent,omitempty"`
+}
+
+// UnschedulableError is returned when some cluster nodes do not fit on any candidate
+type UnschedulableError struct {
+	Nodes []string
+}
+
+func (e *UnschedulableError) Error() string {
+	return fmt.Sprintf("no grid node has enough free capacity for: %s", strings.Join(e.Nodes, ", "))
+}
+
+// ScheduleNodes assigns a grid node to every cluster node without a node_id.
+// A grid node hosts a single node of the cluster, nodes with a node_id are kept where they are and block their grid node.
+// Control plane nodes are placed first so they get the largest grid nodes,
+// rented nodes are preferred over rentable ones and the least loaded grid node wins.
+// Nodes asking for a public IPv4 are only placed on farms that have a free one left.
+// Cluster nodes are only updated when every node could be placed.
+func ScheduleNodes(nodes []Node, candidates []NodeCapacity) ([]Placement, error) {
+	farms := make(map[uint32]uint32, len(candidates))
+	for _, candidate := range candidates {
+		farms[candidate.NodeID] = candidate.FarmID
+	}
+
+	taken := make(map[uint32]struct{})
+	// public IPv4s of each farm that placed nodes will take
+	usedIPs := make(map[uint32]uint64)
+	for _, node := range nodes {
+		if node.NodeID != 0 {
+			taken[node.NodeID] = struct{}{}
+			if farmID, ok := farms[node.NodeID]; ok && needsPublicIPv4(node) {
+				usedIPs[farmID]++
+			}
+		}
+	}
+
+	order := make([]int, 0, len(nodes))
+	for i, node := range nodes {
+		if node.NodeID == 0 {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return nodeTypeRank(nodes[order[a]].Type) < nodeTypeRank(nodes[order[b]].Type)
+	})
+
+	chosen := make(map[int]int, len(order))
+	var unschedulable []string
+	for _, i := range order {
+		node := nodes[i]
+		best := -1
+		for j, candidate := range candidates {
+			if _, ok := taken[candidate.NodeID]; ok || !fits(candidate, node) {
+				continue
+			}
+			if needsPublicIPv4(node) && candidate.FarmFreeIPs <= usedIPs[candidate.FarmID] {
+				continue
+			}
+			if best == -1 || betterCandidate(candidate, candidates[best]) {
+				best = j
+			}
+		}
+
+		if best == -1 {
+			unschedulable = append(unschedulable, node.Name)
+			continue
+		}
+
+		chosen[i] = best
+		taken[candidates[best].NodeID] = struct{}{}
+		if needsPublicIPv4(node) {
+			usedIPs[candidates[best].FarmID]++
+		}
+	}
+
+	if len(unschedulable) > 0 {
+		return nil, &UnschedulableError{Nodes: unschedulable}
+	}
+
+	placements := make([]Placement, 0, len(nodes))
+	for i := range nodes {
+		if j, ok := chosen[i]; ok {
+			nodes[i].NodeID = candidates[j].NodeID
+			placements = append(placements, Placement{NodeName: nodes[i].Name, NodeID: candidates[j].NodeID, RequiresRent: !candidates[j].Rented})
+			continue
+		}
+		placements = append(placements, Placement{NodeName: nodes[i].Name, NodeID: nodes[i].NodeID})
+	}
+
+	return placements, nil
+}
+
+func isControlPlane(t NodeType) bool {
+	return t == NodeTypeLeader || t == NodeTypeMaster
+}
+
+// needsPublicIPv4 reports whether a node still has to reserve a public IPv4 of its farm
+func needsPublicIPv4(node Node) bool {
+	return node.PublicIPv4 && node.PublicIP == ""
+}
+
+func fits(capacity NodeCapacity, node Node) bool {
+	if capacity.CPU < uint64(node.CPU) || capacity.Memory < node.Memory || capacity.Disk < node.RootSize+node.DiskSize {
+		return false
+	}
+	for _, gpu := range node.GPUIDs {
+		if !slices.Contains(capacity.GPUs, gpu) {
+			return false
+		}
+	}
+	return true
+}
+
+func betterCandidate(a, b NodeCapacity) bool {
+	if a.Rented != b.Rented {
+		return a.Rented
+	}
+	if a.Memory != b.Memory {
+		return a.Memory > b.Memory
+	}
+	return a.CPU > b.CPU
+}
@@ -0,0 +1,111 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleNodes(t *testing.T) {
+	candidates := []NodeCapacity{
+		{NodeID: 1, CPU: 8, Memory: 16384, Disk: 102400, Rented: true},
+		{NodeID: 2, CPU: 8, Memory: 32768, Disk: 102400, Rented: true},
+		{NodeID: 3, CPU: 8, Memory: 65536, Disk: 102400, GPUs: []string{"gpu-1"}, Rented: false},
+		{NodeID: 4, CPU: 8, Memory: 8192, Disk: 102400, Rented: true},
+	}
+
+	t.Run("control plane first and rented nodes are preferred", func(t *testing.T) {
+		nodes := []Node{
+			specNode("worker", NodeTypeWorker, 0),
+			specNode("leader", NodeTypeLeader, 0),
+			specNode("master", NodeTypeMaster, 0),
+		}
+
+		placements, err := ScheduleNodes(nodes, candidates)
+		require.NoError(t, err)
+		require.Len(t, placements, 3)
+
+		require.Equal(t, uint32(2), nodes[1].NodeID)
+		require.Equal(t, uint32(1), nodes[2].NodeID)
+		require.Equal(t, uint32(4), nodes[0].NodeID)
+		for _, placement := range placements {
+			require.False(t, placement.RequiresRent)
+		}
+	})
+
+	t.Run("a grid node hosts a single cluster node", func(t *testing.T) {
+		nodes := []Node{
+			specNode("leader", NodeTypeLeader, 2),
+			specNode("master", NodeTypeMaster, 0),
+			specNode("worker", NodeTypeWorker, 0),
+			specNode("worker2", NodeTypeWorker, 0),
+		}
+
+		_, err := ScheduleNodes(nodes, candidates[:2])
+		var unschedulable *UnschedulableError
+		require.ErrorAs(t, err, &unschedulable)
+		require.Equal(t, []string{"worker", "worker2"}, unschedulable.Nodes)
+
+		nodes = nodes[:3]
+		_, err = ScheduleNodes(nodes, candidates)
+		require.NoError(t, err)
+		require.Equal(t, uint32(1), nodes[1].NodeID)
+		require.Equal(t, uint32(4), nodes[2].NodeID)
+	})
+
+	t.Run("gpu requests need a node with the free gpu", func(t *testing.T) {
+		worker := specNode("worker", NodeTypeWorker, 0)
+		worker.GPUIDs = []string{"gpu-1"}
+		nodes := []Node{worker}
+
+		placements, err := ScheduleNodes(nodes, candidates)
+		require.NoError(t, err)
+		require.Equal(t, uint32(3), nodes[0].NodeID)
+		require.True(t, placements[0].RequiresRent)
+	})
+
+	t.Run("public ipv4 nodes need a free ip on the farm", func(t *testing.T) {
+		candidates := []NodeCapacity{
+			{NodeID: 1, CPU: 8, Memory: 65536, Disk: 102400, FarmID: 1, Rented: true},
+			{NodeID: 2, CPU: 8, Memory: 16384, Disk: 102400, FarmID: 2, FarmFreeIPs: 1, Rented: true},
+			{NodeID: 3, CPU: 8, Memory: 8192, Disk: 102400, FarmID: 2, FarmFreeIPs: 1, Rented: true},
+		}
+
+		leader := specNode("leader", NodeTypeLeader, 0)
+		leader.PublicIPv4 = true
+		nodes := []Node{leader}
+		_, err := ScheduleNodes(nodes, candidates)
+		require.NoError(t, err)
+		require.Equal(t, uint32(2), nodes[0].NodeID, "the farm of the largest node has no free ip")
+
+		master := specNode("master", NodeTypeMaster, 0)
+		master.PublicIPv4 = true
+		nodes = []Node{leader, master}
+		nodes[0].NodeID = 0
+		_, err = ScheduleNodes(nodes, candidates)
+		var unschedulable *UnschedulableError
+		require.ErrorAs(t, err, &unschedulable)
+		require.Equal(t, []string{"master"}, unschedulable.Nodes, "both nodes can't take the only free ip of the farm")
+
+		// a node that already got its public ip doesn't take another one
+		deployed := specNode("leader", NodeTypeLeader, 2)
+		deployed.PublicIPv4 = true
+		deployed.PublicIP = "185.206.122.10/24"
+		nodes = []Node{deployed, master}
+		_, err = ScheduleNodes(nodes, candidates)
+		require.NoError(t, err)
+		require.Equal(t, uint32(3), nodes[1].NodeID)
+	})
+
+	t.Run("unschedulable nodes are reported and nothing is assigned", func(t *testing.T) {
+		big := specNode("big", NodeTypeWorker, 0)
+		big.Memory = 131072
+		nodes := []Node{specNode("small", NodeTypeWorker, 0), big}
+
+		_, err := ScheduleNodes(nodes, candidates)
+		var unschedulable *UnschedulableError
+		require.ErrorAs(t, err, &unschedulable)
+		require.Equal(t, []string{"big"}, unschedulable.Nodes)
+		require.Zero(t, nodes[0].NodeID)
+	})
+}
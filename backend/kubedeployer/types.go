@@ -24,6 +24,19 @@ type Cluster struct {
 	Token string `json:"token"`
 	Nodes []Node `json:"nodes" binding:"required,min=1,dive"`
 
+	// Private network, CIDR is a /16 defaulting to one not used by the other clusters of the user, outside of the
+	// k3s pod and service ranges. IPv6CIDR is an optional ULA prefix holding the IPv6 pod and service ranges of the
+	// dual-stack k3s cluster. Nodes get no private IPv6 address from it: the grid network is IPv4 only, so nodes
+	// reach each other over their mycelium IPv6 address.
+	CIDR     string `json:"cidr,omitempty"`
+	IPv6CIDR string `json:"ipv6_cidr,omitempty"`
+
+	// Addons are installed once the nodes are ready
+	Addons []Addon `json:"addons,omitempty"`
+
+	// Pools of identical workers, scaled with PUT /deployments/{name}/pools/{pool}
+	Pools []NodePool `json:"pools,omitempty"`
+
 	// Computed
 	Network     workloads.ZNet `json:"network,omitempty"`
 	ProjectName string         `json:"project_name,omitempty"`
@@ -32,7 +45,7 @@ type Cluster struct {
 type Node struct {
 	Name   string   `json:"name" binding:"required,min=3,max=20,alphanum"`
 	Type   NodeType `json:"type" binding:"required,oneof=worker master leader"`
-	NodeID uint32   `json:"node_id" binding:"required"`
+	NodeID uint32   `json:"node_id"` // Optional, the node is placed automatically when empty
 
 	CPU      uint8             `json:"cpu" binding:"required,min=1"`
 	Memory   uint64            `json:"memory" binding:"required,min=2048"`     // Memory in MB
@@ -44,23 +57,34 @@ type Node struct {
 	// Optional fields
 	Flist      string `json:"flist,omitempty"`
 	Entrypoint string `json:"entrypoint,omitempty"`
+	PublicIPv4 bool   `json:"public_ipv4,omitempty"` // Reserve a public IPv4 of the farm for the VM
+	PublicIPv6 bool   `json:"public_ipv6,omitempty"` // Reserve a public IPv6 of the farm for the VM
+	Pool       string `json:"pool,omitempty"`        // Name of the node pool the node belongs to
 
 	// Computed
-	IP           string `json:"ip,omitempty"`
-	MyceliumIP   string `json:"mycelium_ip,omitempty"`
-	PlanetaryIP  string `json:"planetary_ip,omitempty"`
-	ContractID   uint64 `json:"contract_id,omitempty"`
-	OriginalName string `json:"original_name,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	PublicIP    string `json:"public_ip,omitempty"`
+	PublicIP6   string `json:"public_ip6,omitempty"`
+	MyceliumIP  string `json:"mycelium_ip,omitempty"`
+	PlanetaryIP string `json:"planetary_ip,omitempty"`
+	// MyceliumIPSeed is kept so a redeployed VM gets the same mycelium IP
+	MyceliumIPSeed []byte `json:"mycelium_ip_seed,omitempty"`
+	ContractID     uint64 `json:"contract_id,omitempty"`
+	OriginalName   string `json:"original_name,omitempty"`
 }
 
 // MarshalJSON implements custom JSON marshaling for Cluster
 func (c Cluster) MarshalJSON() ([]byte, error) {
 	// Create a serializable version of the cluster
 	serializable := struct {
-		Name        string `json:"name"`
-		Token       string `json:"token"`
-		Nodes       []Node `json:"nodes"`
-		ProjectName string `json:"project_name,omitempty"`
+		Name        string     `json:"name"`
+		Token       string     `json:"token"`
+		Nodes       []Node     `json:"nodes"`
+		CIDR        string     `json:"cidr,omitempty"`
+		IPv6CIDR    string     `json:"ipv6_cidr,omitempty"`
+		Addons      []Addon    `json:"addons,omitempty"`
+		Pools       []NodePool `json:"pools,omitempty"`
+		ProjectName string     `json:"project_name,omitempty"`
 		// TODO: add new network object (serialized, minimal, mapped to workloads.ZNet)
 		Network struct {
 			Name             string            `json:"name"`
@@ -83,6 +107,10 @@ func (c Cluster) MarshalJSON() ([]byte, error) {
 		Name:        c.Name,
 		Token:       c.Token,
 		Nodes:       c.Nodes,
+		CIDR:        c.CIDR,
+		IPv6CIDR:    c.IPv6CIDR,
+		Addons:      c.Addons,
+		Pools:       c.Pools,
 		ProjectName: c.ProjectName,
 	}
 
@@ -155,10 +183,14 @@ func (c Cluster) MarshalJSON() ([]byte, error) {
 func (c *Cluster) UnmarshalJSON(data []byte) error {
 	// First unmarshal into a temporary structure
 	var temp struct {
-		Name        string `json:"name"`
-		Token       string `json:"token"`
-		Nodes       []Node `json:"nodes"`
-		ProjectName string `json:"project_name,omitempty"`
+		Name        string     `json:"name"`
+		Token       string     `json:"token"`
+		Nodes       []Node     `json:"nodes"`
+		CIDR        string     `json:"cidr,omitempty"`
+		IPv6CIDR    string     `json:"ipv6_cidr,omitempty"`
+		Addons      []Addon    `json:"addons,omitempty"`
+		Pools       []NodePool `json:"pools,omitempty"`
+		ProjectName string     `json:"project_name,omitempty"`
 		Network     struct {
 			Name             string            `json:"name"`
 			Description      string            `json:"description"`
@@ -186,6 +218,10 @@ func (c *Cluster) UnmarshalJSON(data []byte) error {
 	c.Name = temp.Name
 	c.Token = temp.Token
 	c.Nodes = temp.Nodes
+	c.CIDR = temp.CIDR
+	c.IPv6CIDR = temp.IPv6CIDR
+	c.Addons = temp.Addons
+	c.Pools = temp.Pools
 	c.ProjectName = temp.ProjectName
 
 	// Initialize network with basic fields
@@ -320,12 +356,93 @@ func (c *Cluster) Validate() error {
 		}
 		nodeNames[node.Name] = struct{}{}
 
+		// nodes without a node_id are placed once the cluster is validated
+		if node.NodeID == 0 {
+			continue
+		}
 		if _, exists := nodeIDs[node.NodeID]; exists {
 			return fmt.Errorf("duplicate node id found: %d", node.NodeID)
 		}
 		nodeIDs[node.NodeID] = struct{}{}
 	}
 
+	if c.CIDR != "" {
+		if err := ValidateClusterCIDR(c.CIDR); err != nil {
+			return err
+		}
+	}
+	if c.IPv6CIDR != "" {
+		if err := ValidateIPv6CIDR(c.IPv6CIDR); err != nil {
+			return err
+		}
+	}
+
+	if err := ValidateAddons(c.Addons); err != nil {
+		return err
+	}
+
+	for _, pool := range c.Pools {
+		if err := pool.Validate(); err != nil {
+			return fmt.Errorf("invalid pool %q: %w", pool.Name, err)
+		}
+	}
+
+	return ValidateControlPlane(c.Nodes)
+}
+
+// ValidateControlPlane checks that the masters can form an etcd quorum: a single master or an HA control plane of 3 or 5
+func ValidateControlPlane(nodes []Node) error {
+	controlPlane, err := countControlPlane(nodes)
+	if err != nil {
+		return err
+	}
+	return validateControlPlaneSize(controlPlane)
+}
+
+// ValidateControlPlaneScaling checks the control plane of a cluster growing towards target masters one node at a time,
+// the intermediate even sizes are allowed as long as they don't go past target
+func ValidateControlPlaneScaling(nodes []Node, target int) error {
+	if err := validateControlPlaneSize(target); err != nil {
+		return fmt.Errorf("invalid target control plane size: %w", err)
+	}
+
+	controlPlane, err := countControlPlane(nodes)
+	if err != nil {
+		return err
+	}
+	if controlPlane == 0 {
+		return fmt.Errorf("at least one master node is required")
+	}
+	if controlPlane > target {
+		return fmt.Errorf("the control plane would have %d master nodes, more than the target of %d", controlPlane, target)
+	}
+	return nil
+}
+
+func countControlPlane(nodes []Node) (int, error) {
+	leaders, masters := 0, 0
+	for _, node := range nodes {
+		switch node.Type {
+		case NodeTypeLeader:
+			leaders++
+		case NodeTypeMaster:
+			masters++
+		}
+	}
+
+	if leaders > 1 {
+		return 0, fmt.Errorf("only one leader node is allowed, found %d", leaders)
+	}
+	return leaders + masters, nil
+}
+
+func validateControlPlaneSize(controlPlane int) error {
+	if controlPlane == 0 {
+		return fmt.Errorf("at least one master node is required")
+	}
+	if controlPlane > 1 && controlPlane != 3 && controlPlane != 5 {
+		return fmt.Errorf("an HA control plane needs 3 or 5 master nodes to keep etcd quorum, found %d", controlPlane)
+	}
 	return nil
 }
 
@@ -0,0 +1,52 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func controlPlaneNodes(masters int) []Node {
+	nodes := []Node{{Name: "leader", Type: NodeTypeLeader}}
+	for i := 1; i < masters; i++ {
+		nodes = append(nodes, Node{Name: "master", Type: NodeTypeMaster})
+	}
+	return append(nodes, Node{Name: "worker", Type: NodeTypeWorker})
+}
+
+func TestValidateControlPlane(t *testing.T) {
+	for _, size := range []int{1, 3, 5} {
+		require.NoError(t, ValidateControlPlane(controlPlaneNodes(size)), "size %d", size)
+	}
+	for _, size := range []int{2, 4, 6} {
+		require.Error(t, ValidateControlPlane(controlPlaneNodes(size)), "size %d", size)
+	}
+	require.Error(t, ValidateControlPlane([]Node{{Type: NodeTypeWorker}}))
+	require.Error(t, ValidateControlPlane([]Node{{Type: NodeTypeLeader}, {Type: NodeTypeLeader}, {Type: NodeTypeMaster}}))
+}
+
+func TestValidateControlPlaneScaling(t *testing.T) {
+	// masters are added one at a time towards the target
+	for size := 1; size <= 3; size++ {
+		require.NoError(t, ValidateControlPlaneScaling(controlPlaneNodes(size), 3), "size %d", size)
+	}
+	for size := 3; size <= 5; size++ {
+		require.NoError(t, ValidateControlPlaneScaling(controlPlaneNodes(size), 5), "size %d", size)
+	}
+
+	require.Error(t, ValidateControlPlaneScaling(controlPlaneNodes(4), 3), "past the target")
+	require.Error(t, ValidateControlPlaneScaling(controlPlaneNodes(2), 4), "target without quorum")
+	require.Error(t, ValidateControlPlaneScaling([]Node{{Type: NodeTypeWorker}}, 3))
+}
+
+func TestClusterValidateNodeIDs(t *testing.T) {
+	cluster := Cluster{Nodes: []Node{
+		{Name: "leader", Type: NodeTypeLeader, NodeID: 1},
+		{Name: "worker1", Type: NodeTypeWorker},
+		{Name: "worker2", Type: NodeTypeWorker},
+	}}
+	require.NoError(t, cluster.Validate(), "nodes without a node_id are placed later")
+
+	cluster.Nodes[2].NodeID = 1
+	require.ErrorContains(t, cluster.Validate(), "duplicate node id found: 1")
+}
@@ -0,0 +1,105 @@
+package kubedeployer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"kubecloud/internal/logger"
+
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	zosTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
+)
+
+// RedeployNode replaces the VM of a node with one booting the given flist.
+// The VM is removed from its deployment and added back under the same contract,
+// so the data disk holding the k3s state and the private IP are kept.
+func (c *Client) RedeployNode(ctx context.Context, cluster *Cluster, nodeName, flist string) error {
+	idx := slices.IndexFunc(cluster.Nodes, func(n Node) bool { return n.Name == nodeName })
+	if idx == -1 {
+		return fmt.Errorf("node %s not found in cluster", nodeName)
+	}
+	node := cluster.Nodes[idx]
+
+	depl, err := c.GridClient.State.LoadDeploymentFromGrid(ctx, node.NodeID, node.Name)
+	if err != nil {
+		return fmt.Errorf("failed to load deployment for node %s: %v", node.Name, err)
+	}
+	depl.NodeDeploymentID = map[uint32]uint64{node.NodeID: depl.ContractID}
+
+	// a previous attempt may have already removed the VM, rebuild it from the stored node then
+	var vm workloads.VM
+	if len(depl.Vms) > 0 {
+		vm = depl.Vms[0]
+	} else {
+		vm, err = vmFromNode(node, cluster.Network.Name)
+		if err != nil {
+			return err
+		}
+	}
+	vm.Flist = flist
+
+	if len(depl.Vms) > 0 {
+		logger.GetLogger().Debug().Str("node_name", node.Name).Msg("Removing VM while keeping its data disk")
+		depl.Vms = nil
+		if err := c.GridClient.DeploymentDeployer.Deploy(ctx, &depl); err != nil {
+			return fmt.Errorf("failed to remove VM of node %s: %v", node.Name, err)
+		}
+	}
+
+	logger.GetLogger().Debug().Str("node_name", node.Name).Str("flist", flist).Msg("Deploying VM with new flist")
+	depl.Vms = []workloads.VM{vm}
+	if err := c.GridClient.DeploymentDeployer.Deploy(ctx, &depl); err != nil {
+		return fmt.Errorf("failed to redeploy node %s: %v", node.Name, err)
+	}
+
+	result, err := c.GridClient.State.LoadDeploymentFromGrid(ctx, node.NodeID, node.Name)
+	if err != nil {
+		return fmt.Errorf("failed to load deployment for node %s: %v", node.Name, err)
+	}
+
+	res, err := nodeFromDeployment(result)
+	if err != nil {
+		return fmt.Errorf("failed to get node from deployment: %v", err)
+	}
+	res.OriginalName = node.OriginalName
+	res.Type = node.Type
+	cluster.Nodes[idx] = res
+
+	return nil
+}
+
+func vmFromNode(node Node, networkName string) (workloads.VM, error) {
+	ipSeed, err := myceliumIPSeed(node)
+	if err != nil {
+		return workloads.VM{}, err
+	}
+
+	var gpus []zosTypes.GPU
+	for _, gpuID := range node.GPUIDs {
+		gpus = append(gpus, zosTypes.GPU(gpuID))
+	}
+
+	return workloads.VM{
+		Name:           node.Name,
+		NodeID:         node.NodeID,
+		CPU:            node.CPU,
+		MemoryMB:       node.Memory,
+		RootfsSizeMB:   node.RootSize,
+		EnvVars:        node.EnvVars,
+		Flist:          node.Flist,
+		Entrypoint:     withDefault(node.Entrypoint, K3S_ENTRYPOINT),
+		NetworkName:    networkName,
+		IP:             node.IP,
+		PublicIP:       node.PublicIPv4,
+		PublicIP6:      node.PublicIPv6,
+		MyceliumIPSeed: ipSeed,
+		Mounts: []workloads.Mount{
+			{
+				Name:       fmt.Sprintf("%s_data", node.Name),
+				MountPoint: K3S_DATA_DIR,
+			},
+		},
+		GPUs: gpus,
+	}, nil
+}
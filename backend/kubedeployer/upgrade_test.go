@@ -0,0 +1,22 @@
+package kubedeployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zosTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
+)
+
+func TestVMFromNodeKeepsMyceliumIPSeed(t *testing.T) {
+	seed := []byte{1, 2, 3, 4, 5, 6}
+	node := Node{Name: "worker1", Type: NodeTypeWorker, NodeID: 11, EnvVars: map[string]string{}, MyceliumIPSeed: seed}
+
+	vm, err := vmFromNode(node, "net")
+	require.NoError(t, err)
+	require.Equal(t, seed, vm.MyceliumIPSeed)
+
+	node.MyceliumIPSeed = nil
+	vm, err = vmFromNode(node, "net")
+	require.NoError(t, err)
+	require.Len(t, vm.MyceliumIPSeed, zosTypes.MyceliumIPSeedLen)
+}
@@ -2,14 +2,30 @@ package middlewares
 
 import (
 	"kubecloud/internal"
+	"kubecloud/internal/logger"
+	"kubecloud/kubedeployer"
+	"kubecloud/models"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// UserMiddleware function validates users token
-func UserMiddleware(tokenManager internal.TokenManager) gin.HandlerFunc {
+// apiTokenRoute is a route an API token scope grants, matched against the end of the route path
+type apiTokenRoute struct {
+	method string // empty for any method
+	path   string
+}
+
+// apiTokenRoutes are the deployment routes each API token scope grants, all other routes reject API tokens
+var apiTokenRoutes = map[string][]apiTokenRoute{
+	models.APITokenScopeK8s: {
+		{path: "/deployments/:name/k8s/*path"},
+	},
+}
+
+// UserMiddleware function validates users token, either a JWT access token or an API token
+func UserMiddleware(tokenManager internal.TokenManager, db models.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		var tokenStr string
@@ -25,6 +41,27 @@ func UserMiddleware(tokenManager internal.TokenManager) gin.HandlerFunc {
 			}
 		}
 
+		if internal.IsAPIToken(tokenStr) {
+			token, err := db.GetAPITokenByHash(internal.HashAPIToken(tokenStr))
+			if err != nil || token.IsExpired() {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				return
+			}
+			if !apiTokenAllows(c, token) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API token is not allowed on this endpoint"})
+				return
+			}
+			if err := db.TouchAPIToken(token.ID); err != nil {
+				logger.GetLogger().Warn().Err(err).Int("token_id", token.ID).Msg("Failed to record API token usage")
+			}
+
+			// API tokens never grant admin access
+			c.Set("user_id", token.UserID)
+			c.Set("admin", false)
+			c.Next()
+			return
+		}
+
 		claims, err := tokenManager.VerifyToken(tokenStr)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
@@ -36,3 +73,18 @@ func UserMiddleware(tokenManager internal.TokenManager) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// apiTokenAllows reports whether the request targets a route of the token's scope on the deployment it is bound to
+func apiTokenAllows(c *gin.Context, token models.APIToken) bool {
+	if token.ProjectName == "" || kubedeployer.GetProjectName(token.UserID, c.Param("name")) != token.ProjectName {
+		return false
+	}
+
+	routePath := c.FullPath()
+	for _, route := range apiTokenRoutes[token.Scope] {
+		if (route.method == "" || route.method == c.Request.Method) && strings.HasSuffix(routePath, route.path) {
+			return true
+		}
+	}
+	return false
+}
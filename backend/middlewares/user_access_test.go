@@ -0,0 +1,124 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kubecloud/internal"
+	"kubecloud/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func setupUserMiddlewareRouter(t *testing.T) (*gin.Engine, models.DB, internal.TokenManager) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := models.NewSqliteDB(filepath.Join(t.TempDir(), "middleware.db"))
+	require.NoError(t, err)
+	tokenManager := internal.NewTokenHandler("secret", time.Hour, time.Hour)
+
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetInt("user_id"), "admin": c.GetBool("admin")})
+	}
+
+	router := gin.New()
+	group := router.Group("/api/v1")
+	group.Use(UserMiddleware(tokenManager, db))
+	group.GET("/user/", ok)
+	group.GET("/deployments/:name", ok)
+	group.DELETE("/deployments/:name", ok)
+	group.GET("/deployments/:name/kubeconfig", ok)
+	group.Any("/deployments/:name/k8s/*path", ok)
+	group.PUT("/deployments/:name/pools/:pool", ok)
+
+	return router, db, tokenManager
+}
+
+func createTestAPIToken(t *testing.T, db models.DB, scope, projectName string, expiresAt *time.Time) string {
+	t.Helper()
+	token, tokenHash, err := internal.GenerateAPIToken()
+	require.NoError(t, err)
+	require.NoError(t, db.CreateAPIToken(&models.APIToken{
+		UserID:      7,
+		Name:        scope + projectName + token[len(token)-6:],
+		TokenHash:   tokenHash,
+		Scope:       scope,
+		ProjectName: projectName,
+		ExpiresAt:   expiresAt,
+	}))
+	return token
+}
+
+func serveWithToken(router *gin.Engine, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+func TestUserMiddleware(t *testing.T) {
+	t.Run("Missing token", func(t *testing.T) {
+		router, _, _ := setupUserMiddlewareRouter(t)
+		require.Equal(t, http.StatusUnauthorized, serveWithToken(router, http.MethodGet, "/api/v1/user/", ""))
+	})
+
+	t.Run("Access token is accepted on every route", func(t *testing.T) {
+		router, _, tokenManager := setupUserMiddlewareRouter(t)
+		pair, err := tokenManager.CreateTokenPair(7, "user", false)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, serveWithToken(router, http.MethodGet, "/api/v1/user/", pair.AccessToken))
+		require.Equal(t, http.StatusOK, serveWithToken(router, http.MethodDelete, "/api/v1/deployments/prod", pair.AccessToken))
+		require.Equal(t, http.StatusUnauthorized, serveWithToken(router, http.MethodGet, "/api/v1/user/", "not-a-token"))
+	})
+
+	t.Run("K8s API token only reaches the proxy of its deployment", func(t *testing.T) {
+		router, db, _ := setupUserMiddlewareRouter(t)
+		token := createTestAPIToken(t, db, models.APITokenScopeK8s, "kc7prod", nil)
+
+		require.Equal(t, http.StatusOK, serveWithToken(router, http.MethodGet, "/api/v1/deployments/prod/k8s/api/v1/pods", token))
+		require.Equal(t, http.StatusOK, serveWithToken(router, http.MethodPost, "/api/v1/deployments/prod/k8s/api/v1/namespaces", token))
+
+		require.Equal(t, http.StatusForbidden, serveWithToken(router, http.MethodGet, "/api/v1/deployments/staging/k8s/api/v1/pods", token))
+		require.Equal(t, http.StatusForbidden, serveWithToken(router, http.MethodGet, "/api/v1/deployments/prod", token))
+		require.Equal(t, http.StatusForbidden, serveWithToken(router, http.MethodGet, "/api/v1/deployments/prod/kubeconfig", token))
+		require.Equal(t, http.StatusForbidden, serveWithToken(router, http.MethodPut, "/api/v1/deployments/prod/pools/batch", token))
+		require.Equal(t, http.StatusForbidden, serveWithToken(router, http.MethodGet, "/api/v1/user/", token))
+	})
+
+	t.Run("Unscoped API token is rejected", func(t *testing.T) {
+		router, db, _ := setupUserMiddlewareRouter(t)
+		token := createTestAPIToken(t, db, "", "", nil)
+
+		require.Equal(t, http.StatusForbidden, serveWithToken(router, http.MethodGet, "/api/v1/user/", token))
+		require.Equal(t, http.StatusForbidden, serveWithToken(router, http.MethodGet, "/api/v1/deployments/prod/k8s/api", token))
+	})
+
+	t.Run("Expired and unknown API tokens are rejected", func(t *testing.T) {
+		router, db, _ := setupUserMiddlewareRouter(t)
+		expired := time.Now().Add(-time.Minute)
+		token := createTestAPIToken(t, db, models.APITokenScopeK8s, "kc7prod", &expired)
+
+		require.Equal(t, http.StatusUnauthorized, serveWithToken(router, http.MethodGet, "/api/v1/deployments/prod/k8s/api", token))
+		require.Equal(t, http.StatusUnauthorized, serveWithToken(router, http.MethodGet, "/api/v1/deployments/prod/k8s/api", internal.APITokenPrefix+"unknown"))
+	})
+
+	t.Run("API token usage is recorded", func(t *testing.T) {
+		router, db, _ := setupUserMiddlewareRouter(t)
+		token := createTestAPIToken(t, db, models.APITokenScopeK8s, "kc7prod", nil)
+
+		require.Equal(t, http.StatusOK, serveWithToken(router, http.MethodGet, "/api/v1/deployments/prod/k8s/version", token))
+
+		stored, err := db.GetAPITokenByHash(internal.HashAPIToken(token))
+		require.NoError(t, err)
+		require.NotNil(t, stored.LastUsedAt)
+	})
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	// APITokenScopeK8s allows the Kubernetes API proxy of the token's deployment
+	APITokenScopeK8s = "k8s"
+)
+
+// APIToken is a long lived token a user authenticates API calls with, for example from a kubeconfig.
+// A token is bound to a single deployment and only grants the routes of its scope. Only the SHA-256 hash of the token is stored.
+type APIToken struct {
+	ID        int    `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	UserID    int    `gorm:"index:idx_api_token_user_name,unique" json:"-"`
+	Name      string `gorm:"index:idx_api_token_user_name,unique" json:"name"`
+	TokenHash string `gorm:"uniqueIndex" json:"-"`
+	// Prefix is the start of the token shown to tell tokens apart
+	Prefix string `json:"prefix"`
+	Scope  string `json:"scope"`
+	// ProjectName is the deployment the token is bound to
+	ProjectName string     `gorm:"index" json:"project_name"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// IsExpired reports whether the token has an expiry in the past
+func (t *APIToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
@@ -2,19 +2,101 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"kubecloud/kubedeployer"
 	"time"
 )
 
+const (
+	ClusterStatusActive   = "active"
+	ClusterStatusDegraded = "degraded" // some of its contracts are missing or in grace period on chain
+	ClusterStatusDeleting = "deleting" // its deletion workflow is running
+)
+
+// Orders of the clusters listed by ListUserClustersPage
+const (
+	ClusterSortCreatedAt = "created_at"
+	ClusterSortUpdatedAt = "updated_at"
+	ClusterSortName      = "name"
+)
+
 // Cluster represents a deployed cluster in the system
 type Cluster struct {
-	ID          int       `gorm:"primaryKey;autoIncrement;column:id"`
-	UserID      int       `gorm:"user_id;index" json:"user_id" binding:"required"`
-	ProjectName string    `gorm:"project_name;uniqueIndex:idx_user_project" json:"project_name" binding:"required"`
-	Result      string    `gorm:"type:text" json:"result"` // JSON serialized kubedeployer.Cluster
-	Kubeconfig  string    `gorm:"type:text" json:"kubeconfig"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                  int               `gorm:"primaryKey;autoIncrement;column:id"`
+	UserID              int               `gorm:"user_id;index" json:"user_id" binding:"required"`
+	ProjectName         string            `gorm:"project_name;uniqueIndex:idx_user_project" json:"project_name" binding:"required"`
+	Result              string            `gorm:"type:text" json:"result"` // JSON serialized kubedeployer.Cluster
+	Kubeconfig          string            `gorm:"type:text" json:"kubeconfig"`
+	Status              string            `gorm:"default:active" json:"status"`
+	Description         string            `gorm:"type:text" json:"description"`
+	Labels              map[string]string `gorm:"type:text;serializer:json" json:"labels"`  // matched by the label selector of the deployments list
+	DeletionProtection  bool              `gorm:"default:false" json:"deletion_protection"` // delete requests fail while it is on
+	DeletionScheduledAt *time.Time        `json:"deletion_scheduled_at,omitempty"`          // set while the cluster is pending deletion
+	FailoverStartedAt   *time.Time        `json:"-"`                                        // set while a leader promotion runs
+	PoolWorkflowID      string            `json:"-"`                                        // set while a workflow scaling its node pools, updating its nodes or promoting its leader runs
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+}
+
+// PendingDeletion reports whether the deletion of the cluster is scheduled and can still be cancelled
+func (c *Cluster) PendingDeletion() bool {
+	return c.DeletionScheduledAt != nil
+}
+
+// ClusterFilter selects and orders a page of the clusters of a user
+type ClusterFilter struct {
+	ProjectNamePrefix string
+	Statuses          []string
+	CreatedAfter      *time.Time
+	CreatedBefore     *time.Time
+	SortBy            string // one of the ClusterSort constants, created_at by default
+	Descending        bool
+	After             *ClusterCursor // the last cluster of the previous page
+	Limit             int
+}
+
+// ClusterCursor is the position of a cluster in a sorted list: its sort value, and its ID to order clusters with the same value
+type ClusterCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// Cursor returns the position of the cluster in a list sorted by sortBy
+func (c *Cluster) Cursor(sortBy string) ClusterCursor {
+	switch sortBy {
+	case ClusterSortName:
+		return ClusterCursor{Value: c.ProjectName, ID: c.ID}
+	case ClusterSortUpdatedAt:
+		return ClusterCursor{Value: c.UpdatedAt.UTC().Format(time.RFC3339Nano), ID: c.ID}
+	default:
+		return ClusterCursor{Value: c.CreatedAt.UTC().Format(time.RFC3339Nano), ID: c.ID}
+	}
+}
+
+// sortColumn returns the column a cluster list is sorted by and the value of the cursor in that column
+func (f *ClusterFilter) sortColumn() (string, interface{}, error) {
+	column := ClusterSortCreatedAt
+	switch f.SortBy {
+	case ClusterSortName:
+		column = "project_name"
+	case ClusterSortUpdatedAt:
+		column = ClusterSortUpdatedAt
+	case ClusterSortCreatedAt, "":
+	default:
+		return "", nil, fmt.Errorf("unsupported sort field %q", f.SortBy)
+	}
+
+	if f.After == nil {
+		return column, nil, nil
+	}
+	if column == "project_name" {
+		return column, f.After.Value, nil
+	}
+	value, err := time.Parse(time.RFC3339Nano, f.After.Value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return column, value, nil
 }
 
 // GetClusterResult deserializes the Result field into a kubedeployer.Cluster
@@ -0,0 +1,300 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func clusterNames(clusters []Cluster) []string {
+	names := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		names = append(names, cluster.ProjectName)
+	}
+	return names
+}
+
+func TestListUserClustersPage(t *testing.T) {
+	db, err := NewSqliteDB(filepath.Join(t.TempDir(), "clusters.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"kc1web", "kc1web_a", "kc1api", "kc1webx", "kc1db"} {
+		cluster := &Cluster{ProjectName: name, Result: "{}"}
+		require.NoError(t, db.CreateCluster(1, cluster))
+		cluster.CreatedAt = created.Add(time.Duration(i) * time.Hour)
+		if name == "kc1api" {
+			cluster.Status = ClusterStatusDegraded
+		}
+		require.NoError(t, db.UpdateCluster(cluster))
+	}
+	require.NoError(t, db.CreateCluster(2, &Cluster{ProjectName: "kc2web", Result: "{}"}))
+
+	t.Run("pages in order", func(t *testing.T) {
+		filter := ClusterFilter{Limit: 2}
+		var names []string
+		for {
+			clusters, err := db.ListUserClustersPage(1, filter)
+			require.NoError(t, err)
+			names = append(names, clusterNames(clusters)...)
+			if len(clusters) < filter.Limit {
+				break
+			}
+			cursor := clusters[len(clusters)-1].Cursor(filter.SortBy)
+			filter.After = &cursor
+		}
+		assert.Equal(t, []string{"kc1web", "kc1web_a", "kc1api", "kc1webx", "kc1db"}, names)
+	})
+
+	t.Run("descending by name", func(t *testing.T) {
+		clusters, err := db.ListUserClustersPage(1, ClusterFilter{SortBy: ClusterSortName, Descending: true, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"kc1webx", "kc1web_a"}, clusterNames(clusters))
+
+		cursor := clusters[1].Cursor(ClusterSortName)
+		clusters, err = db.ListUserClustersPage(1, ClusterFilter{SortBy: ClusterSortName, Descending: true, After: &cursor})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"kc1web", "kc1db", "kc1api"}, clusterNames(clusters))
+	})
+
+	t.Run("name prefix wildcards are literal", func(t *testing.T) {
+		clusters, err := db.ListUserClustersPage(1, ClusterFilter{ProjectNamePrefix: "kc1web_"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"kc1web_a"}, clusterNames(clusters))
+	})
+
+	t.Run("status and creation time", func(t *testing.T) {
+		clusters, err := db.ListUserClustersPage(1, ClusterFilter{Statuses: []string{ClusterStatusDegraded}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"kc1api"}, clusterNames(clusters))
+
+		after, before := created.Add(time.Hour), created.Add(3*time.Hour)
+		clusters, err = db.ListUserClustersPage(1, ClusterFilter{CreatedAfter: &after, CreatedBefore: &before})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"kc1web_a", "kc1api"}, clusterNames(clusters))
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		cluster, err := db.GetClusterByName(1, "kc1db")
+		require.NoError(t, err)
+		require.NoError(t, db.UpdateClusterMetadata(cluster.ID, "main database", map[string]string{"env": "prod"}))
+
+		cluster, err = db.GetClusterByName(1, "kc1db")
+		require.NoError(t, err)
+		assert.Equal(t, "main database", cluster.Description)
+		assert.Equal(t, map[string]string{"env": "prod"}, cluster.Labels)
+
+		require.NoError(t, db.UpdateClusterMetadata(cluster.ID, "", map[string]string{}))
+		cluster, err = db.GetClusterByName(1, "kc1db")
+		require.NoError(t, err)
+		assert.Empty(t, cluster.Description)
+		assert.Empty(t, cluster.Labels)
+	})
+}
+
+func TestScheduledClusterDeletion(t *testing.T) {
+	db, err := NewSqliteDB(filepath.Join(t.TempDir(), "clusters.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	cluster := &Cluster{ProjectName: "kc1web", Result: "{}"}
+	require.NoError(t, db.CreateCluster(1, cluster))
+
+	now := time.Now()
+	scheduled, err := db.ScheduleClusterDeletion(cluster.ID, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, scheduled)
+
+	due, err := db.ListDueClusterDeletions(now)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	claimed, err := db.ClaimClusterDeletion(cluster.ID, now)
+	require.NoError(t, err)
+	assert.False(t, claimed, "the grace period is not over")
+
+	cancelled, err := db.CancelClusterDeletion(cluster.ID)
+	require.NoError(t, err)
+	assert.True(t, cancelled)
+
+	cancelled, err = db.CancelClusterDeletion(cluster.ID)
+	require.NoError(t, err)
+	assert.False(t, cancelled, "the deletion was already cancelled")
+
+	_, err = db.ScheduleClusterDeletion(cluster.ID, now.Add(-time.Minute))
+	require.NoError(t, err)
+	due, err = db.ListDueClusterDeletions(now)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.True(t, due[0].PendingDeletion())
+
+	claimed, err = db.ClaimClusterDeletion(cluster.ID, now)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	cancelled, err = db.CancelClusterDeletion(cluster.ID)
+	require.NoError(t, err)
+	assert.False(t, cancelled, "a claimed deletion can't be cancelled")
+
+	t.Run("protection", func(t *testing.T) {
+		_, err := db.ScheduleClusterDeletion(cluster.ID, now.Add(time.Hour))
+		require.NoError(t, err)
+
+		require.NoError(t, db.SetClusterDeletionProtection(cluster.ID, true))
+		protected, err := db.GetClusterByName(1, "kc1web")
+		require.NoError(t, err)
+		assert.True(t, protected.DeletionProtection)
+		assert.False(t, protected.PendingDeletion(), "turning protection on cancels the scheduled deletion")
+
+		scheduled, err := db.ScheduleClusterDeletion(cluster.ID, now.Add(time.Hour))
+		require.NoError(t, err)
+		assert.False(t, scheduled)
+	})
+}
+
+func TestClusterDeletingStatus(t *testing.T) {
+	db, err := NewSqliteDB(filepath.Join(t.TempDir(), "clusters.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	status := func(projectName string) string {
+		cluster, err := db.GetClusterByName(1, projectName)
+		require.NoError(t, err)
+		return cluster.Status
+	}
+
+	t.Run("marked until the deletion is released", func(t *testing.T) {
+		cluster := &Cluster{ProjectName: "kc1web", Result: "{}"}
+		require.NoError(t, db.CreateCluster(1, cluster))
+		_, err := db.ScheduleClusterDeletion(cluster.ID, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		marked, err := db.MarkClusterDeleting(cluster.ID)
+		require.NoError(t, err)
+		assert.True(t, marked)
+		stored, err := db.GetClusterByName(1, "kc1web")
+		require.NoError(t, err)
+		assert.Equal(t, ClusterStatusDeleting, stored.Status)
+		assert.False(t, stored.PendingDeletion(), "deleting right away supersedes the scheduled deletion")
+
+		marked, err = db.MarkClusterDeleting(cluster.ID)
+		require.NoError(t, err)
+		assert.False(t, marked, "the cluster is already being deleted")
+
+		scheduled, err := db.ScheduleClusterDeletion(cluster.ID, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.False(t, scheduled)
+
+		require.NoError(t, db.UpdateClusterStatus(cluster.ID, ClusterStatusDegraded))
+		assert.Equal(t, ClusterStatusDeleting, status("kc1web"), "the drift reconciler doesn't reset a deleting cluster")
+
+		require.NoError(t, db.ReleaseClusterDeletion(1, "kc1web"))
+		assert.Equal(t, ClusterStatusActive, status("kc1web"))
+	})
+
+	t.Run("claimed scheduled deletion", func(t *testing.T) {
+		cluster := &Cluster{ProjectName: "kc1api", Result: "{}"}
+		require.NoError(t, db.CreateCluster(1, cluster))
+		now := time.Now()
+		_, err := db.ScheduleClusterDeletion(cluster.ID, now.Add(-time.Minute))
+		require.NoError(t, err)
+
+		claimed, err := db.ClaimClusterDeletion(cluster.ID, now)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+		assert.Equal(t, ClusterStatusDeleting, status("kc1api"))
+	})
+
+	t.Run("protected cluster", func(t *testing.T) {
+		cluster := &Cluster{ProjectName: "kc1db", Result: "{}"}
+		require.NoError(t, db.CreateCluster(1, cluster))
+		require.NoError(t, db.SetClusterDeletionProtection(cluster.ID, true))
+
+		marked, err := db.MarkClusterDeleting(cluster.ID)
+		require.NoError(t, err)
+		assert.False(t, marked)
+		assert.Equal(t, ClusterStatusActive, status("kc1db"))
+	})
+}
+
+func TestClusterFailoverClaim(t *testing.T) {
+	db, err := NewSqliteDB(filepath.Join(t.TempDir(), "clusters.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	cluster := &Cluster{ProjectName: "kc1web", Result: "{}"}
+	require.NoError(t, db.CreateCluster(1, cluster))
+
+	now := time.Now()
+	claimed, err := db.ClaimClusterFailover(cluster.ID, now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = db.ClaimClusterFailover(cluster.ID, now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.False(t, claimed, "a promotion is already running")
+
+	// a cluster loaded during the promotion doesn't restore the claim once released
+	stored, err := db.GetClusterByName(1, "kc1web")
+	require.NoError(t, err)
+	require.NotNil(t, stored.FailoverStartedAt)
+	require.NoError(t, db.ReleaseClusterFailover(cluster.ID))
+	require.NoError(t, db.UpdateCluster(&stored))
+	claimed, err = db.ClaimClusterFailover(cluster.ID, now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = db.ClaimClusterFailover(cluster.ID, now.Add(2*time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, claimed, "a stale claim is taken over")
+
+	require.NoError(t, db.ReleaseClusterFailover(cluster.ID))
+	claimed, err = db.ClaimClusterFailover(cluster.ID, now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestClusterPoolsClaim(t *testing.T) {
+	db, err := NewSqliteDB(filepath.Join(t.TempDir(), "clusters.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	cluster := &Cluster{ProjectName: "kc1web", Result: "{}"}
+	require.NoError(t, db.CreateCluster(1, cluster))
+
+	claimed, err := db.ClaimClusterPools(cluster.ID, "scale-1", "")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = db.ClaimClusterPools(cluster.ID, "scale-2", "")
+	require.NoError(t, err)
+	assert.False(t, claimed, "a pool workflow is already running")
+
+	// a cluster saved while the workflow runs keeps its claim
+	stored, err := db.GetClusterByName(1, "kc1web")
+	require.NoError(t, err)
+	assert.Equal(t, "scale-1", stored.PoolWorkflowID)
+	stored.PoolWorkflowID = ""
+	require.NoError(t, db.UpdateCluster(&stored))
+	stored, err = db.GetClusterByName(1, "kc1web")
+	require.NoError(t, err)
+	assert.Equal(t, "scale-1", stored.PoolWorkflowID)
+
+	claimed, err = db.ClaimClusterPools(cluster.ID, "scale-2", "scale-1")
+	require.NoError(t, err)
+	assert.True(t, claimed, "a stale claim is taken over")
+
+	require.NoError(t, db.ReleaseClusterPools("scale-1"))
+	stored, err = db.GetClusterByName(1, "kc1web")
+	require.NoError(t, err)
+	assert.Equal(t, "scale-2", stored.PoolWorkflowID, "only the workflow holding the claim releases it")
+
+	require.NoError(t, db.ReleaseClusterPools("scale-2"))
+	claimed, err = db.ClaimClusterPools(cluster.ID, "scale-3", "")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
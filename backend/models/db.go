@@ -6,6 +6,7 @@ import (
 	"kubecloud/internal/utils"
 	"net/url"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -57,10 +58,65 @@ type DB interface {
 	// Cluster methods
 	CreateCluster(userID int, cluster *Cluster) error
 	ListUserClusters(userID int) ([]Cluster, error)
+	ListUserClustersPage(userID int, filter ClusterFilter) ([]Cluster, error)
+	UpdateClusterMetadata(clusterID int, description string, labels map[string]string) error
+	SetClusterDeletionProtection(clusterID int, enabled bool) error
+	ScheduleClusterDeletion(clusterID int, at time.Time) (bool, error)
+	CancelClusterDeletion(clusterID int) (bool, error)
+	ListDueClusterDeletions(now time.Time) ([]Cluster, error)
+	ClaimClusterDeletion(clusterID int, now time.Time) (bool, error)
+	MarkClusterDeleting(clusterID int) (bool, error)
+	ReleaseClusterDeletion(userID int, projectName string) error
+	ClaimClusterFailover(clusterID int, now time.Time, staleBefore time.Time) (bool, error)
+	ReleaseClusterFailover(clusterID int) error
+	ClaimClusterPools(clusterID int, workflowID, staleWorkflowID string) (bool, error)
+	ReleaseClusterPools(workflowID string) error
 	GetClusterByName(userID int, projectName string) (Cluster, error)
 	UpdateCluster(cluster *Cluster) error
 	DeleteCluster(userID int, projectName string) error
 	DeleteAllUserClusters(userID int) error
+	UpdateClusterStatus(clusterID int, status string) error
+	// cluster snapshot methods
+	CreateClusterSnapshot(snapshot *ClusterSnapshot) error
+	ListClusterSnapshots(userID int, projectName string) ([]ClusterSnapshot, error)
+	GetClusterSnapshot(userID int, projectName string, snapshotID int) (ClusterSnapshot, error)
+	DeleteClusterSnapshot(snapshotID int) error
+	SaveSnapshotSchedule(schedule *SnapshotSchedule) error
+	GetSnapshotSchedule(userID int, projectName string) (SnapshotSchedule, error)
+	ListEnabledSnapshotSchedules() ([]SnapshotSchedule, error)
+	UpdateSnapshotScheduleLastRun(scheduleID int, at time.Time) error
+	DeleteSnapshotSchedule(userID int, projectName string) error
+	// contract drift methods
+	SaveContractDrift(drift *ContractDrift) error
+	ListContractDrifts() ([]ContractDrift, error)
+	GetContractDrift(id int) (ContractDrift, error)
+	DeleteContractDrift(id int) error
+	DeleteContractDriftsSeenBefore(t time.Time) error
+	// ip allocation methods
+	ReserveIPAllocation(allocation *IPAllocation) error
+	GetIPAllocation(projectName, nodeName string) (IPAllocation, error)
+	ReleaseIPAllocation(projectName, nodeName string) error
+	ReleaseClusterIPAllocations(projectName string) error
+	// kubeconfig credential methods
+	CreateKubeconfigCredential(credential *KubeconfigCredential) error
+	ListKubeconfigCredentials(userID int, projectName string) ([]KubeconfigCredential, error)
+	GetKubeconfigCredential(userID int, projectName string, id int) (KubeconfigCredential, error)
+	GetOwnerKubeconfigCredential(userID int, projectName string) (KubeconfigCredential, error)
+	RevokeKubeconfigCredential(id int) error
+	DeleteClusterKubeconfigCredentials(userID int, projectName string) error
+	// api token methods
+	CreateAPIToken(token *APIToken) error
+	ListUserAPITokens(userID int) ([]APIToken, error)
+	GetAPITokenByHash(tokenHash string) (APIToken, error)
+	DeleteAPIToken(id int, userID int) error
+	DeleteClusterAPITokens(userID int, projectName string) error
+	TouchAPIToken(id int) error
+	// node host key methods
+	GetNodeHostKey(nodeName string) (NodeHostKey, error)
+	TrustNodeHostKey(hostKey *NodeHostKey) error
+	RecordNodeHostKeyChange(nodeName, presentedKey, presentedFingerprint string) error
+	ListNodeHostKeys(changedOnly bool) ([]NodeHostKey, error)
+	DeleteNodeHostKey(nodeName string) error
 	// pending records methods
 	CreatePendingRecord(record *PendingRecord) error
 	ListAllPendingRecords() ([]PendingRecord, error)
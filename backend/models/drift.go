@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// DriftKind is the kind of mismatch found between a contract on chain and the database
+type DriftKind string
+
+const (
+	DriftMissingContract  DriftKind = "missing_contract"  // the database references a contract that no longer exists
+	DriftGracePeriod      DriftKind = "grace_period"      // the database references a contract in grace period
+	DriftOrphanedContract DriftKind = "orphaned_contract" // a user twin owns a contract the database does not know about
+)
+
+const (
+	DriftResourceCluster      = "cluster"
+	DriftResourceReservedNode = "reserved_node"
+)
+
+// ContractDrift is a contract whose on chain state does not match the database, found by the drift reconciler
+type ContractDrift struct {
+	ID         int       `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	UserID     int       `gorm:"index" json:"user_id"`
+	ContractID uint64    `gorm:"uniqueIndex" json:"contract_id"`
+	Kind       DriftKind `json:"kind"`
+	// Resource is the database record referencing the contract, empty for orphaned contracts
+	Resource    string `json:"resource,omitempty"`
+	ProjectName string `json:"project_name,omitempty"`
+	NodeID      uint32 `json:"node_id"`
+	// ContractType and DeploymentName describe orphaned contracts as found on chain
+	ContractType   string    `json:"contract_type,omitempty"`
+	DeploymentName string    `json:"deployment_name,omitempty"`
+	DetectedAt     time.Time `json:"detected_at"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContractDrifts(t *testing.T) {
+	db, err := NewSqliteDB(filepath.Join(t.TempDir(), "drifts.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	drift := &ContractDrift{UserID: 1, ContractID: 10, Kind: DriftGracePeriod, Resource: DriftResourceCluster, ProjectName: "kc1web"}
+	require.NoError(t, db.SaveContractDrift(drift))
+	first, err := db.GetContractDrift(drift.ID)
+	require.NoError(t, err)
+
+	t.Run("a drift seen again keeps its detection time", func(t *testing.T) {
+		again := &ContractDrift{UserID: 1, ContractID: 10, Kind: DriftGracePeriod, Resource: DriftResourceCluster, ProjectName: "kc1web"}
+		require.NoError(t, db.SaveContractDrift(again))
+		require.Equal(t, drift.ID, again.ID)
+
+		got, err := db.GetContractDrift(drift.ID)
+		require.NoError(t, err)
+		require.True(t, got.DetectedAt.Equal(first.DetectedAt))
+		require.False(t, got.LastSeenAt.Before(first.LastSeenAt))
+
+		drifts, err := db.ListContractDrifts()
+		require.NoError(t, err)
+		require.Len(t, drifts, 1)
+	})
+
+	t.Run("a drift changing kind is detected again", func(t *testing.T) {
+		time.Sleep(10 * time.Millisecond)
+		missing := &ContractDrift{UserID: 1, ContractID: 10, Kind: DriftMissingContract, Resource: DriftResourceCluster, ProjectName: "kc1web"}
+		require.NoError(t, db.SaveContractDrift(missing))
+
+		got, err := db.GetContractDrift(drift.ID)
+		require.NoError(t, err)
+		require.Equal(t, DriftMissingContract, got.Kind)
+		require.True(t, got.DetectedAt.After(first.DetectedAt))
+	})
+
+	t.Run("drifts not seen again are resolved", func(t *testing.T) {
+		checkStarted := time.Now()
+		require.NoError(t, db.SaveContractDrift(&ContractDrift{UserID: 2, ContractID: 20, Kind: DriftOrphanedContract}))
+		require.NoError(t, db.DeleteContractDriftsSeenBefore(checkStarted))
+
+		drifts, err := db.ListContractDrifts()
+		require.NoError(t, err)
+		require.Len(t, drifts, 1)
+		require.Equal(t, uint64(20), drifts[0].ContractID)
+	})
+}
+
+func TestUpdateClusterStatus(t *testing.T) {
+	db, err := NewSqliteDB(filepath.Join(t.TempDir(), "clusters.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	cluster := &Cluster{ProjectName: "kc1web", Result: "{}"}
+	require.NoError(t, db.CreateCluster(1, cluster))
+
+	got, err := db.GetClusterByName(1, "kc1web")
+	require.NoError(t, err)
+	require.Equal(t, ClusterStatusActive, got.Status)
+
+	require.NoError(t, db.UpdateClusterStatus(cluster.ID, ClusterStatusDegraded))
+	got, err = db.GetClusterByName(1, "kc1web")
+	require.NoError(t, err)
+	require.Equal(t, ClusterStatusDegraded, got.Status)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/xmonader/ewf"
 	"gorm.io/gorm"
@@ -14,10 +15,86 @@ type EWFGormStore struct {
 }
 
 type gormWorkflowRecord struct {
-	UUID   string `gorm:"primaryKey;column:uuid"`
-	Name   string `gorm:"column:name;not null;index"`
-	Status string `gorm:"column:status;not null;index"`
-	Data   []byte `gorm:"column:data;not null"`
+	UUID      string    `gorm:"primaryKey;column:uuid"`
+	Name      string    `gorm:"column:name;not null;index"`
+	Status    string    `gorm:"column:status;not null;index"`
+	UserID    int       `gorm:"column:user_id;index"` // the user the workflow acts on behalf of, 0 for system workflows
+	CreatedAt time.Time `gorm:"column:created_at;index"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+	// RollbackAt is when a failed workflow kept for retry is rolled back, nil once retried or rolled back
+	RollbackAt *time.Time `gorm:"column:rollback_at;index"`
+	// CancelRequestedAt is when the cancellation of the running workflow was requested, the server running it cancels it
+	CancelRequestedAt *time.Time `gorm:"column:cancel_requested_at"`
+	Data              []byte     `gorm:"column:data;not null"`
+}
+
+// WorkflowFilter selects a page of the workflows of a user, newest first
+type WorkflowFilter struct {
+	NamePrefix    string
+	Statuses      []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	After         *WorkflowCursor // the last workflow of the previous page
+	Limit         int
+}
+
+// WorkflowCursor is the position of a workflow in a list sorted by creation time
+type WorkflowCursor struct {
+	CreatedAt time.Time `json:"t"`
+	UUID      string    `json:"id"`
+}
+
+// NewWorkflowCursor returns the position of a workflow in a list sorted by creation time
+func NewWorkflowCursor(workflow *ewf.Workflow) WorkflowCursor {
+	return WorkflowCursor{CreatedAt: workflowCreatedAt(workflow), UUID: workflow.UUID}
+}
+
+// workflowCreatedAt returns the creation time of a workflow as stored, databases don't keep nanoseconds
+func workflowCreatedAt(workflow *ewf.Workflow) time.Time {
+	return workflow.CreatedAt.UTC().Truncate(time.Microsecond)
+}
+
+// workflowOwner returns the user a workflow acts on behalf of, from the 'config' of deployer workflows
+// or the 'user_id' of user workflows in its state
+func workflowOwner(state ewf.State) int {
+	data, err := json.Marshal(map[string]interface{}{
+		"config":  state["config"],
+		"user_id": state["user_id"],
+	})
+	if err != nil {
+		return 0
+	}
+
+	var owner struct {
+		Config struct {
+			UserID int `json:"user_id"`
+		} `json:"config"`
+		UserID int `json:"user_id"`
+	}
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return 0
+	}
+	if owner.Config.UserID != 0 {
+		return owner.Config.UserID
+	}
+	return owner.UserID
+}
+
+// workflowRollbackAt returns when a failed workflow kept for retry is rolled back, from the 'rollback_at' in its state
+func workflowRollbackAt(state ewf.State) *time.Time {
+	switch value := state["rollback_at"].(type) {
+	case time.Time:
+		rollbackAt := value.UTC()
+		return &rollbackAt
+	case string:
+		rollbackAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil
+		}
+		rollbackAt = rollbackAt.UTC()
+		return &rollbackAt
+	}
+	return nil
 }
 
 type gormTemplateRecord struct {
@@ -34,7 +111,34 @@ func NewGormStore(db *gorm.DB) *EWFGormStore {
 }
 
 func (s *EWFGormStore) Setup() error {
-	return s.db.AutoMigrate(&gormWorkflowRecord{}, &gormTemplateRecord{})
+	if err := s.db.AutoMigrate(&gormWorkflowRecord{}, &gormTemplateRecord{}); err != nil {
+		return err
+	}
+	return s.backfillWorkflowColumns()
+}
+
+// backfillWorkflowColumns sets the owner and creation time of the workflows saved before these columns existed
+func (s *EWFGormStore) backfillWorkflowColumns() error {
+	var records []gormWorkflowRecord
+	return s.db.Where("created_at IS NULL").FindInBatches(&records, 100, func(tx *gorm.DB, batch int) error {
+		for _, record := range records {
+			workflow, err := decodeWorkflow(record)
+			if err != nil {
+				return fmt.Errorf("failed to backfill workflow %s: %w", record.UUID, err)
+			}
+
+			err = s.db.Model(&gormWorkflowRecord{}).
+				Where("uuid = ?", record.UUID).
+				Updates(map[string]interface{}{
+					"user_id":    workflowOwner(workflow.State),
+					"created_at": workflowCreatedAt(workflow),
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to backfill workflow %s: %w", record.UUID, err)
+			}
+		}
+		return nil
+	}).Error
 }
 
 func (s *EWFGormStore) SaveWorkflow(ctx context.Context, workflow *ewf.Workflow) error {
@@ -44,13 +148,19 @@ func (s *EWFGormStore) SaveWorkflow(ctx context.Context, workflow *ewf.Workflow)
 	}
 
 	gormWorkflow := gormWorkflowRecord{
-		UUID:   workflow.UUID,
-		Name:   workflow.Name,
-		Status: string(workflow.Status),
-		Data:   data,
+		UUID:       workflow.UUID,
+		Name:       workflow.Name,
+		Status:     string(workflow.Status),
+		UserID:     workflowOwner(workflow.State),
+		CreatedAt:  workflowCreatedAt(workflow),
+		UpdatedAt:  time.Now(),
+		RollbackAt: workflowRollbackAt(workflow.State),
+		Data:       data,
 	}
 
-	return s.db.WithContext(ctx).Save(&gormWorkflow).Error
+	// cancelling a workflow cancels its context, its final status must still be saved.
+	// The cancel request is only changed by its request and the claim of a retry.
+	return s.db.WithContext(context.WithoutCancel(ctx)).Omit("cancel_requested_at").Save(&gormWorkflow).Error
 }
 
 func (s *EWFGormStore) LoadWorkflowByName(ctx context.Context, name string) (*ewf.Workflow, error) {
@@ -79,6 +189,63 @@ func (s *EWFGormStore) LoadWorkflowByUUID(ctx context.Context, uuid string) (*ew
 	return &workflow, nil
 }
 
+// LoadUserWorkflow loads a workflow acting on behalf of a user, it fails with gorm.ErrRecordNotFound for the workflows of other users
+func (s *EWFGormStore) LoadUserWorkflow(ctx context.Context, userID int, uuid string) (*ewf.Workflow, error) {
+	var gormWorkflow gormWorkflowRecord
+	if err := s.db.WithContext(ctx).Where("uuid = ? AND user_id = ?", uuid, userID).First(&gormWorkflow).Error; err != nil {
+		return nil, err
+	}
+	return decodeWorkflow(gormWorkflow)
+}
+
+// ListUserWorkflows returns the workflows acting on behalf of a user matching filter, newest first and after its cursor
+func (s *EWFGormStore) ListUserWorkflows(ctx context.Context, userID int, filter WorkflowFilter) ([]*ewf.Workflow, error) {
+	query := s.db.WithContext(ctx).Where("user_id = ?", userID)
+	if filter.NamePrefix != "" {
+		query = query.Where("name LIKE ? ESCAPE '\\'", likeEscaper.Replace(filter.NamePrefix)+"%")
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", *filter.CreatedBefore)
+	}
+	if filter.After != nil {
+		query = query.Where("(created_at < ? OR (created_at = ? AND uuid < ?))",
+			filter.After.CreatedAt, filter.After.CreatedAt, filter.After.UUID)
+	}
+	query = query.Order("created_at DESC, uuid DESC")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var records []gormWorkflowRecord
+	if err := query.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	workflows := make([]*ewf.Workflow, 0, len(records))
+	for _, record := range records {
+		workflow, err := decodeWorkflow(record)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, workflow)
+	}
+	return workflows, nil
+}
+
+func decodeWorkflow(record gormWorkflowRecord) (*ewf.Workflow, error) {
+	var workflow ewf.Workflow
+	if err := json.Unmarshal(record.Data, &workflow); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal workflow: %w", err)
+	}
+	return &workflow, nil
+}
+
 func (s *EWFGormStore) ListWorkflowUUIDsByStatus(ctx context.Context, status ewf.WorkflowStatus) ([]string, error) {
 	var uuids []string
 	err := s.db.WithContext(ctx).
@@ -89,6 +256,86 @@ func (s *EWFGormStore) ListWorkflowUUIDsByStatus(ctx context.Context, status ewf
 	return uuids, err
 }
 
+// ListWorkflowsDueForRollback returns the failed workflows kept for retry whose rollback is due
+func (s *EWFGormStore) ListWorkflowsDueForRollback(ctx context.Context, now time.Time) ([]*ewf.Workflow, error) {
+	var records []gormWorkflowRecord
+	err := s.db.WithContext(ctx).
+		Where("status = ? AND rollback_at IS NOT NULL AND rollback_at <= ?", ewf.StatusFailed, now.UTC()).
+		Order("rollback_at").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	workflows := make([]*ewf.Workflow, 0, len(records))
+	for _, record := range records {
+		workflow, err := decodeWorkflow(record)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, workflow)
+	}
+	return workflows, nil
+}
+
+// ListUserPendingRollbacks returns the failed workflows of a user kept for retry whose name starts with namePrefix
+func (s *EWFGormStore) ListUserPendingRollbacks(ctx context.Context, userID int, namePrefix string) ([]*ewf.Workflow, error) {
+	var records []gormWorkflowRecord
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND status = ? AND rollback_at IS NOT NULL AND name LIKE ?", userID, ewf.StatusFailed, namePrefix+"%").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	workflows := make([]*ewf.Workflow, 0, len(records))
+	for _, record := range records {
+		workflow, err := decodeWorkflow(record)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, workflow)
+	}
+	return workflows, nil
+}
+
+// ClaimRollback atomically takes the pending rollback of a failed workflow kept for retry, so that it is either
+// retried or rolled back once. It reports false when the workflow has no pending rollback anymore.
+// The cancel request of the failed run is cleared so a retry isn't cancelled by it.
+func (s *EWFGormStore) ClaimRollback(ctx context.Context, uuid string) (bool, error) {
+	result := s.db.WithContext(ctx).
+		Model(&gormWorkflowRecord{}).
+		Where("uuid = ? AND rollback_at IS NOT NULL", uuid).
+		Updates(map[string]interface{}{"rollback_at": nil, "cancel_requested_at": nil})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RequestCancel records the cancellation of a running workflow for the server running it.
+// It reports false when the workflow is not running.
+func (s *EWFGormStore) RequestCancel(ctx context.Context, uuid string) (bool, error) {
+	result := s.db.WithContext(ctx).
+		Model(&gormWorkflowRecord{}).
+		Where("uuid = ? AND status = ?", uuid, ewf.StatusRunning).
+		Update("cancel_requested_at", time.Now().UTC())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// CancelRequested reports whether the cancellation of a workflow was requested
+func (s *EWFGormStore) CancelRequested(ctx context.Context, uuid string) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).
+		Model(&gormWorkflowRecord{}).
+		Where("uuid = ? AND cancel_requested_at IS NOT NULL", uuid).
+		Count(&count).Error
+	return count > 0, err
+}
+
 func (s *EWFGormStore) LoadWorkflowTemplate(ctx context.Context, name string) (*ewf.WorkflowTemplate, error) {
 	var gormTemplate gormTemplateRecord
 	if err := s.db.WithContext(ctx).Where("name = ?", name).First(&gormTemplate).Error; err != nil {
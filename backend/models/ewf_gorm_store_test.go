@@ -2,8 +2,11 @@ package models
 
 import (
 	"context"
+	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/xmonader/ewf"
@@ -107,3 +110,213 @@ func TestGormStore_LoadNotFound(t *testing.T) {
 	_, err = store.LoadWorkflowByName(context.Background(), "non-existent-name")
 	require.Error(t, err, "Expected an error when loading a non-existent workflow by name")
 }
+
+func TestGormStore_ListUserWorkflows(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "ewf.db")), &gorm.Config{})
+	require.NoError(t, err)
+
+	store := NewGormStore(db)
+	require.NoError(t, store.Setup())
+
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	save := func(name string, status ewf.WorkflowStatus, state ewf.State, age time.Duration) *ewf.Workflow {
+		wf := ewf.NewWorkflow(name)
+		wf.Status = status
+		wf.State = state
+		wf.CreatedAt = start.Add(-age)
+		require.NoError(t, store.SaveWorkflow(ctx, wf))
+		return wf
+	}
+
+	deploy := save("deploy-3-nodes", ewf.StatusFailed, ewf.State{"config": map[string]interface{}{"user_id": 1}}, 0)
+	addNode := save("add-node", ewf.StatusCompleted, ewf.State{"config": map[string]interface{}{"user_id": 1}}, time.Minute)
+	reserve := save("reserve-node", ewf.StatusCompleted, ewf.State{"user_id": 1}, 2*time.Minute)
+	save("deploy-1-nodes", ewf.StatusCompleted, ewf.State{"config": map[string]interface{}{"user_id": 2}}, 0)
+	save("send-notification", ewf.StatusCompleted, ewf.State{}, 0)
+
+	uuids := func(workflows []*ewf.Workflow) []string {
+		var ids []string
+		for _, wf := range workflows {
+			ids = append(ids, wf.UUID)
+		}
+		return ids
+	}
+
+	t.Run("newest first", func(t *testing.T) {
+		workflows, err := store.ListUserWorkflows(ctx, 1, WorkflowFilter{})
+		require.NoError(t, err)
+		require.Equal(t, []string{deploy.UUID, addNode.UUID, reserve.UUID}, uuids(workflows))
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		workflows, err := store.ListUserWorkflows(ctx, 1, WorkflowFilter{Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, []string{deploy.UUID, addNode.UUID}, uuids(workflows))
+
+		cursor := NewWorkflowCursor(workflows[1])
+		workflows, err = store.ListUserWorkflows(ctx, 1, WorkflowFilter{Limit: 2, After: &cursor})
+		require.NoError(t, err)
+		require.Equal(t, []string{reserve.UUID}, uuids(workflows))
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		workflows, err := store.ListUserWorkflows(ctx, 1, WorkflowFilter{NamePrefix: "deploy-"})
+		require.NoError(t, err)
+		require.Equal(t, []string{deploy.UUID}, uuids(workflows))
+
+		workflows, err = store.ListUserWorkflows(ctx, 1, WorkflowFilter{Statuses: []string{string(ewf.StatusCompleted)}})
+		require.NoError(t, err)
+		require.Equal(t, []string{addNode.UUID, reserve.UUID}, uuids(workflows))
+
+		after := start.Add(-90 * time.Second)
+		workflows, err = store.ListUserWorkflows(ctx, 1, WorkflowFilter{CreatedAfter: &after})
+		require.NoError(t, err)
+		require.Equal(t, []string{deploy.UUID, addNode.UUID}, uuids(workflows))
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		loaded, err := store.LoadUserWorkflow(ctx, 1, deploy.UUID)
+		require.NoError(t, err)
+		require.Equal(t, deploy.Name, loaded.Name)
+
+		_, err = store.LoadUserWorkflow(ctx, 2, deploy.UUID)
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestGormStore_PendingRollbacks(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "ewf.db")), &gorm.Config{})
+	require.NoError(t, err)
+
+	store := NewGormStore(db)
+	require.NoError(t, store.Setup())
+
+	ctx := context.Background()
+	now := time.Now()
+	save := func(status ewf.WorkflowStatus, rollbackAt time.Time) *ewf.Workflow {
+		wf := ewf.NewWorkflow("deploy-1-nodes")
+		wf.Status = status
+		wf.State["config"] = map[string]interface{}{"user_id": 1}
+		wf.State["rollback_at"] = rollbackAt
+		require.NoError(t, store.SaveWorkflow(ctx, wf))
+		return wf
+	}
+
+	due := save(ewf.StatusFailed, now.Add(-time.Minute))
+	kept := save(ewf.StatusFailed, now.Add(time.Hour))
+	save(ewf.StatusRunning, now.Add(-time.Minute))
+
+	workflows, err := store.ListWorkflowsDueForRollback(ctx, now)
+	require.NoError(t, err)
+	require.Len(t, workflows, 1)
+	require.Equal(t, due.UUID, workflows[0].UUID)
+
+	workflows, err = store.ListUserPendingRollbacks(ctx, 1, "deploy-")
+	require.NoError(t, err)
+	require.Len(t, workflows, 2)
+	require.ElementsMatch(t, []string{due.UUID, kept.UUID}, []string{workflows[0].UUID, workflows[1].UUID})
+
+	workflows, err = store.ListUserPendingRollbacks(ctx, 2, "deploy-")
+	require.NoError(t, err)
+	require.Empty(t, workflows)
+
+	workflows, err = store.ListUserPendingRollbacks(ctx, 1, "add-node")
+	require.NoError(t, err)
+	require.Empty(t, workflows)
+
+	claimed, err := store.ClaimRollback(ctx, due.UUID)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	claimed, err = store.ClaimRollback(ctx, due.UUID)
+	require.NoError(t, err)
+	require.False(t, claimed, "a rollback is claimed once")
+
+	workflows, err = store.ListWorkflowsDueForRollback(ctx, now)
+	require.NoError(t, err)
+	require.Empty(t, workflows)
+
+	// a claimed rollback releases the workflow
+	workflows, err = store.ListUserPendingRollbacks(ctx, 1, "deploy-")
+	require.NoError(t, err)
+	require.Len(t, workflows, 1)
+	require.Equal(t, kept.UUID, workflows[0].UUID)
+}
+
+func TestGormStore_CancelRequest(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "ewf.db")), &gorm.Config{})
+	require.NoError(t, err)
+
+	store := NewGormStore(db)
+	require.NoError(t, store.Setup())
+
+	ctx := context.Background()
+	wf := ewf.NewWorkflow("deploy-1-nodes")
+	wf.Status = ewf.StatusPending
+	require.NoError(t, store.SaveWorkflow(ctx, wf))
+
+	requested, err := store.RequestCancel(ctx, wf.UUID)
+	require.NoError(t, err)
+	require.False(t, requested, "only running workflows are cancelled")
+
+	wf.Status = ewf.StatusRunning
+	require.NoError(t, store.SaveWorkflow(ctx, wf))
+	requested, err = store.RequestCancel(ctx, wf.UUID)
+	require.NoError(t, err)
+	require.True(t, requested)
+
+	// the running workflow saving its steps keeps the request
+	wf.CurrentStep = 1
+	require.NoError(t, store.SaveWorkflow(ctx, wf))
+	requested, err = store.CancelRequested(ctx, wf.UUID)
+	require.NoError(t, err)
+	require.True(t, requested)
+
+	// the failed run kept for retry is retried without its cancel request
+	wf.Status = ewf.StatusFailed
+	wf.State["rollback_at"] = time.Now().Add(time.Hour)
+	require.NoError(t, store.SaveWorkflow(ctx, wf))
+	claimed, err := store.ClaimRollback(ctx, wf.UUID)
+	require.NoError(t, err)
+	require.True(t, claimed)
+	requested, err = store.CancelRequested(ctx, wf.UUID)
+	require.NoError(t, err)
+	require.False(t, requested)
+}
+
+func TestGormStore_BackfillWorkflowColumns(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "ewf.db")), &gorm.Config{})
+	require.NoError(t, err)
+
+	// workflows saved before the owner and creation time columns existed
+	require.NoError(t, db.Exec("CREATE TABLE gorm_workflow_records (uuid text PRIMARY KEY, name text NOT NULL, status text NOT NULL, data blob NOT NULL)").Error)
+	createdAt := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	insert := func(name string, state ewf.State) *ewf.Workflow {
+		wf := ewf.NewWorkflow(name)
+		wf.Status = ewf.StatusCompleted
+		wf.CreatedAt = createdAt
+		wf.State = state
+		data, err := json.Marshal(wf)
+		require.NoError(t, err)
+		require.NoError(t, db.Exec("INSERT INTO gorm_workflow_records (uuid, name, status, data) VALUES (?, ?, ?, ?)", wf.UUID, wf.Name, wf.Status, data).Error)
+		return wf
+	}
+	deploy := insert("deploy-1-nodes", ewf.State{"config": map[string]interface{}{"user_id": 7}})
+	register := insert("register", ewf.State{"email": "user@example.com"})
+
+	store := NewGormStore(db)
+	require.NoError(t, store.Setup())
+	require.NoError(t, store.Setup(), "the backfill runs once")
+
+	ctx := context.Background()
+	workflows, err := store.ListUserWorkflows(ctx, 7, WorkflowFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, workflows, 1)
+	require.Equal(t, deploy.UUID, workflows[0].UUID)
+
+	var record gormWorkflowRecord
+	require.NoError(t, db.Where("uuid = ?", register.UUID).First(&record).Error)
+	require.Equal(t, 0, record.UserID)
+	require.True(t, createdAt.Equal(record.CreatedAt), "created at %s", record.CreatedAt)
+}
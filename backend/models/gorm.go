@@ -2,13 +2,18 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"kubecloud/kubedeployer"
 	"strings"
 	"sync"
 	"time"
 )
 
+// likeEscaper escapes the wildcards of a LIKE pattern, matched with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // GormDB struct implements db interface with gorm
 type GormDB struct {
 	db    *gorm.DB
@@ -34,6 +39,13 @@ func NewGormStorage(dialector gorm.Dialector) (DB, error) {
 		&SSHKey{},
 		&Cluster{},
 		&PendingRecord{},
+		&ClusterSnapshot{},
+		&SnapshotSchedule{},
+		&ContractDrift{},
+		&IPAllocation{},
+		&KubeconfigCredential{},
+		&APIToken{},
+		&NodeHostKey{},
 	)
 	if err != nil {
 		return nil, err
@@ -369,6 +381,144 @@ func (s *GormDB) ListUserClusters(userID int) ([]Cluster, error) {
 	return clusters, query.Error
 }
 
+// ListUserClustersPage returns the clusters of a user matching filter, in its order and after its cursor
+func (s *GormDB) ListUserClustersPage(userID int, filter ClusterFilter) ([]Cluster, error) {
+	column, after, err := filter.sortColumn()
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Where("user_id = ?", userID)
+	if filter.ProjectNamePrefix != "" {
+		query = query.Where("project_name LIKE ? ESCAPE '\\'", likeEscaper.Replace(filter.ProjectNamePrefix)+"%")
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", *filter.CreatedBefore)
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.After != nil {
+		query = query.Where(
+			fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, comparison),
+			after, after, filter.After.ID,
+		)
+	}
+	query = query.Order(fmt.Sprintf("%s %s, id %s", column, direction, direction))
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var clusters []Cluster
+	return clusters, query.Find(&clusters).Error
+}
+
+// UpdateClusterMetadata replaces the description and labels of a cluster
+func (s *GormDB) UpdateClusterMetadata(clusterID int, description string, labels map[string]string) error {
+	return s.db.Model(&Cluster{ID: clusterID}).
+		Select("description", "labels", "updated_at").
+		Updates(&Cluster{Description: description, Labels: labels, UpdatedAt: time.Now()}).Error
+}
+
+// SetClusterDeletionProtection turns the deletion protection of a cluster on or off.
+// Turning it on also cancels a scheduled deletion of the cluster.
+func (s *GormDB) SetClusterDeletionProtection(clusterID int, enabled bool) error {
+	updates := map[string]interface{}{"deletion_protection": enabled, "updated_at": time.Now()}
+	if enabled {
+		updates["deletion_scheduled_at"] = nil
+	}
+	return s.db.Model(&Cluster{}).Where("id = ?", clusterID).Updates(updates).Error
+}
+
+// ScheduleClusterDeletion marks a cluster as pending deletion until at,
+// it returns false when the cluster is protected from deletion or already being deleted
+func (s *GormDB) ScheduleClusterDeletion(clusterID int, at time.Time) (bool, error) {
+	result := s.db.Model(&Cluster{}).
+		Where("id = ? AND deletion_protection = ? AND status <> ?", clusterID, false, ClusterStatusDeleting).
+		Update("deletion_scheduled_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+// CancelClusterDeletion clears the scheduled deletion of a cluster, it returns false when the cluster wasn't pending deletion
+func (s *GormDB) CancelClusterDeletion(clusterID int) (bool, error) {
+	result := s.db.Model(&Cluster{}).
+		Where("id = ? AND deletion_scheduled_at IS NOT NULL", clusterID).
+		Update("deletion_scheduled_at", nil)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListDueClusterDeletions returns the clusters whose scheduled deletion time is reached
+func (s *GormDB) ListDueClusterDeletions(now time.Time) ([]Cluster, error) {
+	var clusters []Cluster
+	query := s.db.Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", now).Find(&clusters)
+	return clusters, query.Error
+}
+
+// ClaimClusterDeletion clears the due scheduled deletion of a cluster and marks it as deleting, so that it can't be cancelled anymore.
+// It returns false when the deletion was cancelled in the meantime.
+func (s *GormDB) ClaimClusterDeletion(clusterID int, now time.Time) (bool, error) {
+	result := s.db.Model(&Cluster{}).
+		Where("id = ? AND deletion_protection = ? AND deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", clusterID, false, now).
+		Updates(map[string]interface{}{"deletion_scheduled_at": nil, "status": ClusterStatusDeleting})
+	return result.RowsAffected > 0, result.Error
+}
+
+// MarkClusterDeleting marks a cluster as deleting before its deletion workflow starts, superseding a scheduled deletion.
+// It returns false when the cluster is protected from deletion or already being deleted.
+func (s *GormDB) MarkClusterDeleting(clusterID int) (bool, error) {
+	result := s.db.Model(&Cluster{}).
+		Where("id = ? AND deletion_protection = ? AND status <> ?", clusterID, false, ClusterStatusDeleting).
+		Updates(map[string]interface{}{"deletion_scheduled_at": nil, "status": ClusterStatusDeleting})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseClusterDeletion marks a cluster whose deletion failed as active again, the drift reconciler flags it if it is degraded
+func (s *GormDB) ReleaseClusterDeletion(userID int, projectName string) error {
+	return s.db.Model(&Cluster{}).
+		Where("user_id = ? AND project_name = ? AND status = ?", userID, projectName, ClusterStatusDeleting).
+		Update("status", ClusterStatusActive).Error
+}
+
+// ClaimClusterFailover marks a leader promotion of a cluster as running, so that only one runs at a time.
+// A claim made before staleBefore is taken over, the promotion that made it is considered lost.
+func (s *GormDB) ClaimClusterFailover(clusterID int, now time.Time, staleBefore time.Time) (bool, error) {
+	result := s.db.Model(&Cluster{}).
+		Where("id = ? AND (failover_started_at IS NULL OR failover_started_at < ?)", clusterID, staleBefore).
+		Update("failover_started_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseClusterFailover clears the leader promotion claim of a cluster once the promotion ended
+func (s *GormDB) ReleaseClusterFailover(clusterID int) error {
+	return s.db.Model(&Cluster{}).
+		Where("id = ?", clusterID).
+		Update("failover_started_at", nil).Error
+}
+
+// ClaimClusterPools marks workflowID as the workflow changing the node pools or nodes of a cluster, so that only one runs at a time.
+// The claim of staleWorkflowID is taken over, an empty one claims a cluster whose pools are not being changed.
+func (s *GormDB) ClaimClusterPools(clusterID int, workflowID, staleWorkflowID string) (bool, error) {
+	result := s.db.Model(&Cluster{}).
+		Where("id = ? AND COALESCE(pool_workflow_id, '') = ?", clusterID, staleWorkflowID).
+		Update("pool_workflow_id", workflowID)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseClusterPools clears the node pools claim of a workflow once it ended
+func (s *GormDB) ReleaseClusterPools(workflowID string) error {
+	return s.db.Model(&Cluster{}).
+		Where("pool_workflow_id = ?", workflowID).
+		Update("pool_workflow_id", "").Error
+}
+
 // GetClusterByName returns a cluster by name for a specific user
 func (s *GormDB) GetClusterByName(userID int, projectName string) (Cluster, error) {
 	var cluster Cluster
@@ -379,8 +529,10 @@ func (s *GormDB) GetClusterByName(userID int, projectName string) (Cluster, erro
 // UpdateCluster updates an existing cluster
 func (s *GormDB) UpdateCluster(cluster *Cluster) error {
 	cluster.UpdatedAt = time.Now()
+	// the failover and node pools claims are only changed by their claim and release
 	return s.db.Model(&Cluster{}).
 		Where("user_id = ? AND project_name = ?", cluster.UserID, cluster.ProjectName).
+		Omit("failover_started_at", "pool_workflow_id").
 		Updates(cluster).Error
 }
 
@@ -389,11 +541,198 @@ func (s *GormDB) DeleteCluster(userID int, projectName string) error {
 	return s.db.Where("user_id = ? AND project_name = ?", userID, projectName).Delete(&Cluster{}).Error
 }
 
+// UpdateClusterStatus sets the status of a cluster, a cluster being deleted keeps its status
+func (s *GormDB) UpdateClusterStatus(clusterID int, status string) error {
+	return s.db.Model(&Cluster{}).Where("id = ? AND status <> ?", clusterID, ClusterStatusDeleting).Update("status", status).Error
+}
+
 // DeleteAllUserClusters deletes all clusters for a specific user
 func (s *GormDB) DeleteAllUserClusters(userID int) error {
 	return s.db.Where("user_id = ?", userID).Delete(&Cluster{}).Error
 }
 
+// CreateClusterSnapshot records a snapshot stored in the backup store
+func (s *GormDB) CreateClusterSnapshot(snapshot *ClusterSnapshot) error {
+	snapshot.CreatedAt = time.Now()
+	return s.db.Create(snapshot).Error
+}
+
+// ListClusterSnapshots returns the snapshots of a cluster, newest first
+func (s *GormDB) ListClusterSnapshots(userID int, projectName string) ([]ClusterSnapshot, error) {
+	var snapshots []ClusterSnapshot
+	query := s.db.Where("user_id = ? AND project_name = ?", userID, projectName).Order("created_at DESC, id DESC").Find(&snapshots)
+	return snapshots, query.Error
+}
+
+// GetClusterSnapshot returns a snapshot of a cluster by ID
+func (s *GormDB) GetClusterSnapshot(userID int, projectName string, snapshotID int) (ClusterSnapshot, error) {
+	var snapshot ClusterSnapshot
+	query := s.db.Where("id = ? AND user_id = ? AND project_name = ?", snapshotID, userID, projectName).First(&snapshot)
+	return snapshot, query.Error
+}
+
+// DeleteClusterSnapshot deletes a snapshot record by ID
+func (s *GormDB) DeleteClusterSnapshot(snapshotID int) error {
+	return s.db.Delete(&ClusterSnapshot{}, snapshotID).Error
+}
+
+// SaveContractDrift records a drift of a contract or refreshes the one already recorded for it
+func (s *GormDB) SaveContractDrift(drift *ContractDrift) error {
+	var existing ContractDrift
+	err := s.db.Where("contract_id = ?", drift.ContractID).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	now := time.Now()
+	drift.ID = existing.ID
+	drift.DetectedAt = existing.DetectedAt
+	if drift.DetectedAt.IsZero() || existing.Kind != drift.Kind {
+		drift.DetectedAt = now
+	}
+	drift.LastSeenAt = now
+	return s.db.Save(drift).Error
+}
+
+// ListContractDrifts returns all recorded drifts, newest first
+func (s *GormDB) ListContractDrifts() ([]ContractDrift, error) {
+	var drifts []ContractDrift
+	return drifts, s.db.Order("detected_at DESC").Find(&drifts).Error
+}
+
+// GetContractDrift returns a recorded drift by its ID
+func (s *GormDB) GetContractDrift(id int) (ContractDrift, error) {
+	var drift ContractDrift
+	return drift, s.db.First(&drift, id).Error
+}
+
+// DeleteContractDrift removes a recorded drift
+func (s *GormDB) DeleteContractDrift(id int) error {
+	return s.db.Delete(&ContractDrift{}, id).Error
+}
+
+// DeleteContractDriftsSeenBefore removes the drifts that were not found again since t, they were resolved
+func (s *GormDB) DeleteContractDriftsSeenBefore(t time.Time) error {
+	return s.db.Where("last_seen_at < ?", t).Delete(&ContractDrift{}).Error
+}
+
+// ReserveIPAllocation reserves the IP of an allocation for its node.
+// It returns kubedeployer.ErrIPReserved when the IP is already reserved for another node of the same cluster.
+func (s *GormDB) ReserveIPAllocation(allocation *IPAllocation) error {
+	var existing IPAllocation
+	err := s.db.Where("project_name = ? AND ip = ?", allocation.ProjectName, allocation.IP).First(&existing).Error
+	if err == nil {
+		if existing.NodeName != allocation.NodeName {
+			return kubedeployer.ErrIPReserved
+		}
+		*allocation = existing
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err := s.db.Create(allocation).Error; err != nil {
+		// another workflow may have reserved the same IP in the meantime
+		if lookupErr := s.db.Where("project_name = ? AND ip = ?", allocation.ProjectName, allocation.IP).First(&existing).Error; lookupErr == nil && existing.NodeName != allocation.NodeName {
+			return kubedeployer.ErrIPReserved
+		}
+		return err
+	}
+	return nil
+}
+
+// GetIPAllocation returns the IP reserved for a node of a cluster
+func (s *GormDB) GetIPAllocation(projectName, nodeName string) (IPAllocation, error) {
+	var allocation IPAllocation
+	return allocation, s.db.Where("project_name = ? AND node_name = ?", projectName, nodeName).First(&allocation).Error
+}
+
+// ReleaseIPAllocation frees the IP reserved for a node of a cluster
+func (s *GormDB) ReleaseIPAllocation(projectName, nodeName string) error {
+	return s.db.Where("project_name = ? AND node_name = ?", projectName, nodeName).Delete(&IPAllocation{}).Error
+}
+
+// ReleaseClusterIPAllocations frees all IPs reserved in a cluster
+func (s *GormDB) ReleaseClusterIPAllocations(projectName string) error {
+	return s.db.Where("project_name = ?", projectName).Delete(&IPAllocation{}).Error
+}
+
+// CreateKubeconfigCredential records an issued kubeconfig credential
+func (s *GormDB) CreateKubeconfigCredential(credential *KubeconfigCredential) error {
+	return s.db.Create(credential).Error
+}
+
+// ListKubeconfigCredentials returns the credentials issued for a cluster, newest first
+func (s *GormDB) ListKubeconfigCredentials(userID int, projectName string) ([]KubeconfigCredential, error) {
+	var credentials []KubeconfigCredential
+	return credentials, s.db.Where("user_id = ? AND project_name = ?", userID, projectName).Order("created_at DESC").Find(&credentials).Error
+}
+
+// GetKubeconfigCredential returns a credential issued for a cluster by its ID
+func (s *GormDB) GetKubeconfigCredential(userID int, projectName string, id int) (KubeconfigCredential, error) {
+	var credential KubeconfigCredential
+	return credential, s.db.Where("user_id = ? AND project_name = ?", userID, projectName).First(&credential, id).Error
+}
+
+// GetOwnerKubeconfigCredential returns the latest owner credential of a cluster that was not revoked
+func (s *GormDB) GetOwnerKubeconfigCredential(userID int, projectName string) (KubeconfigCredential, error) {
+	var credential KubeconfigCredential
+	return credential, s.db.Where("user_id = ? AND project_name = ? AND owner = ? AND revoked_at IS NULL", userID, projectName, true).
+		Order("created_at DESC").First(&credential).Error
+}
+
+// RevokeKubeconfigCredential marks a credential as revoked and drops its kubeconfig
+func (s *GormDB) RevokeKubeconfigCredential(id int) error {
+	return s.db.Model(&KubeconfigCredential{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "kubeconfig": ""}).Error
+}
+
+// DeleteClusterKubeconfigCredentials removes the credentials of a deleted cluster
+func (s *GormDB) DeleteClusterKubeconfigCredentials(userID int, projectName string) error {
+	return s.db.Where("user_id = ? AND project_name = ?", userID, projectName).Delete(&KubeconfigCredential{}).Error
+}
+
+// SaveSnapshotSchedule creates or replaces the snapshot schedule of a cluster
+func (s *GormDB) SaveSnapshotSchedule(schedule *SnapshotSchedule) error {
+	existing, err := s.GetSnapshotSchedule(schedule.UserID, schedule.ProjectName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	schedule.ID = existing.ID
+	schedule.LastSnapshotAt = existing.LastSnapshotAt
+	schedule.CreatedAt = existing.CreatedAt
+	if schedule.CreatedAt.IsZero() {
+		schedule.CreatedAt = time.Now()
+	}
+	schedule.UpdatedAt = time.Now()
+	return s.db.Save(schedule).Error
+}
+
+// GetSnapshotSchedule returns the snapshot schedule of a cluster
+func (s *GormDB) GetSnapshotSchedule(userID int, projectName string) (SnapshotSchedule, error) {
+	var schedule SnapshotSchedule
+	query := s.db.Where("user_id = ? AND project_name = ?", userID, projectName).First(&schedule)
+	return schedule, query.Error
+}
+
+// ListEnabledSnapshotSchedules returns all enabled snapshot schedules
+func (s *GormDB) ListEnabledSnapshotSchedules() ([]SnapshotSchedule, error) {
+	var schedules []SnapshotSchedule
+	return schedules, s.db.Where("enabled = ?", true).Find(&schedules).Error
+}
+
+// UpdateSnapshotScheduleLastRun records when the last scheduled snapshot was started
+func (s *GormDB) UpdateSnapshotScheduleLastRun(scheduleID int, at time.Time) error {
+	return s.db.Model(&SnapshotSchedule{}).Where("id = ?", scheduleID).UpdateColumn("last_snapshot_at", at).Error
+}
+
+// DeleteSnapshotSchedule deletes the snapshot schedule of a cluster
+func (s *GormDB) DeleteSnapshotSchedule(userID int, projectName string) error {
+	return s.db.Where("user_id = ? AND project_name = ?", userID, projectName).Delete(&SnapshotSchedule{}).Error
+}
+
 func (s *GormDB) CreatePendingRecord(record *PendingRecord) error {
 	record.CreatedAt = time.Now()
 	return s.db.Create(record).Error
@@ -509,3 +848,88 @@ func migrateNotifications(db *gorm.DB) error {
 		return nil
 	})
 }
+
+// CreateAPIToken creates a new API token for a user
+func (s *GormDB) CreateAPIToken(token *APIToken) error {
+	token.CreatedAt = time.Now()
+	return s.db.Create(token).Error
+}
+
+// ListUserAPITokens returns all API tokens of a user
+func (s *GormDB) ListUserAPITokens(userID int) ([]APIToken, error) {
+	var tokens []APIToken
+	return tokens, s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+}
+
+// GetAPITokenByHash returns the API token with the given hash
+func (s *GormDB) GetAPITokenByHash(tokenHash string) (APIToken, error) {
+	var token APIToken
+	return token, s.db.Where("token_hash = ?", tokenHash).First(&token).Error
+}
+
+// DeleteAPIToken deletes an API token by ID for a specific user
+func (s *GormDB) DeleteAPIToken(id int, userID int) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteClusterAPITokens removes the API tokens bound to a deleted cluster
+func (s *GormDB) DeleteClusterAPITokens(userID int, projectName string) error {
+	return s.db.Where("user_id = ? AND project_name = ?", userID, projectName).Delete(&APIToken{}).Error
+}
+
+// TouchAPIToken records the last time an API token was used
+func (s *GormDB) TouchAPIToken(id int) error {
+	return s.db.Model(&APIToken{}).Where("id = ?", id).Update("last_used_at", time.Now()).Error
+}
+
+// GetNodeHostKey returns the host key trusted for a node
+func (s *GormDB) GetNodeHostKey(nodeName string) (NodeHostKey, error) {
+	var hostKey NodeHostKey
+	return hostKey, s.db.Where("node_name = ?", nodeName).First(&hostKey).Error
+}
+
+// TrustNodeHostKey stores the host key trusted for a node, replacing the previous one and clearing any recorded change
+func (s *GormDB) TrustNodeHostKey(hostKey *NodeHostKey) error {
+	hostKey.TrustedAt = time.Now()
+	hostKey.PresentedKey = ""
+	hostKey.PresentedFingerprint = ""
+	hostKey.ChangedAt = nil
+	existing, err := s.GetNodeHostKey(hostKey.NodeName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	hostKey.ID = existing.ID
+	return s.db.Save(hostKey).Error
+}
+
+// RecordNodeHostKeyChange records the key a node presented instead of its trusted one
+func (s *GormDB) RecordNodeHostKeyChange(nodeName, presentedKey, presentedFingerprint string) error {
+	return s.db.Model(&NodeHostKey{}).Where("node_name = ?", nodeName).Updates(map[string]any{
+		"presented_key":         presentedKey,
+		"presented_fingerprint": presentedFingerprint,
+		"changed_at":            time.Now(),
+	}).Error
+}
+
+// ListNodeHostKeys returns the trusted host keys, only the ones of nodes that presented another key when changedOnly is set
+func (s *GormDB) ListNodeHostKeys(changedOnly bool) ([]NodeHostKey, error) {
+	var hostKeys []NodeHostKey
+	query := s.db.Order("node_name")
+	if changedOnly {
+		query = query.Where("changed_at IS NOT NULL")
+	}
+	return hostKeys, query.Find(&hostKeys).Error
+}
+
+// DeleteNodeHostKey forgets the host key of a node, the next key it presents is trusted
+func (s *GormDB) DeleteNodeHostKey(nodeName string) error {
+	return s.db.Where("node_name = ?", nodeName).Delete(&NodeHostKey{}).Error
+}
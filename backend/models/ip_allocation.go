@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// IPAllocation reserves a private IP of a cluster network for a node, so that concurrent workflows never pick the same one
+type IPAllocation struct {
+	ID          int       `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	ProjectName string    `gorm:"uniqueIndex:idx_allocation_ip;uniqueIndex:idx_allocation_node" json:"project_name"`
+	NodeName    string    `gorm:"uniqueIndex:idx_allocation_node" json:"node_name"`
+	NodeID      uint32    `json:"node_id"`
+	IP          string    `gorm:"uniqueIndex:idx_allocation_ip" json:"ip"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// KubeconfigCredential is a kubeconfig issued for a cluster with its own identity, role and expiry
+type KubeconfigCredential struct {
+	ID          int    `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	UserID      int    `gorm:"index:idx_credential_cluster" json:"-"`
+	ProjectName string `gorm:"index:idx_credential_cluster" json:"-"`
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	Kind        string `json:"kind"`
+	// Namespace the role is bound in, empty when it applies to the whole cluster
+	Namespace string `json:"namespace,omitempty"`
+	// Subject is the service account or certificate name of the credential in the cluster
+	Subject string `json:"subject"`
+	// Owner marks the admin credential returned by the kubeconfig endpoint, the only one whose kubeconfig is kept
+	Owner      bool       `json:"owner"`
+	Kubeconfig string     `gorm:"type:text" json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsActive reports whether the credential is neither revoked nor expired
+func (c *KubeconfigCredential) IsActive() bool {
+	return c.RevokedAt == nil && time.Now().Before(c.ExpiresAt)
+}
@@ -38,6 +38,27 @@ func MigrateAll(ctx context.Context, src DB, dst DB) error {
 	if err := migrateNotificationsToDst(ctx, src.GetDB(), dst.GetDB()); err != nil {
 		return fmt.Errorf("notifications: %w", err)
 	}
+	if err := migrateClusterSnapshots(ctx, src.GetDB(), dst.GetDB()); err != nil {
+		return fmt.Errorf("cluster_snapshots: %w", err)
+	}
+	if err := migrateSnapshotSchedules(ctx, src.GetDB(), dst.GetDB()); err != nil {
+		return fmt.Errorf("snapshot_schedules: %w", err)
+	}
+	if err := migrateContractDrifts(ctx, src.GetDB(), dst.GetDB()); err != nil {
+		return fmt.Errorf("contract_drifts: %w", err)
+	}
+	if err := migrateIPAllocations(ctx, src.GetDB(), dst.GetDB()); err != nil {
+		return fmt.Errorf("ip_allocations: %w", err)
+	}
+	if err := migrateKubeconfigCredentials(ctx, src.GetDB(), dst.GetDB()); err != nil {
+		return fmt.Errorf("kubeconfig_credentials: %w", err)
+	}
+	if err := migrateAPITokens(ctx, src.GetDB(), dst.GetDB()); err != nil {
+		return fmt.Errorf("api_tokens: %w", err)
+	}
+	if err := migrateNodeHostKeys(ctx, src.GetDB(), dst.GetDB()); err != nil {
+		return fmt.Errorf("node_host_keys: %w", err)
+	}
 	return nil
 }
 
@@ -127,3 +148,59 @@ func migrateNotificationsToDst(ctx context.Context, src *gorm.DB, dst *gorm.DB)
 	}
 	return insertOnConflictReturnError(ctx, dst, rows)
 }
+
+func migrateClusterSnapshots(ctx context.Context, src *gorm.DB, dst *gorm.DB) error {
+	var rows []ClusterSnapshot
+	if err := src.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+	return insertOnConflictReturnError(ctx, dst, rows)
+}
+
+func migrateSnapshotSchedules(ctx context.Context, src *gorm.DB, dst *gorm.DB) error {
+	var rows []SnapshotSchedule
+	if err := src.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+	return insertOnConflictReturnError(ctx, dst, rows)
+}
+
+func migrateContractDrifts(ctx context.Context, src *gorm.DB, dst *gorm.DB) error {
+	var rows []ContractDrift
+	if err := src.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+	return insertOnConflictReturnError(ctx, dst, rows)
+}
+
+func migrateIPAllocations(ctx context.Context, src *gorm.DB, dst *gorm.DB) error {
+	var rows []IPAllocation
+	if err := src.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+	return insertOnConflictReturnError(ctx, dst, rows)
+}
+
+func migrateKubeconfigCredentials(ctx context.Context, src *gorm.DB, dst *gorm.DB) error {
+	var rows []KubeconfigCredential
+	if err := src.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+	return insertOnConflictReturnError(ctx, dst, rows)
+}
+
+func migrateAPITokens(ctx context.Context, src *gorm.DB, dst *gorm.DB) error {
+	var rows []APIToken
+	if err := src.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+	return insertOnConflictReturnError(ctx, dst, rows)
+}
+
+func migrateNodeHostKeys(ctx context.Context, src *gorm.DB, dst *gorm.DB) error {
+	var rows []NodeHostKey
+	if err := src.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+	return insertOnConflictReturnError(ctx, dst, rows)
+}
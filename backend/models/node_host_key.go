@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// NodeHostKey is the SSH host key trusted for a cluster node, recorded the first time the backend connects to it
+type NodeHostKey struct {
+	ID int `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	// NodeName is the full name of the node, prefixed with the project name of its cluster
+	NodeName   string `gorm:"uniqueIndex" json:"node_name"`
+	ContractID uint64 `json:"contract_id"`
+	Address    string `json:"address"`
+	// HostKey is the trusted key in authorized_keys format
+	HostKey     string `gorm:"type:text" json:"host_key"`
+	Fingerprint string `json:"fingerprint"`
+	// PresentedKey is the key the node presented instead of the trusted one, empty unless the key changed
+	PresentedKey         string     `gorm:"type:text" json:"presented_key,omitempty"`
+	PresentedFingerprint string     `json:"presented_fingerprint,omitempty"`
+	ChangedAt            *time.Time `json:"changed_at,omitempty"`
+	TrustedAt            time.Time  `json:"trusted_at"`
+}
@@ -16,6 +16,7 @@ const (
 	NotificationTypeUser       NotificationType = "user"
 	NotificationTypeConnected  NotificationType = "connected"
 	NotificationTypeNode       NotificationType = "node"
+	NotificationTypeCluster    NotificationType = "cluster"
 )
 
 // NotificationStatus represents the status of a notification
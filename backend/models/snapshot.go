@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// ClusterSnapshot is a k3s datastore snapshot of a cluster kept in the backup store
+type ClusterSnapshot struct {
+	ID          int       `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	UserID      int       `gorm:"index:idx_snapshot_cluster" json:"user_id"`
+	ProjectName string    `gorm:"index:idx_snapshot_cluster" json:"project_name"`
+	Name        string    `json:"name"`
+	StorageKey  string    `json:"-"`
+	Size        int64     `json:"size"`
+	Scheduled   bool      `json:"scheduled"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SnapshotSchedule holds how often snapshots of a cluster are taken and how many scheduled snapshots are kept
+type SnapshotSchedule struct {
+	ID             int       `gorm:"primaryKey;autoIncrement;column:id" json:"-"`
+	UserID         int       `gorm:"uniqueIndex:idx_schedule_cluster" json:"-"`
+	ProjectName    string    `gorm:"uniqueIndex:idx_schedule_cluster" json:"-"`
+	Enabled        bool      `json:"enabled"`
+	IntervalHours  int       `json:"interval_hours"`
+	Retention      int       `json:"retention"`
+	LastSnapshotAt time.Time `json:"last_snapshot_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// IsDue reports whether the next scheduled snapshot should be taken
+func (s SnapshotSchedule) IsDue(now time.Time) bool {
+	return s.Enabled && s.IntervalHours > 0 && !now.Before(s.LastSnapshotAt.Add(time.Duration(s.IntervalHours)*time.Hour))
+}
@@ -1,7 +1,7 @@
 import { defineStore } from 'pinia'
 import { ref, computed } from 'vue'
 import { authService, type LoginRequest, type RegisterRequest } from '@/utils/authService'
-import { api, createWorkflowStatusChecker } from '@/utils/api'
+import { api } from '@/utils/api'
 import type { ApiResponse, VerifyCodeRequest } from '@/utils/authService'
 import { userService } from '@/utils/userService'
 import { useNotificationStore } from './notifications'
@@ -279,7 +279,7 @@ export async function deployCluster(payload: any) {
 }
 
 export async function getWorkflowStatus(workflowID: string): Promise<ApiResponse<{ data: WorkflowStatus }>> {
-  return api.get(`/v1/workflow/${workflowID}`, { requiresAuth: false, showNotifications: false })
+  return api.get(`/v1/workflow/${workflowID}`, { requiresAuth: true, showNotifications: false })
 }
 
 
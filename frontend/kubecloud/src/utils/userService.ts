@@ -1,5 +1,5 @@
 import { WorkflowStatus } from '@/types/ewf'
-import { api, createWorkflowStatusChecker, type ApiError } from './api'
+import { api, type ApiError } from './api'
 import type { ApiResponse } from './authService'
 import type { ChargeBalanceResponse } from './stripeService'
 import { useNotificationStore } from '@/stores/notifications'
@@ -18,8 +18,8 @@ if [ -z "${K3S_FLANNEL_IFACE}" ]; then
 fi
 
 if [[ "${DUAL_STACK}" = "true" && "${MASTER}" = "true" ]]; then
-    EXTRA_ARGS="$EXTRA_ARGS --cluster-cidr=10.42.0.0/16,2001:cafe:42::/56"
-    EXTRA_ARGS="$EXTRA_ARGS --service-cidr=10.43.0.0/16,2001:cafe:43::/112"
+    EXTRA_ARGS="$EXTRA_ARGS --cluster-cidr=10.42.0.0/16,${CLUSTER_CIDR_IPV6:-2001:cafe:42::/56}"
+    EXTRA_ARGS="$EXTRA_ARGS --service-cidr=10.43.0.0/16,${SERVICE_CIDR_IPV6:-2001:cafe:43::/112}"
     EXTRA_ARGS="$EXTRA_ARGS --flannel-ipv6-masq"
 fi
 
@@ -61,8 +61,9 @@ if [[ "${DUAL_STACK}" = "true" ]]; then
     EXTRA_ARGS="$EXTRA_ARGS --node-ip=$ipv4,$ipv6"
 fi 
 
-if [ -z "${K3S_URL}" ]; then
-    # Add additional SANs for planetary network IP, public IPv4, and public IPv6  
+if [[ -z "${K3S_URL}" || "${MASTER}" = "true" ]]; then
+    # Add additional SANs for planetary network IP, public IPv4, and public IPv6 on every server,
+    # the kubeconfig can point at any master once it has been promoted to leader
     # https://github.com/threefoldtech/tf-images/issues/98
     ifaces=( "tun0" "eth1" "eth2" )
 
@@ -75,6 +76,9 @@ if [ -z "${K3S_URL}" ]; then
             ip route get $addr && EXTRA_ARGS="$EXTRA_ARGS --tls-san $addr"
         done
     done
+fi
+
+if [ -z "${K3S_URL}" ]; then
     if [ "${HA}" = "true" ]; then
         EXTRA_ARGS="$EXTRA_ARGS --cluster-init"
     fi